@@ -2,19 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/danielboakye/go-echo-app/controllers"
 	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/danielboakye/go-echo-app/rpc"
+	"github.com/danielboakye/go-echo-app/storage"
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -24,15 +39,192 @@ func main() {
 		log.Fatal(err)
 	}
 
-	//  connect to DB
-	conn, err := data.OpenDB()
+	// TOTP secrets are sealed with this key in the database
+	box, err := openSecretBox()
 	if err != nil {
-		log.Panic("can't connect to postgres")
+		log.Panic(err)
 	}
 
 	// setup config
-	app := controllers.Config{
-		Repo: data.NewRepository(conn),
+	var (
+		app  controllers.Config
+		orgs data.IOrganizationRepository
+	)
+
+	switch os.Getenv("STORAGE") {
+	case "memory":
+		app = controllers.Config{
+			Repo:        data.NewMemoryRepository(),
+			Idempotency: data.NewMemoryIdempotencyStore(),
+			APIKeys:     data.NewMemoryAPIKeyRepository(),
+			TwoFactor:   data.NewMemoryTwoFactorRepository(),
+			Identities:  data.NewMemoryIdentityRepository(),
+			Sessions:    data.NewMemorySessionRepository(),
+			Fields:      data.NewMemoryFieldRepository(),
+			Avatars:     data.NewMemoryAvatarRepository(),
+			Groups:      data.NewMemoryGroupRepository(),
+			Invitations: data.NewMemoryInvitationRepository(),
+			PrivacyJobs: data.NewMemoryPrivacyJobRepository(),
+
+			LoginAttempts: data.NewMemoryAttemptRepository(),
+			LoginEvents:   data.NewMemoryLoginEventRepository(),
+
+			OAuthClients: data.NewMemoryOAuthClientRepository(),
+			Consents:     data.NewMemoryConsentRepository(),
+			OAuthTokens:  data.NewMemoryOAuthTokenRepository(),
+		}
+		orgs = data.NewMemoryOrganizationRepository(app.Repo)
+	default:
+		//  connect to DB
+		conn, dialect, err := data.OpenDB()
+		if err != nil {
+			log.Panic("can't connect to the database")
+		}
+
+		err = data.Migrate(conn, dialect)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		app = controllers.Config{
+			Repo:        data.NewRepositoryFor(conn, dialect),
+			Idempotency: data.NewIdempotencyRepositoryFor(conn, dialect),
+			APIKeys:     data.NewAPIKeyRepositoryFor(conn, dialect),
+			Identities:  data.NewIdentityRepositoryFor(conn, dialect),
+			Sessions:    data.NewSessionRepositoryFor(conn, dialect),
+			Fields:      data.NewFieldRepositoryFor(conn, dialect),
+			Avatars:     data.NewAvatarRepositoryFor(conn, dialect),
+			Groups:      data.NewGroupRepositoryFor(conn, dialect),
+			Invitations: data.NewInvitationRepositoryFor(conn, dialect),
+			PrivacyJobs: data.NewPrivacyJobRepositoryFor(conn, dialect),
+
+			LoginAttempts: data.NewAttemptRepositoryFor(conn, dialect),
+			LoginEvents:   data.NewLoginEventRepositoryFor(conn, dialect),
+
+			OAuthClients: data.NewOAuthClientRepositoryFor(conn, dialect),
+			Consents:     data.NewConsentRepositoryFor(conn, dialect),
+			OAuthTokens:  data.NewOAuthTokenRepositoryFor(conn, dialect),
+		}
+		orgs = data.NewOrganizationRepositoryFor(conn, dialect)
+
+		if box != nil {
+			app.TwoFactor = data.NewTwoFactorRepositoryFor(conn, dialect, box)
+		}
+
+		// reads go to the replicas when there are any
+		replicas, err := data.OpenReplicas(dialect)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		if len(replicas) > 0 {
+			stickiness, err := envDuration("REPLICA_STICKINESS", 0)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			set := data.NewReplicaSet(conn, replicas, stickiness)
+			set.Check(context.Background())
+			go set.Watch(context.Background(), 0)
+
+			app.Repo = data.NewReplicatedRepository(set, dialect)
+		}
+
+		// the database enforces tenant isolation as well
+		if os.Getenv("ROW_LEVEL_SECURITY") == "true" {
+			app.Repo, err = data.EnableRowLevelSecurity(app.Repo)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+	}
+
+	app.Debug = os.Getenv("DEBUG") == "true"
+	app.AdminToken = os.Getenv("ADMIN_TOKEN")
+	app.RequireAuth = os.Getenv("REQUIRE_AUTH") == "true"
+
+	// users log in for access tokens, with a TOTP step once they enable it
+	app.TokenSecret = []byte(os.Getenv("TOKEN_SECRET"))
+	app.TokenTTL, err = envDuration("TOKEN_TTL", 0)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// failed logins slow down and then lock out the account or IP, with
+	// the defaults of controllers.DefaultLockoutPolicy for unset values
+	app.Lockout, err = lockoutPolicy()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// users are emailed about logins from new devices
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		app.Mailer = mail.NewSMTP(addr, os.Getenv("MAIL_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+
+	// invitations are emailed with a link to INVITATION_URL, and need the
+	// token secret and a mailer
+	app.InvitationURL = os.Getenv("INVITATION_URL")
+	app.InvitationTTL, err = envDuration("INVITATION_TTL", 0)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// avatars are stored in a directory (BLOB_DIR) or an S3-compatible
+	// bucket (S3_BUCKET), and not served without either
+	app.Blobs, err = openBlobStore()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	maxAvatar, err := envInt("AVATAR_MAX_BYTES", 0)
+	if err != nil {
+		log.Panic(err)
+	}
+	app.AvatarMaxBytes = int64(maxAvatar)
+
+	// users can also sign in through external identity providers
+	app.OIDC = oidcProviders()
+
+	// other applications sign users in through us as an OAuth2 and OpenID
+	// Connect authorization server
+	app.Issuer = os.Getenv("OAUTH_ISSUER")
+	app.SigningKey, err = readSigningKey(os.Getenv("OAUTH_SIGNING_KEY"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	app.TOTPIssuer = os.Getenv("TOTP_ISSUER")
+	app.TOTPSkew, err = envInt("TOTP_SKEW", 1)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// cache user reads in process (lru) or in Redis (redis)
+	if kind := os.Getenv("CACHE"); kind != "" {
+		cache, err := openCache(kind)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		ttl, err := envDuration("CACHE_TTL", time.Minute)
+		if err != nil {
+			log.Panic(err)
+		}
+		app.Repo = data.NewCachedRepository(app.Repo, cache, ttl)
+	}
+
+	app.CacheMaxAge, err = envDuration("CACHE_MAX_AGE", 0)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// keep the users of each organization apart
+	if os.Getenv("MULTI_TENANT") == "true" {
+		app.Organizations = orgs
+		app.DefaultOrganization = os.Getenv("DEFAULT_ORGANIZATION")
+		app.TenantDomain = os.Getenv("TENANT_DOMAIN")
+		app.TenantTokenSecret = []byte(os.Getenv("TENANT_TOKEN_SECRET"))
 	}
 
 	e := app.NewServer()
@@ -43,6 +235,35 @@ func main() {
 		}
 	}()
 
+	// the gRPC server is optional and only started when it has a port
+	var grpcServer *grpc.Server
+	if port := os.Getenv("GRPC_PORT"); port != "" {
+		if os.Getenv("GRPC_TOKEN") == "" {
+			log.Panic("GRPC_TOKEN is required to serve gRPC")
+		}
+
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		rpcApp := rpc.Config{
+			Repo:                app.Repo,
+			Sessions:            app.Sessions,
+			Groups:              app.Groups,
+			Token:               os.Getenv("GRPC_TOKEN"),
+			Organizations:       app.Organizations,
+			DefaultOrganization: app.DefaultOrganization,
+		}
+		grpcServer = rpcApp.NewServer()
+
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server with a timeout of 10 seconds.
 	// Use a buffered channel to avoid missing signals as recommended for signal.Notify
 	quit := make(chan os.Signal, 1)
@@ -50,7 +271,195 @@ func main() {
 	<-quit
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+
+	// both servers drain at the same time, within the same timeout
+	grpcStopped := make(chan struct{})
+	go func() {
+		defer close(grpcStopped)
+		if grpcServer == nil {
+			return
+		}
+
+		if err := rpc.Shutdown(ctx, grpcServer); err != nil {
+			log.Println(err)
+		}
+	}()
+
 	if err := e.Shutdown(ctx); err != nil {
 		e.Logger.Fatal(err)
 	}
+	<-grpcStopped
+}
+
+func openCache(kind string) (data.ICache, error) {
+	switch kind {
+	case "lru":
+		size := 10000
+		if v := os.Getenv("CACHE_SIZE"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CACHE_SIZE: %w", err)
+			}
+			size = n
+		}
+
+		return data.NewLRUCache(size)
+	case "redis":
+		return data.OpenRedisCache(os.Getenv("REDIS_URL"), "go-echo-app:")
+	}
+
+	return nil, fmt.Errorf("unknown CACHE %q", kind)
+}
+
+// oidcProviders returns the identity providers named in OIDC_PROVIDERS, each
+// configured by OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL
+// and optionally _SCOPES
+func oidcProviders() map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+	for _, name := range strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_PROVIDERS"), ",", " ")) {
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+		}
+
+		providers[name] = &oidc.Provider{
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+			HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	return providers
+}
+
+// openSecretBox returns the SecretBox of the base64 key in
+// TOTP_ENCRYPTION_KEY, or nil when it is unset
+func openSecretBox() (*data.SecretBox, error) {
+	v := os.Getenv("TOTP_ENCRYPTION_KEY")
+	if v == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP_ENCRYPTION_KEY: %w", err)
+	}
+
+	return data.NewSecretBox(key)
+}
+
+// readSigningKey reads the PEM RSA private key, PKCS #1 or PKCS #8, at
+// path, or returns nil when path is empty
+func readSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("OAUTH_SIGNING_KEY: %w", err)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("OAUTH_SIGNING_KEY: no PEM block")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("OAUTH_SIGNING_KEY: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("OAUTH_SIGNING_KEY: not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// lockoutPolicy reads the login lockout settings. Zero values are left for
+// the defaults
+func lockoutPolicy() (controllers.LockoutPolicy, error) {
+	var (
+		p   controllers.LockoutPolicy
+		err error
+	)
+
+	for name, v := range map[string]*int{
+		"LOGIN_LOCKOUT_THRESHOLD":    &p.AccountThreshold,
+		"LOGIN_IP_LOCKOUT_THRESHOLD": &p.IPThreshold,
+	} {
+		if *v, err = envInt(name, 0); err != nil {
+			return p, err
+		}
+	}
+
+	for name, v := range map[string]*time.Duration{
+		"LOGIN_LOCKOUT_DURATION": &p.Duration,
+		"LOGIN_DELAY":            &p.Delay,
+		"LOGIN_MAX_DELAY":        &p.MaxDelay,
+		"LOGIN_FAILURE_WINDOW":   &p.Window,
+	} {
+		if *v, err = envDuration(name, 0); err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
+
+// openBlobStore returns the store named by the environment, nil when none
+// is configured
+func openBlobStore() (storage.BlobStore, error) {
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		return storage.NewS3(
+			os.Getenv("S3_ENDPOINT"), os.Getenv("S3_REGION"), bucket,
+			os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"),
+		), nil
+	}
+
+	if dir := os.Getenv("BLOB_DIR"); dir != "" {
+		store, err := storage.NewLocal(dir)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	return nil, nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return n, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return d, nil
 }
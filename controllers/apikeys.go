@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+func (app *Config) getAllAPIKeys(c echo.Context) error {
+	keys, err := scoped(c, app.APIKeys).GetAll()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]apiKeyV1, len(keys))
+	for i, k := range keys {
+		res[i] = newAPIKeyV1(k)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (app *Config) getAPIKey(c echo.Context) error {
+	k, err := scoped(c, app.APIKeys).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "api key not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newAPIKeyV1(k))
+}
+
+// saveAPIKey creates a key and answers with its secret, which is not shown
+// again. Callers cannot grant scopes they do not hold themselves
+func (app *Config) saveAPIKey(c echo.Context) error {
+	var in apiKeyInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	k := in.apiKey()
+	if errs := data.ValidateAPIKey(k, grantableBy(principalOf(c))); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid api key", Details: errs})
+	}
+
+	key, prefix, hash, err := data.NewAPIKey()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	k.Prefix = prefix
+	k.Hash = hash
+
+	id, err := scoped(c, app.APIKeys).Insert(k)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	created, err := scoped(c, app.APIKeys).GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusCreated, apiKeySecretV1{apiKeyV1: newAPIKeyV1(created), Key: key})
+}
+
+// rotateAPIKey replaces the secret of a key, keeping its name, scopes and
+// expiry. The previous secret stops working at once
+func (app *Config) rotateAPIKey(c echo.Context) error {
+	id := c.Param("id")
+
+	k, err := scoped(c, app.APIKeys).GetOne(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "api key not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if k.RevokedAt != nil {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "api key revoked"})
+	}
+
+	key, prefix, hash, err := data.NewAPIKey()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.APIKeys).Rotate(id, prefix, hash); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+	k.Prefix = prefix
+
+	return c.JSON(http.StatusOK, apiKeySecretV1{apiKeyV1: newAPIKeyV1(k), Key: key})
+}
+
+// revokeAPIKey disables a key for good. Revoked keys stay listed
+func (app *Config) revokeAPIKey(c echo.Context) error {
+	id := c.Param("id")
+
+	if _, err := scoped(c, app.APIKeys).GetOne(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, errorResponse{Error: "api key not found"})
+		}
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.APIKeys).Revoke(id); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
@@ -0,0 +1,196 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API keys", func() {
+
+	type apiKey struct {
+		ID         string   `json:"key_id"`
+		Prefix     string   `json:"prefix"`
+		Scopes     []string `json:"scopes"`
+		LastUsedAt *string  `json:"last_used_at"`
+		RevokedAt  *string  `json:"revoked_at"`
+		Key        string   `json:"key"`
+	}
+
+	var (
+		app controllers.Config
+		e   *echo.Echo
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	create := func(body string, headers ...string) apiKey {
+		w := do("POST", "/v1/api-keys", body, headers...)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var k apiKey
+		Expect(json.Unmarshal(w.Body.Bytes(), &k)).To(Succeed())
+		Expect(k.Key).To(HavePrefix("gea_"))
+
+		return k
+	}
+
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+		e = app.NewServer()
+	})
+
+	It("should authenticate with the key as a bearer token or in X-API-Key", func() {
+		k := create(`{"name": "sync job", "scopes": ["users:read"]}`, admin...)
+
+		w := do("GET", "/v1/users", "", "Authorization", "Bearer "+k.Key)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("GET", "/v1/users", "", "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("GET", "/v1/api-keys/"+k.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var got apiKey
+		Expect(json.Unmarshal(w.Body.Bytes(), &got)).To(Succeed())
+		Expect(got.Key).To(BeEmpty())
+		Expect(got.Prefix).To(Equal(k.Prefix))
+		Expect(got.LastUsedAt).NotTo(BeNil())
+	})
+
+	It("should enforce the scopes of the key", func() {
+		k := create(`{"name": "reader", "scopes": ["users:read"]}`, admin...)
+
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password"}`, "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("GET", "/v1/api-keys", "", "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("POST", "/graphql", `{"query": "mutation { deleteUser(id: \"x\") }"}`, "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should hold each batch method to its own scope", func() {
+		k := create(`{"name": "reader", "scopes": ["users:read"]}`, admin...)
+
+		w := do("POST", "/v1/users:batchGet", `{"ids": ["00000000-0000-0000-0000-000000000000"]}`, "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		w = do("POST", "/v1/users:batchCreate", `[{"email": "clark@mail.com", "password": "password"}]`, "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should stop anonymous writes once keys are issued", func() {
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		k := create(`{"name": "reader", "scopes": ["users:read"]}`, admin...)
+
+		w = do("POST", "/v1/users", `{"email": "lois@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/graphql", `{"query": "mutation { deleteUser(id: \"x\") }"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("GET", "/v1/users", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("DELETE", "/v1/api-keys/"+k.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("POST", "/v1/users", `{"email": "lois@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+	})
+
+	It("should not let keys grant scopes they do not hold", func() {
+		k := create(`{"name": "manager", "scopes": ["api-keys:manage", "users:read"]}`, admin...)
+
+		w := do("POST", "/v1/api-keys", `{"name": "writer", "scopes": ["users:write"]}`, "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Body.String()).To(ContainSubstring(`"field":"scopes"`))
+
+		create(`{"name": "reader", "scopes": ["users:read"]}`, "X-API-Key", k.Key)
+	})
+
+	It("should reject rotated, revoked, expired and unknown keys", func() {
+		k := create(`{"name": "sync job", "scopes": ["users:read"]}`, admin...)
+
+		w := do("POST", "/v1/api-keys/"+k.ID+":rotate", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var rotated apiKey
+		Expect(json.Unmarshal(w.Body.Bytes(), &rotated)).To(Succeed())
+		Expect(rotated.ID).To(Equal(k.ID))
+		Expect(rotated.Key).NotTo(Equal(k.Key))
+
+		w = do("GET", "/v1/users", "", "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("GET", "/v1/users", "", "X-API-Key", rotated.Key)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("DELETE", "/v1/api-keys/"+k.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "/v1/users", "", "X-API-Key", rotated.Key)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/api-keys/"+k.ID+":rotate", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/api-keys", `{"name": "old", "scopes": ["users:read"], "expires_at": "2000-01-01T00:00:00Z"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+
+		w = do("GET", "/v1/users", "", "X-API-Key", "not-a-key")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should bind keys to their organization", func() {
+		w := do("POST", "/v1/organizations", `{"slug": "acme", "name": "Acme"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		k := create(`{"name": "acme job", "scopes": ["users:read"]}`, append(admin, "X-Organization", "acme")...)
+
+		w = do("GET", "/v1/users", "", "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("GET", "/v1/users", "", "X-API-Key", k.Key, "X-Organization", "00000000-0000-0000-0000-000000000001")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("GET", "/v1/api-keys/"+k.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should reject anonymous requests when authentication is required", func() {
+		app.RequireAuth = true
+		e = app.NewServer()
+
+		w := do("GET", "/v1/users", "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		k := create(`{"name": "sync job", "scopes": ["users:read"]}`, admin...)
+
+		w = do("GET", "/v1/users", "", "X-API-Key", k.Key)
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+})
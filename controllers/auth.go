@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+const (
+	headerAPIKey = "X-API-Key"
+
+	contextPrincipal = "principal"
+
+	// apiKeyTouchInterval is how stale last_used_at may get before a use of
+	// the key records it again
+	apiKeyTouchInterval = time.Minute
+)
+
+// Scopes are the permissions route policies check
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeAPIKeys    = "api-keys:manage"
+	// ScopeAdmin covers the organization admin endpoints. Only the admin
+	// token holds it
+	ScopeAdmin = "admin"
+)
+
+// grantableScopes are the scopes an API key may be given
+var grantableScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAPIKeys}
+
+// userScopes are the scopes of users logged in with a password
+var userScopes = []string{ScopeUsersRead}
+
+// Principal kinds
+const (
+	PrincipalAdmin  = "admin"
+	PrincipalAPIKey = "api_key"
+	PrincipalUser   = "user"
+)
+
+// Principal is the authenticated caller of a request, whatever credential
+// it presented
+type Principal struct {
+	Kind string
+	// ID identifies the credential, e.g. the id of an API key, or the user
+	ID string
+	// OrgID is the organization the principal belongs to, if it is bound to
+	// one
+	OrgID string
+	// SessionID is the session of a user logged in with an access token
+	SessionID string
+	Scopes    []string
+}
+
+// Has reports whether p was granted scope. Admins hold every scope
+func (p *Principal) Has(scope string) bool {
+	if p.Kind == PrincipalAdmin {
+		return true
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func principalOf(c echo.Context) *Principal {
+	p, _ := c.Get(contextPrincipal).(*Principal)
+	return p
+}
+
+// authenticate builds the principal of requests bearing the admin token or
+// an API key, in the X-API-Key header or as an Authorization bearer token,
+// or an access token issued at login. Invalid API keys and access tokens
+// are rejected; other bearer tokens are left to tenancy, and requests
+// without credentials go on anonymously for the route policies to judge
+func (app *Config) authenticate() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+
+			token := r.Header.Get(headerAPIKey)
+			fromHeader := token != ""
+			if !fromHeader {
+				auth := r.Header.Get(echo.HeaderAuthorization)
+				if t := strings.TrimPrefix(auth, "Bearer "); t != auth {
+					token = t
+				}
+			}
+
+			if token == "" {
+				return next(c)
+			}
+
+			if app.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(app.AdminToken)) == 1 {
+				c.Set(contextPrincipal, &Principal{Kind: PrincipalAdmin})
+				return next(c)
+			}
+
+			if _, ok := data.APIKeyPrefix(token); ok || fromHeader {
+				p, err := app.apiKeyPrincipal(token)
+				if err != nil {
+					return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid API key"})
+				}
+
+				c.Set(contextPrincipal, p)
+				return next(c)
+			}
+
+			if !fromHeader {
+				p, err := app.accessTokenPrincipal(token)
+				if err != nil {
+					return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid token"})
+				}
+
+				if p != nil {
+					c.Set(contextPrincipal, p)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func (app *Config) apiKeyPrincipal(token string) (*Principal, error) {
+	prefix, ok := data.APIKeyPrefix(token)
+	if !ok || app.APIKeys == nil {
+		return nil, errInvalidCredentials
+	}
+
+	k, err := app.APIKeys.GetByPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !k.Verify(token) || !k.Active(now) {
+		return nil, errInvalidCredentials
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > apiKeyTouchInterval {
+		// last_used_at is informative, so failing to record it does not fail
+		// the request
+		_ = app.APIKeys.Touch(k.ID, now)
+	}
+
+	return &Principal{Kind: PrincipalAPIKey, ID: k.ID, OrgID: k.OrgID, Scopes: k.Scopes}, nil
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// allow is the policy of the users API: principals need scope, and
+// anonymous requests are let through unless RequireAuth is set. Once the
+// organization has issued API keys, anonymous requests may only read, or
+// a key refused users:write could be dropped to write anonymously
+func (app *Config) allow(scope string) echo.MiddlewareFunc {
+	if app.RequireAuth || scope == ScopeUsersRead {
+		return app.policy(scope, !app.RequireAuth)
+	}
+
+	open, closed := app.policy(scope, true), app.require(scope)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		open, closed := open(next), closed(next)
+		return func(c echo.Context) error {
+			if principalOf(c) == nil && app.keysIssued(c) {
+				return closed(c)
+			}
+
+			return open(c)
+		}
+	}
+}
+
+// keysIssued reports whether the organization of the request holds an API
+// key in use. Keys that cannot be listed count as issued, which errs on
+// the side of refusing anonymous writes
+func (app *Config) keysIssued(c echo.Context) bool {
+	if app.APIKeys == nil {
+		return false
+	}
+
+	keys, err := scoped(c, app.APIKeys).GetAll()
+	if err != nil {
+		return true
+	}
+
+	now := time.Now()
+	for _, k := range keys {
+		if k.Active(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// self lets through only the user named by the path parameter param, e.g.
+// for the endpoints a user manages their own account with
+func (app *Config) self(param string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := principalOf(c)
+			switch {
+			case p == nil:
+				return c.JSON(http.StatusUnauthorized, errorResponse{Error: "missing or invalid token"})
+			case p.Kind != PrincipalUser || p.ID != c.Param(param):
+				return c.JSON(http.StatusForbidden, errorResponse{Error: "only the user may do this"})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// selfOr lets through the user named by the path parameter param, like
+// self, and the principals holding scope
+func (app *Config) selfOr(param, scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := principalOf(c)
+			switch {
+			case p == nil:
+				return c.JSON(http.StatusUnauthorized, errorResponse{Error: "missing or invalid token"})
+			case p.Kind == PrincipalUser && p.ID == c.Param(param):
+			case !p.Has(scope):
+				return c.JSON(http.StatusForbidden, errorResponse{Error: "missing scope " + scope})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// require lets through only principals holding scope
+func (app *Config) require(scope string) echo.MiddlewareFunc {
+	return app.policy(scope, false)
+}
+
+func (app *Config) policy(scope string, anonymous bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := principalOf(c)
+			switch {
+			case p == nil && anonymous:
+			case p == nil:
+				return c.JSON(http.StatusUnauthorized, errorResponse{Error: "missing or invalid token"})
+			case !p.Has(scope):
+				return c.JSON(http.StatusForbidden, errorResponse{Error: "missing scope " + scope})
+			}
+
+			return next(c)
+		}
+	}
+}
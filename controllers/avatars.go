@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/storage"
+	"github.com/danielboakye/go-echo-app/thumbnail"
+	"github.com/labstack/echo"
+)
+
+const (
+	// defaultAvatarMaxBytes is the largest image accepted when
+	// AvatarMaxBytes is not set
+	defaultAvatarMaxBytes = 5 << 20
+	// avatarMaxSide is the widest and tallest image accepted, in pixels,
+	// which bounds the memory decoding takes
+	avatarMaxSide = 4096
+	// multipartOverhead is the room left for the headers and boundaries
+	// of the form around the image
+	multipartOverhead = 64 << 10
+
+	// avatarSizeOriginal names the image as it was uploaded
+	avatarSizeOriginal = "original"
+	// avatarSizeDefault is served when no size is asked for
+	avatarSizeDefault = "256"
+
+	headerLastModified = "Last-Modified"
+)
+
+// avatarSizes are the square thumbnails made of each upload, by name
+var avatarSizes = map[string]int{"64": 64, "256": 256}
+
+// avatarTypes are the image types accepted, as sniffed from their content
+var avatarTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// putAvatar replaces the avatar of a user with the image in the avatar part
+// of a multipart form. Thumbnails are made up front, and the previous
+// images are removed once the new ones are in place
+func (app *Config) putAvatar(c echo.Context) error {
+	id := c.Param("id")
+
+	if _, err := app.users(c).GetOne(id); err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	img, status, err := app.readAvatar(c)
+	if err != nil {
+		return c.JSON(status, errorResponse{Error: err.Error()})
+	}
+
+	contentType := http.DetectContentType(img)
+	if !avatarTypes[contentType] {
+		return c.JSON(http.StatusUnsupportedMediaType, errorResponse{Error: "avatar must be a JPEG, PNG or GIF image"})
+	}
+
+	decoded, format, err := thumbnail.Decode(img, avatarMaxSide)
+	if errors.Is(err, thumbnail.ErrTooLarge) {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{
+			Error:   "invalid avatar",
+			Details: []fieldError{{Field: "avatar", Message: "must be at most " + strconv.Itoa(avatarMaxSide) + " pixels wide and high"}},
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{
+			Error:   "invalid avatar",
+			Details: []fieldError{{Field: "avatar", Message: "is not a valid image"}},
+		})
+	}
+
+	sum := sha256.Sum256(img)
+	version := hex.EncodeToString(sum[:8])
+
+	ctx := c.Request().Context()
+	if err := app.Blobs.Put(ctx, avatarKey(id, version, avatarSizeOriginal), contentType, bytes.NewReader(img)); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	for name, side := range avatarSizes {
+		var thumb bytes.Buffer
+		thumbType, err := thumbnail.Encode(&thumb, thumbnail.Square(decoded, side), format)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+
+		if err := app.Blobs.Put(ctx, avatarKey(id, version, name), thumbType, &thumb); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+		}
+	}
+
+	previous, err := scoped(c, app.Avatars).GetByUser(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.Avatars).Put(data.Avatar{UserID: id, Version: version, ContentType: contentType}); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	// an upload of the same image keeps its version, and so its images
+	if previous != nil && previous.Version != version {
+		app.removeAvatarBlobs(c, id, previous.Version)
+	}
+
+	user, err := app.users(c).GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := newUserV1(user)
+	if err := app.expandUsers(c, &res); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// readAvatar reads the avatar part of the multipart form of the request,
+// and returns the status to answer with when it cannot
+func (app *Config) readAvatar(c echo.Context) ([]byte, int, error) {
+	max := app.AvatarMaxBytes
+	if max <= 0 {
+		max = defaultAvatarMaxBytes
+	}
+
+	r := c.Request()
+	r.Body = http.MaxBytesReader(c.Response(), r.Body, max+multipartOverhead)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("avatar must be sent as multipart/form-data")
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, http.StatusBadRequest, errors.New("avatar is required")
+		}
+		if err != nil {
+			return nil, uploadErrorStatus(err), errors.New("bad request")
+		}
+
+		if part.FormName() != "avatar" {
+			part.Close()
+			continue
+		}
+
+		img, err := io.ReadAll(io.LimitReader(part, max+1))
+		part.Close()
+		if err != nil {
+			return nil, uploadErrorStatus(err), errors.New("bad request")
+		}
+		if int64(len(img)) > max {
+			return nil, http.StatusRequestEntityTooLarge, errors.New("avatar must be at most " + strconv.FormatInt(max, 10) + " bytes")
+		}
+		if len(img) == 0 {
+			return nil, http.StatusBadRequest, errors.New("avatar is required")
+		}
+
+		return img, http.StatusOK, nil
+	}
+}
+
+// uploadErrorStatus tells bodies over the limit of the request apart from
+// malformed ones
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// getAvatar serves one size of the avatar of a user. The URL in the user
+// response names the version, so responses to it never change and may be
+// cached for good; others are revalidated with their ETag
+func (app *Config) getAvatar(c echo.Context) error {
+	id := c.Param("id")
+
+	size := c.QueryParam("size")
+	if size == "" {
+		size = avatarSizeDefault
+	}
+	if _, ok := avatarSizes[size]; !ok && size != avatarSizeOriginal {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "unknown size"})
+	}
+
+	avatar, err := scoped(c, app.Avatars).GetByUser(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "avatar not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	visibility := "public"
+	if app.RequireAuth || app.Organizations != nil {
+		visibility = "private"
+	}
+
+	h := c.Response().Header()
+	h.Set(headerETag, `"`+avatar.Version+"-"+size+`"`)
+	if c.QueryParam("v") == avatar.Version {
+		h.Set(headerCacheControl, visibility+", max-age=31536000, immutable")
+	} else {
+		h.Set(headerCacheControl, visibility+", no-cache")
+	}
+	h.Set(headerLastModified, avatar.CreatedAt.UTC().Format(http.TimeFormat))
+	if app.Organizations != nil {
+		h.Add(headerVary, headerOrganization)
+		h.Add(headerVary, echo.HeaderAuthorization)
+	}
+
+	if etagMatches(c.Request().Header.Get(headerIfNoneMatch), h.Get(headerETag)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	rc, info, err := app.Blobs.Get(c.Request().Context(), avatarKey(id, avatar.Version, size))
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "avatar not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	defer rc.Close()
+
+	h.Set(echo.HeaderContentLength, strconv.FormatInt(info.Size, 10))
+	h.Set(echo.HeaderXContentTypeOptions, "nosniff")
+
+	return c.Stream(http.StatusOK, info.ContentType, rc)
+}
+
+func (app *Config) deleteAvatar(c echo.Context) error {
+	id := c.Param("id")
+
+	avatar, err := scoped(c, app.Avatars).GetByUser(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "avatar not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.Avatars).DeleteByUser(id); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	app.removeAvatarBlobs(c, id, avatar.Version)
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// removeAvatarBlobs deletes the images of a version of an avatar. They are
+// no longer referenced, so failures are only logged
+func (app *Config) removeAvatarBlobs(c echo.Context, userID, version string) {
+	for _, name := range avatarBlobSizes() {
+		if err := app.Blobs.Delete(c.Request().Context(), avatarKey(userID, version, name)); err != nil {
+			c.Logger().Warnf("avatars: removing %s of user %s: %v", name, userID, err)
+		}
+	}
+}
+
+// avatarBlobSizes names the images stored for each version of an avatar
+func avatarBlobSizes() []string {
+	names := []string{avatarSizeOriginal}
+	for name := range avatarSizes {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// attachAvatars sets the avatar URLs of users for their response
+func (app *Config) attachAvatars(c echo.Context, users ...*userV1) error {
+	if !app.avatarsEnabled() || len(users) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+
+	avatars, err := scoped(c, app.Avatars).GetMany(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if a, ok := avatars[u.ID]; ok {
+			u.AvatarURL = avatarURL(u.ID, a.Version)
+		}
+	}
+
+	return nil
+}
+
+// avatarsEnabled reports whether avatars are served, which takes both a
+// blob store for the images and a repository for their versions
+func (app *Config) avatarsEnabled() bool {
+	return app.Blobs != nil && app.Avatars != nil
+}
+
+// avatarKey is the blob store key of one size of a version of an avatar
+func avatarKey(userID, version, size string) string {
+	return "avatars/" + userID + "/" + version + "/" + size
+}
+
+// avatarURL is the URL of a version of an avatar, which may be cached for
+// good
+func avatarURL(userID, version string) string {
+	return "/v1/users/" + userID + "/avatar?v=" + version
+}
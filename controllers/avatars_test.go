@@ -0,0 +1,169 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/storage"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("avatars", func() {
+
+	type user struct {
+		ID        string `json:"user_id"`
+		AvatarURL string `json:"avatar_url"`
+	}
+
+	var (
+		e     *echo.Echo
+		blobs *storage.Memory
+		clark user
+	)
+
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	do := func(method, target, contentType string, body []byte, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// form returns a multipart form with file as its avatar part
+	form := func(file []byte) (string, []byte) {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		part, err := mw.CreateFormFile("avatar", "me.png")
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = part.Write(file)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(mw.Close()).To(Succeed())
+
+		return mw.FormDataContentType(), b.Bytes()
+	}
+
+	pngOf := func(width, height int, c color.Color) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, c)
+			}
+		}
+
+		var b bytes.Buffer
+		Expect(png.Encode(&b, img)).To(Succeed())
+
+		return b.Bytes()
+	}
+
+	upload := func(file []byte, headers ...string) *httptest.ResponseRecorder {
+		contentType, body := form(file)
+
+		return do("PUT", "/v1/users/"+clark.ID+"/avatar", contentType, body, headers...)
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		blobs = storage.NewMemory()
+		app.Blobs = blobs
+		app.AvatarMaxBytes = 64 << 10
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", "application/json", []byte(`{"email": "clark@mail.com", "password": "password"}`))
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(json.Unmarshal(w.Body.Bytes(), &clark)).To(Succeed())
+		Expect(clark.AvatarURL).To(BeEmpty())
+	})
+
+	It("should upload, serve, replace and remove an avatar", func() {
+		w := upload(pngOf(300, 200, color.RGBA{R: 255, A: 255}), admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var res user
+		Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+		Expect(res.AvatarURL).To(HavePrefix("/v1/users/" + clark.ID + "/avatar?v="))
+		Expect(blobs.Keys()).To(HaveLen(3))
+
+		w = do("GET", "/v1/users/"+clark.ID, "", nil)
+		Expect(w.Body.String()).To(ContainSubstring(`"avatar_url":"` + res.AvatarURL + `"`))
+
+		w = do("GET", res.AvatarURL+"&size=64", "", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("image/png"))
+		Expect(w.Header().Get("Cache-Control")).To(ContainSubstring("immutable"))
+		Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		thumb, err := png.Decode(w.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(thumb.Bounds().Dx()).To(Equal(64))
+		Expect(thumb.Bounds().Dy()).To(Equal(64))
+
+		w = do("GET", "/v1/users/"+clark.ID+"/avatar", "", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Cache-Control")).To(ContainSubstring("no-cache"))
+		etag := w.Header().Get("ETag")
+		Expect(etag).NotTo(BeEmpty())
+
+		w = do("GET", "/v1/users/"+clark.ID+"/avatar", "", nil, "If-None-Match", etag)
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+
+		w = upload(pngOf(10, 10, color.RGBA{B: 255, A: 255}), admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var replaced user
+		Expect(json.Unmarshal(w.Body.Bytes(), &replaced)).To(Succeed())
+		Expect(replaced.AvatarURL).NotTo(Equal(res.AvatarURL))
+		Expect(blobs.Keys()).To(HaveLen(3))
+
+		w = do("GET", "/v1/users/"+clark.ID+"/avatar", "", nil, "If-None-Match", etag)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("DELETE", "/v1/users/"+clark.ID+"/avatar", "", nil, admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Expect(blobs.Keys()).To(BeEmpty())
+
+		w = do("GET", "/v1/users/"+clark.ID+"/avatar", "", nil)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should reject uploads that are not small images", func() {
+		w := upload(pngOf(10, 10, color.Black))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = upload([]byte("<html><body>hi</body></html>"), admin...)
+		Expect(w.Code).To(Equal(http.StatusUnsupportedMediaType), w.Body.String())
+
+		w = upload(bytes.Repeat([]byte{0}, 65<<10), admin...)
+		Expect(w.Code).To(Equal(http.StatusRequestEntityTooLarge), w.Body.String())
+
+		w = upload(pngOf(5000, 1, color.Black), admin...)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring("4096 pixels"))
+
+		w = upload(pngOf(10, 10, color.Black)[:40], admin...)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity), w.Body.String())
+
+		w = do("PUT", "/v1/users/"+clark.ID+"/avatar", "multipart/form-data; boundary=x", []byte("--x--\r\n"), admin...)
+		Expect(w.Code).To(Equal(http.StatusBadRequest), w.Body.String())
+
+		w = do("PUT", "/v1/users/"+clark.ID+"/avatar", "application/json", []byte(strings.Repeat("x", 10)), admin...)
+		Expect(w.Code).To(Equal(http.StatusBadRequest), w.Body.String())
+
+		Expect(blobs.Keys()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,503 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+const (
+	maxBatchCreateSize = 500
+	maxBatchGetSize    = 100
+	exportFlushEvery   = 100
+
+	// maxBatchBodyBytes bounds the body of an import whatever its format
+	maxBatchBodyBytes = 8 << 20
+
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best_effort"
+)
+
+var csvExportHeader = []string{
+	"user_id", "email", "first_name", "last_name", "active", "created_at", "updated_at",
+}
+
+type batchRowResult struct {
+	Row    int          `json:"row"`
+	Email  string       `json:"email,omitempty"`
+	UserID string       `json:"user_id,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+type batchCreateResponse struct {
+	Mode    string           `json:"mode"`
+	Created int              `json:"created"`
+	Failed  int              `json:"failed"`
+	Results []batchRowResult `json:"results"`
+}
+
+type batchGetRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// batchGetResponse holds the users found, in the order they were asked for,
+// and the ids that matched no user
+type batchGetResponse struct {
+	Users   []userV1 `json:"users"`
+	Missing []string `json:"missing"`
+}
+
+// batchRow is one decoded row of an import along with its decode errors
+type batchRow struct {
+	user data.User
+	errs []fieldError
+}
+
+func (app *Config) batchGetUsers(c echo.Context) error {
+	var req batchGetRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	if len(req.IDs) == 0 {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "empty batch"})
+	}
+
+	if len(req.IDs) > maxBatchGetSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, errorResponse{Error: "batch too large"})
+	}
+
+	users, missing, err := app.users(c).GetMany(c.Request().Context(), req.IDs)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := batchGetResponse{Users: make([]userV1, len(users)), Missing: missing}
+	refs := make([]*userV1, len(users))
+	for i, u := range users {
+		res.Users[i] = newUserV1(u)
+		refs[i] = &res.Users[i]
+	}
+	if err := app.expandUsers(c, refs...); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if res.Missing == nil {
+		res.Missing = []string{}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (app *Config) batchCreateUsers(c echo.Context) error {
+	mode := c.QueryParam("mode")
+	if mode == "" {
+		mode = batchModeAtomic
+	}
+
+	if mode != batchModeAtomic && mode != batchModeBestEffort {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid mode"})
+	}
+	allOrNothing := mode == batchModeAtomic
+
+	r := c.Request()
+	r.Body = http.MaxBytesReader(c.Response(), r.Body, maxBatchBodyBytes)
+
+	rows, err := decodeBatch(r)
+	if err != nil {
+		return c.JSON(uploadErrorStatus(err), errorResponse{Error: batchErrorMessage(err)})
+	}
+
+	if len(rows) == 0 {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "empty batch"})
+	}
+
+	if len(rows) > maxBatchCreateSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, errorResponse{Error: "batch too large"})
+	}
+
+	resp := batchCreateResponse{Mode: mode, Results: make([]batchRowResult, len(rows))}
+	seen := make(map[string]bool, len(rows))
+
+	var valid []data.User
+	for i, row := range rows {
+		res := batchRowResult{Row: i + 1, Email: row.user.Email, Errors: row.errs}
+		if res.Errors == nil {
+			res.Errors = validateUser(row.user)
+		}
+
+		if len(res.Errors) == 0 {
+			if seen[row.user.Email] {
+				res.Errors = append(res.Errors, fieldError{Field: "email", Message: "is duplicated in the batch"})
+			} else {
+				seen[row.user.Email] = true
+				valid = append(valid, row.user)
+			}
+		}
+
+		resp.Results[i] = res
+	}
+
+	if allOrNothing && len(valid) != len(rows) {
+		return c.JSON(http.StatusUnprocessableEntity, resp.tally())
+	}
+
+	ids, err := app.users(c).InsertMany(valid, allOrNothing)
+	if err != nil && !errors.Is(err, data.ErrBatchConflict) {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	for i, res := range resp.Results {
+		if len(res.Errors) > 0 {
+			continue
+		}
+
+		id, ok := ids[res.Email]
+		switch {
+		case !ok:
+			resp.Results[i].Errors = []fieldError{{Field: "email", Message: "user exists"}}
+		case err == nil:
+			resp.Results[i].UserID = id
+		}
+	}
+
+	resp = resp.tally()
+
+	switch {
+	case err != nil:
+		return c.JSON(http.StatusUnprocessableEntity, resp)
+	case resp.Failed > 0:
+		return c.JSON(http.StatusMultiStatus, resp)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// tally counts created and failed rows
+func (r batchCreateResponse) tally() batchCreateResponse {
+	r.Created, r.Failed = 0, 0
+	for _, res := range r.Results {
+		switch {
+		case len(res.Errors) > 0:
+			r.Failed++
+		case res.UserID != "":
+			r.Created++
+		}
+	}
+
+	return r
+}
+
+// decodeBatch reads the users of an import from a JSON array, NDJSON or CSV
+// body, or from the "file" field of a multipart upload
+func decodeBatch(r *http.Request) ([]batchRow, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return nil, errors.New("invalid content type")
+	}
+
+	body := io.Reader(r.Body)
+
+	if mediaType == echo.MIMEMultipartForm {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, batchError(err, "missing file")
+		}
+		defer file.Close()
+
+		body = file
+		mediaType = header.Header.Get(echo.HeaderContentType)
+		switch strings.ToLower(filepath.Ext(header.Filename)) {
+		case ".csv":
+			mediaType = mimeCSV
+		case ".ndjson", ".jsonl":
+			mediaType = mimeNDJSON
+		case ".json":
+			mediaType = echo.MIMEApplicationJSON
+		}
+	}
+
+	switch mediaType {
+	case echo.MIMEApplicationJSON:
+		return decodeJSONBatch(body)
+	case mimeNDJSON, "application/ndjson", "application/jsonl":
+		return decodeNDJSONBatch(body)
+	case mimeCSV:
+		return decodeCSVBatch(body)
+	}
+
+	return nil, errors.New("unsupported content type")
+}
+
+// decodeJSONBatch streams the elements of the array and, like the other
+// formats, stops reading once there is one row too many
+func decodeJSONBatch(body io.Reader) ([]batchRow, error) {
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	if err != nil || tok != json.Delim('[') {
+		return nil, batchError(err, "body must be a JSON array")
+	}
+
+	var rows []batchRow
+	for dec.More() {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			return nil, batchError(err, "body must be a JSON array")
+		}
+
+		rows = append(rows, decodeJSONRow(msg))
+		if len(rows) > maxBatchCreateSize {
+			return rows, nil
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, batchError(err, "body must be a JSON array")
+	}
+
+	return rows, nil
+}
+
+func decodeNDJSONBatch(body io.Reader) ([]batchRow, error) {
+	var rows []batchRow
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		rows = append(rows, decodeJSONRow(line))
+		if len(rows) > maxBatchCreateSize {
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, batchError(err, "invalid NDJSON body")
+	}
+
+	return rows, nil
+}
+
+// batchError describes why an import could not be read. Bodies cut off at
+// maxBatchBodyBytes keep their error, which uploadErrorStatus recognizes
+func batchError(err error, msg string) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+
+	return errors.New(msg)
+}
+
+// batchErrorMessage is the message answered for an import that could not be
+// read
+func batchErrorMessage(err error) string {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "batch too large"
+	}
+
+	return err.Error()
+}
+
+func decodeJSONRow(msg []byte) batchRow {
+	var (
+		row batchRow
+		in  userInputV1
+	)
+	if err := json.Unmarshal(msg, &in); err != nil {
+		row.errs = []fieldError{{Field: "row", Message: "is not a valid JSON object"}}
+	} else if in.Active != 0 && in.Active != 1 {
+		row.errs = []fieldError{{Field: "active", Message: "must be 0 or 1"}}
+	}
+	row.user = in.user()
+
+	return row
+}
+
+func decodeCSVBatch(body io.Reader) ([]batchRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, batchError(err, "missing CSV header")
+	}
+
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		switch header[i] {
+		case "email", "first_name", "last_name", "password", "active":
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+	}
+
+	var rows []batchRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, batchError(err, "invalid CSV body")
+		}
+
+		var row batchRow
+		if len(record) != len(header) {
+			row.errs = []fieldError{{Field: "row", Message: "has the wrong number of columns"}}
+		}
+
+		for i := 0; i < len(record) && i < len(header); i++ {
+			switch header[i] {
+			case "email":
+				row.user.Email = record[i]
+			case "first_name":
+				row.user.FirstName = record[i]
+			case "last_name":
+				row.user.LastName = record[i]
+			case "password":
+				row.user.Password = record[i]
+			case "active":
+				if record[i] == "" {
+					continue
+				}
+
+				active, err := strconv.Atoi(record[i])
+				if err != nil || (active != 0 && active != 1) {
+					row.errs = append(row.errs, fieldError{Field: "active", Message: "must be 0 or 1"})
+				}
+				row.user.Status = data.StatusOf(active == 1)
+			}
+		}
+
+		rows = append(rows, row)
+		if len(rows) > maxBatchCreateSize {
+			break
+		}
+	}
+
+	return rows, nil
+}
+
+func (app *Config) exportUsers(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeNDJSON) {
+			format = "ndjson"
+		}
+	}
+
+	res := c.Response()
+
+	var (
+		contentType string
+		begin       func() error
+		write       func(*data.User) error
+		flush       func() error
+	)
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(res)
+		contentType = mimeCSV + "; charset=UTF-8"
+		begin = func() error { return w.Write(csvExportHeader) }
+		write = func(u *data.User) error {
+			return w.Write([]string{
+				u.ID, u.Email, u.FirstName, u.LastName, strconv.Itoa(activeV1(u.Status)),
+				u.CreatedAt.Format(time.RFC3339), u.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	case "ndjson":
+		enc := json.NewEncoder(res)
+		contentType = mimeNDJSON
+		begin = func() error { return nil }
+		write = func(u *data.User) error { return enc.Encode(newUserV1(u)) }
+		flush = func() error { return nil }
+	default:
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid format"})
+	}
+
+	// the status line is only sent once the first row has been read, so that
+	// a failing query can still be reported as an error
+	started := false
+	start := func() error {
+		started = true
+		res.Header().Set(echo.HeaderContentType, contentType)
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "users."+format))
+		res.WriteHeader(http.StatusOK)
+
+		return begin()
+	}
+
+	n := 0
+	err := app.users(c).Stream(func(u *data.User) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		if err := write(u); err != nil {
+			return err
+		}
+
+		n++
+		if n%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+
+		return nil
+	})
+
+	if err == nil && !started {
+		err = start()
+	}
+
+	if err != nil && !started {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	if err == nil {
+		err = flush()
+	}
+
+	if err != nil {
+		// the response is already under way, all that is left is to cut it short
+		c.Logger().Error(err)
+		return nil
+	}
+
+	res.Flush()
+
+	return nil
+}
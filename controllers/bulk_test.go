@@ -0,0 +1,335 @@
+package controllers_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type batchResult struct {
+	Mode    string `json:"mode"`
+	Created int    `json:"created"`
+	Failed  int    `json:"failed"`
+	Results []struct {
+		Row    int    `json:"row"`
+		UserID string `json:"user_id"`
+		Errors []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"results"`
+}
+
+var _ = Describe("batch create Users", func() {
+
+	var (
+		resp   *http.Response
+		result batchResult
+	)
+
+	send := func(app http.Handler, url, contentType, body string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", url, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		app.ServeHTTP(w, r)
+
+		resp = w.Result()
+		b, err := io.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		result = batchResult{}
+		Expect(json.Unmarshal(b, &result)).To(Succeed())
+	}
+
+	Context("JSON array", func() {
+		BeforeEach(func() {
+			app, mockDB := newTestApp()
+
+			mockDB.ExpectBegin()
+			mockDB.ExpectQuery(`
+					INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at)
+					VALUES ($1,$2,$3,$4,$5,$6,$7),($8,$9,$10,$11,$12,$13,$14)
+					ON CONFLICT (org_id, email) DO NOTHING RETURNING user_id, email`,
+			).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"user_id", "email"}).
+						AddRow("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "clark@mail.com").
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "lois@mail.com"),
+				)
+			mockDB.ExpectCommit()
+
+			send(app.NewServer(), "http:/users:batchCreate", "application/json", `[
+				{"email": "clark@mail.com", "first_name": "Clark", "last_name": "Kent", "password": "password", "active": 1},
+				{"email": "lois@mail.com", "first_name": "Lois", "last_name": "Lane", "password": "password", "active": 1}
+			]`)
+		})
+
+		It("should set correct status code", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		})
+
+		It("should report every created row", func() {
+			Expect(result.Created).To(Equal(2))
+			Expect(result.Results[1].UserID).To(Equal("ae17b2e2-6b87-4c5b-9c94-3623dacf113b"))
+		})
+	})
+
+	Context("JSON array with too many rows", func() {
+		BeforeEach(func() {
+			app, _ := newTestApp()
+
+			rows := strings.TrimSuffix(strings.Repeat(`{"email": "clark@mail.com"},`, 501), ",")
+			send(app.NewServer(), "http:/users:batchCreate", "application/json", "["+rows+"]")
+		})
+
+		It("should reject the batch", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+	})
+
+	Context("body over the size limit", func() {
+		BeforeEach(func() {
+			app, _ := newTestApp()
+
+			send(app.NewServer(), "http:/users:batchCreate", "text/csv",
+				"email,first_name\nclark@mail.com,"+strings.Repeat("x", 9<<20)+"\n")
+		})
+
+		It("should reject the batch", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+	})
+
+	Context("CSV with an invalid row, best effort", func() {
+		BeforeEach(func() {
+			app, mockDB := newTestApp()
+
+			mockDB.ExpectBegin()
+			mockDB.ExpectQuery(`
+					INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at)
+					VALUES ($1,$2,$3,$4,$5,$6,$7)
+					ON CONFLICT (org_id, email) DO NOTHING RETURNING user_id, email`,
+			).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"user_id", "email"}).
+						AddRow("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "clark@mail.com"),
+				)
+			mockDB.ExpectCommit()
+
+			send(app.NewServer(), "http:/users:batchCreate?mode=best_effort", "text/csv",
+				"email,first_name,last_name,password,active\n"+
+					"clark@mail.com,Clark,Kent,password,1\n"+
+					"not-an-email,Lois,Lane,short,1\n")
+		})
+
+		It("should set correct status code", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusMultiStatus))
+		})
+
+		It("should report the results per row", func() {
+			Expect(result.Created).To(Equal(1))
+			Expect(result.Failed).To(Equal(1))
+			Expect(result.Results[1].Row).To(Equal(2))
+			Expect(result.Results[1].Errors).To(HaveLen(2))
+			Expect(result.Results[1].Errors[0].Field).To(Equal("email"))
+		})
+	})
+
+	Context("NDJSON with an invalid row, all or nothing", func() {
+		BeforeEach(func() {
+			app, _ := newTestApp()
+
+			send(app.NewServer(), "http:/users:batchCreate", "application/x-ndjson",
+				`{"email": "clark@mail.com", "password": "password", "active": 1}`+"\n"+
+					`{"email": "clark@mail.com", "password": "password", "active": 1}`+"\n")
+		})
+
+		It("should set correct status code", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should not create anything", func() {
+			Expect(result.Created).To(Equal(0))
+			Expect(result.Results[1].Errors[0].Message).To(Equal("is duplicated in the batch"))
+		})
+	})
+
+	When("an unknown custom method is called", func() {
+		It("should not be found", func() {
+			app, _ := newTestApp()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/users:batchDestroy", strings.NewReader(`[]`))
+			r.Header.Set("Content-Type", "application/json")
+			app.NewServer().ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
+
+var _ = Describe("batch get Users", func() {
+
+	var (
+		app  controllers.Config
+		resp *http.Response
+		body string
+	)
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+	})
+
+	send := func(reqBody string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/v1/users:batchGet", strings.NewReader(reqBody))
+		r.Header.Set("Content-Type", "application/json")
+		app.NewServer().ServeHTTP(w, r)
+
+		resp = w.Result()
+		body = w.Body.String()
+	}
+
+	It("should return the users found in order and list the missing ids", func() {
+		clark, err := app.Repo.Insert(data.User{Email: "clark@mail.com", LastName: "Kent", Password: "password"})
+		Expect(err).ShouldNot(HaveOccurred())
+		lois, err := app.Repo.Insert(data.User{Email: "lois@mail.com", LastName: "Lane", Password: "password"})
+		Expect(err).ShouldNot(HaveOccurred())
+		unknown := "00000000-0000-0000-0000-000000000000"
+
+		send(`{"ids": ["` + lois + `", "` + unknown + `", "` + clark + `", "` + lois + `", "nope"]}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		var result struct {
+			Users []struct {
+				ID       string `json:"user_id"`
+				Password string `json:"password"`
+			} `json:"users"`
+			Missing []string `json:"missing"`
+		}
+		Expect(json.Unmarshal([]byte(body), &result)).To(Succeed())
+
+		Expect(result.Users).To(HaveLen(2))
+		Expect(result.Users[0].ID).To(Equal(lois))
+		Expect(result.Users[1].ID).To(Equal(clark))
+		Expect(result.Users[0].Password).To(BeEmpty())
+		Expect(result.Missing).To(Equal([]string{unknown, "nope"}))
+	})
+
+	It("should return an empty result when nothing matches", func() {
+		send(`{"ids": ["00000000-0000-0000-0000-000000000000"]}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"users": [], "missing": ["00000000-0000-0000-0000-000000000000"]}`))
+	})
+
+	It("should reject empty batches", func() {
+		send(`{"ids": []}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject batches over the maximum size", func() {
+		ids := make([]string, 101)
+		for i := range ids {
+			ids[i] = `"00000000-0000-0000-0000-000000000000"`
+		}
+
+		send(`{"ids": [` + strings.Join(ids, ",") + `]}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(body).To(ContainSubstring("batch too large"))
+	})
+})
+
+var _ = Describe("export Users", func() {
+
+	var (
+		body string
+		resp *http.Response
+	)
+
+	export := func(url string, rows bool) {
+		app, mockDB := newTestApp()
+
+		q := mockDB.ExpectQuery(`
+					SELECT 
+						user_id, email, first_name, last_name, user_status, created_at, updated_at
+					FROM users
+					ORDER BY last_name ASC
+				`)
+		if rows {
+			created := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+			q.WillReturnRows(
+				sqlmock.NewRows(
+					[]string{
+						"user_id", "email", "first_name",
+						"last_name", "user_status",
+						"created_at", "updated_at",
+					},
+				).
+					AddRow(
+						"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "example@mail.com", "Clark",
+						"Kent", "active",
+						created, created,
+					),
+			)
+		} else {
+			q.WillReturnError(sql.ErrConnDone)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", url, nil)
+		app.NewServer().ServeHTTP(w, r)
+
+		resp = w.Result()
+		b, err := io.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		body = string(b)
+	}
+
+	Context("CSV", func() {
+		BeforeEach(func() {
+			export("http:/users/export", true)
+		})
+
+		It("should stream a CSV file", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/csv"))
+			Expect(body).To(Equal(
+				"user_id,email,first_name,last_name,active,created_at,updated_at\n" +
+					"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,example@mail.com,Clark,Kent,1,2023-04-01T12:00:00Z,2023-04-01T12:00:00Z\n",
+			))
+		})
+	})
+
+	Context("NDJSON", func() {
+		BeforeEach(func() {
+			export("http:/users/export?format=ndjson", true)
+		})
+
+		It("should stream one JSON object per line", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+			Expect(strings.Count(body, "\n")).To(Equal(1))
+			Expect(body).To(ContainSubstring(`"user_id":"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"`))
+		})
+	})
+
+	When("the query fails", func() {
+		BeforeEach(func() {
+			export("http:/users/export", false)
+		})
+
+		It("should set correct status code", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
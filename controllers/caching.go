@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	headerETag         = "ETag"
+	headerIfNoneMatch  = "If-None-Match"
+	headerCacheControl = "Cache-Control"
+	headerVary         = "Vary"
+)
+
+// conditionalGet tags successful responses with an ETag over their body and
+// answers requests whose If-None-Match holds that tag with 304 Not Modified.
+// Responses may be kept by the client for CacheMaxAge, after which they must
+// be revalidated; shared caches never store them
+func (app *Config) conditionalGet() echo.MiddlewareFunc {
+	cacheControl := "private, no-cache"
+	if app.CacheMaxAge > 0 {
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(app.CacheMaxAge.Seconds()))
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := c.Response()
+			w := &bufferedResponseWriter{ResponseWriter: res.Writer, status: http.StatusOK}
+			res.Writer = w
+
+			err := next(c)
+			res.Writer = w.ResponseWriter
+			if err != nil {
+				return err
+			}
+
+			if w.status != http.StatusOK {
+				w.ResponseWriter.WriteHeader(w.status)
+				_, err := w.ResponseWriter.Write(w.body.Bytes())
+				return err
+			}
+
+			sum := sha256.Sum256(w.body.Bytes())
+			etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+			h := w.Header()
+			h.Set(headerETag, etag)
+			h.Set(headerCacheControl, cacheControl)
+			h.Add(headerVary, echo.HeaderAccept)
+			if app.Organizations != nil {
+				h.Add(headerVary, headerOrganization)
+				h.Add(headerVary, echo.HeaderAuthorization)
+			}
+
+			if etagMatches(c.Request().Header.Get(headerIfNoneMatch), etag) {
+				h.Del(echo.HeaderContentType)
+				h.Del(echo.HeaderContentLength)
+				w.ResponseWriter.WriteHeader(http.StatusNotModified)
+				res.Status = http.StatusNotModified
+				return nil
+			}
+
+			w.ResponseWriter.WriteHeader(http.StatusOK)
+			_, err = w.ResponseWriter.Write(w.body.Bytes())
+			return err
+		}
+	}
+}
+
+// etagMatches applies the weak comparison of If-None-Match to etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bufferedResponseWriter holds back the status and body of a response so
+// that they can be replaced once the handler is done
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
@@ -0,0 +1,93 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conditional GET", func() {
+
+	var (
+		app    controllers.Config
+		server http.Handler
+		id     string
+	)
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+		server = app.NewServer()
+
+		var err error
+		id, err = app.Repo.Insert(data.User{Email: "clark@mail.com", LastName: "Kent", Password: "password"})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		server.ServeHTTP(w, r)
+
+		return w
+	}
+
+	It("should tag responses and answer matching revalidations with 304", func() {
+		w := get("/v1/users/"+id, "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("private, no-cache"))
+		etag := w.Header().Get("ETag")
+		Expect(etag).To(MatchRegexp(`^"[0-9a-f]{32}"$`))
+
+		w = get("/v1/users/"+id, etag)
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+		Expect(w.Body.Len()).To(BeZero())
+		Expect(w.Header().Get("ETag")).To(Equal(etag))
+
+		w = get("/v1/users/"+id, `"other", W/`+etag)
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+	})
+
+	It("should change the tag when the user changes", func() {
+		etag := get("/v1/users/"+id, "").Header().Get("ETag")
+
+		u, _ := app.Repo.GetOne(id)
+		u.LastName = "Lane"
+		Expect(app.Repo.Update(*u)).To(Succeed())
+
+		w := get("/v1/users/"+id, etag)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("ETag")).ToNot(Equal(etag))
+		Expect(w.Body.String()).To(ContainSubstring("Lane"))
+	})
+
+	It("should tag the list of users", func() {
+		w := get("/v1/users", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = get("/v1/users", w.Header().Get("ETag"))
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+	})
+
+	It("should not tag errors", func() {
+		w := get("/v1/users/00000000-0000-0000-0000-000000000000", "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Header().Get("ETag")).To(BeEmpty())
+		Expect(w.Body.String()).To(ContainSubstring("bad request"))
+	})
+
+	It("should let clients reuse responses for CacheMaxAge", func() {
+		app.CacheMaxAge = 30 * time.Second
+		server = app.NewServer()
+
+		w := get("/v1/users/"+id, "")
+		Expect(w.Header().Get("Cache-Control")).To(Equal("private, max-age=30"))
+	})
+})
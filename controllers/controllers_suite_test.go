@@ -1,11 +1,16 @@
 package controllers_test
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/danielboakye/go-echo-app/controllers"
 	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/danielboakye/go-echo-app/storage"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -27,3 +32,57 @@ func newTestApp() (
 
 	return app, mockDB
 }
+
+const testAdminToken = "admin-token"
+
+// newMemoryTestApp returns a multi-tenant app whose requests default to the
+// default organization
+func newMemoryTestApp() controllers.Config {
+	repo := data.NewMemoryRepository()
+
+	return controllers.Config{
+		Repo:                repo,
+		Organizations:       data.NewMemoryOrganizationRepository(repo),
+		DefaultOrganization: data.DefaultOrganizationID,
+		AdminToken:          testAdminToken,
+		APIKeys:             data.NewMemoryAPIKeyRepository(),
+		TokenSecret:         []byte("token-secret"),
+		TwoFactor:           data.NewMemoryTwoFactorRepository(),
+		Identities:          data.NewMemoryIdentityRepository(),
+		Sessions:            data.NewMemorySessionRepository(),
+		LoginEvents:         data.NewMemoryLoginEventRepository(),
+		Fields:              data.NewMemoryFieldRepository(),
+		Blobs:               storage.NewMemory(),
+		Avatars:             data.NewMemoryAvatarRepository(),
+		Groups:              data.NewMemoryGroupRepository(),
+		Invitations:         data.NewMemoryInvitationRepository(),
+		PrivacyJobs:         data.NewMemoryPrivacyJobRepository(),
+		Mailer:              mail.NewMemory(),
+		// wide enough for the steps of a spec to straddle a period
+		TOTPSkew:     2,
+		Issuer:       testIssuer,
+		SigningKey:   testSigningKey(),
+		OAuthClients: data.NewMemoryOAuthClientRepository(),
+		Consents:     data.NewMemoryConsentRepository(),
+		OAuthTokens:  data.NewMemoryOAuthTokenRepository(),
+	}
+}
+
+const testIssuer = "http://users.example.com"
+
+var (
+	signingKeyOnce sync.Once
+	signingKey     *rsa.PrivateKey
+)
+
+// testSigningKey generates the key of the authorization server once, as
+// generating one per app would slow the suite down
+func testSigningKey() *rsa.PrivateKey {
+	signingKeyOnce.Do(func() {
+		var err error
+		signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	return signingKey
+}
@@ -30,7 +30,7 @@ var _ = Describe("get all users", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, user_status, created_at, updated_at
 						FROM users
 						ORDER BY last_name ASC
 					`).
@@ -38,18 +38,18 @@ var _ = Describe("get all users", func() {
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "user_active",
+							"last_name", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "example@mail.com", "Clark",
-							"Kent", 1,
+							"Kent", "active",
 							time.Now(), time.Now(),
 						).
 						AddRow(
 							"ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example1@mail.com", "Lois",
-							"Lane", 0,
+							"Lane", "pending",
 							time.Now(), time.Now(),
 						),
 				)
@@ -77,7 +77,7 @@ var _ = Describe("get all users", func() {
 
 		It("should populate the fields correctly", func() {
 			Expect(u[0].ID).To(Equal("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"))
-			Expect(u[1].Active).To(Equal(0))
+			Expect(u[1].Status).To(Equal(data.StatusPending))
 			Expect(u[0].Email).ToNot(Equal(u[1].Email))
 		})
 
@@ -125,7 +125,7 @@ var _ = Describe("get User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
 						WHERE user_id = $1
 					`).
@@ -134,13 +134,13 @@ var _ = Describe("get User", func() {
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "password", "user_active",
+							"last_name", "password", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							uid, "example@mail.com", "Clark",
-							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", 1,
+							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", "active",
 							time.Now(), time.Now(),
 						),
 				)
@@ -168,7 +168,7 @@ var _ = Describe("get User", func() {
 
 		It("should populate the fields correctly", func() {
 			Expect(u.ID).To(Equal(uid))
-			Expect(u.Active).To(Equal(1))
+			Expect(u.Status).To(Equal(data.StatusActive))
 		})
 
 	})
@@ -216,15 +216,15 @@ var _ = Describe("save User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
-						WHERE email = $1
+						WHERE email = $1 AND org_id = $2
 					`).
-				WithArgs(email).
+				WithArgs(email, data.DefaultOrganizationID).
 				WillReturnError(sql.ErrNoRows)
 
 			mockDB.ExpectQuery(`
-					INSERT INTO users (email,first_name,last_name,password,user_active,created_at,updated_at) 
+					INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at) 
 					VALUES ($1,$2,$3,$4,$5,$6,$7) 
 					RETURNING user_id`,
 			).
@@ -274,22 +274,22 @@ var _ = Describe("save User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
-						WHERE email = $1
+						WHERE email = $1 AND org_id = $2
 					`).
-				WithArgs(email).
+				WithArgs(email, data.DefaultOrganizationID).
 				WillReturnRows(
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "password", "user_active",
+							"last_name", "password", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", email, "Clark",
-							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", 1,
+							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", "active",
 							time.Now(), time.Now(),
 						),
 				)
@@ -331,15 +331,15 @@ var _ = Describe("save User", func() {
 			app, mockDB := newTestApp()
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
-						WHERE email = $1
+						WHERE email = $1 AND org_id = $2
 					`).
-				WithArgs(email).
+				WithArgs(email, data.DefaultOrganizationID).
 				WillReturnError(sql.ErrNoRows)
 
 			mockDB.ExpectQuery(`
-					INSERT INTO users (email,first_name,last_name,password,user_active,created_at,updated_at) 
+					INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at) 
 					VALUES ($1,$2,$3,$4,$5,$6,$7) 
 					RETURNING user_id`,
 			).
@@ -404,11 +404,11 @@ var _ = Describe("save User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
-						WHERE email = $1
+						WHERE email = $1 AND org_id = $2
 					`).
-				WithArgs(email).
+				WithArgs(email, data.DefaultOrganizationID).
 				WillReturnError(sql.ErrConnDone)
 
 			e := app.NewServer()
@@ -458,7 +458,7 @@ var _ = Describe("update User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
 						WHERE user_id = $1
 					`).
@@ -467,13 +467,13 @@ var _ = Describe("update User", func() {
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "password", "user_active",
+							"last_name", "password", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							uid, "example@mail.com", "Clark",
-							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", 1,
+							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", "active",
 							time.Now(), time.Now(),
 						),
 				)
@@ -482,9 +482,8 @@ var _ = Describe("update User", func() {
 						UPDATE users
 						SET
 							email = $1, first_name = $2,
-							last_name = $3, updated_at = $4,
-							user_active = $5
-						WHERE user_id = $6
+							last_name = $3, updated_at = $4
+						WHERE user_id = $5
 					`).
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -518,7 +517,7 @@ var _ = Describe("update User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
 						WHERE user_id = $1
 					`).
@@ -527,13 +526,13 @@ var _ = Describe("update User", func() {
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "password", "user_active",
+							"last_name", "password", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							uid, "example@mail.com", "Clark",
-							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", 1,
+							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", "active",
 							time.Now(), time.Now(),
 						),
 				)
@@ -542,9 +541,8 @@ var _ = Describe("update User", func() {
 						UPDATE users
 						SET
 							email = $1, first_name = $2,
-							last_name = $3, updated_at = $4,
-							user_active = $5
-						WHERE user_id = $6
+							last_name = $3, updated_at = $4
+						WHERE user_id = $5
 					`).
 				WillReturnError(sql.ErrConnDone)
 
@@ -578,7 +576,7 @@ var _ = Describe("update User", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
 						WHERE user_id = $1
 					`).
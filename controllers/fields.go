@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/openapi"
+	"github.com/labstack/echo"
+)
+
+func (app *Config) getAllFields(c echo.Context) error {
+	defs, err := scoped(c, app.Fields).GetAll()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]customFieldV1, len(defs))
+	for i, d := range defs {
+		res[i] = newCustomFieldV1(d)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (app *Config) getField(c echo.Context) error {
+	def, err := scoped(c, app.Fields).GetByName(c.Param("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "field not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newCustomFieldV1(def))
+}
+
+func (app *Config) saveField(c echo.Context) error {
+	var in customFieldInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	def := in.field()
+	if errs := data.ValidateFieldDefinition(def); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid field", Details: errs})
+	}
+
+	_, err := scoped(c, app.Fields).Insert(def)
+	if errors.Is(err, data.ErrDuplicateField) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "field already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	created, err := scoped(c, app.Fields).GetByName(def.Name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusCreated, newCustomFieldV1(created))
+}
+
+func (app *Config) updateField(c echo.Context) error {
+	var in customFieldInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	def, err := scoped(c, app.Fields).GetByName(c.Param("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "field not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	// values already stored were checked against the type, so it stays
+	if in.Type != "" && data.FieldType(in.Type) != def.Type {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{
+			Error:   "invalid field",
+			Details: []fieldError{{Field: "type", Message: "cannot be changed"}},
+		})
+	}
+
+	in.Name = def.Name
+	in.Type = string(def.Type)
+	update := in.field()
+	if errs := data.ValidateFieldDefinition(update); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid field", Details: errs})
+	}
+
+	if err := scoped(c, app.Fields).Update(update); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *Config) deleteField(c echo.Context) error {
+	err := scoped(c, app.Fields).DeleteByName(c.Param("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "field not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// fieldDefinitions returns the custom fields of the organization of the
+// request, none when custom fields are not configured
+func (app *Config) fieldDefinitions(c echo.Context) ([]*data.FieldDefinition, error) {
+	if app.Fields == nil {
+		return nil, nil
+	}
+
+	return scoped(c, app.Fields).GetAll()
+}
+
+// validateUserInput checks a submitted user and its attributes at once, so
+// that every problem of the submission is reported in one response, named
+// like those found by validateAgainstSpec. The built-in fields are checked
+// against the spec and, when they pass, by data.ValidateUser, or
+// data.ValidateProfile for updates
+func (app *Config) validateUserInput(c echo.Context, u data.User, attrs data.Attributes, create bool) ([]fieldError, error) {
+	defs, err := app.fieldDefinitions(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []fieldError
+	if errs := specErrorsOf(c); len(errs) > 0 {
+		found = errs
+	} else if create {
+		found = inBody(validateUser(u))
+	} else {
+		found = inBody(data.ValidateProfile(u))
+	}
+
+	return append(found, inBody(data.ValidateAttributes(defs, attrs, create))...), nil
+}
+
+// inBody names errs the way the spec names the fields of a request body
+func inBody(errs []fieldError) []fieldError {
+	for i := range errs {
+		errs[i].Field = "body." + errs[i].Field
+	}
+
+	return errs
+}
+
+// specErrorsOf returns the problems validateAgainstSpec left to the handler
+func specErrorsOf(c echo.Context) []fieldError {
+	found, _ := c.Get(contextSpecErrors).([]openapi.ValidationError)
+
+	errs := make([]fieldError, 0, len(found))
+	for _, e := range found {
+		errs = append(errs, fieldError{Field: e.Field, Message: e.Message})
+	}
+
+	return errs
+}
+
+// expandUsers adds what users have beyond their record to their response:
+// their attributes and the URL of their avatar
+func (app *Config) expandUsers(c echo.Context, users ...*userV1) error {
+	if err := app.attachAttributes(c, users...); err != nil {
+		return err
+	}
+
+	return app.attachAvatars(c, users...)
+}
+
+// attachAttributes sets the attributes of users for their response
+func (app *Config) attachAttributes(c echo.Context, users ...*userV1) error {
+	if app.Fields == nil || len(users) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+
+	attrs, err := scoped(c, app.Fields).Attributes(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		u.Attributes = attrs[u.ID]
+	}
+
+	return nil
+}
+
+// attributeFilters reads the attributes[name]=value query parameters of
+// the list endpoint. The values are read as the type of their field
+func (app *Config) attributeFilters(c echo.Context) (data.Attributes, []openapi.ValidationError, error) {
+	raw := make(map[string]string)
+	for key, values := range c.QueryParams() {
+		if strings.HasPrefix(key, "attributes[") && strings.HasSuffix(key, "]") {
+			raw[strings.TrimSuffix(strings.TrimPrefix(key, "attributes["), "]")] = values[0]
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, nil, nil
+	}
+
+	defs, err := app.fieldDefinitions(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byName := make(map[string]*data.FieldDefinition, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		filters = make(data.Attributes, len(raw))
+		errs    []openapi.ValidationError
+	)
+	for _, name := range names {
+		at := "query.attributes[" + name + "]"
+
+		d, ok := byName[name]
+		if !ok {
+			errs = append(errs, openapi.ValidationError{Field: at, Message: "is not a defined field"})
+			continue
+		}
+
+		v, err := d.ParseAttribute(raw[name])
+		if err != nil {
+			errs = append(errs, openapi.ValidationError{Field: at, Message: err.Error()})
+			continue
+		}
+		filters[name] = v
+	}
+
+	return filters, errs, nil
+}
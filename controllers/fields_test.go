@@ -0,0 +1,187 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("custom fields", func() {
+
+	type user struct {
+		ID         string                 `json:"user_id"`
+		Email      string                 `json:"email"`
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+
+	type fieldErrors struct {
+		Details []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"details"`
+	}
+
+	var e *echo.Echo
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	create := func(body string) user {
+		w := do("POST", "/v1/users", body)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var u user
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+
+		return u
+	}
+
+	list := func(query string) []user {
+		w := do("GET", "/v1/users"+query, "")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var users []user
+		Expect(json.Unmarshal(w.Body.Bytes(), &users)).To(Succeed())
+
+		return users
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+
+		w := do("POST", "/v1/custom-fields", `{"name": "department", "label": "Department", "type": "enum", "options": ["eng", "sales"], "required": true}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		w = do("POST", "/v1/custom-fields", `{"name": "floor", "type": "integer", "min": 0, "max": 40}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+	})
+
+	It("should manage definitions with the admin scope", func() {
+		w := do("POST", "/v1/custom-fields", `{"name": "locale", "type": "string"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/custom-fields", `{"name": "floor", "type": "string"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/custom-fields", `{"name": "colour", "type": "enum"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+
+		w = do("POST", "/v1/custom-fields/floor", `{"label": "Floor", "type": "number"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+
+		w = do("POST", "/v1/custom-fields/floor", `{"label": "Floor", "max": 60}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted), w.Body.String())
+
+		w = do("GET", "/v1/custom-fields", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var fields []struct {
+			Name  string   `json:"name"`
+			Label string   `json:"label"`
+			Max   *float64 `json:"max"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &fields)).To(Succeed())
+		Expect(fields).To(HaveLen(2))
+		Expect(fields[1].Name).To(Equal("floor"))
+		Expect(fields[1].Label).To(Equal("Floor"))
+		Expect(*fields[1].Max).To(Equal(60.0))
+
+		Expect(do("DELETE", "/v1/custom-fields/floor", "", admin...).Code).To(Equal(http.StatusAccepted))
+		Expect(do("GET", "/v1/custom-fields/floor", "").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should check attributes against the definitions", func() {
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "attributes": {"floor": 41, "badge": "x"}}`)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		var res fieldErrors
+		Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+		Expect(res.Details).To(HaveLen(3))
+		Expect(res.Details[0].Field).To(Equal("body.attributes.department"))
+		Expect(res.Details[0].Message).To(Equal("is required"))
+		Expect(res.Details[1].Field).To(Equal("body.attributes.badge"))
+		Expect(res.Details[2].Field).To(Equal("body.attributes.floor"))
+		Expect(res.Details[2].Message).To(Equal("must be at most 40"))
+
+		u := create(`{"email": "clark@mail.com", "password": "password", "attributes": {"department": "eng", "floor": 3}}`)
+		Expect(u.Attributes).To(Equal(map[string]interface{}{"department": "eng", "floor": 3.0}))
+
+		w = do("POST", "/v1/users/"+u.ID, `{"email": "clark@mail.com", "attributes": {"department": null}}`)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		w = do("POST", "/v1/users/"+u.ID, `{"email": "clark@mail.com", "attributes": {"department": "sales", "floor": null}}`)
+		Expect(w.Code).To(Equal(http.StatusAccepted), w.Body.String())
+
+		w = do("GET", "/v1/users/"+u.ID, "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var got user
+		Expect(json.Unmarshal(w.Body.Bytes(), &got)).To(Succeed())
+		Expect(got.Attributes).To(Equal(map[string]interface{}{"department": "sales"}))
+	})
+
+	It("should report the built-in fields and the attributes together", func() {
+		w := do("POST", "/v1/users", `{"email": "clark", "password": "short", "attributes": {"department": "eng", "floor": "high"}}`)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		var res fieldErrors
+		Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+
+		var fields []string
+		for _, d := range res.Details {
+			fields = append(fields, d.Field)
+		}
+		Expect(fields).To(ConsistOf("body.email", "body.password", "body.attributes.floor"))
+
+		u := create(`{"email": "clark@mail.com", "password": "password", "attributes": {"department": "eng"}}`)
+
+		w = do("POST", "/v1/users/"+u.ID, `{"email": "clark", "attributes": {"department": "hr"}}`)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		res = fieldErrors{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+		fields = nil
+		for _, d := range res.Details {
+			fields = append(fields, d.Field)
+		}
+		Expect(fields).To(ConsistOf("body.email", "body.attributes.department"))
+	})
+
+	It("should filter the list by attributes", func() {
+		clark := create(`{"email": "clark@mail.com", "password": "password", "attributes": {"department": "eng", "floor": 3}}`)
+		create(`{"email": "bob@mail.com", "password": "password", "attributes": {"department": "eng", "floor": 4}}`)
+		create(`{"email": "lois@mail.com", "password": "password", "attributes": {"department": "sales", "floor": 3}}`)
+
+		Expect(list("")).To(HaveLen(3))
+		Expect(list("?attributes[department]=eng")).To(HaveLen(2))
+
+		users := list("?attributes[department]=eng&attributes[floor]=3")
+		Expect(users).To(HaveLen(1))
+		Expect(users[0].ID).To(Equal(clark.ID))
+		Expect(users[0].Attributes).To(HaveKeyWithValue("floor", 3.0))
+
+		w := do("GET", "/v1/users?attributes[floor]=third", "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("query.attributes[floor]"))
+
+		w = do("GET", "/v1/users?attributes[badge]=x", "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/danielboakye/go-echo-app/graph"
+	"github.com/labstack/echo"
+)
+
+// graphQL serves the GraphQL schema of the users API. Requests that fail
+// before execution, e.g. on syntax, validation or query limits, are answered
+// with 400; errors raised while resolving fields are reported with 200
+// alongside the partial data, as GraphQL clients expect
+func (app *Config) graphQL() echo.HandlerFunc {
+	schema, err := (&graph.Config{Repo: app.Repo}).NewSchema()
+	if err != nil {
+		panic("graphql: " + err.Error())
+	}
+
+	return func(c echo.Context) error {
+		var req graph.Request
+		if err := c.Bind(&req); err != nil || req.Query == "" {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request"})
+		}
+
+		// mutations follow the write policy of allow
+		if p := principalOf(c); graph.IsMutation(req) {
+			switch {
+			case p == nil && app.keysIssued(c):
+				return c.JSON(http.StatusUnauthorized, errorResponse{Error: "missing or invalid token"})
+			case p != nil && !p.Has(ScopeUsersWrite):
+				return c.JSON(http.StatusForbidden, errorResponse{Error: "missing scope " + ScopeUsersWrite})
+			}
+		}
+
+		ctx := graph.WithRepository(c.Request().Context(), app.users(c))
+
+		res, executed := schema.Do(ctx, req)
+		if !executed {
+			return c.JSON(http.StatusBadRequest, res)
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
@@ -0,0 +1,70 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GraphQL", func() {
+
+	var (
+		app    controllers.Config
+		server http.Handler
+		resp   *http.Response
+		result map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+		server = app.NewServer()
+	})
+
+	send := func(reqBody string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/graphql", strings.NewReader(reqBody))
+		r.Header.Set("Content-Type", "application/json")
+		server.ServeHTTP(w, r)
+
+		resp = w.Result()
+		result = nil
+		Expect(json.Unmarshal(w.Body.Bytes(), &result)).To(Succeed())
+	}
+
+	It("should run queries", func() {
+		id, err := app.Repo.Insert(data.User{Email: "clark@example.com", LastName: "Kent", Password: "password"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		send(`{"query": "query($id: ID!) { user(id: $id) { email lastName } }", "variables": {"id": "` + id + `"}}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(result).ShouldNot(HaveKey("errors"))
+		Expect(result["data"]).To(Equal(map[string]interface{}{
+			"user": map[string]interface{}{"email": "clark@example.com", "lastName": "Kent"},
+		}))
+	})
+
+	It("should report resolver errors alongside the data", func() {
+		send(`{"query": "mutation { deleteUser(id: \"00000000-0000-0000-0000-000000000000\") }"}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(result["errors"]).To(HaveLen(1))
+	})
+
+	It("should reject invalid operations", func() {
+		send(`{"query": "{ user(id: \"x\") { password } }"}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(result["data"]).To(BeNil())
+		Expect(result["errors"]).ToNot(BeEmpty())
+	})
+
+	It("should reject requests without a query", func() {
+		send(`{"variables": {}}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(result).To(HaveKey("error"))
+	})
+})
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultMembersLimit = 20
+	maxMembersLimit     = 100
+)
+
+type groupMembersResponse struct {
+	Members []userV1 `json:"members"`
+	Total   int      `json:"total"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+}
+
+func (app *Config) getAllGroups(c echo.Context) error {
+	groups, err := scoped(c, app.Groups).GetAll()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newGroupsV1(groups))
+}
+
+func (app *Config) getGroup(c echo.Context) error {
+	g, err := scoped(c, app.Groups).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "group not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newGroupV1(g))
+}
+
+// saveGroup creates a group. Its members get its scopes, so callers cannot
+// grant scopes they do not hold themselves, as with API keys
+func (app *Config) saveGroup(c echo.Context) error {
+	var in groupInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	g := in.group()
+	if errs := data.ValidateGroup(g, grantableBy(principalOf(c))); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid group", Details: errs})
+	}
+
+	id, err := scoped(c, app.Groups).Insert(g)
+	if errors.Is(err, data.ErrDuplicateGroup) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "group already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	created, err := scoped(c, app.Groups).GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusCreated, newGroupV1(created))
+}
+
+func (app *Config) updateGroup(c echo.Context) error {
+	var in groupInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	g := in.group()
+	g.ID = c.Param("id")
+	if errs := data.ValidateGroup(g, grantableBy(principalOf(c))); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid group", Details: errs})
+	}
+
+	err := scoped(c, app.Groups).Update(g)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "group not found"})
+	case errors.Is(err, data.ErrDuplicateGroup):
+		return c.JSON(http.StatusConflict, errorResponse{Error: "group already exists"})
+	case err != nil:
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *Config) deleteGroup(c echo.Context) error {
+	err := scoped(c, app.Groups).Delete(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "group not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// getGroupMembers lists a page of the members of a group, in the order they
+// joined
+func (app *Config) getGroupMembers(c echo.Context) error {
+	id := c.Param("id")
+
+	limit, offset := defaultMembersLimit, 0
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxMembersLimit {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid limit"})
+		}
+		limit = n
+	}
+
+	if v := c.QueryParam("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid offset"})
+		}
+		offset = n
+	}
+
+	if _, err := scoped(c, app.Groups).GetOne(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, errorResponse{Error: "group not found"})
+		}
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	ids, total, err := scoped(c, app.Groups).Members(id, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	users, _, err := app.users(c).GetMany(c.Request().Context(), ids)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	members := newUsersV1(users)
+	if members == nil {
+		members = []userV1{}
+	}
+
+	refs := make([]*userV1, len(members))
+	for i := range members {
+		refs[i] = &members[i]
+	}
+	if err := app.expandUsers(c, refs...); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, groupMembersResponse{
+		Members: members,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+func (app *Config) addGroupMember(c echo.Context) error {
+	var in groupMemberInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	id := c.Param("id")
+	if _, err := scoped(c, app.Groups).GetOne(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, errorResponse{Error: "group not found"})
+		}
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if _, err := app.users(c).GetOne(in.UserID); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{
+			Error:   "invalid member",
+			Details: []fieldError{{Field: "user_id", Message: "is not a user"}},
+		})
+	}
+
+	if err := scoped(c, app.Groups).AddMember(id, in.UserID); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *Config) removeGroupMember(c echo.Context) error {
+	err := scoped(c, app.Groups).RemoveMember(c.Param("id"), c.Param("user_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "member not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// getUserGroups lists the groups a user is a member of, by name
+func (app *Config) getUserGroups(c echo.Context) error {
+	id := c.Param("id")
+
+	if _, err := app.users(c).GetOne(id); err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	groups, err := scoped(c, app.Groups).GroupsOf(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newGroupsV1(groups))
+}
+
+// groupScopes returns the scopes a user of an organization holds through
+// their groups, which route policies weigh along with those of their token.
+// They are read on every request, so that membership changes apply at once
+func (app *Config) groupScopes(orgID, userID string) ([]string, error) {
+	repo := app.Groups
+	if app.Organizations != nil && orgID != "" {
+		repo = repo.WithTenant(orgID)
+	}
+
+	groups, err := repo.GroupsOf(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+	for _, g := range groups {
+		scopes = append(scopes, g.Scopes...)
+	}
+
+	return scopes, nil
+}
+
+// grantableBy returns the scopes p may grant to API keys and groups: the
+// grantable ones it holds itself
+func grantableBy(p *Principal) []string {
+	var grantable []string
+	for _, s := range grantableScopes {
+		if p.Has(s) {
+			grantable = append(grantable, s)
+		}
+	}
+
+	return grantable
+}
@@ -0,0 +1,165 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("groups", func() {
+
+	type group struct {
+		ID     string   `json:"group_id"`
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	type members struct {
+		Members []struct {
+			ID string `json:"user_id"`
+		} `json:"members"`
+		Total int `json:"total"`
+	}
+
+	var (
+		e          *echo.Echo
+		clark, bob string
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	createUser := func(email string) string {
+		w := do("POST", "/v1/users", `{"email": "`+email+`", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+
+		return u.ID
+	}
+
+	createGroup := func(body string) group {
+		w := do("POST", "/v1/groups", body, admin...)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var g group
+		Expect(json.Unmarshal(w.Body.Bytes(), &g)).To(Succeed())
+
+		return g
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+
+		clark = createUser("clark@mail.com")
+		bob = createUser("bob@mail.com")
+	})
+
+	It("should manage groups and page through their members", func() {
+		w := do("POST", "/v1/groups", `{"name": "eng"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/groups", `{"name": "eng", "scopes": ["admin"]}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusBadRequest), w.Body.String())
+
+		eng := createGroup(`{"name": "eng", "description": "Engineering", "scopes": ["users:write"]}`)
+		Expect(eng.Scopes).To(Equal([]string{"users:write"}))
+		sales := createGroup(`{"name": "sales"}`)
+		Expect(sales.Scopes).To(BeEmpty())
+
+		w = do("POST", "/v1/groups", `{"name": "eng"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/groups/"+sales.ID, `{"name": "eng"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		for _, id := range []string{clark, bob} {
+			w = do("POST", "/v1/groups/"+eng.ID+"/members", `{"user_id": "`+id+`"}`, admin...)
+			Expect(w.Code).To(Equal(http.StatusAccepted), w.Body.String())
+		}
+		w = do("POST", "/v1/groups/"+sales.ID+"/members", `{"user_id": "`+clark+`"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("POST", "/v1/groups/"+eng.ID+"/members", `{"user_id": "00000000-0000-0000-0000-00000000dead"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+
+		w = do("GET", "/v1/groups/"+eng.ID+"/members?limit=1&offset=1", "")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var page members
+		Expect(json.Unmarshal(w.Body.Bytes(), &page)).To(Succeed())
+		Expect(page.Total).To(Equal(2))
+		Expect(page.Members).To(HaveLen(1))
+		Expect(page.Members[0].ID).To(Equal(bob))
+
+		w = do("GET", "/v1/users/"+clark+"/groups", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var groups []group
+		Expect(json.Unmarshal(w.Body.Bytes(), &groups)).To(Succeed())
+		Expect(groups).To(HaveLen(2))
+		Expect(groups[0].Name).To(Equal("eng"))
+		Expect(groups[1].Name).To(Equal("sales"))
+
+		w = do("DELETE", "/v1/groups/"+eng.ID+"/members/"+bob, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		w = do("DELETE", "/v1/groups/"+eng.ID+"/members/"+bob, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+
+		w = do("DELETE", "/v1/groups/"+eng.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "/v1/groups/"+eng.ID, "")
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+
+		w = do("GET", "/v1/users/"+clark+"/groups", "")
+		Expect(json.Unmarshal(w.Body.Bytes(), &groups)).To(Succeed())
+		Expect(groups).To(HaveLen(1))
+	})
+
+	It("should grant the scopes of their groups to members", func() {
+		w := do("POST", "/v1/login", `{"email": "clark@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var l struct {
+			AccessToken string `json:"access_token"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &l)).To(Succeed())
+		token := []string{"Authorization", "Bearer " + l.AccessToken}
+
+		rename := func() int {
+			return do("POST", "/v1/users/"+bob, `{"email": "bob@mail.com", "first_name": "Robert"}`, token...).Code
+		}
+
+		Expect(rename()).To(Equal(http.StatusForbidden))
+
+		editors := createGroup(`{"name": "editors", "scopes": ["users:write"]}`)
+		w = do("POST", "/v1/groups/"+editors.ID+"/members", `{"user_id": "`+clark+`"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		Expect(rename()).To(Equal(http.StatusAccepted))
+
+		w = do("DELETE", "/v1/groups/"+editors.ID+"/members/"+clark, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		Expect(rename()).To(Equal(http.StatusForbidden))
+	})
+})
@@ -10,33 +10,83 @@ import (
 )
 
 func (app *Config) getAllUsers(c echo.Context) error {
-	users, err := app.Repo.GetAll()
+	filters, errs, err := app.attributeFilters(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, validationErrorResponse{Error: "invalid request", Details: errs})
+	}
+
+	users, err := app.users(c).GetAll()
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
 	}
 
-	return c.JSON(http.StatusOK, users)
+	if len(filters) > 0 {
+		ids, err := scoped(c, app.Fields).FindUsers(filters)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+
+		found := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			found[id] = true
+		}
+
+		var matched []*data.User
+		for _, u := range users {
+			if found[u.ID] {
+				matched = append(matched, u)
+			}
+		}
+		users = matched
+	}
+
+	res := newUsersV1(users)
+	refs := make([]*userV1, len(res))
+	for i := range res {
+		refs[i] = &res[i]
+	}
+	if err := app.expandUsers(c, refs...); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, res)
 }
 
 func (app *Config) getUser(c echo.Context) error {
 	id := c.Param("id")
 
-	user, err := app.Repo.GetOne(id)
+	user, err := app.users(c).GetOne(id)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
 	}
 
-	user.Password = ""
-	return c.JSON(http.StatusOK, user)
+	res := newUserV1(user)
+	if err := app.expandUsers(c, &res); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, res)
 }
 
 func (app *Config) saveUser(c echo.Context) error {
-	var u data.User
-	if err := c.Bind(&u); err != nil {
-		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	var in userInputV1
+	if err := c.Bind(&in); err != nil {
+		return badUserInput(c)
+	}
+	u := in.user()
+
+	errs, err := app.validateUserInput(c, u, in.Attributes, true)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, fieldErrorResponse{Error: "invalid request", Details: errs})
 	}
 
-	eu, err := app.Repo.GetByEmail(u.Email)
+	eu, err := app.users(c).GetByEmail(u.Email)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = nil
 	}
@@ -49,26 +99,37 @@ func (app *Config) saveUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "user exists"})
 	}
 
-	id, err := app.Repo.Insert(u)
+	id, err := app.users(c).Insert(u)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
 	}
 
 	u.ID = id
-	u.Password = ""
+	c.Set(contextResponseUser, id)
+
+	if len(in.Attributes) > 0 {
+		if err := scoped(c, app.Fields).SetAttributes(id, in.Attributes); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+		}
+	}
+
+	res := newUserV1(&u)
+	if err := app.expandUsers(c, &res); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
 
-	return c.JSON(http.StatusCreated, u)
+	return c.JSON(http.StatusCreated, res)
 }
 
 func (app *Config) updateUser(c echo.Context) error {
 	id := c.Param("id")
 
-	var r data.User
+	var r userInputV1
 	if err := c.Bind(&r); err != nil {
-		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+		return badUserInput(c)
 	}
 
-	user, err := app.Repo.GetOne(id)
+	user, err := app.users(c).GetOne(id)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
 	}
@@ -76,23 +137,80 @@ func (app *Config) updateUser(c echo.Context) error {
 	user.Email = r.Email
 	user.FirstName = r.FirstName
 	user.LastName = r.LastName
-	user.Active = r.Active
 
-	err = app.Repo.Update(*user)
+	errs, err := app.validateUserInput(c, *user, r.Attributes, false)
 	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, fieldErrorResponse{Error: "invalid request", Details: errs})
+	}
+
+	// the flag cannot lift a suspension or deactivation, which is refused
+	// before anything of the profile is saved
+	if _, err := user.Status.WithActive(r.Active == 1); err != nil {
+		return c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+	}
+
+	err = app.users(c).Update(*user)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	if len(r.Attributes) > 0 {
+		if err := scoped(c, app.Fields).SetAttributes(id, r.Attributes); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+		}
+	}
+
+	// the active flag of v1 maps onto the status: see setStatus for the
+	// transitions with a reason
+	err = data.SetActive(app.users(c), user, r.Active == 1, actorOf(c))
+	switch {
+	case errors.Is(err, data.ErrInvalidTransition), errors.Is(err, data.ErrStatusConflict):
+		return c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+	case err != nil:
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
 	}
 
+	// deactivated users are logged out everywhere at once
+	if err := app.dependents(c).StatusChanged(user); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
 	return c.NoContent(http.StatusAccepted)
 }
 
 func (app *Config) deleteUser(c echo.Context) error {
 	id := c.Param("id")
 
-	err := app.Repo.DeleteByID(id)
+	err := app.users(c).DeleteByID(id)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
 	}
 
+	if err := app.dependents(c).Deleted(id); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if app.avatarsEnabled() {
+		if avatar, err := scoped(c, app.Avatars).GetByUser(id); err == nil {
+			if err := scoped(c, app.Avatars).DeleteByUser(id); err != nil {
+				return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+			}
+			app.removeAvatarBlobs(c, id, avatar.Version)
+		}
+	}
+
 	return c.NoContent(http.StatusAccepted)
 }
+
+// badUserInput answers a user submission that could not be read, with the
+// problems the spec found in it if any
+func badUserInput(c echo.Context) error {
+	if errs := specErrorsOf(c); len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, fieldErrorResponse{Error: "invalid request", Details: errs})
+	}
+
+	return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+}
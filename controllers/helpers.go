@@ -1,5 +1,71 @@
 package controllers
 
+import (
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
 type errorResponse struct {
 	Error string `json:"error"`
 }
+
+// fieldErrorResponse reports a submission rejected for the listed fields
+type fieldErrorResponse struct {
+	Error   string       `json:"error"`
+	Details []fieldError `json:"details"`
+}
+
+// customMethods maps the verbs of API style custom methods such as
+// POST /users:batchCreate to their handlers. Echo treats everything after
+// a colon as a path parameter, so the route is registered as /users:method
+// and the verb, which arrives as ":batchCreate", is dispatched here.
+type customMethods map[string]echo.HandlerFunc
+
+func (m customMethods) handler(param string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		verb := c.Param(param)
+		if !strings.HasPrefix(verb, ":") {
+			return echo.ErrNotFound
+		}
+
+		h, ok := m[strings.TrimPrefix(verb, ":")]
+		if !ok {
+			return echo.ErrNotFound
+		}
+
+		return h(c)
+	}
+}
+
+// itemHandler dispatches the custom methods of a single resource, such as
+// POST /api-keys/{id}:rotate. The route is registered as /api-keys/:id, so
+// the verb arrives appended to the id; it is cut off before the handler
+// runs. Requests without a verb go to the handler registered for "", if any
+func (m customMethods) itemHandler(param string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		value := c.Param(param)
+
+		verb := ""
+		if i := strings.LastIndexByte(value, ':'); i >= 0 {
+			value, verb = value[:i], value[i+1:]
+		}
+
+		h, ok := m[verb]
+		if !ok {
+			return echo.ErrNotFound
+		}
+
+		// the values share the pooled slice of the context, which
+		// SetParamValues would cut down to the params of this route and
+		// so leave too short for routes with more of them
+		values := c.ParamValues()
+		for i, name := range c.ParamNames() {
+			if name == param {
+				values[i] = value
+			}
+		}
+
+		return h(c)
+	}
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	defaultIdempotencyTTL    = 24 * time.Hour
+	maxIdempotencyKeyLength  = 255
+
+	// idempotencyLease bounds how long a request in progress holds its key,
+	// so that a key left pending by a crash can be retried
+	idempotencyLease = time.Minute
+
+	// contextResponseUser is set by handlers to the id of the user their
+	// response describes, which the stored copy of the response is filed
+	// under for the exports and erasures of the user
+	contextResponseUser = "response_user"
+)
+
+// idempotent replays the stored response of a request that carries an
+// Idempotency-Key header which has been seen before. Reusing a key for a
+// different request is rejected, and requests sharing a key are serialized.
+// Keys belong to the caller who sent them, within its organization.
+func (app *Config) idempotent() echo.MiddlewareFunc {
+	locks := newKeyedMutex()
+
+	ttl := app.IdempotencyTTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(headerIdempotencyKey)
+			if key == "" {
+				return next(c)
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid idempotency key"})
+			}
+			key = scopedIdempotencyKey(tenantOf(c), actorOf(c), key)
+
+			var reqBody []byte
+			if c.Request().Body != nil {
+				var err error
+				reqBody, err = io.ReadAll(c.Request().Body)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+				}
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(reqBody))
+
+			fingerprint := requestFingerprint(c.Request(), reqBody)
+
+			unlock := locks.Lock(key)
+			defer unlock()
+
+			rec, reserved, err := app.Idempotency.Reserve(key, fingerprint, idempotencyLease)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+			}
+
+			if !reserved {
+				if rec.Fingerprint != fingerprint {
+					return c.JSON(http.StatusUnprocessableEntity, errorResponse{Error: "idempotency key reused with a different request"})
+				}
+
+				if !rec.Completed {
+					return c.JSON(http.StatusConflict, errorResponse{Error: "request in progress"})
+				}
+
+				c.Response().Header().Set(headerIdempotentReplayed, "true")
+				return c.Blob(rec.StatusCode, rec.ContentType, rec.Body)
+			}
+
+			resBody := new(bytes.Buffer)
+			c.Response().Writer = &captureResponseWriter{
+				Writer:         io.MultiWriter(c.Response().Writer, resBody),
+				ResponseWriter: c.Response().Writer,
+			}
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			if c.Response().Status >= http.StatusInternalServerError {
+				app.Idempotency.Release(key)
+				return nil
+			}
+
+			rec.Completed = true
+			rec.StatusCode = c.Response().Status
+			rec.ContentType = c.Response().Header().Get(echo.HeaderContentType)
+			rec.Body = resBody.Bytes()
+			rec.UserID, _ = c.Get(contextResponseUser).(string)
+			rec.ExpiresAt = time.Now().Add(ttl)
+
+			if err := app.Idempotency.Complete(*rec); err != nil {
+				c.Logger().Error(err)
+			}
+
+			return nil
+		}
+	}
+}
+
+// scopedIdempotencyKey stores key under the organization and the principal
+// that sent it, so that callers never see the responses of each other
+func scopedIdempotencyKey(tenant, actor, key string) string {
+	h := sha256.New()
+	h.Write([]byte(tenant))
+	h.Write([]byte{0})
+	h.Write([]byte(actor))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// requestFingerprint identifies a request by method, path and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// captureResponseWriter copies everything written to the response into Writer
+type captureResponseWriter struct {
+	io.Writer
+	http.ResponseWriter
+}
+
+func (w *captureResponseWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureResponseWriter) Write(b []byte) (int, error) {
+	return w.Writer.Write(b)
+}
+
+func (w *captureResponseWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *captureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// keyedMutex hands out one mutex per key and forgets it once nobody holds it
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*refMutex)}
+}
+
+// Lock blocks until key is free and returns the function that unlocks it
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &refMutex{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
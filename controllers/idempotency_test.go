@@ -0,0 +1,155 @@
+package controllers_test
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("idempotent save User", func() {
+
+	const (
+		key     = "6f1c0d8e-2b6a-4f55-9b7e-8a1d2b3c4d5e"
+		payload = `{"email": "example@gmail.com", "first_name": "test", "last_name": "test", "password": "password", "active": 1}`
+	)
+
+	var (
+		e      *echo.Echo
+		mockDB sqlmock.Sqlmock
+	)
+
+	post := func(body string) (*http.Response, string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/users", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", key)
+		e.ServeHTTP(w, r)
+
+		resp := w.Result()
+		b, err := io.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return resp, string(b)
+	}
+
+	BeforeEach(func() {
+		app, mock := newTestApp()
+		mockDB = mock
+		e = app.NewServer()
+
+		mockDB.ExpectQuery(`
+					SELECT 
+						user_id, email, first_name, last_name, password, user_status, created_at, updated_at
+					FROM users
+					WHERE email = $1 AND org_id = $2
+				`).
+			WithArgs("example@gmail.com", data.DefaultOrganizationID).
+			WillReturnError(sql.ErrNoRows)
+
+		mockDB.ExpectQuery(`
+				INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at) 
+				VALUES ($1,$2,$3,$4,$5,$6,$7) 
+				RETURNING user_id`,
+		).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b"))
+	})
+
+	When("the request is retried", func() {
+		It("should replay the stored response without touching the database", func() {
+			first, firstBody := post(payload)
+			Expect(first.StatusCode).To(Equal(http.StatusCreated))
+
+			second, secondBody := post(payload)
+			Expect(second.StatusCode).To(Equal(http.StatusCreated))
+			Expect(second.Header.Get("Idempotent-Replayed")).To(Equal("true"))
+			Expect(secondBody).To(Equal(firstBody))
+
+			Expect(mockDB.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	When("the key is reused for a different request", func() {
+		It("should reject the request", func() {
+			first, _ := post(payload)
+			Expect(first.StatusCode).To(Equal(http.StatusCreated))
+
+			second, body := post(strings.Replace(payload, "example@gmail.com", "other@gmail.com", 1))
+			Expect(second.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+
+			replacer := strings.NewReplacer("\r", "", "\n", "")
+			Expect(replacer.Replace(body)).To(Equal(`{"error":"idempotency key reused with a different request"}`))
+		})
+	})
+
+	When("requests with the same key arrive concurrently", func() {
+		It("should run the handler once and replay it for the others", func() {
+			var wg sync.WaitGroup
+			codes := make([]int, 5)
+
+			for i := range codes {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					resp, _ := post(payload)
+					codes[i] = resp.StatusCode
+				}(i)
+			}
+			wg.Wait()
+
+			for _, code := range codes {
+				Expect(code).To(Equal(http.StatusCreated))
+			}
+			Expect(mockDB.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("idempotency keys of different callers", func() {
+
+	const payload = `{"email": "clark@mail.com", "password": "password", "active": 1}`
+
+	var e *echo.Echo
+
+	post := func(headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/v1/users", strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "create-clark")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+	})
+
+	It("should not replay the response of one caller to another", func() {
+		w := post()
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		w = post("Authorization", "Bearer "+testAdminToken)
+		Expect(w.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("user exists"))
+
+		w = post()
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+	})
+})
@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+)
+
+const (
+	// defaultInvitationTTL is how long an invitation may be accepted when
+	// InvitationTTL is unset
+	defaultInvitationTTL = 7 * 24 * time.Hour
+
+	tokenUseInvitation = "invitation"
+
+	// invitationExpired is the status shown for pending invitations past
+	// their expiry
+	invitationExpired = "expired"
+)
+
+// errInvitationGone is the answer to tokens that cannot be accepted, be
+// they forged, expired, replaced by a resend, revoked or used
+var errInvitationGone = errorResponse{Error: "invitation is invalid or has expired"}
+
+// invitationsEnabled reports whether invitations are served, which takes a
+// repository for them, TokenSecret to sign their tokens and a Mailer to
+// send them
+func (app *Config) invitationsEnabled() bool {
+	return app.Invitations != nil && len(app.TokenSecret) > 0 && app.Mailer != nil
+}
+
+// getAllInvitations lists the invitations of the organization, newest
+// first, optionally only those of one status
+func (app *Config) getAllInvitations(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch data.InvitationStatus(status) {
+	case "", invitationExpired, data.InvitationPending, data.InvitationAccepted, data.InvitationRevoked:
+	default:
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "unknown status"})
+	}
+
+	invitations, err := scoped(c, app.Invitations).GetAll()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	now := time.Now()
+	res := []invitationV1{}
+	for _, i := range invitations {
+		v := newInvitationV1(i, now)
+		if status == "" || v.Status == status {
+			res = append(res, v)
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// saveInvitation invites someone to create their account and emails them
+// the token to accept with. An expired invitation of the same email is
+// replaced; a live one must be resent instead
+func (app *Config) saveInvitation(c echo.Context) error {
+	var in invitationInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	inv := in.invitation()
+	inv.InvitedBy = actorOf(c)
+	if errs := data.ValidateProfile(data.User{Email: inv.Email, FirstName: inv.FirstName, LastName: inv.LastName}); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid invitation", Details: errs})
+	}
+
+	if _, err := app.users(c).GetByEmail(inv.Email); err == nil {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "user exists"})
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	previous, err := scoped(c, app.Invitations).GetPending(inv.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	case !previous.Expired(time.Now()):
+		return c.JSON(http.StatusConflict, errorResponse{Error: "invitation already pending"})
+	default:
+		if err := scoped(c, app.Invitations).Revoke(previous.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+	}
+
+	id, err := scoped(c, app.Invitations).Insert(inv)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	return app.sendInvitation(c, id, http.StatusCreated)
+}
+
+// resendInvitation emails a pending invitation again with a new token and
+// expiry. The tokens sent before stop working
+func (app *Config) resendInvitation(c echo.Context) error {
+	inv, err := scoped(c, app.Invitations).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "invitation not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if inv.Status != data.InvitationPending {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "a " + string(inv.Status) + " invitation cannot be resent"})
+	}
+
+	return app.sendInvitation(c, inv.ID, http.StatusOK)
+}
+
+// revokeInvitation withdraws a pending invitation, so that its token can no
+// longer be accepted
+func (app *Config) revokeInvitation(c echo.Context) error {
+	inv, err := scoped(c, app.Invitations).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "invitation not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	err = scoped(c, app.Invitations).Revoke(inv.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "invitation is no longer pending"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// sendInvitation gives an invitation a new token and emails it, answering
+// with the invitation and status. The token is only ever in the email. An
+// invitation whose email could not be sent stays pending, to be resent
+func (app *Config) sendInvitation(c echo.Context, id string, status int) error {
+	ttl := app.InvitationTTL
+	if ttl <= 0 {
+		ttl = defaultInvitationTTL
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	token, err := app.signInvitation(id, tenantOf(c), expiresAt)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.Invitations).Send(id, data.HashOAuthToken(token), expiresAt); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	inv, err := scoped(c, app.Invitations).GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), mailTimeout)
+	defer cancel()
+
+	if err := app.Mailer.Send(ctx, app.invitationMessage(inv, token)); err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusBadGateway, errorResponse{Error: "invitation could not be sent"})
+	}
+
+	return c.JSON(status, newInvitationV1(inv, time.Now()))
+}
+
+// signInvitation returns a token for an invitation of an organization. Each
+// one has a random id, so that a token sent again at once still replaces
+// the previous one
+func (app *Config) signInvitation(id, orgID string, expiresAt time.Time) (string, error) {
+	jti, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   id,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		OrgID: orgID,
+		Use:   tokenUseInvitation,
+	}).SignedString(app.TokenSecret)
+}
+
+// invitationTenant scopes the request to the organization named by the
+// org_id claim of an invitation token. It returns sql.ErrNoRows when the
+// organization is gone, or the token does not fit the tenancy of the server
+func (app *Config) invitationTenant(c echo.Context, orgID string) error {
+	if app.Organizations == nil {
+		if orgID != "" {
+			return sql.ErrNoRows
+		}
+		return nil
+	}
+
+	if orgID == "" {
+		return sql.ErrNoRows
+	}
+
+	org, err := app.Organizations.GetOne(orgID)
+	if err != nil {
+		return err
+	}
+
+	c.Set(contextOrganization, org)
+
+	return nil
+}
+
+// invitationMessage is the email that carries the token of an invitation,
+// as a link to InvitationURL when it is set
+func (app *Config) invitationMessage(inv *data.Invitation, token string) mail.Message {
+	link := token
+	if app.InvitationURL != "" {
+		link = app.InvitationURL + url.PathEscape(token)
+	}
+
+	return mail.Message{
+		To:      inv.Email,
+		Subject: "You are invited to create an account",
+		Body: fmt.Sprintf("You have been invited to create an account for %s.\n\n"+
+			"Choose your password to accept the invitation:\n%s\n\n"+
+			"The invitation expires on %s. If you were not expecting it, you can ignore this email.\n",
+			inv.Email, link, inv.ExpiresAt.UTC().Format(time.RFC1123)),
+	}
+}
+
+// acceptInvitation creates the user of an invitation with the password the
+// invitee chose, given the latest token they were sent. The user is
+// activated by the invitation, which the status history records
+func (app *Config) acceptInvitation(c echo.Context) error {
+	var in invitationAcceptInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	token := c.Param("token")
+	claims, err := app.parseToken(token)
+	if err != nil || claims.Use != tokenUseInvitation {
+		return c.JSON(http.StatusGone, errInvitationGone)
+	}
+
+	// the invitation is accepted in the organization that sent it, whatever
+	// host or headers the link was opened with
+	err = app.invitationTenant(c, claims.OrgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusGone, errInvitationGone)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	inv, err := scoped(c, app.Invitations).GetOne(claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusGone, errInvitationGone)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	hash := data.HashOAuthToken(token)
+	if inv.Status != data.InvitationPending || inv.Expired(time.Now()) ||
+		subtle.ConstantTimeCompare([]byte(hash), []byte(inv.TokenHash)) != 1 {
+		return c.JSON(http.StatusGone, errInvitationGone)
+	}
+
+	u := data.User{Email: inv.Email, FirstName: inv.FirstName, LastName: inv.LastName, Password: in.Password}
+	if errs := validateUser(u); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid password", Details: errs})
+	}
+
+	if _, err := app.users(c).GetByEmail(u.Email); err == nil {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "user exists"})
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	id, err := app.users(c).Insert(u)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	// the invitation may have been accepted, revoked or resent meanwhile,
+	// in which case the user is taken back
+	err = scoped(c, app.Invitations).Accept(inv.ID, hash, id)
+	if err != nil {
+		if derr := app.users(c).DeleteByID(id); derr != nil {
+			c.Logger().Error(derr)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusGone, errInvitationGone)
+		}
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	err = app.users(c).Transition(data.StatusTransition{
+		UserID: id,
+		From:   data.StatusPending,
+		To:     data.StatusActive,
+		Reason: "accepted the invitation of " + inv.InvitedBy,
+		Actor:  "invitation:" + inv.ID,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	created, err := app.users(c).GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := newUserV1(created)
+	if err := app.expandUsers(c, &res); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusCreated, res)
+}
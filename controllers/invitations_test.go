@@ -0,0 +1,165 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("invitations", func() {
+
+	type invitation struct {
+		ID     string `json:"invitation_id"`
+		Email  string `json:"email"`
+		Status string `json:"status"`
+		UserID string `json:"user_id"`
+	}
+
+	var (
+		e      *echo.Echo
+		mailer *mail.Memory
+	)
+
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	token := regexp.MustCompile(`https://app\.example\.com/join/(\S+)`)
+
+	// lastToken returns the token of the latest invitation emailed to email
+	lastToken := func(email string) string {
+		sent := mailer.Sent()
+		Expect(sent).NotTo(BeEmpty())
+
+		msg := sent[len(sent)-1]
+		Expect(msg.To).To(Equal(email))
+		m := token.FindStringSubmatch(msg.Body)
+		Expect(m).To(HaveLen(2), msg.Body)
+
+		return m[1]
+	}
+
+	invite := func(body string) invitation {
+		w := do("POST", "/v1/invitations", body, admin...)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var i invitation
+		Expect(json.Unmarshal(w.Body.Bytes(), &i)).To(Succeed())
+
+		return i
+	}
+
+	list := func(query string) []invitation {
+		w := do("GET", "/v1/invitations"+query, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var res []invitation
+		Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+
+		return res
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		mailer = mail.NewMemory()
+		app.Mailer = mailer
+		app.InvitationURL = "https://app.example.com/join/"
+		e = app.NewServer()
+	})
+
+	It("should invite, resend and accept an invitation", func() {
+		inv := invite(`{"email": "clark@mail.com", "first_name": "Clark"}`)
+		Expect(inv.Status).To(Equal("pending"))
+		first := lastToken("clark@mail.com")
+
+		w := do("POST", "/v1/invitations", `{"email": "clark@mail.com"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/invitations/"+inv.ID+":resend", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		second := lastToken("clark@mail.com")
+
+		accept := `{"password": "password"}`
+		w = do("POST", "/v1/invitations/"+first+"/accept", accept)
+		Expect(w.Code).To(Equal(http.StatusGone), w.Body.String())
+
+		w = do("POST", "/v1/invitations/not-a-token/accept", accept)
+		Expect(w.Code).To(Equal(http.StatusGone))
+
+		w = do("POST", "/v1/invitations/"+second+"/accept", accept)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		var user struct {
+			ID        string `json:"user_id"`
+			FirstName string `json:"first_name"`
+			Status    string `json:"status"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &user)).To(Succeed())
+		Expect(user.FirstName).To(Equal("Clark"))
+		Expect(user.Status).To(Equal("active"))
+
+		w = do("POST", "/v1/invitations/"+second+"/accept", accept)
+		Expect(w.Code).To(Equal(http.StatusGone))
+
+		w = do("POST", "/v1/login", `{"email": "clark@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		accepted := list("?status=accepted")
+		Expect(accepted).To(HaveLen(1))
+		Expect(accepted[0].UserID).To(Equal(user.ID))
+
+		w = do("GET", "/v1/users/"+user.ID+"/status-history", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`"actor":"invitation:` + inv.ID + `"`))
+
+		w = do("POST", "/v1/invitations/"+inv.ID+":resend", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("should revoke invitations and turn away anonymous inviters", func() {
+		w := do("POST", "/v1/invitations", `{"email": "bob@mail.com"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/users", `{"email": "lois@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		w = do("POST", "/v1/invitations", `{"email": "lois@mail.com"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/invitations", `{"email": "not an email"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		inv := invite(`{"email": "bob@mail.com"}`)
+		t := lastToken("bob@mail.com")
+
+		w = do("DELETE", "/v1/invitations/"+inv.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		w = do("DELETE", "/v1/invitations/"+inv.ID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/invitations/"+t+"/accept", `{"password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusGone))
+
+		Expect(list("?status=revoked")).To(HaveLen(1))
+		Expect(list("?status=pending")).To(BeEmpty())
+
+		// a revoked invitation does not stand in the way of a new one
+		invite(`{"email": "bob@mail.com"}`)
+		Expect(list("")).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/labstack/echo"
+)
+
+const (
+	// loginEventsLimit is how many login events are listed when the request
+	// does not say
+	loginEventsLimit = 20
+	// mailTimeout bounds the delivery of an email, which happens after the
+	// response is sent
+	mailTimeout = 30 * time.Second
+)
+
+// LockoutPolicy slows down and locks out logins after failures, which are
+// counted per account and per IP. Zero fields take the value of
+// DefaultLockoutPolicy
+type LockoutPolicy struct {
+	// AccountThreshold failures of an account lock it out, as do
+	// IPThreshold failures from an IP, however many accounts they target
+	AccountThreshold int
+	IPThreshold      int
+	// Duration is how long a lockout lasts. Another failure once it is over
+	// locks out again
+	Duration time.Duration
+	// Delay is how long an account must wait after a failure before the
+	// next attempt, doubling with each further failure up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Window is how long failures are remembered
+	Window time.Duration
+}
+
+// DefaultLockoutPolicy allows a handful of typos per account and sizeable
+// offices behind one IP
+var DefaultLockoutPolicy = LockoutPolicy{
+	AccountThreshold: 5,
+	IPThreshold:      50,
+	Duration:         15 * time.Minute,
+	Delay:            time.Second,
+	MaxDelay:         30 * time.Second,
+	Window:           time.Hour,
+}
+
+func (p LockoutPolicy) withDefaults() LockoutPolicy {
+	d := DefaultLockoutPolicy
+	if p.AccountThreshold <= 0 {
+		p.AccountThreshold = d.AccountThreshold
+	}
+	if p.IPThreshold <= 0 {
+		p.IPThreshold = d.IPThreshold
+	}
+	if p.Duration <= 0 {
+		p.Duration = d.Duration
+	}
+	if p.Delay <= 0 {
+		p.Delay = d.Delay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = d.MaxDelay
+	}
+	if p.Window <= 0 {
+		p.Window = d.Window
+	}
+
+	return p
+}
+
+// delay is the wait after the given number of failures
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	d := p.Delay
+	for i := 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	return d
+}
+
+// accountKey names the failure counter of an account, which exists for
+// unknown emails too so that lockouts do not tell which are registered
+func accountKey(c echo.Context, email string) string {
+	return "account:" + tenantOf(c) + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey names the failure counter of the IP of the request
+func ipKey(c echo.Context) string {
+	return "ip:" + c.RealIP()
+}
+
+// loginRetryAfter returns how long the request must wait before logging in
+// to the account of email, or zero when it may go ahead
+func (app *Config) loginRetryAfter(c echo.Context, email string) (time.Duration, error) {
+	if app.LoginAttempts == nil {
+		return 0, nil
+	}
+
+	p := app.Lockout.withDefaults()
+	now := time.Now()
+
+	account, err := app.LoginAttempts.Get(accountKey(c, email))
+	if err != nil {
+		return 0, err
+	}
+
+	ip, err := app.LoginAttempts.Get(ipKey(c))
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, a := range []*data.Attempts{account, ip} {
+		if a.Locked(now) && a.LockedUntil.Sub(now) > wait {
+			wait = a.LockedUntil.Sub(now)
+		}
+	}
+
+	// only accounts are slowed down: an IP may be shared by many users
+	if account.Failures > 0 && now.Sub(account.LastFailedAt) <= p.Window {
+		if w := p.delay(account.Failures) - now.Sub(account.LastFailedAt); w > wait {
+			wait = w
+		}
+	}
+
+	return wait, nil
+}
+
+// throttleLogin answers a login that came too soon with 429 and the time to
+// wait in Retry-After
+func (app *Config) throttleLogin(c echo.Context, email, userID string, wait time.Duration) error {
+	app.recordLogin(c, email, userID, data.LoginThrottled)
+
+	seconds := int((wait + time.Second - 1) / time.Second)
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	return c.JSON(http.StatusTooManyRequests, errorResponse{Error: "too many failed logins, try again later"})
+}
+
+// loginFailed counts a failed password or code against the account and the
+// IP, locking out either once it reaches its threshold
+func (app *Config) loginFailed(c echo.Context, email, userID string) error {
+	app.recordLogin(c, email, userID, data.LoginFailed)
+
+	if app.LoginAttempts == nil {
+		return nil
+	}
+
+	p := app.Lockout.withDefaults()
+	now := time.Now()
+
+	for key, threshold := range map[string]int{
+		accountKey(c, email): p.AccountThreshold,
+		ipKey(c):             p.IPThreshold,
+	} {
+		a, err := app.LoginAttempts.Fail(key, now, p.Window)
+		if err != nil {
+			return err
+		}
+
+		if a.Failures >= threshold {
+			if err := app.LoginAttempts.Lock(key, now.Add(p.Duration)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loginSucceeded records a login that passed every step and clears the
+// failures of the account. Users are told of logins from devices they have
+// not logged in from before
+func (app *Config) loginSucceeded(c echo.Context, userID string) error {
+	if app.LoginAttempts == nil && app.LoginEvents == nil {
+		return nil
+	}
+
+	u, err := app.users(c).GetOne(userID)
+	if err != nil {
+		return err
+	}
+
+	if app.LoginAttempts != nil {
+		if err := app.LoginAttempts.Reset(accountKey(c, u.Email)); err != nil {
+			return err
+		}
+	}
+
+	if app.LoginEvents != nil {
+		known, err := scoped(c, app.LoginEvents).Devices(userID)
+		if err != nil {
+			return err
+		}
+
+		ua := c.Request().UserAgent()
+		if len(known) > 0 && !contains(known, ua) {
+			app.notifyNewDevice(c, u)
+		}
+
+		app.recordLogin(c, u.Email, userID, data.LoginSucceeded)
+	}
+
+	return nil
+}
+
+// recordLogin adds a login event. Events are informative, so failing to
+// record one does not fail the login
+func (app *Config) recordLogin(c echo.Context, email, userID, outcome string) {
+	if app.LoginEvents == nil {
+		return
+	}
+
+	err := scoped(c, app.LoginEvents).Insert(data.LoginEvent{
+		UserID:    userID,
+		Email:     email,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Outcome:   outcome,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// notifyNewDevice emails a user about a login from a new device. The email
+// is sent in the background, so that the login does not wait on the mail
+// server
+func (app *Config) notifyNewDevice(c echo.Context, u *data.User) {
+	if app.Mailer == nil {
+		return
+	}
+
+	msg := mail.Message{
+		To:      u.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf("Your account was signed in to from a new device.\n\n"+
+			"Device: %s\nIP address: %s\nTime: %s\n\n"+
+			"If this was you, there is nothing to do. If not, change your password and sign out of your other sessions.\n",
+			deviceName(c.Request().UserAgent()), c.RealIP(), time.Now().UTC().Format(time.RFC1123)),
+	}
+
+	logger := c.Logger()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := app.Mailer.Send(ctx, msg); err != nil {
+			logger.Error(err)
+		}
+	}()
+}
+
+// unlockUser lifts the lockout of an account and forgets its failures.
+// Lockouts of the IPs the failures came from stay
+func (app *Config) unlockUser(c echo.Context) error {
+	u, err := app.users(c).GetOne(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	if err := app.LoginAttempts.Reset(accountKey(c, u.Email)); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Config) getLoginEvents(c echo.Context) error {
+	limit := loginEventsLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid limit"})
+		}
+		limit = n
+	}
+
+	events, err := scoped(c, app.LoginEvents).GetByUser(c.Param("id"), limit)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]loginEventV1, len(events))
+	for i, e := range events {
+		res[i] = newLoginEventV1(e)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,158 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("login lockout", func() {
+
+	const ip = "203.0.113.7"
+
+	var (
+		e      *echo.Echo
+		mailer *mail.Memory
+		userID string
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Real-Ip", ip)
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	logIn := func(email, password string, headers ...string) *httptest.ResponseRecorder {
+		return do("POST", "/v1/login", `{"email": "`+email+`", "password": "`+password+`"}`, headers...)
+	}
+
+	bearer := func(token string) []string {
+		return []string{"Authorization", "Bearer " + token}
+	}
+
+	// serve starts an app limiting logins with policy
+	serve := func(policy controllers.LockoutPolicy) {
+		app := newMemoryTestApp()
+		app.LoginAttempts = data.NewMemoryAttemptRepository()
+		app.Lockout = policy
+		mailer = mail.NewMemory()
+		app.Mailer = mailer
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		userID = u.ID
+	}
+
+	It("should make accounts wait longer after each failure", func() {
+		serve(controllers.LockoutPolicy{Delay: time.Hour, MaxDelay: 2 * time.Hour})
+
+		Expect(logIn("clark@mail.com", "wrong").Code).To(Equal(http.StatusUnauthorized))
+
+		w := logIn("clark@mail.com", "password")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		wait, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(wait).To(BeNumerically("~", 3600, 5))
+
+		// other accounts are not held up
+		Expect(logIn("lois@mail.com", "password").Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("GET", "/v1/users/"+userID+"/login-events", "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var events []struct {
+			IP      string `json:"ip"`
+			Outcome string `json:"outcome"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &events)).To(Succeed())
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Outcome).To(Equal("throttled"))
+		Expect(events[1].Outcome).To(Equal("failed"))
+		Expect(events[1].IP).To(Equal(ip))
+	})
+
+	It("should lock out an account until it is unlocked", func() {
+		serve(controllers.LockoutPolicy{AccountThreshold: 3, Delay: time.Nanosecond, MaxDelay: time.Nanosecond, Duration: time.Hour})
+
+		for i := 0; i < 3; i++ {
+			Expect(logIn("Clark@mail.com", "wrong").Code).To(Equal(http.StatusUnauthorized))
+		}
+
+		Expect(logIn("clark@mail.com", "password").Code).To(Equal(http.StatusTooManyRequests))
+
+		// unknown emails are locked out alike, so lockouts do not tell
+		// which are registered
+		for i := 0; i < 3; i++ {
+			Expect(logIn("lois@mail.com", "wrong").Code).To(Equal(http.StatusUnauthorized))
+		}
+		Expect(logIn("lois@mail.com", "wrong").Code).To(Equal(http.StatusTooManyRequests))
+
+		Expect(do("POST", "/v1/users/"+userID+":unlock", "").Code).To(Equal(http.StatusUnauthorized))
+
+		w := do("POST", "/v1/users/"+userID+":unlock", "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		w = logIn("clark@mail.com", "password")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var l struct {
+			AccessToken string `json:"access_token"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &l)).To(Succeed())
+
+		w = do("POST", "/v1/users/"+userID+":unlock", "", bearer(l.AccessToken)...)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should lock out an IP spraying passwords across accounts", func() {
+		serve(controllers.LockoutPolicy{IPThreshold: 3, Delay: time.Nanosecond, MaxDelay: time.Nanosecond, Duration: time.Hour})
+
+		for _, email := range []string{"a@mail.com", "b@mail.com", "c@mail.com"} {
+			Expect(logIn(email, "password1").Code).To(Equal(http.StatusUnauthorized))
+		}
+
+		Expect(logIn("clark@mail.com", "password").Code).To(Equal(http.StatusTooManyRequests))
+		Expect(logIn("clark@mail.com", "password", "X-Real-Ip", "198.51.100.1").Code).To(Equal(http.StatusOK))
+	})
+
+	It("should tell users of logins from new devices", func() {
+		serve(controllers.LockoutPolicy{})
+
+		laptop := "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+		Expect(logIn("clark@mail.com", "password", "User-Agent", laptop).Code).To(Equal(http.StatusOK))
+		Expect(logIn("clark@mail.com", "password", "User-Agent", laptop).Code).To(Equal(http.StatusOK))
+		Consistently(mailer.Sent, 100*time.Millisecond).Should(BeEmpty())
+
+		Expect(logIn("clark@mail.com", "password", "User-Agent", "curl/8.5.0").Code).To(Equal(http.StatusOK))
+		Eventually(mailer.Sent).Should(HaveLen(1))
+
+		msg := mailer.Sent()[0]
+		Expect(msg.To).To(Equal("clark@mail.com"))
+		Expect(msg.Subject).To(Equal("New sign-in to your account"))
+		Expect(msg.Body).To(ContainSubstring("Device: curl"))
+		Expect(msg.Body).To(ContainSubstring(ip))
+	})
+})
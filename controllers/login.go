@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// defaultTokenTTL is the lifetime of access tokens when TokenTTL is unset
+	defaultTokenTTL = 15 * time.Minute
+	// challengeTTL is how long a user has to give their second factor
+	challengeTTL = 5 * time.Minute
+
+	tokenUseAccess    = "access"
+	tokenUseChallenge = "2fa"
+	tokenUseOIDC      = "oidc"
+
+	// dummyHashCost is the cost data hashes passwords with
+	dummyHashCost = 12
+)
+
+// tokenClaims are the claims of the tokens issued at login
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	OrgID string `json:"org_id,omitempty"`
+	Scope string `json:"scope,omitempty"`
+	// Use tells access tokens from login challenges and the other tokens
+	// signed with the same secret
+	Use string `json:"use"`
+	// SessionID names the session of an access token, when sessions are
+	// kept
+	SessionID string `json:"sid,omitempty"`
+}
+
+// login checks the email and password of a user of the organization. Users
+// without two-factor authentication get an access token at once; the others
+// get a challenge to answer with a code through login:verify
+func (app *Config) login(c echo.Context) error {
+	var in loginInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	wait, err := app.loginRetryAfter(c, in.Email)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	u, err := app.users(c).GetByEmail(in.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	var userID string
+	if u != nil {
+		userID = u.ID
+	}
+
+	if wait > 0 {
+		return app.throttleLogin(c, in.Email, userID, wait)
+	}
+
+	if !passwordMatches(u, in.Password) {
+		if err := app.loginFailed(c, in.Email, userID); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid email or password"})
+	}
+
+	return app.completeLogin(c, u.ID)
+}
+
+// completeLogin answers a user who passed the first step of a login, with a
+// password or an identity provider: users whose status bars them are
+// refused, users with two-factor authentication get a challenge, and the
+// others an access token
+func (app *Config) completeLogin(c echo.Context, userID string) error {
+	u, err := app.users(c).GetOne(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if !u.Status.CanAuthenticate() {
+		return app.refuseLogin(c, u)
+	}
+
+	enabled, err := app.twoFactorEnabled(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if enabled {
+		challenge, err := app.signToken(userID, tenantOf(c), tokenUseChallenge, "", "", challengeTTL)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+
+		return c.JSON(http.StatusOK, loginV1{TwoFactorRequired: true, Challenge: challenge})
+	}
+
+	return app.issueAccessToken(c, userID)
+}
+
+// verifyLogin completes the login of a user with two-factor authentication,
+// given the challenge of the password step and a TOTP or recovery code
+func (app *Config) verifyLogin(c echo.Context) error {
+	var in loginVerifyInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	claims, err := app.parseToken(in.Challenge)
+	if err != nil || claims.Use != tokenUseChallenge || claims.OrgID != tenantOf(c) {
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid or expired challenge"})
+	}
+
+	u, err := app.users(c).GetOne(claims.Subject)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid or expired challenge"})
+	}
+
+	// codes are guessed more easily than passwords, so they count against
+	// the same lockout
+	wait, err := app.loginRetryAfter(c, u.Email)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if wait > 0 {
+		return app.throttleLogin(c, u.Email, u.ID, wait)
+	}
+
+	// the status may have changed since the password step
+	if !u.Status.CanAuthenticate() {
+		return app.refuseLogin(c, u)
+	}
+
+	ok, err := app.verifySecondFactor(claims.Subject, in.Code, in.RecoveryCode)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if !ok {
+		if err := app.loginFailed(c, u.Email, u.ID); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid code"})
+	}
+
+	return app.issueAccessToken(c, claims.Subject)
+}
+
+// refuseLogin answers the login of a user whose status does not let them
+// log in, e.g. a suspended user who gave the right password
+func (app *Config) refuseLogin(c echo.Context, u *data.User) error {
+	app.recordLogin(c, u.Email, u.ID, data.LoginRefused)
+
+	return c.JSON(http.StatusForbidden, errorResponse{Error: "user is " + string(u.Status)})
+}
+
+// issueAccessToken logs a user in, starting a session on the device of the
+// request when sessions are kept, and records the login
+func (app *Config) issueAccessToken(c echo.Context, userID string) error {
+	ttl := app.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+
+	if err := app.loginSucceeded(c, userID); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	var sessionID string
+	if app.Sessions != nil {
+		ua := c.Request().UserAgent()
+
+		var err error
+		sessionID, err = scoped(c, app.Sessions).Insert(data.Session{
+			UserID:    userID,
+			Device:    deviceName(ua),
+			IP:        c.RealIP(),
+			UserAgent: ua,
+			ExpiresAt: time.Now().Add(ttl),
+		})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+	}
+
+	token, err := app.signToken(userID, tenantOf(c), tokenUseAccess, strings.Join(userScopes, " "), sessionID, ttl)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, loginV1{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(ttl / time.Second)})
+}
+
+func (app *Config) signToken(userID, orgID, use, scope, sessionID string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		OrgID:     orgID,
+		Scope:     scope,
+		Use:       use,
+		SessionID: sessionID,
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(app.TokenSecret)
+}
+
+func (app *Config) parseToken(raw string) (*tokenClaims, error) {
+	if len(app.TokenSecret) == 0 {
+		return nil, errInvalidCredentials
+	}
+
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return app.TokenSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// accessTokenPrincipal returns the principal of an access token issued at
+// login, whose session must still be active when sessions are kept and
+// whose user must still be allowed to authenticate. Tokens
+// that were not signed with TokenSecret are not ours to judge and give no
+// principal and no error
+func (app *Config) accessTokenPrincipal(raw string) (*Principal, error) {
+	if len(app.TokenSecret) == 0 {
+		return nil, nil
+	}
+
+	claims, err := app.parseToken(raw)
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case claims.Use != tokenUseAccess:
+		return nil, errInvalidCredentials
+	}
+
+	if app.Sessions != nil {
+		if err := app.checkSession(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := app.orgUsers(claims.OrgID).GetOne(claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !u.Status.CanAuthenticate()) {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if app.Groups != nil {
+		extra, err := app.groupScopes(claims.OrgID, claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, extra...)
+	}
+
+	return &Principal{Kind: PrincipalUser, ID: claims.Subject, OrgID: claims.OrgID, SessionID: claims.SessionID, Scopes: scopes}, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// passwordMatches reports whether password is the one of u. Unknown users
+// are checked against a dummy hash, so that response times do not tell
+// which emails are registered
+func passwordMatches(u *data.User, password string) bool {
+	if u == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), dummyHashCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
@@ -0,0 +1,62 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("users backed by the memory repository", func() {
+
+	var e *echo.Echo
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+	})
+
+	It("should create, read, update and delete a user", func() {
+		w := do("POST", "http:/users", `{"email": "clark@mail.com", "first_name": "Clark", "last_name": "Kent", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var created data.User
+		Expect(json.Unmarshal(w.Body.Bytes(), &created)).To(Succeed())
+		Expect(created.ID).ToNot(BeEmpty())
+
+		w = do("POST", "http:/users", `{"email": "clark@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("user exists"))
+
+		w = do("POST", "http:/users/"+created.ID, `{"email": "clark@mail.com", "first_name": "Kal", "last_name": "El", "active": 0}`)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "http:/users/"+created.ID, "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var fetched data.User
+		Expect(json.Unmarshal(w.Body.Bytes(), &fetched)).To(Succeed())
+		Expect(fetched.FirstName).To(Equal("Kal"))
+		Expect(fetched.Password).To(BeEmpty())
+
+		w = do("DELETE", "http:/users/"+created.ID, "")
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "http:/users/"+created.ID, "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,765 @@
+package controllers
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+)
+
+const (
+	// authorizationCodeTTL is how long a client has to redeem a code
+	authorizationCodeTTL = 5 * time.Minute
+	// refreshTokenTTL is how long a refresh token lasts unused. Each use
+	// replaces it with a new one
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	// accessTokenType is the typ header of access tokens, which tells them
+	// from ID tokens signed with the same key (RFC 9068)
+	accessTokenType = "at+jwt"
+
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+// identityScopes are the OpenID Connect scopes, which are about a user and
+// mean nothing to a client acting on its own behalf
+var identityScopes = []string{scopeOpenID, scopeProfile, scopeEmail}
+
+// oauthClaims are the claims of the access tokens of the authorization
+// server
+type oauthClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+	OrgID    string `json:"org_id,omitempty"`
+	// FamilyID names the refresh tokens the access token was issued with,
+	// so that revoking them revokes it too
+	FamilyID string `json:"fid,omitempty"`
+}
+
+// oauthErrorResponse is an error of the OAuth protocol (RFC 6749 5.2)
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// consentRequest asks the user to approve the scopes a client wants
+type consentRequest struct {
+	ConsentRequired bool     `json:"consent_required"`
+	ClientID        string   `json:"client_id"`
+	ClientName      string   `json:"client_name"`
+	Scopes          []string `json:"scopes"`
+}
+
+// introspection describes a token (RFC 7662). Inactive tokens are described
+// by Active alone
+type introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
+
+// authorizationRequest is a checked request to the authorization endpoint
+type authorizationRequest struct {
+	client      *data.OAuthClient
+	redirectURI string
+	// redirectURISent tells whether the client named the redirect URI, which
+	// it must then repeat when it redeems the code
+	redirectURISent bool
+	state           string
+	nonce           string
+	challenge       string
+	method          string
+	scopes          []string
+}
+
+func (app *Config) issuer() string {
+	return strings.TrimSuffix(app.Issuer, "/")
+}
+
+func (app *Config) openIDConfiguration(c echo.Context) error {
+	iss := app.issuer()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"issuer":                                         iss,
+		"authorization_endpoint":                         iss + "/oauth/authorize",
+		"token_endpoint":                                 iss + "/oauth/token",
+		"userinfo_endpoint":                              iss + "/oauth/userinfo",
+		"jwks_uri":                                       iss + "/oauth/jwks",
+		"introspection_endpoint":                         iss + "/oauth/introspect",
+		"revocation_endpoint":                            iss + "/oauth/revoke",
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{data.GrantAuthorizationCode, data.GrantClientCredentials, data.GrantRefreshToken},
+		"subject_types_supported":                        []string{"public"},
+		"id_token_signing_alg_values_supported":          []string{jwt.SigningMethodRS256.Alg()},
+		"scopes_supported":                               identityScopes,
+		"claims_supported":                               []string{"sub", "email", "email_verified", "name", "given_name", "family_name", "updated_at"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+func (app *Config) jwks(c echo.Context) error {
+	pub := app.SigningKey.PublicKey
+
+	c.Response().Header().Set(headerCacheControl, "public, max-age=300")
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": signingKeyID(&pub),
+			"use": "sig",
+			"alg": jwt.SigningMethodRS256.Alg(),
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// signingKeyID derives the key id from the public key, so that it changes
+// whenever the key does
+func signingKeyID(pub *rsa.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(der)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// authorize is the authorization endpoint. The user, logged in with an
+// access token, is sent back to the client with a code once they consented
+// to the scopes it asks for. Until then a GET describes the consent to ask
+// for, and a POST with consent=approve or consent=deny records the answer
+func (app *Config) authorize(c echo.Context) error {
+	req, herr := app.authorizationRequest(c)
+	if herr != nil {
+		return c.JSON(herr.Code, oauthErrorResponse{Error: "invalid_request", Description: herr.Message.(string)})
+	}
+
+	if reason, description := req.check(); reason != "" {
+		return app.redirectError(c, req, reason, description)
+	}
+
+	p := principalOf(c)
+	if p == nil || p.Kind != PrincipalUser {
+		return c.JSON(http.StatusUnauthorized, oauthErrorResponse{Error: "login_required", Description: "log in first"})
+	}
+
+	if p.OrgID != "" && p.OrgID != req.client.OrgID {
+		return app.redirectError(c, req, "access_denied", "the client belongs to another organization")
+	}
+
+	consent, err := app.Consents.Get(p.ID, req.client.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	if consent == nil || !consent.Covers(req.scopes) {
+		if c.Request().Method == http.MethodPost {
+			switch c.FormValue("consent") {
+			case "approve":
+				if err := app.Consents.Save(p.ID, req.client.ID, req.scopes); err != nil {
+					return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+				}
+				return app.redirectCode(c, req, p.ID)
+			case "deny":
+				return app.redirectError(c, req, "access_denied", "the user denied the request")
+			}
+		}
+
+		return c.JSON(http.StatusOK, consentRequest{
+			ConsentRequired: true,
+			ClientID:        req.client.ID,
+			ClientName:      req.client.Name,
+			Scopes:          req.scopes,
+		})
+	}
+
+	return app.redirectCode(c, req, p.ID)
+}
+
+// authorizationRequest reads the client and redirect URI of a request to the
+// authorization endpoint. Until both are known to be valid, errors are
+// answered directly rather than by redirecting to an unchecked URI
+func (app *Config) authorizationRequest(c echo.Context) (*authorizationRequest, *echo.HTTPError) {
+	client, err := app.OAuthClients.GetOne(c.FormValue("client_id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "unknown client")
+	}
+
+	redirectURI := c.FormValue("redirect_uri")
+	sent := redirectURI != ""
+	if !sent && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirect(redirectURI) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "redirect_uri is not registered for the client")
+	}
+
+	scopes := strings.Fields(c.FormValue("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	req := &authorizationRequest{
+		client:          client,
+		redirectURI:     redirectURI,
+		redirectURISent: sent,
+		state:           c.FormValue("state"),
+		nonce:           c.FormValue("nonce"),
+		challenge:       c.FormValue("code_challenge"),
+		method:          c.FormValue("code_challenge_method"),
+		scopes:          scopes,
+	}
+
+	return req, nil
+}
+
+// check returns the error to send a valid client back with, if any
+func (req *authorizationRequest) check() (reason, description string) {
+	switch {
+	case !req.client.AllowsGrant(data.GrantAuthorizationCode):
+		return "unauthorized_client", "the client may not use authorization codes"
+	case !req.client.AllowsScopes(req.scopes):
+		return "invalid_scope", "the client may not ask for these scopes"
+	case req.challenge != "" && req.method != "S256":
+		return "invalid_request", "code_challenge_method must be S256"
+	case req.challenge == "" && req.client.Public():
+		return "invalid_request", "public clients must use PKCE with S256"
+	}
+
+	return "", ""
+}
+
+func (app *Config) redirectCode(c echo.Context, req *authorizationRequest, userID string) error {
+	code, hash, err := data.NewOAuthToken()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	err = app.OAuthTokens.InsertCode(data.AuthorizationCode{
+		Hash:        hash,
+		ClientID:    req.client.ID,
+		UserID:      userID,
+		RedirectURI: sentRedirectURI(req),
+		Scopes:      req.scopes,
+		Nonce:       req.nonce,
+		Challenge:   req.challenge,
+		ExpiresAt:   time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	return app.redirectBack(c, req, url.Values{"code": {code}})
+}
+
+// sentRedirectURI is the redirect URI a code is bound to: the one named by
+// the client, or none when the registered one was used by default
+func sentRedirectURI(req *authorizationRequest) string {
+	if !req.redirectURISent {
+		return ""
+	}
+
+	return req.redirectURI
+}
+
+func (app *Config) redirectError(c echo.Context, req *authorizationRequest, reason, description string) error {
+	return app.redirectBack(c, req, url.Values{"error": {reason}, "error_description": {description}})
+}
+
+// redirectBack sends the user back to the client with params, the state of
+// the request and the issuer, which tells clients of several authorization
+// servers where the answer came from (RFC 9207)
+func (app *Config) redirectBack(c echo.Context, req *authorizationRequest, params url.Values) error {
+	back, err := url.Parse(req.redirectURI)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "invalid_request"})
+	}
+
+	q := back.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.state != "" {
+		q.Set("state", req.state)
+	}
+	q.Set("iss", app.issuer())
+	back.RawQuery = q.Encode()
+
+	return c.Redirect(http.StatusFound, back.String())
+}
+
+// token is the token endpoint, for the authorization_code,
+// client_credentials and refresh_token grants
+func (app *Config) token(c echo.Context) error {
+	client, ok := app.authenticateClient(c)
+	if !ok {
+		return app.invalidClient(c)
+	}
+
+	grant := c.FormValue("grant_type")
+	if !client.AllowsGrant(grant) {
+		if grant != data.GrantAuthorizationCode && grant != data.GrantClientCredentials && grant != data.GrantRefreshToken {
+			return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "unsupported_grant_type"})
+		}
+		return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "unauthorized_client", Description: "the client may not use " + grant})
+	}
+
+	switch grant {
+	case data.GrantAuthorizationCode:
+		return app.redeemCode(c, client)
+	case data.GrantClientCredentials:
+		return app.clientCredentials(c, client)
+	default:
+		return app.refresh(c, client)
+	}
+}
+
+func (app *Config) redeemCode(c echo.Context, client *data.OAuthClient) error {
+	code, err := app.OAuthTokens.ConsumeCode(data.HashOAuthToken(c.FormValue("code")))
+	if errors.Is(err, sql.ErrNoRows) {
+		return invalidGrant(c, "invalid or used code")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	// the redirect URI must be repeated only if it was sent for the code
+	// (RFC 6749, section 4.1.3)
+	if code.ClientID != client.ID || time.Now().After(code.ExpiresAt) ||
+		(code.RedirectURI != "" && code.RedirectURI != c.FormValue("redirect_uri")) {
+		return invalidGrant(c, "invalid or used code")
+	}
+
+	if !pkceMatches(code.Challenge, c.FormValue("code_verifier")) {
+		return invalidGrant(c, "code_verifier does not match the code_challenge")
+	}
+
+	u, err := app.orgUsers(client.OrgID).GetOne(code.UserID)
+	if err != nil {
+		return invalidGrant(c, "the user no longer exists")
+	}
+	if !u.Status.CanAuthenticate() {
+		return invalidGrant(c, "the user is "+string(u.Status))
+	}
+
+	return app.issueTokens(c, client, u, code.Scopes, "", code)
+}
+
+// pkceMatches reports whether verifier answers an S256 challenge. Codes
+// issued without a challenge must be redeemed without a verifier
+func pkceMatches(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(want), []byte(challenge)) == 1
+}
+
+// clientCredentials issues a token to a confidential client acting on its
+// own behalf. It gets the scopes it asks for, all of its own by default,
+// except the OpenID Connect ones
+func (app *Config) clientCredentials(c echo.Context, client *data.OAuthClient) error {
+	if client.Public() {
+		return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "unauthorized_client", Description: "public clients may not use client_credentials"})
+	}
+
+	scopes := strings.Fields(c.FormValue("scope"))
+	if len(scopes) == 0 {
+		for _, s := range client.Scopes {
+			if !hasScope(identityScopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	for _, s := range scopes {
+		if hasScope(identityScopes, s) || !client.AllowsScopes([]string{s}) {
+			return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "invalid_scope", Description: "the client may not ask for " + s})
+		}
+	}
+
+	return app.issueTokens(c, client, nil, scopes, "", nil)
+}
+
+// refresh trades a refresh token for new tokens. The refresh token is
+// spent; presenting it again means it leaked, and revokes its whole family
+func (app *Config) refresh(c echo.Context, client *data.OAuthClient) error {
+	rt, err := app.OAuthTokens.GetRefreshToken(data.HashOAuthToken(c.FormValue("refresh_token")))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && rt.ClientID != client.ID) {
+		return invalidGrant(c, "invalid refresh token")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	if rt.RotatedAt != nil {
+		if err := app.OAuthTokens.RevokeFamily(rt.FamilyID); err != nil {
+			return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+		}
+		return invalidGrant(c, "refresh token reused")
+	}
+
+	if !rt.Active(time.Now()) {
+		return invalidGrant(c, "invalid refresh token")
+	}
+
+	scopes := strings.Fields(c.FormValue("scope"))
+	if len(scopes) == 0 {
+		scopes = rt.Scopes
+	} else if !hasScopes(rt.Scopes, scopes) {
+		return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "invalid_scope", Description: "scopes must be among those originally granted"})
+	}
+
+	u, err := app.orgUsers(client.OrgID).GetOne(rt.UserID)
+	if err != nil {
+		return invalidGrant(c, "the user no longer exists")
+	}
+	if !u.Status.CanAuthenticate() {
+		return invalidGrant(c, "the user is "+string(u.Status))
+	}
+
+	rotated, err := app.OAuthTokens.RotateRefreshToken(rt.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+	if !rotated {
+		// a concurrent request spent it first
+		if err := app.OAuthTokens.RevokeFamily(rt.FamilyID); err != nil {
+			return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+		}
+		return invalidGrant(c, "refresh token reused")
+	}
+
+	return app.issueTokens(c, client, u, scopes, rt.FamilyID, nil)
+}
+
+// issueTokens answers a token request with an access token, a refresh token
+// when a user is involved and the client may refresh, and an ID token when
+// code, the code redeemed, granted the openid scope. Tokens refreshed join
+// family
+func (app *Config) issueTokens(c echo.Context, client *data.OAuthClient, u *data.User, scopes []string, family string, code *data.AuthorizationCode) error {
+	ttl := app.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+
+	res := tokenResponse{TokenType: "Bearer", ExpiresIn: int(ttl / time.Second), Scope: strings.Join(scopes, " ")}
+
+	subject := client.ID
+	if u != nil {
+		subject = u.ID
+
+		if client.AllowsGrant(data.GrantRefreshToken) {
+			token, hash, err := data.NewOAuthToken()
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+			}
+
+			id, err := app.OAuthTokens.InsertRefreshToken(data.RefreshToken{
+				FamilyID:  family,
+				Hash:      hash,
+				ClientID:  client.ID,
+				UserID:    u.ID,
+				Scopes:    scopes,
+				ExpiresAt: time.Now().Add(refreshTokenTTL),
+			})
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+			}
+
+			if family == "" {
+				family = id
+			}
+			res.RefreshToken = token
+		}
+	}
+
+	now := time.Now()
+	jti, _, err := data.NewOAuthToken()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	res.AccessToken, err = app.signOAuthToken(accessTokenType, oauthClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.issuer(),
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        jti,
+		},
+		ClientID: client.ID,
+		Scope:    res.Scope,
+		OrgID:    client.OrgID,
+		FamilyID: family,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+	}
+
+	if u != nil && code != nil && hasScope(scopes, scopeOpenID) {
+		claims := userClaims(u, scopes)
+		claims["iss"] = app.issuer()
+		claims["aud"] = client.ID
+		claims["iat"] = now.Unix()
+		claims["exp"] = now.Add(ttl).Unix()
+		if code.Nonce != "" {
+			claims["nonce"] = code.Nonce
+		}
+
+		res.IDToken, err = app.signOAuthToken("", claims)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+		}
+	}
+
+	c.Response().Header().Set(headerCacheControl, "no-store")
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// userClaims are the claims about a user the scopes give access to
+func userClaims(u *data.User, scopes []string) jwt.MapClaims {
+	claims := jwt.MapClaims{"sub": u.ID}
+
+	if hasScope(scopes, scopeProfile) {
+		claims["name"] = strings.TrimSpace(u.FirstName + " " + u.LastName)
+		claims["given_name"] = u.FirstName
+		claims["family_name"] = u.LastName
+		claims["updated_at"] = u.UpdatedAt.Unix()
+	}
+
+	if hasScope(scopes, scopeEmail) {
+		claims["email"] = u.Email
+		// emails are not verified by this service
+		claims["email_verified"] = false
+	}
+
+	return claims
+}
+
+// userinfo answers the holder of an access token with the openid scope with
+// the claims its scopes give access to
+func (app *Config) userinfo(c echo.Context) error {
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	raw := strings.TrimPrefix(auth, "Bearer ")
+
+	claims, err := app.parseOAuthAccessToken(raw)
+	if raw == auth || err != nil || !hasScope(strings.Fields(claims.Scope), scopeOpenID) {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return c.JSON(http.StatusUnauthorized, oauthErrorResponse{Error: "invalid_token"})
+	}
+
+	u, err := app.orgUsers(claims.OrgID).GetOne(claims.Subject)
+	if err != nil || !u.Status.CanAuthenticate() {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return c.JSON(http.StatusUnauthorized, oauthErrorResponse{Error: "invalid_token"})
+	}
+
+	return c.JSON(http.StatusOK, userClaims(u, strings.Fields(claims.Scope)))
+}
+
+// introspect tells confidential clients, such as resource servers, whether
+// a token is active (RFC 7662). Refresh tokens are only described to their
+// own client
+func (app *Config) introspect(c echo.Context) error {
+	client, ok := app.authenticateClient(c)
+	if !ok || client.Public() {
+		return app.invalidClient(c)
+	}
+
+	token := c.FormValue("token")
+
+	if claims, err := app.parseOAuthAccessToken(token); err == nil {
+		return c.JSON(http.StatusOK, introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "Bearer",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+			Issuer:    claims.Issuer,
+		})
+	}
+
+	rt, err := app.OAuthTokens.GetRefreshToken(data.HashOAuthToken(token))
+	if err == nil && rt.ClientID == client.ID && rt.Active(time.Now()) {
+		return c.JSON(http.StatusOK, introspection{
+			Active:    true,
+			Scope:     strings.Join(rt.Scopes, " "),
+			ClientID:  rt.ClientID,
+			Subject:   rt.UserID,
+			TokenType: data.GrantRefreshToken,
+			ExpiresAt: rt.ExpiresAt.Unix(),
+			IssuedAt:  rt.CreatedAt.Unix(),
+			Issuer:    app.issuer(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, introspection{})
+}
+
+// revoke lets a client revoke its refresh tokens, and the access tokens
+// issued with them (RFC 7009). The answer is the same whether or not the
+// token was known
+func (app *Config) revoke(c echo.Context) error {
+	client, ok := app.authenticateClient(c)
+	if !ok {
+		return app.invalidClient(c)
+	}
+
+	token := c.FormValue("token")
+
+	family := ""
+	if rt, err := app.OAuthTokens.GetRefreshToken(data.HashOAuthToken(token)); err == nil && rt.ClientID == client.ID {
+		family = rt.FamilyID
+	} else if claims, err := app.parseOAuthAccessToken(token); err == nil && claims.ClientID == client.ID {
+		family = claims.FamilyID
+	}
+
+	if family != "" {
+		if err := app.OAuthTokens.RevokeFamily(family); err != nil {
+			return c.JSON(http.StatusServiceUnavailable, oauthErrorResponse{Error: "server_error"})
+		}
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// authenticateClient identifies the client of a token endpoint request by
+// HTTP Basic auth, or the client_id and client_secret form fields. Public
+// clients give their id alone
+func (app *Config) authenticateClient(c echo.Context) (*data.OAuthClient, bool) {
+	id, secret, basic := c.Request().BasicAuth()
+	if basic {
+		// Basic credentials are form-encoded first (RFC 6749 2.3.1)
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+
+	client, err := app.OAuthClients.GetOne(id)
+	if err != nil {
+		return nil, false
+	}
+
+	if client.Public() {
+		return client, secret == ""
+	}
+
+	return client, client.VerifySecret(secret)
+}
+
+func (app *Config) invalidClient(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+	return c.JSON(http.StatusUnauthorized, oauthErrorResponse{Error: "invalid_client"})
+}
+
+func invalidGrant(c echo.Context, description string) error {
+	return c.JSON(http.StatusBadRequest, oauthErrorResponse{Error: "invalid_grant", Description: description})
+}
+
+func (app *Config) signOAuthToken(typ string, claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = signingKeyID(&app.SigningKey.PublicKey)
+	if typ != "" {
+		t.Header["typ"] = typ
+	}
+
+	return t.SignedString(app.SigningKey)
+}
+
+// parseOAuthAccessToken returns the claims of an unexpired access token of
+// the authorization server whose refresh tokens were not revoked
+func (app *Config) parseOAuthAccessToken(raw string) (*oauthClaims, error) {
+	claims := &oauthClaims{}
+	t, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return &app.SigningKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(app.issuer()), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	if typ, _ := t.Header["typ"].(string); typ != accessTokenType {
+		return nil, errInvalidCredentials
+	}
+
+	if claims.FamilyID != "" {
+		revoked, err := app.OAuthTokens.FamilyRevoked(claims.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errInvalidCredentials
+		}
+	}
+
+	return claims, nil
+}
+
+// orgUsers returns the users of an organization, or all users when the
+// server is not multi-tenant
+func (app *Config) orgUsers(orgID string) data.IRepository {
+	if app.Organizations != nil && orgID != "" {
+		return app.Repo.WithTenant(orgID)
+	}
+
+	return app.Repo
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasScopes reports whether granted holds every one of scopes
+func hasScopes(granted, scopes []string) bool {
+	for _, s := range scopes {
+		if !hasScope(granted, s) {
+			return false
+		}
+	}
+
+	return true
+}
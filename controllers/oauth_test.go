@@ -0,0 +1,420 @@
+package controllers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OAuth authorization server", func() {
+
+	const callback = "https://planet.example/callback"
+
+	var (
+		server *httptest.Server
+		e      *echo.Echo
+		token  string
+		userID string
+	)
+
+	do := func(method, target, contentType, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	post := func(target string, form url.Values, headers ...string) *httptest.ResponseRecorder {
+		return do("POST", target, "application/x-www-form-urlencoded", form.Encode(), headers...)
+	}
+
+	decode := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var v map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &v)).To(Succeed(), w.Body.String())
+		return v
+	}
+
+	register := func(body string) map[string]interface{} {
+		w := do("POST", "/v1/oauth-clients", "application/json", body, "Authorization", "Bearer "+testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		return decode(w)
+	}
+
+	// authorize asks for a code on behalf of the user and returns where the
+	// answer redirects to
+	authorize := func(params url.Values) *url.URL {
+		w := do("GET", "/oauth/authorize?"+params.Encode(), "", "", "Authorization", "Bearer "+token)
+		Expect(w.Code).To(Equal(http.StatusFound), w.Body.String())
+
+		back, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return back
+	}
+
+	approve := func(params url.Values) *url.URL {
+		form := url.Values{"consent": {"approve"}}
+		for k, v := range params {
+			form[k] = v
+		}
+
+		w := post("/oauth/authorize", form, "Authorization", "Bearer "+token)
+		Expect(w.Code).To(Equal(http.StatusFound), w.Body.String())
+
+		back, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return back
+	}
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		var app controllers.Config = newMemoryTestApp()
+		app.Issuer = server.URL
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", "application/json", `{"email": "clark@mail.com", "first_name": "Clark", "last_name": "Kent", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		userID = decode(w)["user_id"].(string)
+
+		w = do("POST", "/v1/login", "application/json", `{"email": "clark@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		token = decode(w)["access_token"].(string)
+	})
+
+	Describe("the authorization code flow", func() {
+
+		var clientID, secret string
+
+		BeforeEach(func() {
+			client := register(`{"name": "Daily Planet", "redirect_uris": ["` + callback + `"]}`)
+			clientID, secret = client["client_id"].(string), client["client_secret"].(string)
+			Expect(secret).NotTo(BeEmpty())
+		})
+
+		params := func() url.Values {
+			return url.Values{
+				"response_type": {"code"},
+				"client_id":     {clientID},
+				"redirect_uri":  {callback},
+				"scope":         {"openid email profile"},
+				"state":         {"xyz"},
+				"nonce":         {"n-0S6"},
+			}
+		}
+
+		redeem := func(code string) *httptest.ResponseRecorder {
+			return post("/oauth/token", url.Values{
+				"grant_type":   {"authorization_code"},
+				"code":         {code},
+				"redirect_uri": {callback},
+			}, "Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(clientID+":"+secret)))
+		}
+
+		It("should sign the user in to the client once they consent", func() {
+			w := do("GET", "/oauth/authorize?"+params().Encode(), "", "", "Authorization", "Bearer "+token)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(decode(w)).To(HaveKeyWithValue("consent_required", true))
+
+			back := approve(params())
+			Expect(back.Host + back.Path).To(Equal("planet.example/callback"))
+			Expect(back.Query().Get("state")).To(Equal("xyz"))
+			Expect(back.Query().Get("iss")).To(Equal(server.URL))
+			code := back.Query().Get("code")
+			Expect(code).NotTo(BeEmpty())
+
+			w = redeem(code)
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+			Expect(w.Header().Get("Cache-Control")).To(Equal("no-store"))
+			tokens := decode(w)
+			Expect(tokens).To(HaveKeyWithValue("token_type", "Bearer"))
+			Expect(tokens).To(HaveKey("refresh_token"))
+
+			// the ID token verifies against the discovery document and keys
+			provider := &oidc.Provider{Issuer: server.URL, ClientID: clientID, ClientSecret: secret, RedirectURL: callback}
+			claims, err := provider.Verify(context.Background(), tokens["id_token"].(string), "n-0S6")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(claims.Subject).To(Equal(userID))
+			Expect(claims.Email).To(Equal("clark@mail.com"))
+			Expect(claims.GivenName).To(Equal("Clark"))
+
+			w = do("GET", "/oauth/userinfo", "", "", "Authorization", "Bearer "+tokens["access_token"].(string))
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+			Expect(decode(w)).To(And(HaveKeyWithValue("sub", userID), HaveKeyWithValue("email", "clark@mail.com"), HaveKeyWithValue("name", "Clark Kent")))
+
+			w = redeem(code)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "invalid_grant"))
+
+			// consent is remembered
+			back = authorize(params())
+			Expect(back.Query().Get("code")).NotTo(BeEmpty())
+		})
+
+		It("should need the redirect URI for the code only if it was sent", func() {
+			basic := "Basic " + base64.StdEncoding.EncodeToString([]byte(clientID+":"+secret))
+			redeemWithout := func(code string) *httptest.ResponseRecorder {
+				return post("/oauth/token", url.Values{"grant_type": {"authorization_code"}, "code": {code}}, "Authorization", basic)
+			}
+
+			p := params()
+			p.Del("redirect_uri")
+			back := approve(p)
+			Expect(back.Host + back.Path).To(Equal("planet.example/callback"))
+
+			w := redeemWithout(back.Query().Get("code"))
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+			back = authorize(params())
+			w = redeemWithout(back.Query().Get("code"))
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "invalid_grant"))
+		})
+
+		It("should send the client back with an error when the user denies", func() {
+			form := params()
+			form.Set("consent", "deny")
+
+			w := post("/oauth/authorize", form, "Authorization", "Bearer "+token)
+			Expect(w.Code).To(Equal(http.StatusFound))
+
+			back, err := url.Parse(w.Header().Get("Location"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(back.Query().Get("error")).To(Equal("access_denied"))
+			Expect(back.Query().Get("state")).To(Equal("xyz"))
+		})
+
+		It("should not redirect to unregistered URIs", func() {
+			p := params()
+			p.Set("redirect_uri", "https://evil.example/callback")
+
+			w := do("GET", "/oauth/authorize?"+p.Encode(), "", "", "Authorization", "Bearer "+token)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Header().Get("Location")).To(BeEmpty())
+		})
+
+		It("should need a logged-in user", func() {
+			w := do("GET", "/oauth/authorize?"+params().Encode(), "", "")
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "login_required"))
+		})
+
+		It("should reject scopes the client was not registered for", func() {
+			p := params()
+			p.Set("scope", "openid users:write")
+
+			back := authorize(p)
+			Expect(back.Query().Get("error")).To(Equal("invalid_scope"))
+		})
+
+		It("should reject other clients and wrong secrets", func() {
+			back := approve(params())
+
+			w := post("/oauth/token", url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {back.Query().Get("code")},
+				"redirect_uri":  {callback},
+				"client_id":     {clientID},
+				"client_secret": {"guess"},
+			})
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "invalid_client"))
+		})
+
+		Describe("refresh tokens", func() {
+
+			var tokens map[string]interface{}
+
+			BeforeEach(func() {
+				back := approve(params())
+
+				w := redeem(back.Query().Get("code"))
+				Expect(w.Code).To(Equal(http.StatusOK))
+				tokens = decode(w)
+			})
+
+			refresh := func(rt string) *httptest.ResponseRecorder {
+				return post("/oauth/token", url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {rt},
+					"client_id":     {clientID},
+					"client_secret": {secret},
+				})
+			}
+
+			introspect := func(t string) map[string]interface{} {
+				w := post("/oauth/introspect", url.Values{"token": {t}, "client_id": {clientID}, "client_secret": {secret}})
+				Expect(w.Code).To(Equal(http.StatusOK))
+				return decode(w)
+			}
+
+			It("should rotate them, and revoke the family when one is reused", func() {
+				w := refresh(tokens["refresh_token"].(string))
+				Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+				rotated := decode(w)
+				Expect(rotated["refresh_token"]).NotTo(Equal(tokens["refresh_token"]))
+				Expect(rotated).NotTo(HaveKey("id_token"))
+				Expect(introspect(rotated["access_token"].(string))).To(HaveKeyWithValue("active", true))
+
+				w = refresh(tokens["refresh_token"].(string))
+				Expect(w.Code).To(Equal(http.StatusBadRequest))
+				Expect(decode(w)).To(HaveKeyWithValue("error", "invalid_grant"))
+
+				Expect(refresh(rotated["refresh_token"].(string)).Code).To(Equal(http.StatusBadRequest))
+				Expect(introspect(rotated["access_token"].(string))).To(Equal(map[string]interface{}{"active": false}))
+			})
+
+			It("should revoke them along with their access tokens", func() {
+				Expect(introspect(tokens["refresh_token"].(string))).To(HaveKeyWithValue("token_type", "refresh_token"))
+
+				w := post("/oauth/revoke", url.Values{"token": {tokens["refresh_token"].(string)}, "client_id": {clientID}, "client_secret": {secret}})
+				Expect(w.Code).To(Equal(http.StatusOK))
+
+				Expect(introspect(tokens["refresh_token"].(string))).To(HaveKeyWithValue("active", false))
+
+				w = do("GET", "/oauth/userinfo", "", "", "Authorization", "Bearer "+tokens["access_token"].(string))
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+				w = post("/oauth/revoke", url.Values{"token": {"unknown"}, "client_id": {clientID}, "client_secret": {secret}})
+				Expect(w.Code).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Describe("public clients", func() {
+
+		var clientID string
+
+		BeforeEach(func() {
+			client := register(`{"name": "Planet App", "public": true, "redirect_uris": ["http://localhost:3000/callback"]}`)
+			clientID = client["client_id"].(string)
+			Expect(client).NotTo(HaveKey("client_secret"))
+		})
+
+		It("should need PKCE", func() {
+			params := url.Values{"response_type": {"code"}, "client_id": {clientID}, "scope": {"openid"}}
+
+			back := approve(params)
+			Expect(back.Query().Get("error")).To(Equal("invalid_request"))
+
+			verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+			sum := sha256.Sum256([]byte(verifier))
+			params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+			params.Set("code_challenge_method", "S256")
+
+			redeem := func(verifier string) *httptest.ResponseRecorder {
+				back := approve(params)
+				return post("/oauth/token", url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {back.Query().Get("code")},
+					"redirect_uri":  {"http://localhost:3000/callback"},
+					"client_id":     {clientID},
+					"code_verifier": {verifier},
+				})
+			}
+
+			w := redeem("wrong")
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "invalid_grant"))
+
+			w = redeem(verifier)
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+			Expect(decode(w)).To(HaveKey("id_token"))
+		})
+
+		It("should not be registered for client credentials", func() {
+			w := do("POST", "/v1/oauth-clients", "application/json", `{"name": "Bad", "public": true, "grant_types": ["client_credentials"]}`, "Authorization", "Bearer "+testAdminToken)
+			Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Describe("the client credentials grant", func() {
+
+		var clientID, secret string
+
+		BeforeEach(func() {
+			client := register(`{"name": "Billing", "grant_types": ["client_credentials"], "scopes": ["invoices:read", "invoices:write"]}`)
+			clientID, secret = client["client_id"].(string), client["client_secret"].(string)
+		})
+
+		token := func(scope string) *httptest.ResponseRecorder {
+			return post("/oauth/token", url.Values{"grant_type": {"client_credentials"}, "scope": {scope}},
+				"Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(clientID+":"+secret)))
+		}
+
+		It("should issue access tokens for the client itself", func() {
+			w := token("")
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+			tokens := decode(w)
+			Expect(tokens).To(HaveKeyWithValue("scope", "invoices:read invoices:write"))
+			Expect(tokens).NotTo(HaveKey("refresh_token"))
+
+			w = post("/oauth/introspect", url.Values{"token": {tokens["access_token"].(string)}, "client_id": {clientID}, "client_secret": {secret}})
+			Expect(decode(w)).To(And(HaveKeyWithValue("active", true), HaveKeyWithValue("sub", clientID)))
+
+			w = token("openid")
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "invalid_scope"))
+		})
+
+		It("should not let the client use other grants", func() {
+			w := post("/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"x"}, "client_id": {clientID}, "client_secret": {secret}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(decode(w)).To(HaveKeyWithValue("error", "unauthorized_client"))
+		})
+	})
+
+	It("should publish its metadata and keys", func() {
+		w := do("GET", "/.well-known/openid-configuration", "", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		meta := decode(w)
+		Expect(meta).To(HaveKeyWithValue("issuer", server.URL))
+		Expect(meta).To(HaveKeyWithValue("token_endpoint", server.URL+"/oauth/token"))
+
+		w = do("GET", "/oauth/jwks", "", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(decode(w)["keys"]).To(HaveLen(1))
+	})
+
+	It("should let only admins manage clients", func() {
+		w := do("POST", "/v1/oauth-clients", "application/json", `{"name": "Daily Planet", "redirect_uris": ["`+callback+`"]}`, "Authorization", "Bearer "+token)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("POST", "/v1/oauth-clients", "application/json", `{"name": "Daily Planet", "redirect_uris": ["http://planet.example/callback"]}`, "Authorization", "Bearer "+testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+
+		id := register(`{"name": "Daily Planet", "redirect_uris": ["` + callback + `"]}`)["client_id"].(string)
+
+		w = do("GET", "/oauth-clients/"+id, "", "", "Authorization", "Bearer "+testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(decode(w)).NotTo(HaveKey("client_secret"))
+
+		w = do("DELETE", "/v1/oauth-clients/"+id, "", "", "Authorization", "Bearer "+testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "/v1/oauth-clients/"+id, "", "", "Authorization", "Bearer "+testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+})
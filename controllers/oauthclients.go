@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+// defaultClientGrants and defaultClientScopes are given to clients
+// registered without grant types or scopes
+var (
+	defaultClientGrants = []string{data.GrantAuthorizationCode, data.GrantRefreshToken}
+	defaultClientScopes = identityScopes
+)
+
+func (app *Config) getAllOAuthClients(c echo.Context) error {
+	clients, err := scoped(c, app.OAuthClients).GetAll()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]oauthClientV1, len(clients))
+	for i, client := range clients {
+		res[i] = newOAuthClientV1(client)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (app *Config) getOAuthClient(c echo.Context) error {
+	client, err := scoped(c, app.OAuthClients).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "oauth client not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newOAuthClientV1(client))
+}
+
+// saveOAuthClient registers a client. Confidential clients get a secret,
+// which is not shown again
+func (app *Config) saveOAuthClient(c echo.Context) error {
+	var in oauthClientInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	client := in.oauthClient()
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = defaultClientGrants
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = defaultClientScopes
+	}
+
+	var secret string
+	if !in.Public {
+		var err error
+		secret, client.SecretHash, err = data.NewOAuthToken()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+	}
+
+	if errs := data.ValidateOAuthClient(client); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid oauth client", Details: errs})
+	}
+
+	id, err := scoped(c, app.OAuthClients).Insert(client)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	created, err := scoped(c, app.OAuthClients).GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusCreated, oauthClientSecretV1{oauthClientV1: newOAuthClientV1(created), Secret: secret})
+}
+
+// deleteOAuthClient removes a client along with the consents users gave it
+// and its tokens
+func (app *Config) deleteOAuthClient(c echo.Context) error {
+	id := c.Param("id")
+
+	if _, err := scoped(c, app.OAuthClients).GetOne(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, errorResponse{Error: "oauth client not found"})
+		}
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.OAuthClients).DeleteByID(id); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
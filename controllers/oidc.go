@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+)
+
+const (
+	// cookieOIDCState carries the state of a sign-in from start to callback
+	cookieOIDCState = "oidc_state"
+	// oidcStateTTL is how long users have to sign in at the provider
+	oidcStateTTL = 10 * time.Minute
+)
+
+// oidcStateClaims are the claims of the state cookie, which holds what the
+// callback checks the answer of the provider against
+type oidcStateClaims struct {
+	jwt.RegisteredClaims
+	Use      string `json:"use"`
+	Provider string `json:"provider"`
+	OrgID    string `json:"org_id,omitempty"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// startOIDC sends the user to sign in at an identity provider. The state,
+// nonce and PKCE verifier of the sign-in travel in a signed cookie, along
+// with the organization, which the callback from the provider cannot name
+func (app *Config) startOIDC(c echo.Context) error {
+	name := c.Param("provider")
+	p, ok := app.OIDC[name]
+	if !ok {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "unknown identity provider"})
+	}
+
+	var orgID string
+	if app.Organizations != nil {
+		org, err := app.resolveTenant(c)
+		if err != nil {
+			return c.JSON(err.Code, errorResponse{Error: err.Message.(string)})
+		}
+		orgID = org.ID
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	target, err := p.AuthCodeURL(c.Request().Context(), state, nonce, challenge)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusBadGateway, errorResponse{Error: "identity provider unavailable"})
+	}
+
+	now := time.Now()
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcStateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidcStateTTL)),
+		},
+		Use:      tokenUseOIDC,
+		Provider: name,
+		OrgID:    orgID,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+	}).SignedString(app.TokenSecret)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	c.SetCookie(app.oidcStateCookie(c, cookie, oidcStateTTL))
+
+	return c.Redirect(http.StatusFound, target)
+}
+
+// oidcCallback finishes a sign-in at an identity provider. The user of a
+// linked identity is logged in; otherwise the identity is linked to the user
+// with the same email, or to a new user, provided the provider verified the
+// email
+func (app *Config) oidcCallback(c echo.Context) error {
+	name := c.Param("provider")
+	p, ok := app.OIDC[name]
+	if !ok {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "unknown identity provider"})
+	}
+
+	if reason := c.QueryParam("error"); reason != "" {
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "sign-in failed: " + reason})
+	}
+
+	state, ok := app.oidcState(c, name)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid or expired sign-in"})
+	}
+	c.SetCookie(app.oidcStateCookie(c, "", -1))
+
+	if state.OrgID != "" {
+		org, err := app.lookupTenant(state.OrgID)
+		if err != nil {
+			return c.JSON(err.Code, errorResponse{Error: err.Message.(string)})
+		}
+		c.Set(contextOrganization, org)
+	}
+
+	claims, err := p.Exchange(c.Request().Context(), c.QueryParam("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "sign-in failed"})
+	}
+
+	userID, err := app.linkIdentity(c, name, claims)
+	if err != nil {
+		var herr *echo.HTTPError
+		if errors.As(err, &herr) {
+			return c.JSON(herr.Code, errorResponse{Error: herr.Message.(string)})
+		}
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return app.completeLogin(c, userID)
+}
+
+// oidcState returns the state cookie of the request if it is valid and
+// matches the state the provider sent back
+func (app *Config) oidcState(c echo.Context, provider string) (*oidcStateClaims, bool) {
+	cookie, err := c.Cookie(cookieOIDCState)
+	if err != nil {
+		return nil, false
+	}
+
+	claims := &oidcStateClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, claims, func(*jwt.Token) (interface{}, error) {
+		return app.TokenSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.Use != tokenUseOIDC || claims.Provider != provider {
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.State), []byte(c.QueryParam("state"))) != 1 {
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// oidcStateCookie scopes the state cookie to the sign-in endpoints of the
+// provider. A negative maxAge deletes it
+func (app *Config) oidcStateCookie(c echo.Context, value string, maxAge time.Duration) *http.Cookie {
+	path := c.Request().URL.Path
+	path = path[:strings.LastIndexByte(path, '/')+1]
+
+	seconds := int(maxAge / time.Second)
+	if maxAge < 0 {
+		seconds = -1
+	}
+
+	return &http.Cookie{
+		Name:     cookieOIDCState,
+		Value:    value,
+		Path:     path,
+		MaxAge:   seconds,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// linkIdentity returns the user of an identity at a provider, linking the
+// identity first when it is new
+func (app *Config) linkIdentity(c echo.Context, provider string, claims *oidc.Claims) (string, error) {
+	identities := scoped(c, app.Identities)
+
+	i, err := identities.GetBySubject(provider, claims.Subject)
+	if err == nil {
+		if _, err := app.users(c).GetOne(i.UserID); err == nil {
+			return i.UserID, nil
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	// accounts are only linked or created for emails the provider vouches
+	// for, or anyone could take over an account by claiming its email
+	if !claims.Verified() {
+		return "", echo.NewHTTPError(http.StatusForbidden, "the identity provider has not verified the email")
+	}
+
+	u, err := app.users(c).GetByEmail(claims.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		u, err = app.createIdentityUser(c, claims)
+		if err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	}
+
+	_, err = identities.Insert(data.Identity{UserID: u.ID, Provider: provider, Subject: claims.Subject, Email: claims.Email})
+	if errors.Is(err, data.ErrDuplicateIdentity) {
+		// a concurrent sign-in linked it first
+		i, err := identities.GetBySubject(provider, claims.Subject)
+		if err != nil {
+			return "", err
+		}
+		return i.UserID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return u.ID, nil
+}
+
+// createIdentityUser creates the user of a new identity. Its password is
+// random; the user signs in through the provider
+func (app *Config) createIdentityUser(c echo.Context, claims *oidc.Claims) (*data.User, error) {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	u := data.User{
+		Email:     claims.Email,
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+		Password:  password,
+		Status:    data.StatusActive,
+	}
+
+	if errs := data.ValidateUser(u); len(errs) > 0 {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "the identity provider gave an invalid profile")
+	}
+
+	u.ID, err = app.users(c).Insert(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
@@ -0,0 +1,151 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/danielboakye/go-echo-app/oidc/oidctest"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OIDC sign-in", func() {
+
+	const callback = "http://users.test/v1/auth/oidc/stub/callback"
+
+	var (
+		provider *oidctest.Server
+		app      controllers.Config
+		e        *echo.Echo
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// signIn runs a sign-in through the provider, as a browser would, and
+	// returns the answer of the callback
+	signIn := func(headers ...string) *httptest.ResponseRecorder {
+		w := do("GET", "/v1/auth/oidc/stub/start", "", headers...)
+		Expect(w.Code).To(Equal(http.StatusFound), w.Body.String())
+		Expect(w.Header().Get("Location")).To(HavePrefix(provider.URL + "/authorize?"))
+
+		cookie := w.Header().Get("Set-Cookie")
+		Expect(cookie).To(ContainSubstring("oidc_state="))
+		Expect(cookie).To(ContainSubstring("HttpOnly"))
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		res, err := client.Get(w.Header().Get("Location"))
+		Expect(err).ShouldNot(HaveOccurred())
+		res.Body.Close()
+
+		back, err := url.Parse(res.Header.Get("Location"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(back.Path).To(Equal("/v1/auth/oidc/stub/callback"))
+
+		// the provider sends the browser back without the headers of the
+		// start, so the callback has only the cookie to go by
+		return do("GET", back.RequestURI(), "", "Cookie", strings.Split(cookie, ";")[0])
+	}
+
+	users := func(headers ...string) []map[string]interface{} {
+		w := do("GET", "/v1/users", "", headers...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var list []map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &list)).To(Succeed())
+
+		return list
+	}
+
+	BeforeEach(func() {
+		provider = oidctest.NewServer("users-app", "client-secret")
+		DeferCleanup(provider.Close)
+
+		app = newMemoryTestApp()
+		app.OIDC = map[string]*oidc.Provider{
+			"stub": {
+				Issuer:       provider.URL,
+				ClientID:     "users-app",
+				ClientSecret: "client-secret",
+				RedirectURL:  callback,
+			},
+		}
+		e = app.NewServer()
+	})
+
+	It("should create a user for a new identity and sign it in again", func() {
+		provider.SignIn(map[string]interface{}{
+			"sub": "s-1", "email": "clark@mail.com", "email_verified": true,
+			"given_name": "Clark", "family_name": "Kent",
+		})
+
+		for i := 0; i < 2; i++ {
+			w := signIn()
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+			Expect(w.Body.String()).To(ContainSubstring(`"access_token"`))
+		}
+
+		list := users()
+		Expect(list).To(HaveLen(1))
+		Expect(list[0]["first_name"]).To(Equal("Clark"))
+	})
+
+	It("should link identities to users with the verified email", func() {
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		provider.SignIn(map[string]interface{}{"sub": "s-1", "email": "clark@mail.com", "email_verified": false})
+		w = signIn()
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		provider.SignIn(map[string]interface{}{"sub": "s-1", "email": "clark@mail.com", "email_verified": true})
+		w = signIn()
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		Expect(users()).To(HaveLen(1))
+	})
+
+	It("should reject callbacks without the state of the sign-in", func() {
+		w := do("GET", "/v1/auth/oidc/stub/callback?code=c&state=s", "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		w = do("GET", "/v1/auth/oidc/stub/start", "")
+		cookie := strings.Split(w.Header().Get("Set-Cookie"), ";")[0]
+
+		w = do("GET", "/v1/auth/oidc/stub/callback?code=c&state=forged", "", "Cookie", cookie)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		w = do("GET", "/v1/auth/oidc/stub/callback?error=access_denied", "", "Cookie", cookie)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("GET", "/v1/auth/oidc/other/start", "")
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should sign users in to the organization the sign-in started in", func() {
+		w := do("POST", "/v1/organizations", `{"slug": "acme", "name": "Acme"}`, "Authorization", "Bearer "+testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		provider.SignIn(map[string]interface{}{"sub": "s-1", "email": "clark@mail.com", "email_verified": true})
+		w = signIn("X-Organization", "acme")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		Expect(users("X-Organization", "acme")).To(HaveLen(1))
+		Expect(users()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/openapi"
+	"github.com/labstack/echo"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+var spec = mustLoadSpec()
+
+// maxJSONBodyBytes bounds the JSON bodies buffered for validation, which
+// covers the largest batch import
+const maxJSONBodyBytes = maxBatchBodyBytes
+
+func mustLoadSpec() *openapi.Document {
+	doc, err := openapi.Load(openAPISpec)
+	if err != nil {
+		panic("controllers: invalid openapi.json: " + err.Error())
+	}
+
+	return doc
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+const contextSpecErrors = "spec_errors"
+
+// checkedByHandler lists the operations whose handlers report the problems
+// found against the spec, together with those found against the custom
+// fields of the tenant, which are only known after authentication
+var checkedByHandler = map[string]bool{
+	"createUser": true,
+	"updateUser": true,
+}
+
+type validationErrorResponse struct {
+	Error   string                    `json:"error"`
+	Details []openapi.ValidationError `json:"details"`
+}
+
+func (app *Config) getOpenAPI(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, openAPISpec)
+}
+
+func (app *Config) getDocs(c echo.Context) error {
+	return c.HTMLBlob(http.StatusOK, docsPage)
+}
+
+// validateAgainstSpec rejects requests that do not match the operation
+// documented for the matched route. In debug mode responses are checked as
+// well and mismatches are logged, since the client has already been served.
+func (app *Config) validateAgainstSpec() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path, params := specPath(c)
+
+			op := spec.Operation(c.Request().Method, path)
+			if op == nil {
+				return next(c)
+			}
+
+			var reqBody []byte
+			if op.RequestBody != nil && c.Request().Body != nil {
+				var err error
+				reqBody, err = readBody(c.Request())
+				if err == errBodyTooLarge {
+					return c.JSON(http.StatusRequestEntityTooLarge, errorResponse{Error: "request body too large"})
+				}
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+				}
+			}
+
+			if errs := spec.ValidateRequest(op, c.Request(), params, reqBody); len(errs) > 0 {
+				if !checkedByHandler[op.OperationID] {
+					return c.JSON(http.StatusBadRequest, validationErrorResponse{Error: "invalid request", Details: errs})
+				}
+				c.Set(contextSpecErrors, errs)
+			}
+
+			if !app.Debug {
+				return next(c)
+			}
+
+			resBody := new(bytes.Buffer)
+			c.Response().Writer = &captureResponseWriter{
+				Writer:         io.MultiWriter(c.Response().Writer, resBody),
+				ResponseWriter: c.Response().Writer,
+			}
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			res := c.Response()
+			for _, e := range spec.ValidateResponse(op, res.Status, res.Header().Get(echo.HeaderContentType), resBody.Bytes()) {
+				c.Logger().Warnf("openapi: %s %s responded %d: %s", c.Request().Method, path, res.Status, e)
+			}
+
+			return nil
+		}
+	}
+}
+
+// readBody returns the body of r for validation and leaves it readable for
+// the handler. Only JSON bodies are validated against their schema, so others,
+// such as uploads, are not buffered: their first byte is enough to tell
+// whether a body was sent, and the handler applies its own size limit. JSON
+// bodies over maxJSONBodyBytes are refused with errBodyTooLarge
+func readBody(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(echo.HeaderContentType))
+	if mediaType != "" && mediaType != echo.MIMEApplicationJSON && !strings.HasSuffix(mediaType, "+json") {
+		br := bufio.NewReader(r.Body)
+		peek, err := br.Peek(1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{br, r.Body}
+
+		return peek, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxJSONBodyBytes {
+		return nil, errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// specPath turns the route matched by echo, e.g. /users/:id, into the
+// templated path used by the spec, /users/{id}, along with the values of its
+// path parameters. Parameters that do not start a segment carry custom method
+// verbs, as in /users:method, and are written out literally, as are the verbs
+// appended to a parameter, as in /api-keys/{id}:rotate.
+func specPath(c echo.Context) (string, map[string]string) {
+	route := c.Path()
+	params := make(map[string]string)
+
+	var b strings.Builder
+	for i := 0; i < len(route); i++ {
+		if route[i] != ':' {
+			b.WriteByte(route[i])
+			continue
+		}
+
+		end := strings.IndexByte(route[i:], '/')
+		if end < 0 {
+			end = len(route)
+		} else {
+			end += i
+		}
+
+		name := route[i+1 : end]
+		if i > 0 && route[i-1] == '/' {
+			value := c.Param(name)
+			b.WriteString("{" + name + "}")
+			if j := strings.LastIndexByte(value, ':'); j >= 0 {
+				b.WriteString(value[j:])
+				value = value[:j]
+			}
+			params[name] = value
+		} else {
+			b.WriteString(c.Param(name))
+		}
+
+		i = end - 1
+	}
+
+	return b.String(), params
+}
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/openapi"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var routeParam = regexp.MustCompile(`/:([^/]+)`)
+
+var _ = Describe("OpenAPI spec", func() {
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+
+	serve := func(method, target, contentType, reqBody string) {
+		app := newMemoryTestApp()
+		e := app.NewServer()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(reqBody))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		e.ServeHTTP(w, r)
+
+		resp = w.Result()
+
+		var err error
+		body, err = io.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	It("should document every registered route", func() {
+		serve("GET", "/openapi.json", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		doc, err := openapi.Load(body)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		app := newMemoryTestApp()
+		for _, route := range app.NewServer().Routes() {
+			// route groups add catch-all routes that answer 404
+			if strings.HasPrefix(route.Name, "github.com/labstack/echo.") {
+				continue
+			}
+
+			path := routeParam.ReplaceAllString(route.Path, "/{$1}")
+
+			// custom methods such as /users:method are documented per verb, and
+			// item routes serving only verbs, such as /api-keys/{id}:rotate,
+			// are documented by them alone
+			i := strings.Index(path, ":")
+			if i < 0 && doc.Operation(route.Method, path) == nil {
+				path, i = path+":", len(path)
+			}
+			if i >= 0 {
+				var verbs []string
+				for p := range doc.Paths {
+					if strings.HasPrefix(p, path[:i+1]) && doc.Operation(route.Method, p) != nil {
+						verbs = append(verbs, p)
+					}
+				}
+				Expect(verbs).NotTo(BeEmpty(), "%s %s is missing from openapi.json", route.Method, route.Path)
+				continue
+			}
+
+			Expect(doc.Operation(route.Method, path)).NotTo(BeNil(), "%s %s is missing from openapi.json", route.Method, route.Path)
+		}
+	})
+
+	It("should serve the docs page", func() {
+		serve("GET", "/docs", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/html"))
+		Expect(string(body)).To(ContainSubstring(`fetch("/openapi.json")`))
+	})
+
+	When("the request does not match the spec", func() {
+		It("should reject an invalid body", func() {
+			serve("POST", "/users", "application/json", `{"email":"clark","password":"short","active":2}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+			var res struct {
+				Error   string                    `json:"error"`
+				Details []openapi.ValidationError `json:"details"`
+			}
+			Expect(json.Unmarshal(body, &res)).To(Succeed())
+			Expect(res.Error).To(Equal("invalid request"))
+			Expect(res.Details).To(ConsistOf(
+				openapi.ValidationError{Field: "body.active", Message: "must be one of [0 1]"},
+				openapi.ValidationError{Field: "body.email", Message: "is not a valid email address"},
+				openapi.ValidationError{Field: "body.password", Message: "must be at least 8 characters"},
+			))
+		})
+
+		It("should reject an invalid path parameter", func() {
+			serve("GET", "/users/42", "", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(string(body)).To(ContainSubstring(`{"field":"path.id","message":"is not a valid UUID"}`))
+		})
+
+		It("should validate custom methods by verb", func() {
+			serve("POST", "/users:batchCreate?mode=sometimes", "application/json", `[]`)
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(string(body)).To(ContainSubstring(`"field":"query.mode"`))
+		})
+	})
+
+	When("debug is enabled", func() {
+		It("should find the responses match the spec", func() {
+			app := newMemoryTestApp()
+			app.Debug = true
+			e := app.NewServer()
+
+			logs := new(bytes.Buffer)
+			e.Logger.SetOutput(logs)
+
+			do := func(method, target, reqBody string) []byte {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(method, target, strings.NewReader(reqBody))
+				r.Header.Set("Content-Type", "application/json")
+				e.ServeHTTP(w, r)
+				return w.Body.Bytes()
+			}
+
+			var u struct {
+				ID string `json:"user_id"`
+			}
+			Expect(json.Unmarshal(do("POST", "/users", `{"email":"clark@mail.com","first_name":"Clark","last_name":"Kent","password":"password","active":1}`), &u)).To(Succeed())
+
+			do("POST", "/users:batchCreate", `[{"email":"lois@mail.com","password":"password"},{"email":"bad"}]`)
+			do("GET", "/users", "")
+			do("GET", "/users/"+u.ID, "")
+			do("GET", "/users/search?q=clark", "")
+			do("GET", "/users/export?format=ndjson", "")
+			do("POST", "/users/"+u.ID, `{"email":"superman@mail.com","active":0}`)
+			do("DELETE", "/users/"+u.ID, "")
+
+			Expect(logs.String()).NotTo(ContainSubstring("openapi:"))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+func (app *Config) getAllOrganizations(c echo.Context) error {
+	orgs, err := app.Organizations.GetAll()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]organizationV1, len(orgs))
+	for i, o := range orgs {
+		res[i] = newOrganizationV1(o)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (app *Config) getOrganization(c echo.Context) error {
+	org, err := app.Organizations.GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "organization not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, newOrganizationV1(org))
+}
+
+func (app *Config) saveOrganization(c echo.Context) error {
+	var in organizationInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	org := in.organization()
+	if errs := data.ValidateOrganization(org); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid organization", Details: errs})
+	}
+
+	id, err := app.Organizations.Insert(org)
+	if errors.Is(err, data.ErrDuplicateSlug) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "slug already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	created, err := app.Organizations.GetOne(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusCreated, newOrganizationV1(created))
+}
+
+func (app *Config) updateOrganization(c echo.Context) error {
+	var in organizationInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	org, err := app.Organizations.GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "organization not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	org.Slug = in.Slug
+	org.Name = in.Name
+	if errs := data.ValidateOrganization(*org); len(errs) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid organization", Details: errs})
+	}
+
+	err = app.Organizations.Update(*org)
+	if errors.Is(err, data.ErrDuplicateSlug) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "slug already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *Config) deleteOrganization(c echo.Context) error {
+	err := app.Organizations.DeleteByID(c.Param("id"))
+	switch {
+	case errors.Is(err, data.ErrOrganizationInUse), errors.Is(err, data.ErrDefaultOrganization):
+		return c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
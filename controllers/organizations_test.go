@@ -0,0 +1,102 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("organizations", func() {
+
+	type organization struct {
+		ID   string `json:"org_id"`
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	}
+
+	var e *echo.Echo
+
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+	})
+
+	It("should create, read, update and delete an organization", func() {
+		w := do("POST", "/v1/organizations", `{"slug": "acme", "name": "Acme"}`, testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var created organization
+		Expect(json.Unmarshal(w.Body.Bytes(), &created)).To(Succeed())
+		Expect(created.ID).ToNot(BeEmpty())
+
+		w = do("POST", "/v1/organizations", `{"slug": "acme", "name": "Other"}`, testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/organizations/"+created.ID, `{"slug": "acme-corp", "name": "Acme Corp"}`, testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "/v1/organizations", "", testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var orgs []organization
+		Expect(json.Unmarshal(w.Body.Bytes(), &orgs)).To(Succeed())
+		Expect(orgs).To(HaveLen(2))
+		Expect(orgs[0].Slug).To(Equal("acme-corp"))
+
+		w = do("DELETE", "/v1/organizations/"+created.ID, "", testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		w = do("GET", "/v1/organizations/"+created.ID, "", testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should reject invalid slugs", func() {
+		w := do("POST", "/v1/organizations", `{"slug": "Acme Inc", "name": "Acme"}`, testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Body.String()).To(ContainSubstring(`"field":"slug"`))
+	})
+
+	It("should refuse to delete organizations with users", func() {
+		w := do("POST", "/v1/organizations", `{"slug": "acme", "name": "Acme"}`, testAdminToken)
+		var created organization
+		Expect(json.Unmarshal(w.Body.Bytes(), &created)).To(Succeed())
+
+		r := httptest.NewRequest("POST", "/v1/users", strings.NewReader(`{"email": "clark@mail.com", "password": "password"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Organization", "acme")
+		w = httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		w = do("DELETE", "/v1/organizations/"+created.ID, "", testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("DELETE", "/v1/organizations/00000000-0000-0000-0000-000000000001", "", testAdminToken)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("should require the admin token", func() {
+		w := do("GET", "/v1/organizations", "", "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("GET", "/v1/organizations", "", "wrong")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,586 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/storage"
+	"github.com/labstack/echo"
+)
+
+const (
+	// exportLoginEventsLimit bounds the login events of an export, which
+	// keeps its archive small enough to be built in memory
+	exportLoginEventsLimit = 10000
+
+	mimeZip = "application/zip"
+
+	// erasedReason is the reason recorded in the status history of users
+	// deleted by an erasure
+	erasedReason = "personal data erased"
+)
+
+// privacyStep is one step of a privacy job. The steps of exports return the
+// section of the archive they read; those of erasures return nil. Every
+// step may run again when a job is resumed
+type privacyStep struct {
+	name string
+	run  func() (interface{}, error)
+}
+
+// twoFactorExportV1 is the two-factor enrollment of a user in an export.
+// The secret is a credential, so it is left out
+type twoFactorExportV1 struct {
+	Enabled     bool       `json:"enabled"`
+	EnrolledAt  time.Time  `json:"enrolled_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// oauthConsentExportV1 is the scopes a user granted a client in an export
+type oauthConsentExportV1 struct {
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// oauthTokenExportV1 is a refresh token of a user in an export. The hash
+// of the token is a credential, so it is left out
+type oauthTokenExportV1 struct {
+	ID        string     `json:"token_id"`
+	FamilyID  string     `json:"family_id"`
+	ClientID  string     `json:"client_id"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// storedResponseExportV1 is a response describing a user that is kept for
+// the retries of an idempotent request, in an export
+type storedResponseExportV1 struct {
+	StatusCode  int             `json:"status_code"`
+	ContentType string          `json:"content_type"`
+	Body        json.RawMessage `json:"body"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+}
+
+// exportManifestV1 describes the files of an export archive
+type exportManifestV1 struct {
+	UserID     string    `json:"user_id"`
+	JobID      string    `json:"job_id"`
+	ExportedAt time.Time `json:"exported_at"`
+	Files      []string  `json:"files"`
+}
+
+// exportUserData answers with a zip archive of everything stored about a
+// user: a JSON file per section, the original image of their avatar and a
+// manifest listing them. The sections are read by the steps of an export
+// job, so a failed export resumes with the sections it did not read
+func (app *Config) exportUserData(c echo.Context) error {
+	u, err := app.users(c).GetOne(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	job, sections, err := app.runPrivacyJob(c, u, data.PrivacyExport, app.exportSteps(c, u))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "export failed, retry to resume it"})
+	}
+
+	archive, err := app.exportArchive(c, job, sections)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "user-"+u.ID+".zip"))
+
+	return c.Blob(http.StatusOK, mimeZip, archive)
+}
+
+// exportSteps returns the steps that read the sections of an export of u,
+// leaving out the data the server is not configured to store
+func (app *Config) exportSteps(c echo.Context, u *data.User) []privacyStep {
+	steps := []privacyStep{
+		{"profile", func() (interface{}, error) {
+			res := newUserV1(u)
+			err := app.expandUsers(c, &res)
+			return res, err
+		}},
+		{"status_history", func() (interface{}, error) {
+			return app.users(c).Transitions(u.ID)
+		}},
+	}
+
+	if app.Sessions != nil {
+		steps = append(steps, privacyStep{"sessions", func() (interface{}, error) {
+			return scoped(c, app.Sessions).GetByUser(u.ID)
+		}})
+	}
+
+	if app.LoginEvents != nil {
+		steps = append(steps, privacyStep{"login_events", func() (interface{}, error) {
+			return scoped(c, app.LoginEvents).GetByUser(u.ID, exportLoginEventsLimit)
+		}})
+	}
+
+	if app.Identities != nil {
+		steps = append(steps, privacyStep{"identities", func() (interface{}, error) {
+			return scoped(c, app.Identities).GetByUser(u.ID)
+		}})
+	}
+
+	if app.TwoFactor != nil {
+		steps = append(steps, privacyStep{"two_factor", func() (interface{}, error) {
+			t, err := app.TwoFactor.Get(u.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			return twoFactorExportV1{Enabled: t.Enabled(), EnrolledAt: t.CreatedAt, ConfirmedAt: t.ConfirmedAt}, nil
+		}})
+	}
+
+	if app.Groups != nil {
+		steps = append(steps, privacyStep{"groups", func() (interface{}, error) {
+			groups, err := scoped(c, app.Groups).GroupsOf(u.ID)
+			return newGroupsV1(groups), err
+		}})
+	}
+
+	if app.Invitations != nil {
+		steps = append(steps, privacyStep{"invitations", func() (interface{}, error) {
+			invitations, err := scoped(c, app.Invitations).GetAll()
+			if err != nil {
+				return nil, err
+			}
+
+			var theirs []*data.Invitation
+			for _, i := range invitations {
+				if i.UserID == u.ID || i.Email == u.Email {
+					theirs = append(theirs, i)
+				}
+			}
+
+			return theirs, nil
+		}})
+	}
+
+	if app.avatarsEnabled() {
+		steps = append(steps, privacyStep{"avatar", func() (interface{}, error) {
+			a, err := scoped(c, app.Avatars).GetByUser(u.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			}
+
+			return a, err
+		}})
+	}
+
+	if app.oauthServer() {
+		steps = append(steps,
+			privacyStep{"oauth_consents", func() (interface{}, error) {
+				consents, err := app.Consents.GetByUser(u.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				res := make([]oauthConsentExportV1, len(consents))
+				for i, c := range consents {
+					res[i] = oauthConsentExportV1{ClientID: c.ClientID, Scopes: c.Scopes, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt}
+				}
+
+				return res, nil
+			}},
+			privacyStep{"oauth_tokens", func() (interface{}, error) {
+				tokens, err := app.OAuthTokens.GetRefreshTokensByUser(u.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				res := make([]oauthTokenExportV1, len(tokens))
+				for i, t := range tokens {
+					res[i] = oauthTokenExportV1{
+						ID: t.ID, FamilyID: t.FamilyID, ClientID: t.ClientID, Scopes: t.Scopes,
+						CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, RotatedAt: t.RotatedAt, RevokedAt: t.RevokedAt,
+					}
+				}
+
+				return res, nil
+			}},
+		)
+	}
+
+	if app.Idempotency != nil {
+		steps = append(steps, privacyStep{"stored_responses", func() (interface{}, error) {
+			records, err := app.Idempotency.GetByUser(u.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			res := make([]storedResponseExportV1, len(records))
+			for i, rec := range records {
+				res[i] = storedResponseExportV1{
+					StatusCode: rec.StatusCode, ContentType: rec.ContentType, Body: exportBody(rec.Body),
+					CreatedAt: rec.CreatedAt, ExpiresAt: rec.ExpiresAt,
+				}
+			}
+
+			return res, nil
+		}})
+	}
+
+	return steps
+}
+
+// exportBody embeds a stored JSON body as is, and any other as a string
+func exportBody(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return body
+	}
+
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+// exportArchive zips the sections of an export. The avatar section names
+// the version of the image added along with it
+func (app *Config) exportArchive(c echo.Context, job *data.PrivacyJob, sections map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	manifest := exportManifestV1{UserID: job.UserID, JobID: job.ID, ExportedAt: time.Now()}
+
+	add := func(name string, content io.Reader) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, content); err != nil {
+			return err
+		}
+
+		manifest.Files = append(manifest.Files, name)
+		return nil
+	}
+
+	for _, step := range job.Steps {
+		output := sections[step.Name]
+		if output == "" {
+			continue
+		}
+
+		if err := add(step.Name+".json", strings.NewReader(output)); err != nil {
+			return nil, err
+		}
+	}
+
+	if output := sections["avatar"]; output != "" {
+		var a data.Avatar
+		if err := json.Unmarshal([]byte(output), &a); err != nil {
+			return nil, err
+		}
+
+		rc, _, err := app.Blobs.Get(c.Request().Context(), avatarKey(job.UserID, a.Version, avatarSizeOriginal))
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			// the avatar was replaced since it was read
+		case err != nil:
+			return nil, err
+		default:
+			err := add("avatar."+strings.TrimPrefix(a.ContentType, "image/"), rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := add("manifest.json", bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// eraseUser irreversibly anonymizes a user. The rows related to them are
+// removed or stripped of what identifies them, and the user is left as a
+// deleted tombstone, which keeps the ids that refer to it and its status
+// history valid. Each of these is a step of an erase job, so a failed
+// erasure resumes with the steps it did not complete
+func (app *Config) eraseUser(c echo.Context) error {
+	u, err := app.users(c).GetOne(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	// an erased user keeps the tombstone email, unless its erasure failed
+	// after the profile step and is yet to be resumed
+	_, err = scoped(c, app.PrivacyJobs).Unfinished(u.ID, data.PrivacyErase)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if u.Email == data.ErasedEmail(u.ID) {
+			return c.JSON(http.StatusConflict, errorResponse{Error: "user already erased"})
+		}
+	case err != nil:
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	job, _, err := app.runPrivacyJob(c, u, data.PrivacyErase, app.eraseSteps(c, u))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "erasure failed, retry to resume it"})
+	}
+
+	return c.JSON(http.StatusOK, newPrivacyJobV1(job))
+}
+
+// eraseSteps returns the steps that erase u, those of the data the server
+// stores. The profile goes last but one, so that a resumed erasure still
+// knows the email its earlier steps look rows up by
+func (app *Config) eraseSteps(c echo.Context, u *data.User) []privacyStep {
+	var steps []privacyStep
+
+	if app.Sessions != nil {
+		steps = append(steps, privacyStep{"sessions", func() (interface{}, error) {
+			return nil, scoped(c, app.Sessions).DeleteByUser(u.ID)
+		}})
+	}
+
+	if app.TwoFactor != nil {
+		steps = append(steps, privacyStep{"two_factor", func() (interface{}, error) {
+			return nil, app.TwoFactor.Delete(u.ID)
+		}})
+	}
+
+	if app.Identities != nil {
+		steps = append(steps, privacyStep{"identities", func() (interface{}, error) {
+			return nil, scoped(c, app.Identities).DeleteByUser(u.ID)
+		}})
+	}
+
+	if app.Groups != nil {
+		steps = append(steps, privacyStep{"groups", func() (interface{}, error) {
+			return nil, scoped(c, app.Groups).RemoveFromAll(u.ID)
+		}})
+	}
+
+	if app.Fields != nil {
+		steps = append(steps, privacyStep{"attributes", func() (interface{}, error) {
+			attrs, err := scoped(c, app.Fields).Attributes([]string{u.ID})
+			if err != nil || len(attrs[u.ID]) == 0 {
+				return nil, err
+			}
+
+			removed := make(data.Attributes, len(attrs[u.ID]))
+			for name := range attrs[u.ID] {
+				removed[name] = nil
+			}
+
+			return nil, scoped(c, app.Fields).SetAttributes(u.ID, removed)
+		}})
+	}
+
+	if app.avatarsEnabled() {
+		// the images go first, as nothing leads to them once the avatar is
+		// deleted
+		steps = append(steps, privacyStep{"avatar", func() (interface{}, error) {
+			a, err := scoped(c, app.Avatars).GetByUser(u.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			for _, size := range avatarBlobSizes() {
+				if err := app.Blobs.Delete(c.Request().Context(), avatarKey(u.ID, a.Version, size)); err != nil {
+					return nil, err
+				}
+			}
+
+			return nil, scoped(c, app.Avatars).DeleteByUser(u.ID)
+		}})
+	}
+
+	if app.LoginEvents != nil {
+		steps = append(steps, privacyStep{"login_events", func() (interface{}, error) {
+			return nil, scoped(c, app.LoginEvents).Anonymize(u.ID, u.Email)
+		}})
+	}
+
+	if app.LoginAttempts != nil {
+		steps = append(steps, privacyStep{"login_attempts", func() (interface{}, error) {
+			return nil, app.LoginAttempts.Reset(accountKey(c, u.Email))
+		}})
+	}
+
+	if app.Invitations != nil {
+		steps = append(steps, privacyStep{"invitations", func() (interface{}, error) {
+			return nil, scoped(c, app.Invitations).Anonymize(u.ID, u.Email)
+		}})
+	}
+
+	if app.oauthServer() {
+		steps = append(steps,
+			privacyStep{"oauth_consents", func() (interface{}, error) {
+				return nil, app.Consents.DeleteByUser(u.ID)
+			}},
+			// revoked tokens are kept so that the access tokens issued with
+			// them stay revoked
+			privacyStep{"oauth_tokens", func() (interface{}, error) {
+				return nil, app.OAuthTokens.RevokeByUser(u.ID)
+			}},
+		)
+	}
+
+	if app.Idempotency != nil {
+		steps = append(steps, privacyStep{"stored_responses", func() (interface{}, error) {
+			return nil, app.Idempotency.DeleteByUser(u.ID)
+		}})
+	}
+
+	return append(steps,
+		// the sections of unfinished exports hold what is being erased
+		privacyStep{"exports", func() (interface{}, error) {
+			return nil, scoped(c, app.PrivacyJobs).Abandon(u.ID, data.PrivacyExport)
+		}},
+		privacyStep{"profile", func() (interface{}, error) {
+			return nil, app.users(c).Anonymize(u.ID)
+		}},
+		privacyStep{"status", func() (interface{}, error) {
+			current, err := app.users(c).GetOne(u.ID)
+			if err != nil || current.Status == data.StatusDeleted {
+				return nil, err
+			}
+
+			return nil, app.users(c).Transition(data.StatusTransition{
+				UserID: u.ID,
+				From:   current.Status,
+				To:     data.StatusDeleted,
+				Reason: erasedReason,
+				Actor:  actorOf(c),
+			})
+		}},
+	)
+}
+
+// getPrivacyJobs lists the exports and erasures of a user, newest first
+func (app *Config) getPrivacyJobs(c echo.Context) error {
+	id := c.Param("id")
+
+	if _, err := app.users(c).GetOne(id); err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	jobs, err := scoped(c, app.PrivacyJobs).GetByUser(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]privacyJobV1, len(jobs))
+	for i, j := range jobs {
+		res[i] = newPrivacyJobV1(j)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// runPrivacyJob runs the steps of a job of kind for u and returns the
+// completed job with the outputs of its steps, by name. The latest
+// unfinished job of the kind is resumed, skipping the steps it completed,
+// or else a job is started. A failing step fails the job, which keeps the
+// steps completed before it for the next request to resume
+func (app *Config) runPrivacyJob(c echo.Context, u *data.User, kind data.PrivacyJobKind, steps []privacyStep) (*data.PrivacyJob, map[string]string, error) {
+	jobs := scoped(c, app.PrivacyJobs)
+
+	job, err := jobs.Unfinished(u.ID, kind)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		id, err := jobs.Start(data.PrivacyJob{UserID: u.ID, Kind: kind, Actor: actorOf(c)})
+		if err != nil {
+			return nil, nil, err
+		}
+		job = &data.PrivacyJob{ID: id}
+	case err != nil:
+		return nil, nil, err
+	default:
+		if err := jobs.Resume(job.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	outputs := make(map[string]string, len(steps))
+	for _, s := range job.Steps {
+		outputs[s.Name] = s.Output
+	}
+
+	for _, step := range steps {
+		if _, done := job.Step(step.name); done {
+			continue
+		}
+
+		output, err := runPrivacyStep(step)
+		if err != nil {
+			if ferr := jobs.Fail(job.ID, step.name+": "+err.Error()); ferr != nil {
+				c.Logger().Error(ferr)
+			}
+			return nil, nil, fmt.Errorf("%s %s: %w", kind, step.name, err)
+		}
+
+		if err := jobs.CompleteStep(job.ID, step.name, output); err != nil {
+			return nil, nil, err
+		}
+		outputs[step.name] = output
+	}
+
+	if err := jobs.Complete(job.ID); err != nil {
+		return nil, nil, err
+	}
+
+	done, err := jobs.GetOne(job.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return done, outputs, nil
+}
+
+// runPrivacyStep runs a step and returns its output as JSON, or empty when
+// it found nothing
+func runPrivacyStep(step privacyStep) (string, error) {
+	out, err := step.run()
+	if err != nil || out == nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil || string(b) == "null" {
+		return "", err
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,190 @@
+package controllers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("data subject requests", func() {
+
+	type job struct {
+		Kind   string `json:"kind"`
+		Status string `json:"status"`
+		Steps  []struct {
+			Name string `json:"name"`
+		} `json:"steps"`
+	}
+
+	var (
+		app    controllers.Config
+		e      *echo.Echo
+		userID string
+	)
+
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	logIn := func() *httptest.ResponseRecorder {
+		return do("POST", "/v1/login", `{"email": "clark@mail.com", "password": "password"}`)
+	}
+
+	// archive returns the files of an export of the user
+	archive := func(headers ...string) map[string]string {
+		w := do("GET", "/v1/users/"+userID+"/data-export", "", headers...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/zip"))
+
+		z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		Expect(err).NotTo(HaveOccurred())
+
+		files := map[string]string{}
+		for _, f := range z.File {
+			r, err := f.Open()
+			Expect(err).NotTo(HaveOccurred())
+			b, err := io.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			files[f.Name] = string(b)
+		}
+
+		return files
+	}
+
+	token := func() string {
+		w := logIn()
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var l struct {
+			AccessToken string `json:"access_token"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &l)).To(Succeed())
+
+		return l.AccessToken
+	}
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "first_name": "Clark"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		userID = u.ID
+
+		w = do("POST", "/v1/users/"+userID+":activate", `{"reason": "email verified"}`, admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+	})
+
+	It("should export the data of a user as a zip archive", func() {
+		access := token()
+
+		files := archive("Authorization", "Bearer "+access)
+		Expect(files).To(HaveKey("manifest.json"))
+		Expect(files).To(HaveKey("sessions.json"))
+		Expect(files["profile.json"]).To(ContainSubstring(`"first_name":"Clark"`))
+		Expect(files["login_events.json"]).To(ContainSubstring("clark@mail.com"))
+
+		w := do("GET", "/v1/users/"+userID+"/privacy-jobs", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var jobs []job
+		Expect(json.Unmarshal(w.Body.Bytes(), &jobs)).To(Succeed())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].Kind).To(Equal("export"))
+		Expect(jobs[0].Status).To(Equal("completed"))
+	})
+
+	It("should erase the personal data of a user and keep a tombstone", func() {
+		access := token()
+
+		w := do("POST", "/v1/users/"+userID+"/erase", "", "Authorization", "Bearer "+access)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("POST", "/v1/users/"+userID+"/erase", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var j job
+		Expect(json.Unmarshal(w.Body.Bytes(), &j)).To(Succeed())
+		Expect(j.Status).To(Equal("completed"))
+		Expect(j.Steps).NotTo(BeEmpty())
+
+		w = do("GET", "/v1/users/"+userID, "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(userID + "@erased.invalid"))
+		Expect(w.Body.String()).NotTo(ContainSubstring("Clark"))
+		Expect(w.Body.String()).To(ContainSubstring(`"status":"deleted"`))
+
+		w = logIn()
+		Expect(w.Code).NotTo(Equal(http.StatusOK))
+
+		w = do("GET", "/v1/users/"+userID+"/login-events", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("clark@mail.com"))
+
+		w = do("POST", "/v1/users/"+userID+"/erase", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("should export and erase the OAuth grants and stored responses of a user", func() {
+		w := do("POST", "/v1/users", `{"email": "lois@mail.com", "password": "password", "first_name": "Lois"}`, "Idempotency-Key", "create-lois")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		userID = u.ID
+
+		clientID, err := app.OAuthClients.Insert(data.OAuthClient{Name: "Daily Planet", GrantTypes: []string{data.GrantRefreshToken}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.Consents.Save(userID, clientID, []string{"openid"})).To(Succeed())
+		family, err := app.OAuthTokens.InsertRefreshToken(data.RefreshToken{
+			Hash: data.HashOAuthToken("refresh"), ClientID: clientID, UserID: userID,
+			Scopes: []string{"openid"}, ExpiresAt: time.Now().Add(time.Hour),
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		files := archive(admin...)
+		Expect(files["oauth_consents.json"]).To(ContainSubstring(clientID))
+		Expect(files["oauth_tokens.json"]).To(ContainSubstring(family))
+		Expect(files["oauth_tokens.json"]).NotTo(ContainSubstring(data.HashOAuthToken("refresh")))
+		Expect(files["stored_responses.json"]).To(ContainSubstring(`"first_name":"Lois"`))
+
+		w = do("POST", "/v1/users/"+userID+"/erase", "", admin...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		Expect(app.Consents.GetByUser(userID)).To(BeEmpty())
+		Expect(app.OAuthTokens.FamilyRevoked(family)).To(BeTrue())
+		Expect(app.Idempotency.GetByUser(userID)).To(BeEmpty())
+
+		// the key no longer replays the profile of the erased user
+		w = do("POST", "/v1/users", `{"email": "lois@mail.com", "password": "password", "first_name": "Lois"}`, "Idempotency-Key", "create-lois")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(w.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+		Expect(w.Body.String()).NotTo(ContainSubstring(userID))
+	})
+})
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+const (
+	// cookieLastWrite remembers, in Unix milliseconds, the last write of a
+	// client so that its next requests read it back from the primary
+	cookieLastWrite = "last_write"
+
+	contextWriteClock = "write_clock"
+)
+
+// readYourWrites gives every request the write clock of its client, started
+// from the last write remembered by its cookie. The cookie is updated when
+// the request writes, so that the reads of the client see its own writes
+// whichever instance serves them. Reads without the cookie, as for clients
+// that ignore cookies, still see the writes made earlier in the same request
+func (app *Config) readYourWrites() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var last time.Time
+			if cookie, err := c.Cookie(cookieLastWrite); err == nil {
+				if ms, err := strconv.ParseInt(cookie.Value, 10, 64); err == nil {
+					last = time.UnixMilli(ms)
+				}
+			}
+
+			// a write in the future would keep the client on the primary
+			if last.After(time.Now()) {
+				last = time.Time{}
+			}
+
+			clock := data.NewWriteClock(last)
+			c.Set(contextWriteClock, clock)
+
+			c.Response().Before(func() {
+				if wrote := clock.LastWrite(); wrote.After(last) {
+					c.SetCookie(&http.Cookie{
+						Name:     cookieLastWrite,
+						Value:    strconv.FormatInt(wrote.UnixMilli(), 10),
+						Path:     "/",
+						HttpOnly: true,
+						Secure:   c.Scheme() == "https",
+						SameSite: http.SameSiteLaxMode,
+					})
+				}
+			})
+
+			return next(c)
+		}
+	}
+}
+
+// writeClockOf returns the write clock of the request, nil outside of
+// readYourWrites
+func writeClockOf(c echo.Context) *data.WriteClock {
+	clock, _ := c.Get(contextWriteClock).(*data.WriteClock)
+	return clock
+}
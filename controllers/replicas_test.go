@@ -0,0 +1,84 @@
+package controllers_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("read your writes", func() {
+
+	const (
+		clark     = "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"
+		selectOne = `SELECT .* FROM users WHERE user_id = \$1`
+	)
+
+	var (
+		e                *echo.Echo
+		primary, replica sqlmock.Sqlmock
+	)
+
+	newMock := func() (*sql.DB, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		return db, mock
+	}
+
+	userRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "email", "first_name", "last_name", "password", "user_status", "created_at", "updated_at"}).
+			AddRow(clark, "clark@mail.com", "Clark", "Kent", "hash", "active", time.Now(), time.Now())
+	}
+
+	do := func(method, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	BeforeEach(func() {
+		var primaryDB, replicaDB *sql.DB
+		primaryDB, primary = newMock()
+		replicaDB, replica = newMock()
+
+		set := data.NewReplicaSet(primaryDB, []*sql.DB{replicaDB}, time.Minute)
+		app := controllers.Config{Repo: data.NewReplicatedRepository(set, data.Postgres)}
+		e = app.NewServer()
+	})
+
+	It("should read the writes of a client back from the primary", func() {
+		primary.ExpectExec(`DELETE FROM users`).WillReturnResult(sqlmock.NewResult(1, 1))
+		w := do("DELETE", "/v1/users/"+clark)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		cookies := w.Result().Cookies()
+		Expect(cookies).To(HaveLen(1))
+		Expect(cookies[0].Name).To(Equal("last_write"))
+
+		primary.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow())
+		w = do("GET", "/v1/users/"+clark, cookies[0])
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Result().Cookies()).To(BeEmpty())
+
+		// other clients read from the replicas
+		replica.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow())
+		w = do("GET", "/v1/users/"+clark)
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+})
@@ -1,7 +1,13 @@
 package controllers
 
 import (
+	"crypto/rsa"
+	"time"
+
 	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/danielboakye/go-echo-app/storage"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/log"
@@ -9,12 +15,144 @@ import (
 
 type Config struct {
 	Repo data.IRepository
+
+	// Idempotency stores responses of requests sent with an Idempotency-Key
+	// header. An in-memory store is used when it is nil
+	Idempotency    data.IIdempotencyStore
+	IdempotencyTTL time.Duration
+
+	// CacheMaxAge is how long clients may reuse a user read before
+	// revalidating it with If-None-Match. They always revalidate when it is
+	// zero
+	CacheMaxAge time.Duration
+
+	// Organizations makes the server multi-tenant: the users of each
+	// organization are kept apart and requests are resolved to one, see
+	// tenancy. Users are not scoped when it is nil
+	Organizations data.IOrganizationRepository
+	// DefaultOrganization, an id or slug, serves requests that name no
+	// organization. They are rejected when it is empty
+	DefaultOrganization string
+	// TenantDomain is the parent domain of organization subdomains, e.g.
+	// users.example.com for acme.users.example.com
+	TenantDomain string
+	// TenantTokenSecret verifies the HS256 bearer tokens whose org_id claim
+	// names the organization of a request
+	TenantTokenSecret []byte
+	// AdminToken is the bearer token of the admin principal, which holds
+	// every scope, including the one of the organization admin endpoints
+	AdminToken string
+
+	// APIKeys stores the keys of machine clients, which authenticate with
+	// Authorization: Bearer or X-API-Key. The /api-keys endpoints are only
+	// served when it is set
+	APIKeys data.IAPIKeyRepository
+	// RequireAuth rejects anonymous requests to the users API. Without it
+	// only the principals that authenticate are held to the route policies,
+	// and anonymous requests may still write until API keys are issued
+	RequireAuth bool
+
+	// TokenSecret signs the access tokens users get at /login, which is
+	// only served when it is set. Tokens last TokenTTL, 15 minutes if unset
+	TokenSecret []byte
+	TokenTTL    time.Duration
+	// TwoFactor stores the TOTP enrollments of users. Once a user enables
+	// two-factor authentication, logins need a code as a second step
+	TwoFactor data.ITwoFactorRepository
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer string
+	// TOTPSkew is the number of 30 second steps a code may be early or late
+	TOTPSkew int
+
+	// Sessions records the logins of users. When it is set, access tokens
+	// belong to a session, which stops them working once it is revoked,
+	// and users can list and revoke their sessions
+	Sessions data.ISessionRepository
+
+	// LoginAttempts counts failed logins per account and per IP, which
+	// Lockout slows down and locks out. Logins are not limited when it is
+	// nil
+	LoginAttempts data.IAttemptRepository
+	Lockout       LockoutPolicy
+	// LoginEvents records the IP, user agent and outcome of every login
+	LoginEvents data.ILoginEventRepository
+	// Mailer sends the emails of the service, such as the notices of logins
+	// from new devices, which need LoginEvents. None are sent when it is
+	// nil
+	Mailer mail.Mailer
+
+	// OIDC are the identity providers users may sign in with, by name, at
+	// /auth/oidc/:provider/start. They need TokenSecret and Identities
+	OIDC map[string]*oidc.Provider
+	// Identities links the accounts of users at the providers to them
+	Identities data.IIdentityRepository
+
+	// Issuer is the URL the server is reached at as an OAuth2 and OpenID
+	// Connect authorization server, e.g. https://users.example.com. The
+	// authorization server runs when it, SigningKey and the OAuth stores
+	// are set
+	Issuer string
+	// SigningKey signs the access and ID tokens of the authorization
+	// server with RS256. Its public key is published at /oauth/jwks
+	SigningKey *rsa.PrivateKey
+	// OAuthClients stores the registered clients, and Consents the scopes
+	// users granted them
+	OAuthClients data.IOAuthClientRepository
+	Consents     data.IConsentRepository
+	// OAuthTokens stores authorization codes and refresh tokens
+	OAuthTokens data.IOAuthTokenRepository
+
+	// Fields stores the custom fields of each organization and the values
+	// users have for them, which the user API exposes as attributes. The
+	// /custom-fields endpoints are only served when it is set
+	Fields data.IFieldRepository
+
+	// Blobs stores the images of avatars, and Avatars the version each user
+	// has. The avatar endpoints are only served when both are set
+	Blobs   storage.BlobStore
+	Avatars data.IAvatarRepository
+	// AvatarMaxBytes is the largest avatar image accepted, 5 MiB if unset
+	AvatarMaxBytes int64
+
+	// Groups stores the groups of each organization and their members, who
+	// hold the scopes of their groups. The /groups endpoints are only served
+	// when it is set
+	Groups data.IGroupRepository
+
+	// Invitations stores the invitations to create an account that are
+	// emailed to people instead of creating their user with a password.
+	// The /invitations endpoints are only served when it, TokenSecret, which
+	// signs their tokens, and Mailer are set
+	Invitations data.IInvitationRepository
+	// InvitationTTL is how long an invitation may be accepted, 7 days if
+	// unset
+	InvitationTTL time.Duration
+	// InvitationURL is the page of the client where invitees choose their
+	// password, which the token is appended to in the email. The email
+	// carries the bare token when it is empty
+	InvitationURL string
+
+	// PrivacyJobs tracks the exports and erasures of the data of users, one
+	// step at a time, so that a job cut short resumes where it stopped. The
+	// /users/:id/data-export and /users/:id/erase endpoints are only served
+	// when it is set
+	PrivacyJobs data.IPrivacyJobRepository
+
+	// Debug also validates responses against the OpenAPI spec and logs
+	// any mismatch
+	Debug bool
 }
 
 func (app *Config) NewServer() *echo.Echo {
 
+	if app.Idempotency == nil {
+		app.Idempotency = data.NewMemoryIdempotencyStore()
+	}
+
 	e := echo.New()
 
+	e.Pre(negotiateVersion())
+
 	e.Logger.SetLevel(log.INFO)
 
 	e.Use(middleware.Logger())
@@ -22,16 +160,176 @@ func (app *Config) NewServer() *echo.Echo {
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.POST, echo.DELETE},
-		AllowHeaders: []string{"Accept", "Authorization", "Content-Type"},
-		MaxAge:       300,
+		AllowMethods: []string{echo.GET, echo.HEAD, echo.POST, echo.PUT, echo.DELETE},
+		AllowHeaders: []string{"Accept", "Authorization", "Content-Type", headerIdempotencyKey, headerIfNoneMatch, headerOrganization, headerAPIKey},
+		ExposeHeaders: []string{
+			headerAPIVersion, headerDeprecation, headerSunset, headerLink, headerETag,
+		},
+		MaxAge: 300,
 	}))
+	e.Use(app.validateAgainstSpec())
+	e.Use(app.authenticate())
+	e.Use(app.tenancy())
+	e.Use(app.readYourWrites())
+
+	e.GET("/openapi.json", app.getOpenAPI)
+	e.GET("/docs", app.getDocs)
+	e.POST("/graphql", app.graphQL(), app.allow(ScopeUsersRead))
 
-	e.GET("/users", app.getAllUsers)
-	e.GET("/users/:id", app.getUser)
-	e.POST("/users", app.saveUser)
-	e.POST("/users/:id", app.updateUser)
-	e.DELETE("/users/:id", app.deleteUser)
+	if app.oauthServer() {
+		e.GET("/.well-known/openid-configuration", app.openIDConfiguration)
+		e.GET("/oauth/jwks", app.jwks)
+		e.GET("/oauth/authorize", app.authorize)
+		e.POST("/oauth/authorize", app.authorize)
+		e.POST("/oauth/token", app.token)
+		e.GET("/oauth/userinfo", app.userinfo)
+		e.POST("/oauth/userinfo", app.userinfo)
+		e.POST("/oauth/introspect", app.introspect)
+		e.POST("/oauth/revoke", app.revoke)
+	}
+
+	app.v1Routes(e.Group("/v1"))
 
 	return e
 }
+
+// v1Routes registers version 1 of the API. Unversioned paths reach it
+// through negotiateVersion
+func (app *Config) v1Routes(g *echo.Group) {
+	read, write := app.allow(ScopeUsersRead), app.allow(ScopeUsersWrite)
+
+	g.GET("/users", app.getAllUsers, read, app.conditionalGet())
+	g.GET("/users/export", app.exportUsers, read)
+	g.GET("/users/search", app.searchUsers, read)
+	g.GET("/users/:id", app.getUser, read, app.conditionalGet())
+	g.POST("/users", app.saveUser, write, app.idempotent())
+	g.POST("/users:method", customMethods{
+		"batchCreate": write(app.batchCreateUsers),
+		"batchGet":    read(app.batchGetUsers),
+	}.handler("method"))
+	admin := app.require(ScopeAdmin)
+	userMethods := customMethods{
+		"":           app.updateUser,
+		"suspend":    admin(app.setStatus(data.StatusSuspended)),
+		"activate":   admin(app.setStatus(data.StatusActive)),
+		"deactivate": admin(app.setStatus(data.StatusDeactivated)),
+	}
+	if app.LoginAttempts != nil {
+		userMethods["unlock"] = admin(app.unlockUser)
+	}
+	g.POST("/users/:id", userMethods.itemHandler("id"), write)
+	g.DELETE("/users/:id", app.deleteUser, write)
+	g.GET("/users/:id/status-history", app.getStatusHistory, app.selfOr("id", ScopeAdmin))
+
+	if app.avatarsEnabled() {
+		g.GET("/users/:id/avatar", app.getAvatar, read)
+		g.PUT("/users/:id/avatar", app.putAvatar, app.selfOr("id", ScopeUsersWrite))
+		g.DELETE("/users/:id/avatar", app.deleteAvatar, app.selfOr("id", ScopeUsersWrite))
+	}
+
+	if len(app.TokenSecret) > 0 {
+		g.POST("/login", app.login)
+		g.POST("/login:method", customMethods{
+			"verify": app.verifyLogin,
+		}.handler("method"))
+	}
+
+	if len(app.TokenSecret) > 0 && len(app.OIDC) > 0 && app.Identities != nil {
+		g.GET("/auth/oidc/:provider/start", app.startOIDC)
+		g.GET("/auth/oidc/:provider/callback", app.oidcCallback)
+	}
+
+	if app.TwoFactor != nil {
+		self := app.self("id")
+		g.POST("/users/:id/2fa", app.enrollTwoFactor, self)
+		g.POST("/users/:id/2fa:method", customMethods{
+			"confirm": app.confirmTwoFactor,
+			"disable": app.disableTwoFactor,
+		}.handler("method"), self)
+		g.DELETE("/users/:id/2fa", app.resetTwoFactor, app.require(ScopeAdmin))
+	}
+
+	if app.Sessions != nil {
+		owner := app.selfOr("id", ScopeAdmin)
+		g.GET("/users/:id/sessions", app.getSessions, owner)
+		g.POST("/users/:id/sessions:method", customMethods{
+			"revokeAll": app.revokeAllSessions,
+		}.handler("method"), owner)
+		g.DELETE("/users/:id/sessions/:sid", app.revokeSession, owner)
+	}
+
+	if app.LoginEvents != nil {
+		g.GET("/users/:id/login-events", app.getLoginEvents, app.selfOr("id", ScopeAdmin))
+	}
+
+	if app.APIKeys != nil {
+		keys := app.require(ScopeAPIKeys)
+		g.GET("/api-keys", app.getAllAPIKeys, keys)
+		g.POST("/api-keys", app.saveAPIKey, keys)
+		g.GET("/api-keys/:id", app.getAPIKey, keys)
+		g.POST("/api-keys/:id", customMethods{
+			"rotate": app.rotateAPIKey,
+		}.itemHandler("id"), keys)
+		g.DELETE("/api-keys/:id", app.revokeAPIKey, keys)
+	}
+
+	if app.oauthServer() {
+		admin := app.require(ScopeAdmin)
+		g.GET("/oauth-clients", app.getAllOAuthClients, admin)
+		g.POST("/oauth-clients", app.saveOAuthClient, admin)
+		g.GET("/oauth-clients/:id", app.getOAuthClient, admin)
+		g.DELETE("/oauth-clients/:id", app.deleteOAuthClient, admin)
+	}
+
+	if app.Fields != nil {
+		g.GET("/custom-fields", app.getAllFields, read)
+		g.POST("/custom-fields", app.saveField, admin)
+		g.GET("/custom-fields/:name", app.getField, read)
+		g.POST("/custom-fields/:name", app.updateField, admin)
+		g.DELETE("/custom-fields/:name", app.deleteField, admin)
+	}
+
+	if app.Groups != nil {
+		g.GET("/groups", app.getAllGroups, read)
+		g.POST("/groups", app.saveGroup, admin)
+		g.GET("/groups/:id", app.getGroup, read)
+		g.POST("/groups/:id", app.updateGroup, admin)
+		g.DELETE("/groups/:id", app.deleteGroup, admin)
+		g.GET("/groups/:id/members", app.getGroupMembers, read)
+		g.POST("/groups/:id/members", app.addGroupMember, admin)
+		g.DELETE("/groups/:id/members/:user_id", app.removeGroupMember, admin)
+		g.GET("/users/:id/groups", app.getUserGroups, read)
+	}
+
+	if app.invitationsEnabled() {
+		inviters := app.require(ScopeUsersWrite)
+		g.GET("/invitations", app.getAllInvitations, inviters)
+		g.POST("/invitations", app.saveInvitation, inviters)
+		g.POST("/invitations/:id", customMethods{
+			"resend": app.resendInvitation,
+		}.itemHandler("id"), inviters)
+		g.DELETE("/invitations/:id", app.revokeInvitation, inviters)
+		g.POST("/invitations/:token/accept", app.acceptInvitation)
+	}
+
+	if app.PrivacyJobs != nil {
+		g.GET("/users/:id/data-export", app.exportUserData, app.selfOr("id", ScopeAdmin))
+		g.POST("/users/:id/erase", app.eraseUser, admin)
+		g.GET("/users/:id/privacy-jobs", app.getPrivacyJobs, admin)
+	}
+
+	if app.Organizations != nil {
+		admin := app.require(ScopeAdmin)
+		g.GET("/organizations", app.getAllOrganizations, admin)
+		g.POST("/organizations", app.saveOrganization, admin)
+		g.GET("/organizations/:id", app.getOrganization, admin)
+		g.POST("/organizations/:id", app.updateOrganization, admin)
+		g.DELETE("/organizations/:id", app.deleteOrganization, admin)
+	}
+}
+
+// oauthServer reports whether the server is configured as an OAuth2
+// authorization server
+func (app *Config) oauthServer() bool {
+	return app.Issuer != "" && app.SigningKey != nil && app.OAuthClients != nil && app.Consents != nil && app.OAuthTokens != nil
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchQuery     = 255
+)
+
+type searchResponse struct {
+	Results []searchResultV1 `json:"results"`
+	Total   int              `json:"total"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+}
+
+func (app *Config) searchUsers(c echo.Context) error {
+	opts := data.SearchOptions{
+		Query: strings.TrimSpace(c.QueryParam("q")),
+		Limit: defaultSearchLimit,
+	}
+
+	if opts.Query == "" || len(opts.Query) > maxSearchQuery {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid query"})
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid limit"})
+		}
+		opts.Limit = limit
+	}
+
+	if v := c.QueryParam("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid offset"})
+		}
+		opts.Offset = offset
+	}
+
+	if v := c.QueryParam("active"); v != "" {
+		active, err := strconv.Atoi(v)
+		if err != nil || (active != 0 && active != 1) {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid active"})
+		}
+		b := active == 1
+		opts.Active = &b
+	}
+
+	if v := c.QueryParam("status"); v != "" {
+		opts.Status = data.Status(v)
+		if !opts.Status.Valid() {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid status"})
+		}
+	}
+
+	results, total, err := app.users(c).Search(opts)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	res := newSearchResultsV1(results)
+	refs := make([]*userV1, len(res))
+	for i := range res {
+		refs[i] = &res[i].User
+	}
+	if err := app.expandUsers(c, refs...); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.JSON(http.StatusOK, searchResponse{
+		Results: res,
+		Total:   total,
+		Limit:   opts.Limit,
+		Offset:  opts.Offset,
+	})
+}
@@ -0,0 +1,96 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("search Users", func() {
+
+	var (
+		resp   *http.Response
+		result struct {
+			Results []struct {
+				User struct {
+					ID string `json:"user_id"`
+				} `json:"user"`
+				Rank       float64           `json:"rank"`
+				Highlights map[string]string `json:"highlights"`
+			} `json:"results"`
+			Total int `json:"total"`
+			Limit int `json:"limit"`
+		}
+	)
+
+	Context("successful request", func() {
+		BeforeEach(func() {
+			app, mockDB := newTestApp()
+
+			mockDB.ExpectQuery(`
+					SELECT
+						user_id, email, first_name, last_name, user_status, created_at, updated_at,
+						GREATEST(similarity(first_name, $1), similarity(last_name, $2), similarity(email, $3)) AS rank,
+						COUNT(*) OVER() AS total
+					FROM users
+					WHERE (first_name % $4 OR last_name % $5 OR email % $6 OR first_name ILIKE $7 OR last_name ILIKE $8 OR email ILIKE $9)
+					ORDER BY rank DESC, last_name ASC, user_id ASC
+					LIMIT 5`,
+			).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"user_id", "email", "first_name",
+							"last_name", "user_status",
+							"created_at", "updated_at",
+							"rank", "total",
+						},
+					).
+						AddRow(
+							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "example@mail.com", "Clark",
+							"Kent", "active",
+							time.Now(), time.Now(),
+							0.5, 1,
+						),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/users/search?q=kent&limit=5", nil)
+			app.NewServer().ServeHTTP(w, r)
+
+			resp = w.Result()
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(json.Unmarshal(body, &result)).To(Succeed())
+		})
+
+		It("should set correct status code", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("should return the ranked page", func() {
+			Expect(result.Total).To(Equal(1))
+			Expect(result.Limit).To(Equal(5))
+			Expect(result.Results[0].User.ID).To(Equal("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"))
+			Expect(result.Results[0].Highlights).To(HaveKeyWithValue("last_name", "<mark>Kent</mark>"))
+		})
+	})
+
+	When("the query is missing", func() {
+		It("should set correct status code", func() {
+			app, _ := newTestApp()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/users/search", nil)
+			app.NewServer().ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
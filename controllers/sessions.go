@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// sessionTouchInterval is how stale last_seen_at may get before a use of the
+// session records it again
+const sessionTouchInterval = time.Minute
+
+// checkSession fails access tokens whose session is unknown, revoked or
+// expired, or belongs to another user, and records that the session was seen
+func (app *Config) checkSession(claims *tokenClaims) error {
+	sessions := app.Sessions
+	if claims.OrgID != "" {
+		sessions = sessions.WithTenant(claims.OrgID)
+	}
+
+	if claims.SessionID == "" {
+		return errInvalidCredentials
+	}
+
+	s, err := sessions.GetOne(claims.SessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !s.Active(now) || s.UserID != claims.Subject {
+		return errInvalidCredentials
+	}
+
+	if now.Sub(s.LastSeenAt) > sessionTouchInterval {
+		// last_seen_at is informative, so failing to record it does not fail
+		// the request
+		_ = sessions.Touch(s.ID, now)
+	}
+
+	return nil
+}
+
+func (app *Config) getSessions(c echo.Context) error {
+	sessions, err := scoped(c, app.Sessions).GetByUser(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	var current string
+	if p := principalOf(c); p != nil {
+		current = p.SessionID
+	}
+
+	res := make([]sessionV1, len(sessions))
+	for i, s := range sessions {
+		res[i] = newSessionV1(s, current)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// revokeSession logs a user out of one session. The access token of the
+// session stops working at once
+func (app *Config) revokeSession(c echo.Context) error {
+	s, err := scoped(c, app.Sessions).GetOne(c.Param("sid"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && s.UserID != c.Param("id")) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "session not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := scoped(c, app.Sessions).Revoke(s.ID); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// revokeAllSessions logs a user out everywhere, including the session of the
+// request
+func (app *Config) revokeAllSessions(c echo.Context) error {
+	if err := scoped(c, app.Sessions).RevokeAll(c.Param("id")); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deviceName describes the device of a user agent for people to recognize
+// their sessions by, such as "Firefox on Windows"
+func deviceName(ua string) string {
+	var browser string
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	var os string
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+
+	return "Unknown device"
+}
@@ -0,0 +1,200 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sessions", func() {
+
+	type session struct {
+		ID        string `json:"session_id"`
+		Device    string `json:"device"`
+		IP        string `json:"ip"`
+		UserAgent string `json:"user_agent"`
+		Current   bool   `json:"current"`
+	}
+
+	const firefox = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+	var (
+		e      *echo.Echo
+		userID string
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	bearer := func(token string) []string {
+		return []string{"Authorization", "Bearer " + token}
+	}
+
+	logIn := func(userAgent string) string {
+		w := do("POST", "/v1/login", `{"email": "clark@mail.com", "password": "password"}`,
+			"User-Agent", userAgent, "X-Real-Ip", "203.0.113.7")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var l struct {
+			AccessToken string `json:"access_token"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &l)).To(Succeed())
+
+		return l.AccessToken
+	}
+
+	list := func(token string) (int, []session) {
+		w := do("GET", "/v1/users/"+userID+"/sessions", "", bearer(token)...)
+
+		var sessions []session
+		if w.Code == http.StatusOK {
+			Expect(json.Unmarshal(w.Body.Bytes(), &sessions)).To(Succeed())
+		}
+
+		return w.Code, sessions
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		userID = u.ID
+	})
+
+	It("should record a session per login and list it to the user", func() {
+		laptop := logIn(firefox)
+		phone := logIn("curl/8.5.0")
+
+		status, sessions := list(laptop)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(sessions).To(HaveLen(2))
+
+		var current session
+		for _, s := range sessions {
+			Expect(s.IP).To(Equal("203.0.113.7"))
+			if s.Current {
+				current = s
+			}
+		}
+		Expect(current.Device).To(Equal("Firefox on Windows"))
+		Expect(current.UserAgent).To(Equal(firefox))
+
+		status, sessions = list(phone)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(sessions).To(ContainElement(And(
+			HaveField("Device", "curl"),
+			HaveField("Current", true),
+		)))
+
+		status, _ = list(testAdminToken)
+		Expect(status).To(Equal(http.StatusOK))
+	})
+
+	It("should keep the sessions of a user to the user and admins", func() {
+		token := logIn(firefox)
+
+		w := do("POST", "/v1/users", `{"email": "lois@mail.com", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var other struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &other)).To(Succeed())
+
+		w = do("GET", "/v1/users/"+other.ID+"/sessions", "", bearer(token)...)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("GET", "/v1/users/"+userID+"/sessions", "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should stop the token of a revoked session at once", func() {
+		laptop := logIn(firefox)
+		phone := logIn("curl/8.5.0")
+
+		_, sessions := list(phone)
+		var laptopSession string
+		for _, s := range sessions {
+			if !s.Current {
+				laptopSession = s.ID
+			}
+		}
+
+		w := do("DELETE", "/v1/users/"+userID+"/sessions/"+laptopSession, "", bearer(phone)...)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		status, _ := list(laptop)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		status, sessions = list(phone)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(sessions).To(HaveLen(1))
+
+		w = do("DELETE", "/v1/users/"+userID+"/sessions/"+laptopSession, "", bearer(phone)...)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		w = do("DELETE", "/v1/users/"+userID+"/sessions/00000000-0000-0000-0000-000000000000", "", bearer(phone)...)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should log a user out everywhere", func() {
+		laptop := logIn(firefox)
+		phone := logIn("curl/8.5.0")
+
+		w := do("POST", "/v1/users/"+userID+"/sessions:revokeAll", "", bearer(laptop)...)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		for _, token := range []string{laptop, phone} {
+			status, _ := list(token)
+			Expect(status).To(Equal(http.StatusUnauthorized))
+		}
+
+		status, sessions := list(testAdminToken)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(sessions).To(BeEmpty())
+	})
+
+	It("should revoke the sessions of deactivated and deleted users", func() {
+		token := logIn(firefox)
+
+		w := do("POST", "/v1/users/"+userID, `{"email": "clark@mail.com", "active": 0}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted), w.Body.String())
+
+		status, _ := list(token)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/users/"+userID+":activate", `{"reason": "account restored"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		token = logIn(firefox)
+		status, _ = list(token)
+		Expect(status).To(Equal(http.StatusOK))
+
+		w = do("DELETE", "/v1/users/"+userID, "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		status, _ = list(token)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+})
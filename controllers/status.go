@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/labstack/echo"
+)
+
+// actorOf names the caller of a request in the status history of users
+func actorOf(c echo.Context) string {
+	p := principalOf(c)
+	switch {
+	case p == nil:
+		return "anonymous"
+	case p.Kind == PrincipalAdmin:
+		return PrincipalAdmin
+	}
+
+	return p.Kind + ":" + p.ID
+}
+
+// setStatus returns the handler of the custom method that moves a user to
+// status to, e.g. POST /users/:id:suspend. The caller gives a reason, which
+// is kept in the status history. Users who can no longer authenticate are
+// logged out everywhere at once
+func (app *Config) setStatus(to data.Status) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var in statusInputV1
+		if err := c.Bind(&in); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+		}
+
+		u, err := app.users(c).GetOne(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+		}
+
+		t := data.StatusTransition{UserID: u.ID, From: u.Status, To: to, Reason: in.Reason, Actor: actorOf(c)}
+		if errs := data.ValidateTransition(t); len(errs) > 0 {
+			return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{Error: "invalid status change", Details: errs})
+		}
+
+		if !u.Status.CanBecome(to) {
+			return c.JSON(http.StatusConflict, errorResponse{Error: "a " + string(u.Status) + " user cannot become " + string(to)})
+		}
+
+		err = app.users(c).Transition(t)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+		case errors.Is(err, data.ErrStatusConflict):
+			return c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+		case err != nil:
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+		u.Status = to
+
+		if err := app.dependents(c).StatusChanged(u); err != nil {
+			return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+		}
+
+		return c.JSON(http.StatusOK, newUserV1(u))
+	}
+}
+
+func (app *Config) getStatusHistory(c echo.Context) error {
+	id := c.Param("id")
+
+	if _, err := app.users(c).GetOne(id); err != nil {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+
+	history, err := app.users(c).Transitions(id)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	res := make([]statusTransitionV1, len(history))
+	for i, t := range history {
+		res[i] = newStatusTransitionV1(t)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,185 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("user status", func() {
+
+	type transition struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Reason string `json:"reason"`
+		Actor  string `json:"actor"`
+	}
+
+	var (
+		e      *echo.Echo
+		userID string
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	bearer := func(token string) []string {
+		return []string{"Authorization", "Bearer " + token}
+	}
+
+	logIn := func() *httptest.ResponseRecorder {
+		return do("POST", "/v1/login", `{"email": "clark@mail.com", "password": "password"}`)
+	}
+
+	token := func() string {
+		w := logIn()
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var l struct {
+			AccessToken string `json:"access_token"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &l)).To(Succeed())
+
+		return l.AccessToken
+	}
+
+	status := func() string {
+		w := do("GET", "/v1/users/"+userID, "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var u struct {
+			Active int    `json:"active"`
+			Status string `json:"status"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		Expect(u.Active == 1).To(Equal(u.Status == "active"))
+
+		return u.Status
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		userID = u.ID
+	})
+
+	It("should keep pending users from logging in until they are activated", func() {
+		Expect(status()).To(Equal("pending"))
+
+		w := logIn()
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Body.String()).To(ContainSubstring("user is pending"))
+
+		w = do("POST", "/v1/users/"+userID+":activate", `{"reason": "email verified"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring(`"status":"active"`))
+
+		token()
+	})
+
+	It("should log out suspended users and refuse their tokens and logins", func() {
+		do("POST", "/v1/users/"+userID+":activate", `{"reason": "email verified"}`, bearer(testAdminToken)...)
+		access := token()
+
+		w := do("POST", "/v1/users/"+userID+":suspend", `{"reason": "chargeback under review"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(status()).To(Equal("suspended"))
+
+		w = do("GET", "/v1/users/"+userID+"/sessions", "", bearer(access)...)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = logIn()
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		// the active flag of v1 does not lift a suspension
+		w = do("POST", "/v1/users/"+userID, `{"email": "clark@mail.com", "active": 0}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Expect(status()).To(Equal("suspended"))
+
+		w = do("POST", "/v1/users/"+userID, `{"email": "kent@mail.com", "active": 1}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+		Expect(status()).To(Equal("suspended"))
+
+		w = do("GET", "/v1/users/"+userID, "", bearer(testAdminToken)...)
+		Expect(w.Body.String()).To(ContainSubstring("clark@mail.com"))
+	})
+
+	It("should only allow the transitions of the state machine", func() {
+		w := do("POST", "/v1/users/"+userID+":suspend", `{"reason": "spam"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/users/"+userID+":deactivate", `{"reason": "duplicate account"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("POST", "/v1/users/"+userID+":deactivate", `{"reason": "again"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = do("POST", "/v1/users/"+userID+":activate", `{"reason": ""}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		w = do("POST", "/v1/users/00000000-0000-0000-0000-000000000000:activate", `{"reason": "restored"}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should let the active flag of v1 only activate pending users and deactivate active ones", func() {
+		w := do("POST", "/v1/users/"+userID, `{"email": "clark@mail.com", "active": 1}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted), w.Body.String())
+		Expect(status()).To(Equal("active"))
+
+		w = do("POST", "/v1/users/"+userID, `{"email": "clark@mail.com", "active": 0}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted), w.Body.String())
+		Expect(status()).To(Equal("deactivated"))
+
+		w = do("POST", "/v1/users/"+userID, `{"email": "clark@mail.com", "active": 1}`, bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+		Expect(status()).To(Equal("deactivated"))
+	})
+
+	It("should leave status changes with a reason to admins", func() {
+		w := do("POST", "/v1/users/"+userID+":activate", `{"reason": "self service"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(status()).To(Equal("pending"))
+	})
+
+	It("should record the history of a user's status", func() {
+		do("POST", "/v1/users/"+userID+":activate", `{"reason": "email verified"}`, bearer(testAdminToken)...)
+		do("POST", "/v1/users/"+userID+":suspend", `{"reason": "chargeback under review"}`, bearer(testAdminToken)...)
+		do("POST", "/v1/users/"+userID+":activate", `{"reason": "chargeback settled"}`, bearer(testAdminToken)...)
+
+		w := do("GET", "/v1/users/"+userID+"/status-history", "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var history []transition
+		Expect(json.Unmarshal(w.Body.Bytes(), &history)).To(Succeed())
+		Expect(history).To(Equal([]transition{
+			{From: "pending", To: "active", Reason: "email verified", Actor: "admin"},
+			{From: "active", To: "suspended", Reason: "chargeback under review", Actor: "admin"},
+			{From: "suspended", To: "active", Reason: "chargeback settled", Actor: "admin"},
+		}))
+
+		w = do("GET", "/v1/users/"+userID+"/status-history", "", bearer(token())...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+})
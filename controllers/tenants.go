@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+)
+
+const (
+	headerOrganization = "X-Organization"
+
+	contextOrganization = "organization"
+
+	// tenantClaim is the claim of a bearer token naming its organization
+	tenantClaim = "org_id"
+)
+
+// tenantResources are the routes whose requests belong to an organization
+var tenantResources = []string{"/v1/users", "/v1/api-keys", "/v1/oauth-clients", "/v1/custom-fields", "/v1/groups", "/v1/invitations", "/v1/login", "/graphql"}
+
+// tokenScopedRoutes are the tenant routes whose organization is named by the
+// signed token in their path rather than by the request, which their
+// handlers resolve themselves
+var tokenScopedRoutes = map[string]bool{
+	"/v1/invitations/:token/accept": true,
+}
+
+// tenancy resolves the organization of each request for a tenant resource
+// and scopes the repositories of the request to it. The organization
+// is named, by id or slug, in the X-Organization header or by a subdomain
+// of TenantDomain, or by the principal: the organization of an API key or
+// access token, or the org_id claim of a bearer token signed with
+// TenantTokenSecret. When
+// several name one they must agree; when none does DefaultOrganization
+// applies.
+func (app *Config) tenancy() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if app.Organizations == nil || !isTenantResource(c.Path()) || tokenScopedRoutes[c.Path()] {
+				return next(c)
+			}
+
+			org, err := app.resolveTenant(c)
+			if err != nil {
+				return c.JSON(err.Code, errorResponse{Error: err.Message.(string)})
+			}
+
+			c.Set(contextOrganization, org)
+
+			return next(c)
+		}
+	}
+}
+
+func (app *Config) resolveTenant(c echo.Context) (*data.Organization, *echo.HTTPError) {
+	r := c.Request()
+
+	var claimed string
+	if p := principalOf(c); p != nil {
+		claimed = p.OrgID
+	} else {
+		var err *echo.HTTPError
+		if claimed, err = app.tokenTenant(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var org *data.Organization
+	for _, ref := range []string{claimed, r.Header.Get(headerOrganization), app.subdomainTenant(r)} {
+		if ref == "" {
+			continue
+		}
+
+		named, err := app.lookupTenant(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if org != nil && org.ID != named.ID {
+			if claimed != "" {
+				return nil, echo.NewHTTPError(http.StatusForbidden, "credentials are not valid for this organization")
+			}
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "conflicting organizations")
+		}
+		org = named
+	}
+
+	if org != nil {
+		return org, nil
+	}
+
+	if app.DefaultOrganization == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "organization required")
+	}
+
+	return app.lookupTenant(app.DefaultOrganization)
+}
+
+func (app *Config) lookupTenant(ref string) (*data.Organization, *echo.HTTPError) {
+	org, err := data.LookupOrganization(app.Organizations, ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "unknown organization")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "processing error")
+	}
+
+	return org, nil
+}
+
+// tokenTenant returns the organization claimed by the bearer token of r.
+// Tokens are only read when TenantTokenSecret is set
+func (app *Config) tokenTenant(r *http.Request) (string, *echo.HTTPError) {
+	if len(app.TenantTokenSecret) == 0 {
+		return "", nil
+	}
+
+	raw := strings.TrimPrefix(r.Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if raw == "" || raw == r.Header.Get(echo.HeaderAuthorization) {
+		return "", nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return app.TenantTokenSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+
+	orgID, _ := claims[tenantClaim].(string)
+
+	return orgID, nil
+}
+
+// subdomainTenant returns the label in front of TenantDomain in the host of
+// r, e.g. acme for acme.users.example.com
+func (app *Config) subdomainTenant(r *http.Request) string {
+	if app.TenantDomain == "" {
+		return ""
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	label := strings.TrimSuffix(strings.ToLower(host), "."+strings.ToLower(app.TenantDomain))
+	if label == strings.ToLower(host) || strings.Contains(label, ".") {
+		return ""
+	}
+
+	return label
+}
+
+func isTenantResource(path string) bool {
+	for _, res := range tenantResources {
+		if path == res || strings.HasPrefix(path, res+"/") || strings.HasPrefix(path, res+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// users returns the users repository of the request, scoped to its
+// organization. Its reads see the writes of the client when they are served
+// by replicas
+func (app *Config) users(c echo.Context) data.IRepository {
+	return data.Sticky(scoped(c, app.Repo), writeClockOf(c))
+}
+
+// dependents returns the repositories of the rows that follow the users of
+// the request
+func (app *Config) dependents(c echo.Context) data.Dependents {
+	return scoped(c, data.Dependents{Sessions: app.Sessions, Groups: app.Groups})
+}
+
+// scoped returns the view of repo for the organization of the request when
+// the server is multi-tenant, and repo itself otherwise
+func scoped[T interface{ WithTenant(string) T }](c echo.Context, repo T) T {
+	if tenant := tenantOf(c); tenant != "" {
+		return repo.WithTenant(tenant)
+	}
+
+	return repo
+}
+
+// tenantOf returns the id of the organization of the request, or an empty
+// string when the server is not multi-tenant
+func tenantOf(c echo.Context) string {
+	if org, ok := c.Get(contextOrganization).(*data.Organization); ok {
+		return org.ID
+	}
+
+	return ""
+}
@@ -0,0 +1,263 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/mail"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tenancy", func() {
+
+	const (
+		clark  = `{"email": "clark@mail.com", "last_name": "Kent", "password": "password", "active": 1}`
+		secret = "tenant-secret"
+	)
+
+	var (
+		app      controllers.Config
+		e        *echo.Echo
+		acme     string
+		umbrella string
+	)
+
+	do := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			if k == "Host" {
+				r.Host = v
+				continue
+			}
+			r.Header.Set(k, v)
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	token := func(orgID string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"org_id": orgID}).SignedString([]byte(secret))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return "Bearer " + signed
+	}
+
+	count := func(headers map[string]string) int {
+		w := do("GET", "/v1/users", "", headers)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var users []data.User
+		Expect(json.Unmarshal(w.Body.Bytes(), &users)).To(Succeed())
+
+		return len(users)
+	}
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+		app.TenantDomain = "users.example.com"
+		app.TenantTokenSecret = []byte(secret)
+
+		var err error
+		acme, err = app.Organizations.Insert(data.Organization{Slug: "acme", Name: "Acme"})
+		Expect(err).ShouldNot(HaveOccurred())
+		umbrella, err = app.Organizations.Insert(data.Organization{Slug: "umbrella", Name: "Umbrella"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e = app.NewServer()
+	})
+
+	It("should keep the users of each organization apart", func() {
+		w := do("POST", "/v1/users", clark, map[string]string{"X-Organization": "acme"})
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var created data.User
+		Expect(json.Unmarshal(w.Body.Bytes(), &created)).To(Succeed())
+
+		w = do("POST", "/v1/users", clark, map[string]string{"X-Organization": umbrella})
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		w = do("POST", "/v1/users", clark, map[string]string{"X-Organization": "acme"})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("user exists"))
+
+		Expect(count(map[string]string{"X-Organization": acme})).To(Equal(1))
+		Expect(count(nil)).To(BeZero())
+
+		w = do("GET", "/v1/users/"+created.ID, "", map[string]string{"X-Organization": "umbrella"})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		w = do("POST", "/graphql", `{"query": "{ users(first: 10) { totalCount } }"}`, map[string]string{"X-Organization": "acme"})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`"totalCount":1`))
+	})
+
+	It("should resolve the organization from a subdomain", func() {
+		w := do("POST", "/v1/users", clark, map[string]string{"Host": "acme.users.example.com:8080"})
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		Expect(count(map[string]string{"X-Organization": "acme"})).To(Equal(1))
+		Expect(count(map[string]string{"Host": "users.example.com"})).To(BeZero())
+	})
+
+	It("should resolve the organization from a token claim", func() {
+		w := do("POST", "/v1/users", clark, map[string]string{"Authorization": token(umbrella)})
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		Expect(count(map[string]string{"X-Organization": "umbrella"})).To(Equal(1))
+
+		w = do("GET", "/v1/users", "", map[string]string{"Authorization": token(umbrella), "X-Organization": "acme"})
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should reject conflicting and unknown organizations and invalid tokens", func() {
+		w := do("GET", "/v1/users", "", map[string]string{"X-Organization": "acme", "Host": "umbrella.users.example.com"})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("conflicting organizations"))
+
+		w = do("GET", "/v1/users", "", map[string]string{"X-Organization": "initech"})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("unknown organization"))
+
+		w = do("GET", "/v1/users", "", map[string]string{"Authorization": "Bearer not-a-token"})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should require an organization when there is no default", func() {
+		app.DefaultOrganization = ""
+		e = app.NewServer()
+
+		w := do("GET", "/v1/users", "", nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("organization required"))
+
+		w = do("GET", "/openapi.json", "", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should keep the idempotency keys of each organization apart", func() {
+		for _, org := range []string{"acme", "umbrella"} {
+			w := do("POST", "/v1/users", clark, map[string]string{"X-Organization": org, "Idempotency-Key": "create-clark"})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+			Expect(w.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+		}
+	})
+
+	Describe("the resources of an organization", func() {
+
+		const admin = "Bearer " + testAdminToken
+
+		in := func(org string) map[string]string {
+			return map[string]string{"Authorization": admin, "X-Organization": org}
+		}
+
+		created := func(w *httptest.ResponseRecorder, id string) string {
+			Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+			var res map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+
+			return res[id].(string)
+		}
+
+		listed := func(target, org string) int {
+			w := do("GET", target, "", in(org))
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+			var res []map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+
+			return len(res)
+		}
+
+		var mailer *mail.Memory
+
+		BeforeEach(func() {
+			mailer = mail.NewMemory()
+			app.Mailer = mailer
+			app.InvitationURL = "https://app.example.com/join/"
+			e = app.NewServer()
+		})
+
+		It("should keep the groups of each organization apart", func() {
+			group := created(do("POST", "/v1/groups", `{"name": "Heroes", "scopes": ["users:read"]}`, in("acme")), "group_id")
+			user := created(do("POST", "/v1/users", clark, in("acme")), "user_id")
+
+			Expect(listed("/v1/groups", "acme")).To(Equal(1))
+			Expect(listed("/v1/groups", "umbrella")).To(BeZero())
+
+			Expect(do("GET", "/v1/groups/"+group, "", in("umbrella")).Code).To(Equal(http.StatusNotFound))
+			Expect(do("POST", "/v1/groups/"+group, `{"name": "Villains"}`, in("umbrella")).Code).To(Equal(http.StatusNotFound))
+			Expect(do("POST", "/v1/groups/"+group+"/members", `{"user_id": "`+user+`"}`, in("umbrella")).Code).To(Equal(http.StatusNotFound))
+			Expect(do("DELETE", "/v1/groups/"+group, "", in("umbrella")).Code).To(Equal(http.StatusNotFound))
+
+			// the name is free in the other organization
+			created(do("POST", "/v1/groups", `{"name": "Heroes"}`, in("umbrella")), "group_id")
+
+			Expect(do("GET", "/v1/groups/"+group, "", in("acme")).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should keep the invitations of each organization apart", func() {
+			invitation := created(do("POST", "/v1/invitations", `{"email": "clark@mail.com"}`, in("acme")), "invitation_id")
+
+			Expect(listed("/v1/invitations", "acme")).To(Equal(1))
+			Expect(listed("/v1/invitations", "umbrella")).To(BeZero())
+
+			Expect(do("POST", "/v1/invitations/"+invitation+":resend", "", in("umbrella")).Code).To(Equal(http.StatusNotFound))
+			Expect(do("DELETE", "/v1/invitations/"+invitation, "", in("umbrella")).Code).To(Equal(http.StatusNotFound))
+
+			// the same email may be invited by the other organization
+			created(do("POST", "/v1/invitations", `{"email": "clark@mail.com"}`, in("umbrella")), "invitation_id")
+		})
+
+		It("should accept an invitation in the organization that sent it", func() {
+			created(do("POST", "/v1/invitations", `{"email": "clark@mail.com"}`, in("acme")), "invitation_id")
+
+			sent := mailer.Sent()
+			Expect(sent).To(HaveLen(1))
+			link := regexp.MustCompile(`https://app\.example\.com/join/(\S+)`).FindStringSubmatch(sent[0].Body)
+			Expect(link).To(HaveLen(2))
+
+			// the link is opened without naming the organization, or naming
+			// another one
+			app.DefaultOrganization = ""
+			e = app.NewServer()
+
+			w := do("POST", "/v1/invitations/"+link[1]+"/accept", `{"password": "password"}`, map[string]string{"X-Organization": "umbrella"})
+			Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+			Expect(count(map[string]string{"X-Organization": "acme"})).To(Equal(1))
+			Expect(count(map[string]string{"X-Organization": "umbrella"})).To(BeZero())
+		})
+
+		It("should keep the custom fields of each organization apart", func() {
+			w := do("POST", "/v1/custom-fields", `{"name": "department", "type": "enum", "options": ["eng"], "required": true}`, in("acme"))
+			Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+			Expect(listed("/v1/custom-fields", "acme")).To(Equal(1))
+			Expect(listed("/v1/custom-fields", "umbrella")).To(BeZero())
+			Expect(do("GET", "/v1/custom-fields/department", "", in("umbrella")).Code).To(Equal(http.StatusNotFound))
+
+			// the field is neither required nor known in the other organization
+			w = do("POST", "/v1/users", clark, in("acme"))
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Body.String()).To(ContainSubstring("attributes.department"))
+
+			w = do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "attributes": {"department": "eng"}}`, in("umbrella"))
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Body.String()).To(ContainSubstring("attributes.department"))
+
+			created(do("POST", "/v1/users", clark, in("umbrella")), "user_id")
+		})
+	})
+})
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/totp"
+	"github.com/labstack/echo"
+)
+
+// defaultTOTPIssuer names the service in authenticator apps when TOTPIssuer
+// is unset
+const defaultTOTPIssuer = "go-echo-app"
+
+// enrollTwoFactor generates a TOTP secret for the user, which takes effect
+// once confirmed with a first code. Enrolling again before confirming
+// replaces the secret
+func (app *Config) enrollTwoFactor(c echo.Context) error {
+	u, err := app.users(c).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	err = app.TwoFactor.Enroll(u.ID, secret)
+	if errors.Is(err, data.ErrTwoFactorEnabled) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "two-factor authentication already enabled"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "submission failed"})
+	}
+
+	issuer := app.TOTPIssuer
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+
+	return c.JSON(http.StatusCreated, twoFactorEnrollmentV1{
+		Secret:     totp.Encode(secret),
+		OTPAuthURI: totp.URI(issuer, u.Email, secret),
+	})
+}
+
+// confirmTwoFactor enables the pending enrollment of the user given its
+// first code, and answers with recovery codes, which are not shown again
+func (app *Config) confirmTwoFactor(c echo.Context) error {
+	var in twoFactorCodeInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	id := c.Param("id")
+
+	t, err := app.TwoFactor.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "two-factor enrollment not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if t.Enabled() {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "two-factor authentication already enabled"})
+	}
+
+	step, ok := totp.Validate(t.Secret, in.Code, time.Now(), app.TOTPSkew)
+	if !ok {
+		return c.JSON(http.StatusUnprocessableEntity, fieldErrorResponse{
+			Error:   "invalid code",
+			Details: []data.FieldError{{Field: "code", Message: "does not match the secret"}},
+		})
+	}
+
+	codes, hashes, err := data.NewRecoveryCodes()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	err = app.TwoFactor.Confirm(id, step, hashes)
+	if errors.Is(err, data.ErrTwoFactorEnabled) {
+		return c.JSON(http.StatusConflict, errorResponse{Error: "two-factor authentication already enabled"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	return c.JSON(http.StatusOK, recoveryCodesV1{RecoveryCodes: codes})
+}
+
+// disableTwoFactor turns two-factor authentication off for the user, who
+// proves possession of the second factor one last time
+func (app *Config) disableTwoFactor(c echo.Context) error {
+	var in twoFactorCodeInputV1
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "bad request"})
+	}
+
+	id := c.Param("id")
+
+	ok, err := app.verifySecondFactor(id, in.Code, in.RecoveryCode)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, errorResponse{Error: "invalid code"})
+	}
+
+	if err := app.TwoFactor.Delete(id); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// resetTwoFactor lets an admin turn two-factor authentication off for a
+// user who lost their second factor and recovery codes
+func (app *Config) resetTwoFactor(c echo.Context) error {
+	u, err := app.users(c).GetOne(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "user not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "processing error"})
+	}
+
+	if err := app.TwoFactor.Delete(u.ID); err != nil {
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: "update failed"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// twoFactorEnabled reports whether logins of the user need a second factor
+func (app *Config) twoFactorEnabled(userID string) (bool, error) {
+	if app.TwoFactor == nil {
+		return false, nil
+	}
+
+	t, err := app.TwoFactor.Get(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return t.Enabled(), nil
+}
+
+// verifySecondFactor checks a TOTP code, or else a recovery code, of a user
+// with two-factor authentication enabled. Each code is accepted once
+func (app *Config) verifySecondFactor(userID, code, recoveryCode string) (bool, error) {
+	if app.TwoFactor == nil {
+		return false, nil
+	}
+
+	t, err := app.TwoFactor.Get(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil || !t.Enabled() {
+		return false, err
+	}
+
+	if code != "" {
+		step, ok := totp.Validate(t.Secret, code, time.Now(), app.TOTPSkew)
+		if !ok {
+			return false, nil
+		}
+
+		return app.TwoFactor.UseStep(userID, step)
+	}
+
+	if recoveryCode != "" {
+		return app.TwoFactor.UseRecoveryCode(userID, data.HashRecoveryCode(recoveryCode))
+	}
+
+	return false, nil
+}
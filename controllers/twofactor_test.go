@@ -0,0 +1,198 @@
+package controllers_test
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/totp"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("login and two-factor authentication", func() {
+
+	type login struct {
+		AccessToken       string `json:"access_token"`
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		Challenge         string `json:"challenge"`
+	}
+
+	var (
+		e      *echo.Echo
+		userID string
+	)
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		e.ServeHTTP(w, r)
+
+		return w
+	}
+
+	logIn := func(password string) (int, login) {
+		w := do("POST", "/v1/login", `{"email": "clark@mail.com", "password": "`+password+`"}`)
+
+		var l login
+		if w.Code == http.StatusOK {
+			Expect(json.Unmarshal(w.Body.Bytes(), &l)).To(Succeed())
+		}
+
+		return w.Code, l
+	}
+
+	bearer := func(token string) []string {
+		return []string{"Authorization", "Bearer " + token}
+	}
+
+	// code returns the TOTP code steps periods away from now
+	code := func(secret []byte, steps int64) string {
+		return totp.Code(secret, totp.Step(time.Now())+steps)
+	}
+
+	// enable enrolls the user and confirms the enrollment, returning the
+	// secret and the recovery codes
+	enable := func(token string) ([]byte, []string) {
+		w := do("POST", "/v1/users/"+userID+"/2fa", "", bearer(token)...)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var enrollment struct {
+			Secret     string `json:"secret"`
+			OTPAuthURI string `json:"otpauth_uri"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &enrollment)).To(Succeed())
+		Expect(enrollment.OTPAuthURI).To(HavePrefix("otpauth://totp/"))
+		Expect(enrollment.OTPAuthURI).To(ContainSubstring("clark@mail.com"))
+
+		secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w = do("POST", "/v1/users/"+userID+"/2fa:confirm", `{"code": "000000"}`, bearer(token)...)
+		if code(secret, 0) != "000000" {
+			Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		}
+
+		w = do("POST", "/v1/users/"+userID+"/2fa:confirm", `{"code": "`+code(secret, -1)+`"}`, bearer(token)...)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var recovery struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &recovery)).To(Succeed())
+		Expect(recovery.RecoveryCodes).To(HaveLen(10))
+
+		return secret, recovery.RecoveryCodes
+	}
+
+	BeforeEach(func() {
+		app := newMemoryTestApp()
+		e = app.NewServer()
+
+		w := do("POST", "/v1/users", `{"email": "clark@mail.com", "password": "password", "active": 1}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		var u struct {
+			ID string `json:"user_id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &u)).To(Succeed())
+		userID = u.ID
+	})
+
+	It("should issue access tokens for passwords alone until 2FA is enabled", func() {
+		status, _ := logIn("wrong")
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		w := do("POST", "/v1/login", `{"email": "lois@mail.com", "password": "password"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		status, l := logIn("password")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(l.AccessToken).NotTo(BeEmpty())
+		Expect(l.TwoFactorRequired).To(BeFalse())
+
+		w = do("GET", "/v1/users/"+userID, "", bearer(l.AccessToken)...)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("DELETE", "/v1/users/"+userID, "", bearer(l.AccessToken)...)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should require a second step once 2FA is enabled", func() {
+		_, l := logIn("password")
+		secret, _ := enable(l.AccessToken)
+
+		status, l := logIn("password")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(l.TwoFactorRequired).To(BeTrue())
+		Expect(l.AccessToken).To(BeEmpty())
+
+		w := do("GET", "/v1/users", "", bearer(l.Challenge)...)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/login:verify", `{"challenge": "`+l.Challenge+`", "code": "`+code(secret, -1)+`"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized), "codes cannot be replayed")
+
+		w = do("POST", "/v1/login:verify", `{"challenge": "`+l.Challenge+`", "code": "`+code(secret, 0)+`"}`)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var verified login
+		Expect(json.Unmarshal(w.Body.Bytes(), &verified)).To(Succeed())
+		Expect(verified.AccessToken).NotTo(BeEmpty())
+
+		w = do("POST", "/v1/users/"+userID+"/2fa", "", bearer(verified.AccessToken)...)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("should accept each recovery code once", func() {
+		_, l := logIn("password")
+		_, codes := enable(l.AccessToken)
+
+		_, l = logIn("password")
+		w := do("POST", "/v1/login:verify", `{"challenge": "`+l.Challenge+`", "recovery_code": "`+strings.ToUpper(codes[0])+`"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = do("POST", "/v1/login:verify", `{"challenge": "`+l.Challenge+`", "recovery_code": "`+codes[0]+`"}`)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should let the user disable 2FA and admins reset it", func() {
+		_, l := logIn("password")
+		token := l.AccessToken
+		secret, _ := enable(token)
+
+		w := do("POST", "/v1/users/"+userID+"/2fa:disable", `{"code": "`+code(secret, 1)+`"}`, bearer(token)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		status, l := logIn("password")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(l.TwoFactorRequired).To(BeFalse())
+
+		enable(token)
+
+		w = do("DELETE", "/v1/users/"+userID+"/2fa", "", bearer(token)...)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("DELETE", "/v1/users/"+userID+"/2fa", "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		_, l = logIn("password")
+		Expect(l.TwoFactorRequired).To(BeFalse())
+	})
+
+	It("should only let users enroll themselves", func() {
+		w := do("POST", "/v1/users/"+userID+"/2fa", "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = do("POST", "/v1/users/"+userID+"/2fa", "", bearer(testAdminToken)...)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+})
@@ -0,0 +1,542 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+)
+
+// The types below are the JSON contract of version 1 of the API. They are
+// mapped to and from the data package so that the domain model can change
+// without changing what v1 clients send and receive.
+
+// userV1 is a user as returned by v1. The password hash is never part of it
+type userV1 struct {
+	ID        string `json:"user_id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	// Active is 1 for active users, as it was before users had a status
+	Active    int       `json:"active"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Attributes are the values of the custom fields of the organization
+	Attributes data.Attributes `json:"attributes,omitempty"`
+	// AvatarURL is the URL of the current avatar of the user, if any
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// userInputV1 is a user as submitted to v1 for creation or update
+type userInputV1 struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	Active    int    `json:"active"`
+	// Attributes are merged into those of the user; null removes one
+	Attributes data.Attributes `json:"attributes"`
+}
+
+// customFieldV1 is a custom field definition as returned by v1
+type customFieldV1 struct {
+	ID        string    `json:"field_id"`
+	Name      string    `json:"name"`
+	Label     string    `json:"label"`
+	Type      string    `json:"type"`
+	Required  bool      `json:"required"`
+	Options   []string  `json:"options,omitempty"`
+	MaxLength *int      `json:"max_length,omitempty"`
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// customFieldInputV1 is a custom field definition as submitted to v1 for
+// creation or update. The name and type of a field cannot be updated
+type customFieldInputV1 struct {
+	Name      string   `json:"name"`
+	Label     string   `json:"label"`
+	Type      string   `json:"type"`
+	Required  bool     `json:"required"`
+	Options   []string `json:"options"`
+	MaxLength *int     `json:"max_length"`
+	Min       *float64 `json:"min"`
+	Max       *float64 `json:"max"`
+}
+
+// organizationV1 is an organization as returned by v1
+type organizationV1 struct {
+	ID        string    `json:"org_id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// organizationInputV1 is an organization as submitted to v1 for creation or
+// update
+type organizationInputV1 struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// groupV1 is a group as returned by v1
+type groupV1 struct {
+	ID          string    `json:"group_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Scopes      []string  `json:"scopes"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// groupInputV1 is a group as submitted to v1 for creation or update
+type groupInputV1 struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+}
+
+// groupMemberInputV1 names the user to add to a group
+type groupMemberInputV1 struct {
+	UserID string `json:"user_id"`
+}
+
+// invitationV1 is an invitation as returned by v1. Its token is only ever
+// emailed to the invitee. Pending invitations past their expiry show as
+// expired
+type invitationV1 struct {
+	ID        string    `json:"invitation_id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Status    string    `json:"status"`
+	InvitedBy string    `json:"invited_by"`
+	UserID    string    `json:"user_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SentAt    time.Time `json:"sent_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// invitationInputV1 is an invitation as submitted to v1 for creation
+type invitationInputV1 struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// invitationAcceptInputV1 is the password an invitee chose
+type invitationAcceptInputV1 struct {
+	Password string `json:"password"`
+}
+
+// apiKeyV1 is an API key as returned by v1. The key itself is only part of
+// apiKeySecretV1
+type apiKeyV1 struct {
+	ID         string     `json:"key_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// apiKeySecretV1 is an API key just created or rotated, with its key
+type apiKeySecretV1 struct {
+	apiKeyV1
+	Key string `json:"key"`
+}
+
+// apiKeyInputV1 is an API key as submitted to v1 for creation
+type apiKeyInputV1 struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// loginInputV1 is the password step of a login
+type loginInputV1 struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// loginVerifyInputV1 is the second step of a login, which answers the
+// challenge with a TOTP code or a recovery code
+type loginVerifyInputV1 struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// loginV1 is the outcome of a login step: an access token, or a challenge
+// when the user has to give a second factor
+type loginV1 struct {
+	AccessToken       string `json:"access_token,omitempty"`
+	TokenType         string `json:"token_type,omitempty"`
+	ExpiresIn         int    `json:"expires_in,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	Challenge         string `json:"challenge,omitempty"`
+}
+
+// twoFactorEnrollmentV1 is a TOTP secret awaiting confirmation
+type twoFactorEnrollmentV1 struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// twoFactorCodeInputV1 proves possession of the second factor
+type twoFactorCodeInputV1 struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// recoveryCodesV1 lists recovery codes, which are shown once
+type recoveryCodesV1 struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// sessionV1 is a session as returned by v1. Current marks the session of
+// the request
+type sessionV1 struct {
+	ID         string    `json:"session_id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// loginEventV1 is a login attempt as returned by v1
+type loginEventV1 struct {
+	ID        string    `json:"event_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// statusInputV1 is the reason for a status change as submitted to v1
+type statusInputV1 struct {
+	Reason string `json:"reason"`
+}
+
+// statusTransitionV1 is a status change of a user as returned by v1
+type statusTransitionV1 struct {
+	ID        string    `json:"transition_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// privacyJobV1 is an export or erasure of the data of a user as returned by
+// v1, with the steps it completed
+type privacyJobV1 struct {
+	ID          string             `json:"job_id"`
+	UserID      string             `json:"user_id"`
+	Kind        string             `json:"kind"`
+	Status      string             `json:"status"`
+	Actor       string             `json:"actor"`
+	Error       string             `json:"error,omitempty"`
+	Steps       []privacyJobStepV1 `json:"steps"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty"`
+}
+
+// privacyJobStepV1 is a completed step of a privacy job as returned by v1
+type privacyJobStepV1 struct {
+	Name        string    `json:"name"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// oauthClientV1 is an OAuth client as returned by v1. The secret of
+// confidential clients is only part of oauthClientSecretV1
+type oauthClientV1 struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// oauthClientSecretV1 is an OAuth client just registered, with its secret
+type oauthClientSecretV1 struct {
+	oauthClientV1
+	Secret string `json:"client_secret,omitempty"`
+}
+
+// oauthClientInputV1 is an OAuth client as submitted to v1 for
+// registration. Public clients get no secret
+type oauthClientInputV1 struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+}
+
+type searchResultV1 struct {
+	User       userV1            `json:"user"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+func newUserV1(u *data.User) userV1 {
+	return userV1{
+		ID:        u.ID,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Active:    activeV1(u.Status),
+		Status:    string(u.Status),
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+// newUsersV1 keeps a nil list nil, since v1 has always answered null when
+// there are no users
+func newUsersV1(users []*data.User) []userV1 {
+	if users == nil {
+		return nil
+	}
+
+	out := make([]userV1, len(users))
+	for i, u := range users {
+		out[i] = newUserV1(u)
+	}
+
+	return out
+}
+
+func newSearchResultsV1(results []*data.SearchResult) []searchResultV1 {
+	out := make([]searchResultV1, len(results))
+	for i, r := range results {
+		out[i] = searchResultV1{
+			User:       newUserV1(&r.User),
+			Rank:       r.Rank,
+			Highlights: r.Highlights,
+		}
+	}
+
+	return out
+}
+
+func (in userInputV1) user() data.User {
+	return data.User{
+		Email:     in.Email,
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Password:  in.Password,
+		Status:    data.StatusOf(in.Active == 1),
+	}
+}
+
+func newCustomFieldV1(f *data.FieldDefinition) customFieldV1 {
+	return customFieldV1{
+		ID:        f.ID,
+		Name:      f.Name,
+		Label:     f.Label,
+		Type:      string(f.Type),
+		Required:  f.Required,
+		Options:   f.Options,
+		MaxLength: f.MaxLength,
+		Min:       f.Min,
+		Max:       f.Max,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+	}
+}
+
+func (in customFieldInputV1) field() data.FieldDefinition {
+	return data.FieldDefinition{
+		Name:      in.Name,
+		Label:     in.Label,
+		Type:      data.FieldType(in.Type),
+		Required:  in.Required,
+		Options:   in.Options,
+		MaxLength: in.MaxLength,
+		Min:       in.Min,
+		Max:       in.Max,
+	}
+}
+
+func activeV1(s data.Status) int {
+	if s == data.StatusActive {
+		return 1
+	}
+
+	return 0
+}
+
+func newOrganizationV1(o *data.Organization) organizationV1 {
+	return organizationV1{
+		ID:        o.ID,
+		Slug:      o.Slug,
+		Name:      o.Name,
+		CreatedAt: o.CreatedAt,
+		UpdatedAt: o.UpdatedAt,
+	}
+}
+
+func (in organizationInputV1) organization() data.Organization {
+	return data.Organization{Slug: in.Slug, Name: in.Name}
+}
+
+func newGroupV1(g *data.Group) groupV1 {
+	scopes := g.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return groupV1{
+		ID:          g.ID,
+		Name:        g.Name,
+		Description: g.Description,
+		Scopes:      scopes,
+		CreatedAt:   g.CreatedAt,
+		UpdatedAt:   g.UpdatedAt,
+	}
+}
+
+func newGroupsV1(groups []*data.Group) []groupV1 {
+	out := make([]groupV1, len(groups))
+	for i, g := range groups {
+		out[i] = newGroupV1(g)
+	}
+
+	return out
+}
+
+func (in groupInputV1) group() data.Group {
+	return data.Group{Name: strings.TrimSpace(in.Name), Description: in.Description, Scopes: in.Scopes}
+}
+
+func newInvitationV1(i *data.Invitation, now time.Time) invitationV1 {
+	status := string(i.Status)
+	if i.Expired(now) {
+		status = invitationExpired
+	}
+
+	return invitationV1{
+		ID:        i.ID,
+		Email:     i.Email,
+		FirstName: i.FirstName,
+		LastName:  i.LastName,
+		Status:    status,
+		InvitedBy: i.InvitedBy,
+		UserID:    i.UserID,
+		ExpiresAt: i.ExpiresAt,
+		SentAt:    i.SentAt,
+		CreatedAt: i.CreatedAt,
+		UpdatedAt: i.UpdatedAt,
+	}
+}
+
+func (in invitationInputV1) invitation() data.Invitation {
+	return data.Invitation{Email: strings.TrimSpace(in.Email), FirstName: in.FirstName, LastName: in.LastName}
+}
+
+func newAPIKeyV1(k *data.APIKey) apiKeyV1 {
+	return apiKeyV1{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+func (in apiKeyInputV1) apiKey() data.APIKey {
+	return data.APIKey{Name: in.Name, Scopes: in.Scopes, ExpiresAt: in.ExpiresAt}
+}
+
+func newSessionV1(s *data.Session, current string) sessionV1 {
+	return sessionV1{
+		ID:         s.ID,
+		Device:     s.Device,
+		IP:         s.IP,
+		UserAgent:  s.UserAgent,
+		Current:    s.ID == current,
+		CreatedAt:  s.CreatedAt,
+		LastSeenAt: s.LastSeenAt,
+		ExpiresAt:  s.ExpiresAt,
+	}
+}
+
+func newLoginEventV1(e *data.LoginEvent) loginEventV1 {
+	return loginEventV1{
+		ID:        e.ID,
+		IP:        e.IP,
+		UserAgent: e.UserAgent,
+		Outcome:   e.Outcome,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func newStatusTransitionV1(t *data.StatusTransition) statusTransitionV1 {
+	return statusTransitionV1{
+		ID:        t.ID,
+		From:      string(t.From),
+		To:        string(t.To),
+		Reason:    t.Reason,
+		Actor:     t.Actor,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+func newPrivacyJobV1(j *data.PrivacyJob) privacyJobV1 {
+	steps := make([]privacyJobStepV1, len(j.Steps))
+	for i, s := range j.Steps {
+		steps[i] = privacyJobStepV1{Name: s.Name, CompletedAt: s.CompletedAt}
+	}
+
+	return privacyJobV1{
+		ID:          j.ID,
+		UserID:      j.UserID,
+		Kind:        string(j.Kind),
+		Status:      string(j.Status),
+		Actor:       j.Actor,
+		Error:       j.Error,
+		Steps:       steps,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+		CompletedAt: j.CompletedAt,
+	}
+}
+
+func newOAuthClientV1(c *data.OAuthClient) oauthClientV1 {
+	return oauthClientV1{
+		ID:           c.ID,
+		Name:         c.Name,
+		Public:       c.Public(),
+		RedirectURIs: c.RedirectURIs,
+		GrantTypes:   c.GrantTypes,
+		Scopes:       c.Scopes,
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+func (in oauthClientInputV1) oauthClient() data.OAuthClient {
+	return data.OAuthClient{
+		Name:         in.Name,
+		RedirectURIs: in.RedirectURIs,
+		GrantTypes:   in.GrantTypes,
+		Scopes:       in.Scopes,
+	}
+}
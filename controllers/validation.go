@@ -0,0 +1,12 @@
+package controllers
+
+import (
+	"github.com/danielboakye/go-echo-app/data"
+)
+
+type fieldError = data.FieldError
+
+// validateUser checks the fields of a user submitted for creation
+func validateUser(u data.User) []fieldError {
+	return data.ValidateUser(u)
+}
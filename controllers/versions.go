@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	headerDeprecation = "Deprecation"
+	headerSunset      = "Sunset"
+	headerLink        = "Link"
+	headerAPIVersion  = "API-Version"
+
+	// defaultAPIVersion is served on unversioned paths to clients that do not
+	// ask for a version, as those paths carried it before versioning existed
+	defaultAPIVersion = "1"
+
+	// unversioned paths were deprecated on 2026-10-18 (RFC 9745) and are
+	// withdrawn six months later (RFC 8594)
+	unversionedDeprecation = "@1792281600"
+	unversionedSunset      = "Sun, 18 Apr 2027 00:00:00 GMT"
+)
+
+// apiVersions are the versions of the API mounted by NewServer, each under
+// /v<version>
+var apiVersions = map[string]bool{
+	"1": true,
+}
+
+// versionedResources are the path prefixes that live under a version
+var versionedResources = []string{
+	"/users", "/organizations", "/api-keys", "/oauth-clients", "/login", "/auth",
+	"/custom-fields", "/groups", "/invitations",
+}
+
+// negotiateVersion routes requests for a versioned resource to a version of
+// the API. The version comes from the path, e.g. /v1/users, or from the
+// version parameter of the Accept media type, e.g.
+// Accept: application/json; version=1, which moves an unversioned path to
+// that version. Unversioned requests that do not ask for a version get
+// defaultAPIVersion and are marked deprecated.
+func negotiateVersion() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+
+			requested, err := acceptedVersion(r.Header.Get(echo.HeaderAccept))
+			if err != nil {
+				return c.JSON(http.StatusNotAcceptable, errorResponse{Error: err.Error()})
+			}
+
+			version, versioned := pathVersion(r.URL.Path)
+			switch {
+			case versioned:
+				if requested != "" && requested != version {
+					return c.JSON(http.StatusNotAcceptable, errorResponse{Error: "conflicting API versions"})
+				}
+			case isVersionedResource(r.URL.Path):
+				version = requested
+				if version == "" {
+					version = defaultAPIVersion
+					markDeprecated(c.Response().Header(), r.URL.Path)
+				}
+
+				r.URL.Path = "/v" + version + r.URL.Path
+				if r.URL.RawPath != "" {
+					r.URL.RawPath = "/v" + version + r.URL.RawPath
+				}
+			default:
+				return next(c)
+			}
+
+			if apiVersions[version] {
+				c.Response().Header().Set(headerAPIVersion, version)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// acceptedVersion returns the version parameter of the Accept header, if any
+func acceptedVersion(accept string) (string, error) {
+	for _, part := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		if v, ok := params["version"]; ok {
+			if !apiVersions[v] {
+				return "", fmt.Errorf("unsupported API version %q", v)
+			}
+
+			return v, nil
+		}
+	}
+
+	return "", nil
+}
+
+// pathVersion returns the version in a path such as /v1/users
+func pathVersion(path string) (string, bool) {
+	if !strings.HasPrefix(path, "/v") {
+		return "", false
+	}
+
+	end := strings.IndexByte(path[1:], '/') + 1
+	if end == 0 {
+		end = len(path)
+	}
+
+	version := path[2:end]
+	if version == "" || strings.Trim(version, "0123456789") != "" {
+		return "", false
+	}
+
+	return version, true
+}
+
+func isVersionedResource(path string) bool {
+	for _, prefix := range versionedResources {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") || strings.HasPrefix(path, prefix+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markDeprecated tells clients of an unversioned path that it is going away
+// and where its successor lives
+func markDeprecated(h http.Header, path string) {
+	h.Set(headerDeprecation, unversionedDeprecation)
+	h.Set(headerSunset, unversionedSunset)
+	h.Add(headerLink, fmt.Sprintf(`</v%s%s>; rel="successor-version"`, defaultAPIVersion, path))
+}
@@ -0,0 +1,105 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/controllers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API versions", func() {
+
+	var (
+		app  controllers.Config
+		resp *http.Response
+		body string
+	)
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+	})
+
+	send := func(method, target, accept, reqBody string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(reqBody))
+		r.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		app.NewServer().ServeHTTP(w, r)
+
+		resp = w.Result()
+		body = w.Body.String()
+	}
+
+	It("should serve v1 under /v1 without deprecation", func() {
+		send("GET", "/v1/users", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("API-Version")).To(Equal("1"))
+		Expect(resp.Header.Get("Deprecation")).To(BeEmpty())
+		Expect(resp.Header.Get("Sunset")).To(BeEmpty())
+	})
+
+	It("should serve v1 on unversioned paths and mark them deprecated", func() {
+		send("GET", "/users/search?q=clark", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("API-Version")).To(Equal("1"))
+		Expect(resp.Header.Get("Deprecation")).To(Equal("@1792281600"))
+		Expect(resp.Header.Get("Sunset")).To(Equal("Sun, 18 Apr 2027 00:00:00 GMT"))
+		Expect(resp.Header.Get("Link")).To(Equal(`</v1/users/search>; rel="successor-version"`))
+	})
+
+	It("should serve every resource of v1 on its unversioned path", func() {
+		for _, path := range []string{"/custom-fields", "/groups", "/invitations"} {
+			send("GET", path, "", "")
+			Expect(resp.StatusCode).NotTo(Equal(http.StatusNotFound), path)
+			Expect(resp.Header.Get("API-Version")).To(Equal("1"), path)
+			Expect(resp.Header.Get("Link")).To(Equal(`</v1`+path+`>; rel="successor-version"`), path)
+		}
+	})
+
+	It("should pick the version from the Accept header", func() {
+		send("GET", "/users", "application/json; version=1", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("API-Version")).To(Equal("1"))
+		Expect(resp.Header.Get("Deprecation")).To(BeEmpty())
+	})
+
+	It("should refuse versions it does not serve", func() {
+		send("GET", "/users", "application/json; version=7", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusNotAcceptable))
+		Expect(body).To(ContainSubstring(`unsupported API version \"7\"`))
+
+		send("GET", "/v1/users", "text/html, application/json; version=2", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusNotAcceptable))
+
+		send("GET", "/v2/users", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should leave unversioned endpoints alone", func() {
+		send("GET", "/openapi.json", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("API-Version")).To(BeEmpty())
+		Expect(resp.Header.Get("Deprecation")).To(BeEmpty())
+	})
+
+	It("should map users to the v1 representation", func() {
+		send("POST", "/v1/users", "", `{"email":"clark@mail.com","last_name":"Kent","password":"password","active":1}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+		var created map[string]interface{}
+		Expect(json.Unmarshal([]byte(body), &created)).To(Succeed())
+		Expect(created).To(HaveKey("user_id"))
+		Expect(created).To(HaveKeyWithValue("active", 1.0))
+		Expect(created).NotTo(HaveKey("password"))
+
+		send("GET", "/v1/users/"+created["user_id"].(string), "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).NotTo(ContainSubstring("password"))
+	})
+})
@@ -0,0 +1,434 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// apiKeyMarker starts every API key, so that keys are recognisable in
+	// headers and by secret scanners
+	apiKeyMarker = "gea_"
+	// apiKeyPrefixLength is the number of random hex digits after the
+	// marker that identify a key
+	apiKeyPrefixLength = 12
+	apiKeySecretBytes  = 32
+	maxAPIKeyScopes    = 16
+)
+
+var ErrDuplicateAPIKeyPrefix = errors.New("api key prefix already exists")
+
+// APIKey is a long-lived credential of a machine client. Only the prefix,
+// which identifies the key, and a hash of the whole key are stored; the key
+// itself is shown once, when it is created or rotated
+type APIKey struct {
+	ID         string     `json:"key_id"`
+	OrgID      string     `json:"org_id,omitempty"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Active reports whether the key may still be used at t
+func (k *APIKey) Active(t time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || t.Before(*k.ExpiresAt))
+}
+
+// Verify reports whether key is the secret of k
+func (k *APIKey) Verify(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(k.Hash)) == 1
+}
+
+type IAPIKeyRepository interface {
+	// GetAll returns the keys, revoked ones included, newest first
+	GetAll() ([]*APIKey, error)
+	GetOne(id string) (*APIKey, error)
+	GetByPrefix(prefix string) (*APIKey, error)
+	Insert(APIKey) (string, error)
+	// Rotate replaces the prefix and hash of a key, which invalidates the
+	// previous secret
+	Rotate(id, prefix, hash string) error
+	Revoke(id string) error
+	// Touch records that the key was used at t
+	Touch(id string, t time.Time) error
+	// WithTenant returns a view of the keys of one organization, which also
+	// receives the keys it inserts
+	WithTenant(orgID string) IAPIKeyRepository
+}
+
+// NewAPIKey generates a key and returns it along with its prefix and the
+// hash to store
+func NewAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, apiKeyPrefixLength/2+apiKeySecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = apiKeyMarker + hex.EncodeToString(b[:apiKeyPrefixLength/2])
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(b[apiKeyPrefixLength/2:])
+
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// APIKeyPrefix returns the prefix of key, and false when key is not shaped
+// like an API key
+func APIKeyPrefix(key string) (string, bool) {
+	n := len(apiKeyMarker) + apiKeyPrefixLength
+	if !strings.HasPrefix(key, apiKeyMarker) || len(key) <= n || key[n] != '_' {
+		return "", false
+	}
+
+	return key[:n], true
+}
+
+// HashAPIKey hashes a key for storage. Keys carry 256 random bits, so a
+// fast hash is enough to keep them from being recovered
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateAPIKey checks the fields of a key submitted for creation against
+// the scopes that may be granted
+func ValidateAPIKey(k APIKey, grantable []string) []FieldError {
+	var errs []FieldError
+
+	if k.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	} else if len(k.Name) > maxNameLength {
+		errs = append(errs, FieldError{Field: "name", Message: "must be at most 255 characters"})
+	}
+
+	if len(k.Scopes) == 0 || len(k.Scopes) > maxAPIKeyScopes {
+		errs = append(errs, FieldError{Field: "scopes", Message: "must list between 1 and 16 scopes"})
+	}
+
+	allowed := make(map[string]bool, len(grantable))
+	for _, s := range grantable {
+		allowed[s] = true
+	}
+
+	for _, s := range k.Scopes {
+		if !allowed[s] {
+			errs = append(errs, FieldError{Field: "scopes", Message: "cannot grant " + s})
+			break
+		}
+	}
+
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		errs = append(errs, FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+
+	return errs
+}
+
+type APIKeyRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	tenant  string
+}
+
+// NewAPIKeyRepositoryFor returns the API key store for a database of the
+// given dialect
+func NewAPIKeyRepositoryFor(pool *sql.DB, dialect Dialect) IAPIKeyRepository {
+	return &APIKeyRepository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+func (r *APIKeyRepository) WithTenant(orgID string) IAPIKeyRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+const apiKeyColumns = "key_id, org_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+func (r *APIKeyRepository) GetAll() ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(apiKeyColumns).
+		From("api_keys").
+		OrderBy("created_at DESC")
+	rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *APIKeyRepository) GetOne(id string) (*APIKey, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.getBy(sq.Eq{"key_id": id})
+}
+
+func (r *APIKeyRepository) GetByPrefix(prefix string) (*APIKey, error) {
+	return r.getBy(sq.Eq{"prefix": prefix})
+}
+
+func (r *APIKeyRepository) getBy(pred sq.Eq) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(apiKeyColumns).
+		From("api_keys").
+		Where(pred)
+
+	return scanAPIKey(scope(uq, r.tenant).RunWith(r.db).QueryRowContext(ctx))
+}
+
+// Insert stores a new key under a generated id and returns it
+func (r *APIKeyRepository) Insert(k APIKey) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	org := k.OrgID
+	if r.tenant != "" {
+		org = r.tenant
+	}
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
+	_, err = r.sb.Insert("api_keys").
+		Columns("key_id", "org_id", "name", "prefix", "key_hash", "scopes", "expires_at", "created_at").
+		Values(id, org, k.Name, k.Prefix, k.Hash, strings.Join(k.Scopes, " "), k.ExpiresAt, time.Now()).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		if errors.Is(r.dialect.translateError(err), ErrDuplicateEmail) {
+			return "", ErrDuplicateAPIKeyPrefix
+		}
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *APIKeyRepository) Rotate(id, prefix, hash string) error {
+	return r.update(id, sq.Eq{"prefix": prefix, "key_hash": hash})
+}
+
+func (r *APIKeyRepository) Revoke(id string) error {
+	return r.update(id, sq.Eq{"revoked_at": time.Now()})
+}
+
+func (r *APIKeyRepository) Touch(id string, t time.Time) error {
+	return r.update(id, sq.Eq{"last_used_at": t})
+}
+
+func (r *APIKeyRepository) update(id string, set sq.Eq) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Update("api_keys").
+		SetMap(set).
+		Where(sq.Eq{"key_id": id})
+	_, err := scope(uq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func scanAPIKey(row sq.RowScanner) (*APIKey, error) {
+	var (
+		k                          APIKey
+		scopes                     string
+		expires, lastUsed, revoked sql.NullTime
+	)
+
+	err := row.Scan(&k.ID, &k.OrgID, &k.Name, &k.Prefix, &k.Hash, &scopes, &expires, &lastUsed, &revoked, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Scopes = strings.Fields(scopes)
+	k.ExpiresAt = nullTime(expires)
+	k.LastUsedAt = nullTime(lastUsed)
+	k.RevokedAt = nullTime(revoked)
+
+	return &k, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
+
+// MemoryAPIKeyRepository is an IAPIKeyRepository held in process memory
+type MemoryAPIKeyRepository struct {
+	*memoryAPIKeys
+	tenant string
+}
+
+type memoryAPIKeys struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+func NewMemoryAPIKeyRepository() IAPIKeyRepository {
+	return &MemoryAPIKeyRepository{memoryAPIKeys: &memoryAPIKeys{keys: make(map[string]*APIKey)}}
+}
+
+func (r *MemoryAPIKeyRepository) WithTenant(orgID string) IAPIKeyRepository {
+	return &MemoryAPIKeyRepository{memoryAPIKeys: r.memoryAPIKeys, tenant: orgID}
+}
+
+// get returns the key with this id if the tenant may see it. The caller
+// must hold the lock
+func (r *MemoryAPIKeyRepository) get(id string) (*APIKey, bool) {
+	k, ok := r.keys[id]
+	if !ok || (r.tenant != "" && k.OrgID != r.tenant) {
+		return nil, false
+	}
+
+	return k, true
+}
+
+func (r *MemoryAPIKeyRepository) GetAll() ([]*APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var keys []*APIKey
+	for id := range r.keys {
+		if k, ok := r.get(id); ok {
+			keys = append(keys, copyAPIKey(k))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+
+	return keys, nil
+}
+
+func (r *MemoryAPIKeyRepository) GetOne(id string) (*APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	k, ok := r.get(id)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyAPIKey(k), nil
+}
+
+func (r *MemoryAPIKeyRepository) GetByPrefix(prefix string) (*APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for id, k := range r.keys {
+		if k.Prefix != prefix {
+			continue
+		}
+
+		if k, ok := r.get(id); ok {
+			return copyAPIKey(k), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryAPIKeyRepository) Insert(k APIKey) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.keys {
+		if existing.Prefix == k.Prefix {
+			return "", ErrDuplicateAPIKeyPrefix
+		}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	k.ID = id
+	if r.tenant != "" {
+		k.OrgID = r.tenant
+	}
+	if k.OrgID == "" {
+		k.OrgID = DefaultOrganizationID
+	}
+	k.CreatedAt = time.Now()
+	k.LastUsedAt = nil
+	k.RevokedAt = nil
+	r.keys[id] = copyAPIKey(&k)
+
+	return id, nil
+}
+
+func (r *MemoryAPIKeyRepository) Rotate(id, prefix, hash string) error {
+	return r.update(id, func(k *APIKey) {
+		k.Prefix = prefix
+		k.Hash = hash
+	})
+}
+
+func (r *MemoryAPIKeyRepository) Revoke(id string) error {
+	now := time.Now()
+	return r.update(id, func(k *APIKey) { k.RevokedAt = &now })
+}
+
+func (r *MemoryAPIKeyRepository) Touch(id string, t time.Time) error {
+	return r.update(id, func(k *APIKey) { k.LastUsedAt = &t })
+}
+
+func (r *MemoryAPIKeyRepository) update(id string, fn func(*APIKey)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, ok := r.get(id); ok {
+		fn(k)
+	}
+
+	return nil
+}
+
+func copyAPIKey(k *APIKey) *APIKey {
+	c := *k
+	c.Scopes = append([]string(nil), k.Scopes...)
+
+	return &c
+}
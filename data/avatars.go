@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Avatar is the profile image of a user. Its images are kept in a blob
+// store under keys naming the version, which changes with every upload
+type Avatar struct {
+	UserID  string `json:"user_id"`
+	OrgID   string `json:"org_id,omitempty"`
+	Version string `json:"version"`
+	// ContentType is the type of the uploaded image
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type IAvatarRepository interface {
+	GetByUser(userID string) (*Avatar, error)
+	// GetMany returns the avatars of the given users, by user id. Users
+	// without one are left out
+	GetMany(userIDs []string) (map[string]*Avatar, error)
+	// Put sets the avatar of a user, replacing the one they had
+	Put(Avatar) error
+	DeleteByUser(userID string) error
+	// WithTenant returns a view of the avatars of one organization, which
+	// also receives the avatars it puts
+	WithTenant(orgID string) IAvatarRepository
+}
+
+type AvatarRepository struct {
+	db     *sql.DB
+	sb     sq.StatementBuilderType
+	tenant string
+}
+
+// NewAvatarRepositoryFor returns the avatar store for a database of the
+// given dialect
+func NewAvatarRepositoryFor(pool *sql.DB, dialect Dialect) IAvatarRepository {
+	return &AvatarRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *AvatarRepository) WithTenant(orgID string) IAvatarRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+const avatarColumns = "user_id, org_id, version, content_type, created_at"
+
+func (r *AvatarRepository) GetByUser(userID string) (*Avatar, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(avatarColumns).
+		From("user_avatars").
+		Where(sq.Eq{"user_id": userID})
+
+	var a Avatar
+	err := scope(uq, r.tenant).RunWith(r.db).QueryRowContext(ctx).
+		Scan(&a.UserID, &a.OrgID, &a.Version, &a.ContentType, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (r *AvatarRepository) GetMany(userIDs []string) (map[string]*Avatar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	avatars := make(map[string]*Avatar)
+	for _, chunk := range chunkIDs(uniqueIDs(userIDs)) {
+		uq := r.sb.Select(avatarColumns).
+			From("user_avatars").
+			Where(sq.Eq{"user_id": chunk})
+		rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var a Avatar
+			if err := rows.Scan(&a.UserID, &a.OrgID, &a.Version, &a.ContentType, &a.CreatedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			avatars[a.UserID] = &a
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return avatars, nil
+}
+
+func (r *AvatarRepository) Put(a Avatar) error {
+	if !uuidPattern.MatchString(a.UserID) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	org := a.OrgID
+	if r.tenant != "" {
+		org = r.tenant
+	}
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dq := r.sb.Delete("user_avatars").Where(sq.Eq{"user_id": a.UserID})
+	if _, err := scope(dq, r.tenant).RunWith(tx).ExecContext(ctx); err != nil {
+		return err
+	}
+
+	_, err = r.sb.Insert("user_avatars").
+		Columns("user_id", "org_id", "version", "content_type", "created_at").
+		Values(a.UserID, org, a.Version, a.ContentType, time.Now()).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *AvatarRepository) DeleteByUser(userID string) error {
+	if !uuidPattern.MatchString(userID) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	dq := r.sb.Delete("user_avatars").Where(sq.Eq{"user_id": userID})
+	_, err := scope(dq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+// MemoryAvatarRepository is an IAvatarRepository held in process memory
+type MemoryAvatarRepository struct {
+	*memoryAvatars
+	tenant string
+}
+
+type memoryAvatars struct {
+	mu      sync.RWMutex
+	avatars map[string]*Avatar
+}
+
+func NewMemoryAvatarRepository() IAvatarRepository {
+	return &MemoryAvatarRepository{memoryAvatars: &memoryAvatars{avatars: make(map[string]*Avatar)}}
+}
+
+func (r *MemoryAvatarRepository) WithTenant(orgID string) IAvatarRepository {
+	return &MemoryAvatarRepository{memoryAvatars: r.memoryAvatars, tenant: orgID}
+}
+
+// visible reports whether the tenant may see a
+func (r *MemoryAvatarRepository) visible(a *Avatar) bool {
+	return r.tenant == "" || a.OrgID == r.tenant
+}
+
+func (r *MemoryAvatarRepository) GetByUser(userID string) (*Avatar, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, ok := r.avatars[userID]
+	if !ok || !r.visible(a) {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *a
+	return &c, nil
+}
+
+func (r *MemoryAvatarRepository) GetMany(userIDs []string) (map[string]*Avatar, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	avatars := make(map[string]*Avatar)
+	for _, id := range userIDs {
+		if a, ok := r.avatars[id]; ok && r.visible(a) {
+			c := *a
+			avatars[id] = &c
+		}
+	}
+
+	return avatars, nil
+}
+
+func (r *MemoryAvatarRepository) Put(a Avatar) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tenant != "" {
+		a.OrgID = r.tenant
+	}
+	if a.OrgID == "" {
+		a.OrgID = DefaultOrganizationID
+	}
+	a.CreatedAt = time.Now()
+	r.avatars[a.UserID] = &a
+
+	return nil
+}
+
+func (r *MemoryAvatarRepository) DeleteByUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a, ok := r.avatars[userID]; ok && r.visible(a) {
+		delete(r.avatars, userID)
+	}
+
+	return nil
+}
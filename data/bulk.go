@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// streamTimeout bounds how long a single export cursor may stay open
+const streamTimeout = time.Minute * 5
+
+// ErrBatchConflict is returned by InsertMany when an all-or-nothing batch
+// was rolled back because some of its emails are already taken
+var ErrBatchConflict = errors.New("batch contains existing users")
+
+// InsertMany inserts users with a single multi-row statement and returns the
+// ids of the inserted rows keyed by email. Users whose email already exists
+// are skipped; when allOrNothing is set the whole batch is rolled back
+// instead and ErrBatchConflict is returned along with the ids that would
+// have been created
+func (r *Repository) InsertMany(users []User, allOrNothing bool) (map[string]string, error) {
+	if len(users) == 0 {
+		return map[string]string{}, nil
+	}
+
+	hashes, err := hashPasswords(users)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if r.rls {
+		if _, err := tx.ExecContext(ctx, "SELECT set_config('app.org_id', $1, true)", r.tenant); err != nil {
+			return nil, err
+		}
+	}
+
+	var ids map[string]string
+	if r.dialect.generatesIDs() {
+		ids, err = r.insertManyReturning(ctx, tx, users, hashes)
+	} else {
+		ids, err = r.insertManyWithIDs(ctx, tx, users, hashes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if allOrNothing && len(ids) != len(users) {
+		return ids, ErrBatchConflict
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	r.wrote()
+
+	return ids, nil
+}
+
+// insertManyReturning lets the database assign the ids and reads them back
+// with RETURNING
+func (r *Repository) insertManyReturning(ctx context.Context, tx *sql.Tx, users []User, hashes [][]byte) (map[string]string, error) {
+	now := time.Now()
+	uq := r.sb.Insert("users").
+		Columns(r.bulkColumns("email", "first_name", "last_name", "password", "user_status", "created_at", "updated_at")...)
+	for i, u := range users {
+		uq = uq.Values(r.bulkValues(u.Email, u.FirstName, u.LastName, hashes[i], u.statusOrPending(), now, now)...)
+	}
+
+	rows, err := uq.Suffix("ON CONFLICT (org_id, email) DO NOTHING RETURNING user_id, email").
+		RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string, len(users))
+	for rows.Next() {
+		var id, email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return nil, err
+		}
+
+		ids[email] = id
+	}
+
+	return ids, rows.Err()
+}
+
+// insertManyWithIDs generates the ids up front, inserts while ignoring
+// conflicts and then looks up which of the ids made it in
+func (r *Repository) insertManyWithIDs(ctx context.Context, tx *sql.Tx, users []User, hashes [][]byte) (map[string]string, error) {
+	now := time.Now()
+	generated := make([]string, len(users))
+	uq := r.sb.Insert("users").
+		Columns(r.bulkColumns("user_id", "email", "first_name", "last_name", "password", "user_status", "created_at", "updated_at")...)
+	for i, u := range users {
+		id, err := newUUID()
+		if err != nil {
+			return nil, err
+		}
+
+		generated[i] = id
+		uq = uq.Values(r.bulkValues(id, u.Email, u.FirstName, u.LastName, hashes[i], u.statusOrPending(), now, now)...)
+	}
+
+	_, err := r.dialect.ignoreConflict(uq, "org_id, email").RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.sb.Select("user_id, email").
+		From("users").
+		Where(sq.Eq{"user_id": generated}).
+		RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string, len(users))
+	for rows.Next() {
+		var id, email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return nil, err
+		}
+
+		ids[email] = id
+	}
+
+	return ids, rows.Err()
+}
+
+// Stream calls fn for every user, sorted by last name, reading them from a
+// cursor one row at a time. It stops at the first error returned by fn
+func (r *Repository) Stream(fn func(*User) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+
+	uq := r.sb.Select("user_id, email, first_name, last_name, user_status, created_at, updated_at").
+		From("users").
+		OrderBy("last_name ASC")
+	rows, err := scope(uq, r.tenant).RunWith(r.reader()).QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Status,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		user.OrgID = r.tenant
+
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// bulkColumns adds the organization column to an insert of many users when
+// the repository is scoped to a tenant
+func (r *Repository) bulkColumns(columns ...string) []string {
+	if r.tenant == "" {
+		return columns
+	}
+
+	return append(columns, "org_id")
+}
+
+// bulkValues adds the tenant to one row of an insert of many users
+func (r *Repository) bulkValues(values ...interface{}) []interface{} {
+	if r.tenant == "" {
+		return values
+	}
+
+	return append(values, r.tenant)
+}
+
+// hashPasswords bcrypts the passwords of users on all available CPUs
+func hashPasswords(users []User) ([][]byte, error) {
+	hashes := make([][]byte, len(users))
+	errs := make([]error, len(users))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				hashes[i], errs[i] = bcrypt.GenerateFromPassword([]byte(users[i].Password), bcryptCost)
+			}
+		}()
+	}
+
+	for i := range users {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,172 @@
+package data_test
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Insert many users", func() {
+
+	const insertQuery = `
+		INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7),($8,$9,$10,$11,$12,$13,$14)
+		ON CONFLICT (org_id, email) DO NOTHING RETURNING user_id, email`
+
+	var (
+		err   error
+		ids   map[string]string
+		users []data.User
+	)
+
+	BeforeEach(func() {
+		users = []data.User{
+			{Email: "clark@mail.com", FirstName: "Clark", LastName: "Kent", Password: "password", Status: data.StatusActive},
+			{Email: "lois@mail.com", FirstName: "Lois", LastName: "Lane", Password: "password", Status: data.StatusActive},
+		}
+	})
+
+	When("all rows are inserted", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+
+			mockDB.ExpectBegin()
+			mockDB.ExpectQuery(insertQuery).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"user_id", "email"}).
+						AddRow("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "clark@mail.com").
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "lois@mail.com"),
+				)
+			mockDB.ExpectCommit()
+
+			ids, err = testRepo.InsertMany(users, true)
+		})
+
+		It("should not error", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should return the ids keyed by email", func() {
+			Expect(ids).To(HaveKeyWithValue("clark@mail.com", "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"))
+			Expect(ids).To(HaveKeyWithValue("lois@mail.com", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b"))
+		})
+	})
+
+	When("an email exists and the batch is all or nothing", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+
+			mockDB.ExpectBegin()
+			mockDB.ExpectQuery(insertQuery).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"user_id", "email"}).
+						AddRow("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "clark@mail.com"),
+				)
+			mockDB.ExpectRollback()
+
+			ids, err = testRepo.InsertMany(users, true)
+		})
+
+		It("should roll back and report the conflict", func() {
+			Expect(err).To(MatchError(data.ErrBatchConflict))
+			Expect(ids).ToNot(HaveKey("lois@mail.com"))
+		})
+	})
+
+	When("an email exists and the batch is best effort", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+
+			mockDB.ExpectBegin()
+			mockDB.ExpectQuery(insertQuery).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"user_id", "email"}).
+						AddRow("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "clark@mail.com"),
+				)
+			mockDB.ExpectCommit()
+
+			ids, err = testRepo.InsertMany(users, false)
+		})
+
+		It("should keep the inserted rows", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ids).To(HaveLen(1))
+		})
+	})
+})
+
+var _ = Describe("Stream users", func() {
+
+	var (
+		err   error
+		users []*data.User
+	)
+
+	When("there are rows", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+			users = nil
+
+			mockDB.ExpectQuery(`
+						SELECT
+							user_id, email, first_name, last_name, user_status, created_at, updated_at
+						FROM users
+						ORDER BY last_name ASC
+					`).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"user_id", "email", "first_name",
+							"last_name", "user_status",
+							"created_at", "updated_at",
+						},
+					).
+						AddRow(
+							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "example@mail.com", "Clark",
+							"Kent", "active",
+							time.Now(), time.Now(),
+						).
+						AddRow(
+							"ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example1@mail.com", "Lois",
+							"Lane", "pending",
+							time.Now(), time.Now(),
+						),
+				)
+
+			err = testRepo.Stream(func(u *data.User) error {
+				users = append(users, u)
+				return nil
+			})
+		})
+
+		It("should visit every row in order", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(users).To(HaveLen(2))
+			Expect(users[0].LastName).To(Equal("Kent"))
+		})
+	})
+
+	When("the query fails", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+
+			mockDB.ExpectQuery(`
+						SELECT
+							user_id, email, first_name, last_name, user_status, created_at, updated_at
+						FROM users
+						ORDER BY last_name ASC
+					`).
+				WillReturnError(sql.ErrConnDone)
+
+			err = testRepo.Stream(func(u *data.User) error { return nil })
+		})
+
+		It("should return error", func() {
+			Expect(err).To(MatchError(sql.ErrConnDone))
+		})
+	})
+})
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// ICache is a byte cache shared by the caching decorators. Get reports a
+// miss with ok set to false; expired entries are misses
+type ICache interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// LRUCache is an ICache held in process memory. It keeps at most size
+// entries, evicting the least recently used one first
+type LRUCache struct {
+	mu      sync.Mutex
+	entries *lru.Cache[string, lruEntry]
+}
+
+type lruEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRUCache(size int) (ICache, error) {
+	entries, err := lru.New[string, lruEntry](size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LRUCache{entries: entries}, nil
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if !time.Now().Before(e.expiresAt) {
+		c.entries.Remove(key)
+		return nil, false, nil
+	}
+
+	return e.value, true, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries.Add(key, lruEntry{value: value, expiresAt: time.Now().Add(ttl)})
+
+	return nil
+}
+
+func (c *LRUCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		c.entries.Remove(key)
+	}
+
+	return nil
+}
+
+// RedisCache is an ICache shared between instances through Redis. Keys are
+// namespaced with prefix so that the cache can share a database
+type RedisCache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisCache(client redis.UniversalClient, prefix string) ICache {
+	return &RedisCache{client: client, prefix: prefix}
+}
+
+// OpenRedisCache connects to the Redis server at url, e.g.
+// redis://localhost:6379/0
+func OpenRedisCache(url, prefix string) (ICache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return NewRedisCache(client, prefix), nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, c.prefix+key, value, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+
+	return c.client.Del(ctx, prefixed...).Err()
+}
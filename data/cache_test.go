@@ -0,0 +1,294 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheContract runs the specs every ICache must pass
+func cacheContract(newCache func() data.ICache) {
+	var (
+		cache data.ICache
+		ctx   context.Context
+	)
+
+	BeforeEach(func() {
+		cache = newCache()
+		ctx = context.Background()
+	})
+
+	It("should return what was set", func() {
+		Expect(cache.Set(ctx, "a", []byte("1"), time.Minute)).To(Succeed())
+
+		v, ok, err := cache.Get(ctx, "a")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal([]byte("1")))
+	})
+
+	It("should report misses", func() {
+		_, ok, err := cache.Get(ctx, "missing")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should delete keys", func() {
+		Expect(cache.Set(ctx, "a", []byte("1"), time.Minute)).To(Succeed())
+		Expect(cache.Set(ctx, "b", []byte("2"), time.Minute)).To(Succeed())
+		Expect(cache.Delete(ctx, "a", "b", "c")).To(Succeed())
+
+		_, ok, _ := cache.Get(ctx, "a")
+		Expect(ok).To(BeFalse())
+		_, ok, _ = cache.Get(ctx, "b")
+		Expect(ok).To(BeFalse())
+	})
+}
+
+var _ = Describe("LRU cache", func() {
+
+	cacheContract(func() data.ICache {
+		cache, err := data.NewLRUCache(2)
+		Expect(err).ShouldNot(HaveOccurred())
+		return cache
+	})
+
+	It("should expire entries", func() {
+		cache, _ := data.NewLRUCache(2)
+		Expect(cache.Set(context.Background(), "a", []byte("1"), 20*time.Millisecond)).To(Succeed())
+
+		Eventually(func() bool {
+			_, ok, _ := cache.Get(context.Background(), "a")
+			return ok
+		}).Should(BeFalse())
+	})
+
+	It("should evict the least recently used entry", func() {
+		ctx := context.Background()
+		cache, _ := data.NewLRUCache(2)
+		cache.Set(ctx, "a", []byte("1"), time.Minute)
+		cache.Set(ctx, "b", []byte("2"), time.Minute)
+		cache.Get(ctx, "a")
+		cache.Set(ctx, "c", []byte("3"), time.Minute)
+
+		_, ok, _ := cache.Get(ctx, "b")
+		Expect(ok).To(BeFalse())
+		_, ok, _ = cache.Get(ctx, "a")
+		Expect(ok).To(BeTrue())
+	})
+})
+
+var _ = Describe("Redis cache", func() {
+
+	var server *miniredis.Miniredis
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+	})
+
+	cacheContract(func() data.ICache {
+		return data.NewRedisCache(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+	})
+
+	It("should namespace keys and set their TTL", func() {
+		cache := data.NewRedisCache(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+		Expect(cache.Set(context.Background(), "a", []byte("1"), time.Minute)).To(Succeed())
+
+		Expect(server.Exists("test:a")).To(BeTrue())
+		Expect(server.TTL("test:a")).To(Equal(time.Minute))
+
+		server.FastForward(time.Minute)
+		_, ok, err := cache.Get(context.Background(), "a")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should open a cache from a URL", func() {
+		cache, err := data.OpenRedisCache("redis://"+server.Addr()+"/0", "test:")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cache.Set(context.Background(), "a", []byte("1"), time.Minute)).To(Succeed())
+	})
+})
+
+// slowRepo counts GetOne calls and holds them until release is closed
+type slowRepo struct {
+	data.IRepository
+
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (r *slowRepo) GetOne(id string) (*data.User, error) {
+	r.calls.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+
+	return r.IRepository.GetOne(id)
+}
+
+type failingCache struct{}
+
+func (failingCache) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("cache down")
+}
+
+func (failingCache) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("cache down")
+}
+
+func (failingCache) Delete(context.Context, ...string) error {
+	return errors.New("cache down")
+}
+
+var _ = Describe("Cached repository", func() {
+
+	var (
+		backing *slowRepo
+		cache   data.ICache
+		repo    data.IRepository
+		id      string
+	)
+
+	BeforeEach(func() {
+		backing = &slowRepo{IRepository: data.NewMemoryRepository()}
+		cache, _ = data.NewLRUCache(100)
+		repo = data.NewCachedRepository(backing, cache, time.Minute)
+
+		var err error
+		id, err = repo.Insert(data.User{Email: "clark@mail.com", LastName: "Kent", Password: "password", Status: data.StatusActive})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should serve repeated reads from the cache", func() {
+		for i := 0; i < 3; i++ {
+			u, err := repo.GetOne(id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(u.Email).To(Equal("clark@mail.com"))
+		}
+
+		Expect(backing.calls.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should never cache or return the password hash", func() {
+		u, err := repo.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(u.Password).To(BeEmpty())
+
+		b, ok, _ := cache.Get(context.Background(), "user:"+id)
+		Expect(ok).To(BeTrue())
+		Expect(string(b)).ToNot(ContainSubstring("password"))
+	})
+
+	It("should collapse concurrent misses into one lookup", func() {
+		backing.release = make(chan struct{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				_, err := repo.GetOne(id)
+				Expect(err).ShouldNot(HaveOccurred())
+			}()
+		}
+
+		Eventually(backing.calls.Load).Should(BeEquivalentTo(1))
+		close(backing.release)
+		wg.Wait()
+
+		Expect(backing.calls.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should invalidate on update and delete", func() {
+		u, _ := repo.GetOne(id)
+		u.LastName = "Lane"
+		Expect(repo.Update(*u)).To(Succeed())
+
+		u, err := repo.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(u.LastName).To(Equal("Lane"))
+		Expect(backing.calls.Load()).To(BeEquivalentTo(2))
+
+		Expect(repo.DeleteByID(id)).To(Succeed())
+		_, err = repo.GetOne(id)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should serve GetMany from the cache and load the rest", func() {
+		other, err := repo.Insert(data.User{Email: "lois@mail.com", LastName: "Lane", Password: "password"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = repo.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		users, missing, err := repo.GetMany(context.Background(), []string{other, id, "00000000-0000-0000-0000-000000000000"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(users).To(HaveLen(2))
+		Expect(users[0].ID).To(Equal(other))
+		Expect(users[1].ID).To(Equal(id))
+		Expect(missing).To(Equal([]string{"00000000-0000-0000-0000-000000000000"}))
+
+		_, ok, _ := cache.Get(context.Background(), "user:"+other)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should keep the entries of each tenant apart", func() {
+		scoped := repo.WithTenant(data.DefaultOrganizationID)
+		_, err := scoped.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = repo.WithTenant("10000000-0000-0000-0000-000000000000").GetOne(id)
+		Expect(err).Should(HaveOccurred())
+
+		u, err := scoped.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+		u.LastName = "Lane"
+		Expect(scoped.Update(*u)).To(Succeed())
+
+		_, ok, _ := cache.Get(context.Background(), "user:"+id)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should drop the entries of every tenant on unscoped writes", func() {
+		scoped := repo.WithTenant(data.DefaultOrganizationID)
+		u, err := scoped.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		u.LastName = "Lane"
+		Expect(repo.Update(*u)).To(Succeed())
+
+		u, err = scoped.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(u.LastName).To(Equal("Lane"))
+
+		Expect(repo.Anonymize(id)).To(Succeed())
+		u, err = scoped.GetOne(id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(u.Email).To(Equal(data.ErasedEmail(id)))
+	})
+
+	It("should fall back to the repository when the cache fails", func() {
+		repo = data.NewCachedRepository(backing, failingCache{}, time.Minute)
+
+		for i := 0; i < 2; i++ {
+			u, err := repo.GetOne(id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(u.ID).To(Equal(id))
+		}
+		Expect(backing.calls.Load()).To(BeEquivalentTo(2))
+
+		Expect(repo.Update(data.User{ID: id, Email: fmt.Sprintf("kent@%s", "mail.com")})).To(Succeed())
+	})
+})
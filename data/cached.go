@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const userCachePrefix = "user:"
+
+// CachedRepository decorates an IRepository with a read-through cache of
+// users by id. Concurrent misses for the same id share one lookup, and
+// writes drop the entries they touch. Cached users never carry the password
+// hash, so GetOne and GetMany return users without one.
+//
+// The cache is a best effort: when it fails, reads go to the repository and
+// writes still succeed, leaving any stale entry to expire with its TTL
+type CachedRepository struct {
+	repo  IRepository
+	cache ICache
+	ttl   time.Duration
+	// tenant is the organization a scoped view serves entries of
+	tenant string
+	*lookups
+}
+
+// lookups is shared by the tenant views of a CachedRepository
+type lookups struct {
+	group singleflight.Group
+	// writes counts invalidations so that a lookup which raced with a write
+	// does not store what it read before the write
+	writes atomic.Uint64
+}
+
+func NewCachedRepository(repo IRepository, cache ICache, ttl time.Duration) IRepository {
+	return &CachedRepository{repo: repo, cache: cache, ttl: ttl, lookups: &lookups{}}
+}
+
+func (r *CachedRepository) WithTenant(orgID string) IRepository {
+	return &CachedRepository{
+		repo:    r.repo.WithTenant(orgID),
+		cache:   r.cache,
+		ttl:     r.ttl,
+		tenant:  orgID,
+		lookups: r.lookups,
+	}
+}
+
+// key is the cache key of the user with this id. Ids are unique across
+// tenants, so every view shares the entry and a write through any of them
+// drops it. Scoped views only serve entries of their own tenant
+func (r *CachedRepository) key(id string) string {
+	return userCachePrefix + id
+}
+
+// lookupKey is the key under which concurrent misses are collapsed. Unscoped
+// lookups see every tenant, so they are kept apart from the scoped ones
+func (r *CachedRepository) lookupKey(id string) string {
+	if r.tenant == "" {
+		return id
+	}
+
+	return r.tenant + ":" + id
+}
+
+func (r *CachedRepository) GetAll() ([]*User, error) {
+	return r.repo.GetAll()
+}
+
+// GetOne returns the cached user with this id, loading it on a miss
+func (r *CachedRepository) GetOne(id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if u, ok := r.cached(ctx, id); ok {
+		return u, nil
+	}
+
+	v, err, _ := r.group.Do(r.lookupKey(id), func() (interface{}, error) {
+		writes := r.writes.Load()
+
+		u, err := r.repo.GetOne(id)
+		if err != nil {
+			return nil, err
+		}
+		u.Password = ""
+
+		if r.writes.Load() == writes {
+			r.store(ctx, u)
+		}
+
+		return u, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// callers sharing a lookup must not share the user they may modify
+	u := *v.(*User)
+	return &u, nil
+}
+
+// GetMany serves the cached users and loads the rest with one GetMany
+func (r *CachedRepository) GetMany(ctx context.Context, ids []string) ([]*User, []string, error) {
+	found := make(map[string]*User)
+
+	var misses []string
+	for _, id := range uniqueIDs(ids) {
+		if u, ok := r.cached(ctx, id); ok {
+			found[id] = u
+		} else {
+			misses = append(misses, id)
+		}
+	}
+
+	if len(misses) > 0 {
+		writes := r.writes.Load()
+
+		users, _, err := r.repo.GetMany(ctx, misses)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, u := range users {
+			u.Password = ""
+			found[u.ID] = u
+		}
+
+		if r.writes.Load() == writes {
+			for _, u := range users {
+				r.store(ctx, u)
+			}
+		}
+	}
+
+	users, missing := inOrder(ids, found)
+	return users, missing, nil
+}
+
+func (r *CachedRepository) GetByEmail(email string) (*User, error) {
+	return r.repo.GetByEmail(email)
+}
+
+func (r *CachedRepository) Update(u User) error {
+	defer r.invalidate(u.ID)
+
+	return r.repo.Update(u)
+}
+
+func (r *CachedRepository) DeleteByID(id string) error {
+	defer r.invalidate(id)
+
+	return r.repo.DeleteByID(id)
+}
+
+func (r *CachedRepository) Anonymize(id string) error {
+	defer r.invalidate(id)
+
+	return r.repo.Anonymize(id)
+}
+
+// Insert drops any entry left for the id of the new user, which matters
+// when the caller picked the id
+func (r *CachedRepository) Insert(u User) (string, error) {
+	id, err := r.repo.Insert(u)
+	if err == nil {
+		r.invalidate(id)
+	}
+
+	return id, err
+}
+
+func (r *CachedRepository) InsertMany(users []User, allOrNothing bool) (map[string]string, error) {
+	ids, err := r.repo.InsertMany(users, allOrNothing)
+
+	invalidated := make([]string, 0, len(ids))
+	for _, id := range ids {
+		invalidated = append(invalidated, id)
+	}
+	r.invalidate(invalidated...)
+
+	return ids, err
+}
+
+func (r *CachedRepository) Stream(fn func(*User) error) error {
+	return r.repo.Stream(fn)
+}
+
+func (r *CachedRepository) Search(opts SearchOptions) ([]*SearchResult, int, error) {
+	return r.repo.Search(opts)
+}
+
+func (r *CachedRepository) Transition(t StatusTransition) error {
+	defer r.invalidate(t.UserID)
+
+	return r.repo.Transition(t)
+}
+
+func (r *CachedRepository) Transitions(userID string) ([]*StatusTransition, error) {
+	return r.repo.Transitions(userID)
+}
+
+func (r *CachedRepository) cached(ctx context.Context, id string) (*User, bool) {
+	b, ok, err := r.cache.Get(ctx, r.key(id))
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	var u User
+	if err := json.Unmarshal(b, &u); err != nil {
+		return nil, false
+	}
+
+	// entries stored by unscoped reads may not name their organization
+	if r.tenant != "" && u.OrgID != r.tenant {
+		return nil, false
+	}
+
+	return &u, true
+}
+
+func (r *CachedRepository) store(ctx context.Context, u *User) {
+	entry := *u
+	entry.Password = ""
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	_ = r.cache.Set(ctx, r.key(u.ID), b, r.ttl)
+}
+
+// invalidate drops the cached users with these ids, and makes lookups
+// started before it neither store their result nor serve later callers
+func (r *CachedRepository) invalidate(ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	r.writes.Add(1)
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.key(id))
+		r.group.Forget(r.lookupKey(id))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_ = r.cache.Delete(ctx, keys...)
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -11,24 +12,119 @@ import (
 
 const dbTimeout = time.Second * 3
 
-var psql sq.StatementBuilderType
+// bcryptCost is the work factor used to hash user passwords
+const bcryptCost = 12
+
+// ErrDuplicateEmail is returned when a user is stored with an email that
+// belongs to another user
+var ErrDuplicateEmail = errors.New("email already exists")
 
 type Repository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	// replicas, when set, serves reads; db is its primary
+	replicas *ReplicaSet
+	// clock, when set, records the writes of the caller so that its reads
+	// go to the primary while the replicas may lag behind them
+	clock *WriteClock
+	// tenant scopes every statement to one organization when set
+	tenant string
+	// rls runs statements with the tenant set for row level security
+	rls bool
 }
 
 type IRepository interface {
 	GetAll() ([]*User, error)
 	GetOne(string) (*User, error)
+	GetMany(ctx context.Context, ids []string) ([]*User, []string, error)
 	GetByEmail(string) (*User, error)
 	Update(User) error
 	DeleteByID(string) error
+	// Anonymize replaces the email, names and password of a user with those
+	// of a tombstone. Its id, status and history are kept, so that the rows
+	// referring to it stay valid
+	Anonymize(id string) error
 	Insert(User) (string, error)
+	InsertMany(users []User, allOrNothing bool) (map[string]string, error)
+	Stream(func(*User) error) error
+	Search(SearchOptions) ([]*SearchResult, int, error)
+	// Transition moves a user to another status and records the change in
+	// its history
+	Transition(StatusTransition) error
+	// Transitions returns the status history of a user, oldest first
+	Transitions(userID string) ([]*StatusTransition, error)
+	// WithTenant returns a view of the repository restricted to the users
+	// of one organization, which also receives the users it inserts
+	WithTenant(orgID string) IRepository
 }
 
 func NewRepository(pool *sql.DB) IRepository {
-	psql = sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	return &Repository{db: pool}
+	return NewRepositoryFor(pool, Postgres)
+}
+
+// NewRepositoryFor returns the repository for a database of the given
+// dialect, e.g. the one reported by OpenDB
+func NewRepositoryFor(pool *sql.DB, dialect Dialect) IRepository {
+	return &Repository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+// NewReplicatedRepository returns a repository that writes to the primary of
+// set and reads from its replicas
+func NewReplicatedRepository(set *ReplicaSet, dialect Dialect) IRepository {
+	return &Repository{db: set.Primary(), dialect: dialect, sb: dialect.builder(), replicas: set}
+}
+
+// EnableRowLevelSecurity makes a Postgres repository set the app.org_id
+// setting checked by the row level security policy on users before every
+// statement, so that the database enforces tenant isolation as well
+func EnableRowLevelSecurity(repo IRepository) (IRepository, error) {
+	r, ok := repo.(*Repository)
+	if !ok || r.dialect != Postgres {
+		return nil, errors.New("row level security needs a Postgres repository")
+	}
+
+	scoped := *r
+	scoped.rls = true
+
+	return &scoped, nil
+}
+
+func (r *Repository) WithTenant(orgID string) IRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+// reader returns the runner for reads
+func (r *Repository) reader() sq.BaseRunner {
+	db := r.db
+	if r.replicas != nil {
+		db = r.replicas.reader(r.clock.LastWrite())
+	}
+
+	return r.runner(db)
+}
+
+// writer returns the runner for writes
+func (r *Repository) writer() sq.BaseRunner {
+	return r.runner(r.db)
+}
+
+func (r *Repository) runner(db *sql.DB) sq.BaseRunner {
+	if r.rls {
+		return rlsRunner{db: db, tenant: r.tenant}
+	}
+
+	return db
+}
+
+// wrote makes the reads of the caller stick to the primary for a while
+func (r *Repository) wrote() {
+	if r.replicas != nil {
+		r.clock.wrote()
+	}
 }
 
 type User struct {
@@ -37,9 +133,12 @@ type User struct {
 	FirstName string    `json:"first_name,omitempty"`
 	LastName  string    `json:"last_name,omitempty"`
 	Password  string    `json:"password,omitempty"`
-	Active    int       `json:"active"`
+	Status    Status    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// OrgID is the organization of the user. Reads fill it in when the
+	// repository is scoped to a tenant
+	OrgID string `json:"org_id,omitempty"`
 }
 
 // GetAll returns a slice of all users, sorted by last name
@@ -47,10 +146,10 @@ func (r *Repository) GetAll() ([]*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	uq := psql.Select("user_id, email, first_name, last_name, user_active, created_at, updated_at").
+	uq := r.sb.Select("user_id, email, first_name, last_name, user_status, created_at, updated_at").
 		From("users").
 		OrderBy("last_name ASC")
-	rows, err := uq.RunWith(r.db).QueryContext(ctx)
+	rows, err := scope(uq, r.tenant).RunWith(r.reader()).QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -65,13 +164,14 @@ func (r *Repository) GetAll() ([]*User, error) {
 			&user.Email,
 			&user.FirstName,
 			&user.LastName,
-			&user.Active,
+			&user.Status,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		user.OrgID = r.tenant
 
 		users = append(users, &user)
 	}
@@ -85,17 +185,17 @@ func (r *Repository) GetOne(id string) (*User, error) {
 	defer cancel()
 
 	var user User
-	uq := psql.Select("user_id, email, first_name, last_name, password, user_active, created_at, updated_at").
+	uq := r.sb.Select("user_id, email, first_name, last_name, password, user_status, created_at, updated_at").
 		From("users").
 		Where(sq.Eq{"user_id": id})
-	row := uq.RunWith(r.db).QueryRowContext(ctx)
+	row := scope(uq, r.tenant).RunWith(r.reader()).QueryRowContext(ctx)
 	err := row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
-		&user.Active,
+		&user.Status,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -103,27 +203,83 @@ func (r *Repository) GetOne(id string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	user.OrgID = r.tenant
 
 	return &user, nil
 }
 
-// GetByEmail returns one user by email
+// GetMany returns the users with the given ids in one query, in the order
+// of ids and without passwords. Repeated ids are returned once. The ids that
+// match no user, including malformed ones, are returned as missing
+func (r *Repository) GetMany(ctx context.Context, ids []string) ([]*User, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	wanted := uniqueIDs(ids)
+	found := make(map[string]*User, len(wanted))
+
+	if len(wanted) > 0 {
+		uq := r.sb.Select("user_id, email, first_name, last_name, user_status, created_at, updated_at").
+			From("users").
+			Where(sq.Eq{"user_id": wanted})
+		rows, err := scope(uq, r.tenant).RunWith(r.reader()).QueryContext(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user User
+			err := rows.Scan(
+				&user.ID,
+				&user.Email,
+				&user.FirstName,
+				&user.LastName,
+				&user.Status,
+				&user.CreatedAt,
+				&user.UpdatedAt,
+			)
+			if err != nil {
+				return nil, nil, err
+			}
+			user.OrgID = r.tenant
+
+			found[user.ID] = &user
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	users, missing := inOrder(ids, found)
+	return users, missing, nil
+}
+
+// GetByEmail returns one user by email. Emails are only unique within an
+// organization, so unscoped lookups are those of the default organization
 func (r *Repository) GetByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	org := r.tenant
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
 	var user User
-	uq := psql.Select("user_id, email, first_name, last_name, password, user_active, created_at, updated_at").
+	row := r.sb.Select("user_id, email, first_name, last_name, password, user_status, created_at, updated_at").
 		From("users").
-		Where(sq.Eq{"email": email})
-	row := uq.RunWith(r.db).QueryRowContext(ctx)
+		Where(sq.Eq{"email": email}).
+		Where(sq.Eq{"org_id": org}).
+		RunWith(r.reader()).QueryRowContext(ctx)
 	err := row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
-		&user.Active,
+		&user.Status,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -131,61 +287,128 @@ func (r *Repository) GetByEmail(email string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	user.OrgID = r.tenant
 
 	return &user, nil
 }
 
-// Update updates one user in the database, using the information
-// stored in the receiver u
+// Update updates the profile of one user in the database, using the
+// information stored in the receiver u. The status only changes through
+// Transition
 func (r *Repository) Update(u User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
+	defer r.wrote()
 
-	_, err := psql.Update("users").
+	uq := r.sb.Update("users").
 		SetMap(
 			sq.Eq{
 				"email": u.Email, "first_name": u.FirstName,
-				"last_name": u.LastName, "user_active": u.Active,
-				"updated_at": time.Now(),
+				"last_name": u.LastName, "updated_at": time.Now(),
 			}).
-		Where(sq.Eq{"user_id": u.ID}).
-		RunWith(r.db).ExecContext(ctx)
+		Where(sq.Eq{"user_id": u.ID})
+	_, err := scope(uq, r.tenant).RunWith(r.writer()).ExecContext(ctx)
 
-	return err
+	return r.dialect.translateError(err)
 }
 
 // DeleteByID deletes one user from the database, by ID
 func (r *Repository) DeleteByID(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
+	defer r.wrote()
 
-	_, err := psql.Delete("users").Where(sq.Eq{"user_id": id}).
-		RunWith(r.db).ExecContext(ctx)
+	dq := r.sb.Delete("users").Where(sq.Eq{"user_id": id})
+	_, err := scope(dq, r.tenant).RunWith(r.writer()).ExecContext(ctx)
 
 	return err
 }
 
+// ErasedEmail is the email an erased user is left with. It keeps emails
+// unique without telling anything about the user, and the .invalid domain
+// receives no mail
+func ErasedEmail(id string) string {
+	return id + "@erased.invalid"
+}
+
+// Anonymize overwrites the profile of one user with a tombstone. The empty
+// password matches none, so the user can no longer log in with one
+func (r *Repository) Anonymize(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	defer r.wrote()
+
+	uq := r.sb.Update("users").
+		SetMap(
+			sq.Eq{
+				"email": ErasedEmail(id), "first_name": "", "last_name": "",
+				"password": "", "updated_at": time.Now(),
+			}).
+		Where(sq.Eq{"user_id": id})
+	_, err := scope(uq, r.tenant).RunWith(r.writer()).ExecContext(ctx)
+
+	return r.dialect.translateError(err)
+}
+
 // Insert inserts a new user into the database, and returns the ID of the newly inserted row
 func (r *Repository) Insert(u User) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	var newID string
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), 12)
+	defer r.wrote()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcryptCost)
 	if err != nil {
 		return newID, err
 	}
 
-	uq := psql.Insert("users").
-		Columns("email", "first_name", "last_name", "password", "user_active", "created_at", "updated_at").
-		Values(u.Email, u.FirstName, u.LastName, hashedPassword, u.Active, time.Now(), time.Now()).
+	columns := []string{"email", "first_name", "last_name", "password", "user_status", "created_at", "updated_at"}
+	values := []interface{}{u.Email, u.FirstName, u.LastName, hashedPassword, u.statusOrPending(), time.Now(), time.Now()}
+
+	if org := r.orgFor(u); org != "" {
+		columns = append(columns, "org_id")
+		values = append(values, org)
+	}
+
+	if !r.dialect.generatesIDs() {
+		newID, err = newUUID()
+		if err != nil {
+			return "", err
+		}
+
+		_, err = r.sb.Insert("users").
+			Columns(append([]string{"user_id"}, columns...)...).
+			Values(append([]interface{}{newID}, values...)...).
+			RunWith(r.writer()).ExecContext(ctx)
+		if err != nil {
+			return "", r.dialect.translateError(err)
+		}
+
+		return newID, nil
+	}
+
+	uq := r.sb.Insert("users").
+		Columns(columns...).
+		Values(values...).
 		Suffix("RETURNING user_id").
-		RunWith(r.db).QueryRowContext(ctx)
+		RunWith(r.writer()).QueryRowContext(ctx)
 
 	err = uq.Scan(&newID)
 	if err != nil {
-		return newID, err
+		return newID, r.dialect.translateError(err)
 	}
 
 	return newID, nil
 }
+
+// orgFor returns the organization receiving u: the tenant, or else the one
+// set on u. When both are empty the column default, the default
+// organization, applies
+func (r *Repository) orgFor(u User) string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return u.OrgID
+}
@@ -1,6 +1,7 @@
 package data_test
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -25,7 +26,7 @@ var _ = Describe("Get user", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
 						WHERE user_id = $1
 					`).
@@ -34,13 +35,13 @@ var _ = Describe("Get user", func() {
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "password", "user_active",
+							"last_name", "password", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							uid, "example@mail.com", "Clark",
-							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", 1,
+							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", "active",
 							time.Now(), time.Now(),
 						),
 				)
@@ -70,7 +71,7 @@ var _ = Describe("Get user", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
 						WHERE user_id = $1
 					`).
@@ -104,7 +105,7 @@ var _ = Describe("Get All Users", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, user_status, created_at, updated_at
 						FROM users
 						ORDER BY last_name ASC
 					`).
@@ -112,18 +113,18 @@ var _ = Describe("Get All Users", func() {
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "user_active",
+							"last_name", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "example@mail.com", "Clark",
-							"Kent", 1,
+							"Kent", "active",
 							time.Now(), time.Now(),
 						).
 						AddRow(
 							"ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example1@mail.com", "Lois",
-							"Lane", 0,
+							"Lane", "pending",
 							time.Now(), time.Now(),
 						),
 				)
@@ -139,7 +140,7 @@ var _ = Describe("Get All Users", func() {
 
 		It("should populate the fields correctly", func() {
 			Expect(users[0].ID).To(Equal("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"))
-			Expect(users[1].Active).To(Equal(0))
+			Expect(users[1].Status).To(Equal(data.StatusPending))
 			Expect(users[0].Email).ToNot(Equal(users[1].Email))
 		})
 
@@ -154,7 +155,7 @@ var _ = Describe("Get All Users", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, user_status, created_at, updated_at
 						FROM users
 						ORDER BY last_name ASC
 					`).
@@ -189,22 +190,22 @@ var _ = Describe("Get user by Email", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
-						WHERE email = $1
+						WHERE email = $1 AND org_id = $2
 					`).
-				WithArgs(email).
+				WithArgs(email, data.DefaultOrganizationID).
 				WillReturnRows(
 					sqlmock.NewRows(
 						[]string{
 							"user_id", "email", "first_name",
-							"last_name", "password", "user_active",
+							"last_name", "password", "user_status",
 							"created_at", "updated_at",
 						},
 					).
 						AddRow(
 							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", email, "Clark",
-							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", 1,
+							"Kent", "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy", "active",
 							time.Now(), time.Now(),
 						),
 				)
@@ -234,11 +235,11 @@ var _ = Describe("Get user by Email", func() {
 
 			mockDB.ExpectQuery(`
 						SELECT 
-							user_id, email, first_name, last_name, password, user_active, created_at, updated_at
+							user_id, email, first_name, last_name, password, user_status, created_at, updated_at
 						FROM users
-						WHERE email = $1
+						WHERE email = $1 AND org_id = $2
 					`).
-				WithArgs(email).
+				WithArgs(email, data.DefaultOrganizationID).
 				WillReturnError(sql.ErrNoRows)
 
 			u, err = testRepo.GetByEmail(email)
@@ -270,7 +271,7 @@ var _ = Describe("Update user", func() {
 				Email:     "example@gmail.com",
 				FirstName: "Clark",
 				LastName:  "Kent",
-				Active:    1,
+				Status:    data.StatusActive,
 				UpdatedAt: time.Now(),
 			}
 
@@ -278,9 +279,8 @@ var _ = Describe("Update user", func() {
 						UPDATE users
 						SET 
 							email = $1, first_name = $2,
-							last_name = $3, updated_at = $4, 
-							user_active = $5
-						WHERE user_id = $6
+							last_name = $3, updated_at = $4
+						WHERE user_id = $5
 					`).
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -301,7 +301,7 @@ var _ = Describe("Update user", func() {
 				Email:     "example@gmail.com",
 				FirstName: "Clark",
 				LastName:  "Kent",
-				Active:    1,
+				Status:    data.StatusActive,
 				UpdatedAt: time.Now(),
 			}
 
@@ -309,9 +309,8 @@ var _ = Describe("Update user", func() {
 						UPDATE users
 						SET
 							email = $1, first_name = $2,
-							last_name = $3, updated_at = $4,
-							user_active = $5
-						WHERE user_id = $6
+							last_name = $3, updated_at = $4
+						WHERE user_id = $5
 					`).
 				WillReturnError(sql.ErrConnDone)
 
@@ -393,13 +392,13 @@ var _ = Describe("Insert user into db", func() {
 				FirstName: "Clark",
 				LastName:  "Kent",
 				Password:  "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy",
-				Active:    1,
+				Status:    data.StatusActive,
 				CreatedAt: time.Now(),
 				UpdatedAt: time.Now(),
 			}
 
 			mockDB.ExpectQuery(`
-					INSERT INTO users (email,first_name,last_name,password,user_active,created_at,updated_at) 
+					INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at) 
 					VALUES ($1,$2,$3,$4,$5,$6,$7) 
 					RETURNING user_id`,
 			).
@@ -428,13 +427,13 @@ var _ = Describe("Insert user into db", func() {
 				FirstName: "Clark",
 				LastName:  "Kent",
 				Password:  "$2a$12$4P.DPHoR0ULhMVCRSa8qg.HVagvaPoYG3Di9i253G9ILIli3sTGwy",
-				Active:    1,
+				Status:    data.StatusActive,
 				CreatedAt: time.Now(),
 				UpdatedAt: time.Now(),
 			}
 
 			mockDB.ExpectQuery(`
-					INSERT INTO users (email,first_name,last_name,password,user_active,created_at,updated_at) 
+					INSERT INTO users (email,first_name,last_name,password,user_status,created_at,updated_at) 
 					VALUES ($1,$2,$3,$4,$5,$6,$7) 
 					RETURNING user_id`,
 			).
@@ -453,3 +452,107 @@ var _ = Describe("Insert user into db", func() {
 		})
 	})
 })
+
+var _ = Describe("Get many users", func() {
+
+	It("should fetch the ids in one query and keep their order", func() {
+		mockDB, testRepo := newTestRepo()
+		first := "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"
+		second := "ae17b2e2-6b87-4c5b-9c94-3623dacf113b"
+		missing := "61296308-2148-463d-b888-1010b3d9643b"
+
+		mockDB.ExpectQuery(`
+					SELECT user_id, email, first_name, last_name, user_status, created_at, updated_at
+					FROM users
+					WHERE user_id IN ($1,$2,$3)
+				`).
+			WithArgs(second, first, missing).
+			WillReturnRows(
+				sqlmock.NewRows(
+					[]string{
+						"user_id", "email", "first_name",
+						"last_name", "user_status",
+						"created_at", "updated_at",
+					},
+				).
+					AddRow(first, "example@mail.com", "Clark", "Kent", "active", time.Now(), time.Now()).
+					AddRow(second, "example1@mail.com", "Lois", "Lane", "pending", time.Now(), time.Now()),
+			)
+
+		users, notFound, err := testRepo.GetMany(context.Background(), []string{second, first, second, missing, "42"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(users).To(HaveLen(2))
+		Expect(users[0].ID).To(Equal(second))
+		Expect(users[1].ID).To(Equal(first))
+		Expect(notFound).To(Equal([]string{missing, "42"}))
+		Expect(mockDB.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should not query when no id is well formed", func() {
+		mockDB, testRepo := newTestRepo()
+
+		users, notFound, err := testRepo.GetMany(context.Background(), []string{"42"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(users).To(BeEmpty())
+		Expect(notFound).To(Equal([]string{"42"}))
+		Expect(mockDB.ExpectationsWereMet()).To(Succeed())
+	})
+})
+
+var _ = Describe("Row level security", func() {
+
+	const acme = "10000000-0000-0000-0000-000000000000"
+
+	var (
+		mockDB sqlmock.Sqlmock
+		repo   data.IRepository
+	)
+
+	BeforeEach(func() {
+		var plain data.IRepository
+		mockDB, plain = newTestRepo()
+
+		var err error
+		repo, err = data.EnableRowLevelSecurity(plain)
+		Expect(err).ShouldNot(HaveOccurred())
+		repo = repo.WithTenant(acme)
+	})
+
+	AfterEach(func() {
+		Expect(mockDB.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should set the tenant for the transaction of each statement only", func() {
+		mockDB.ExpectBegin()
+		mockDB.ExpectExec(`SELECT set_config('app.org_id', $1, true)`).
+			WithArgs(acme).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mockDB.ExpectExec(`
+					DELETE FROM users
+					WHERE user_id = $1 AND org_id = $2
+				`).
+			WithArgs("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", acme).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectCommit()
+
+		Expect(repo.DeleteByID("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3")).To(Succeed())
+	})
+
+	It("should end the transaction of a read once it is done", func() {
+		mockDB.ExpectBegin()
+		mockDB.ExpectExec(`SELECT set_config('app.org_id', $1, true)`).
+			WithArgs(acme).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mockDB.ExpectQuery(`
+					SELECT
+						user_id, email, first_name, last_name, password, user_status, created_at, updated_at
+					FROM users
+					WHERE user_id = $1 AND org_id = $2
+				`).
+			WillReturnError(sql.ErrNoRows)
+		mockDB.ExpectRollback()
+
+		_, err := repo.GetOne("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3")
+		Expect(err).To(MatchError(sql.ErrNoRows))
+	})
+})
@@ -0,0 +1,46 @@
+package data
+
+// Dependents are the repositories of the rows that belong to a user and
+// follow what happens to it, whichever API changed it. Nil repositories are
+// skipped
+type Dependents struct {
+	Sessions ISessionRepository
+	Groups   IGroupRepository
+}
+
+// WithTenant returns the dependents of the users of one organization
+func (d Dependents) WithTenant(orgID string) Dependents {
+	if d.Sessions != nil {
+		d.Sessions = d.Sessions.WithTenant(orgID)
+	}
+	if d.Groups != nil {
+		d.Groups = d.Groups.WithTenant(orgID)
+	}
+
+	return d
+}
+
+// StatusChanged logs u out everywhere once its status no longer lets it
+// authenticate
+func (d Dependents) StatusChanged(u *User) error {
+	if u.Status.CanAuthenticate() || d.Sessions == nil {
+		return nil
+	}
+
+	return d.Sessions.RevokeAll(u.ID)
+}
+
+// Deleted revokes the sessions and group memberships of a deleted user
+func (d Dependents) Deleted(userID string) error {
+	if d.Sessions != nil {
+		if err := d.Sessions.RevokeAll(userID); err != nil {
+			return err
+		}
+	}
+
+	if d.Groups != nil {
+		return d.Groups.RemoveFromAll(userID)
+	}
+
+	return nil
+}
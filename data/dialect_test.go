@@ -0,0 +1,34 @@
+package data_test
+
+import (
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse DSN", func() {
+
+	DescribeTable("picking the dialect from the scheme",
+		func(dsn string, dialect data.Dialect, driverDSN string) {
+			d, out, err := data.ParseDSN(dsn)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(d).To(Equal(dialect))
+			Expect(out).To(Equal(driverDSN))
+		},
+		Entry("postgres keyword/value", "host=localhost port=5432 dbname=users", data.Postgres, "host=localhost port=5432 dbname=users"),
+		Entry("postgres URL", "postgres://u:p@localhost:5432/users", data.Postgres, "postgres://u:p@localhost:5432/users"),
+		Entry("sqlite file", "sqlite:///var/lib/users.db", data.SQLite, "/var/lib/users.db"),
+		Entry("sqlite memory", "sqlite://:memory:", data.SQLite, ":memory:"),
+		Entry("mysql", "mysql://u:p@localhost:3306/users", data.MySQL, "u:p@tcp(localhost:3306)/users?multiStatements=true&parseTime=true"),
+	)
+
+	It("should reject unknown schemes", func() {
+		_, _, err := data.ParseDSN("oracle://localhost")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject an empty DSN", func() {
+		_, _, err := data.ParseDSN("")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,823 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// FieldType is the type of the values of a custom field
+type FieldType string
+
+const (
+	FieldString  FieldType = "string"
+	FieldInteger FieldType = "integer"
+	FieldNumber  FieldType = "number"
+	FieldBoolean FieldType = "boolean"
+	// FieldDate holds calendar dates written as YYYY-MM-DD
+	FieldDate FieldType = "date"
+	// FieldEnum holds one of the options of its definition
+	FieldEnum FieldType = "enum"
+)
+
+// FieldTypes lists every field type
+var FieldTypes = []FieldType{FieldString, FieldInteger, FieldNumber, FieldBoolean, FieldDate, FieldEnum}
+
+// Valid reports whether t is a known field type
+func (t FieldType) Valid() bool {
+	for _, known := range FieldTypes {
+		if t == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	maxFieldOptions     = 100
+	maxAttributeLength  = 4096
+	attributeDateLayout = "2006-01-02"
+)
+
+// ErrDuplicateField is returned when a field is defined twice in an
+// organization
+var ErrDuplicateField = errors.New("field already exists")
+
+// fieldNamePattern keeps field names usable as JSON keys and query
+// parameters without escaping
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// FieldDefinition is a custom field the users of an organization may have a
+// value for, which is exposed among their attributes
+type FieldDefinition struct {
+	ID       string    `json:"field_id"`
+	OrgID    string    `json:"org_id,omitempty"`
+	Name     string    `json:"name"`
+	Label    string    `json:"label"`
+	Type     FieldType `json:"type"`
+	Required bool      `json:"required"`
+	// Options are the values an enum field may take
+	Options []string `json:"options,omitempty"`
+	// MaxLength limits the characters of string values
+	MaxLength *int `json:"max_length,omitempty"`
+	// Min and Max bound integer and number values
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Attributes are the custom field values of a user, by field name. Values
+// are JSON values: strings, float64 numbers and bools
+type Attributes map[string]interface{}
+
+type IFieldRepository interface {
+	// GetAll returns the field definitions, sorted by name
+	GetAll() ([]*FieldDefinition, error)
+	GetByName(name string) (*FieldDefinition, error)
+	Insert(FieldDefinition) (string, error)
+	// Update changes the definition with f's name. The type of a field
+	// cannot change once users have values for it
+	Update(FieldDefinition) error
+	// DeleteByName deletes a definition along with every value of it
+	DeleteByName(name string) error
+	// Attributes returns the attributes of the given users. Users without
+	// any are left out
+	Attributes(userIDs []string) (map[string]Attributes, error)
+	// SetAttributes merges attrs into the attributes of a user. nil values
+	// remove the attribute
+	SetAttributes(userID string, attrs Attributes) error
+	// FindUsers returns the ids of the users whose attributes hold every
+	// value of filters
+	FindUsers(filters Attributes) ([]string, error)
+	// WithTenant returns a view of the fields and attributes of one
+	// organization. Without a tenant they are those of the default one
+	WithTenant(orgID string) IFieldRepository
+}
+
+// ValidateFieldDefinition checks the fields of a submitted definition
+func ValidateFieldDefinition(f FieldDefinition) []FieldError {
+	var errs []FieldError
+
+	if !fieldNamePattern.MatchString(f.Name) {
+		errs = append(errs, FieldError{Field: "name", Message: "must be lowercase letters, digits and underscores starting with a letter, at most 63 characters"})
+	}
+
+	if len(f.Label) > maxNameLength {
+		errs = append(errs, FieldError{Field: "label", Message: "must be at most 255 characters"})
+	}
+
+	if !f.Type.Valid() {
+		errs = append(errs, FieldError{Field: "type", Message: fmt.Sprintf("must be one of %v", FieldTypes)})
+	}
+
+	if f.Type == FieldEnum {
+		if len(f.Options) == 0 || len(f.Options) > maxFieldOptions {
+			errs = append(errs, FieldError{Field: "options", Message: "must list between 1 and 100 options"})
+		}
+
+		seen := make(map[string]bool, len(f.Options))
+		for _, o := range f.Options {
+			if o == "" || seen[o] {
+				errs = append(errs, FieldError{Field: "options", Message: "must be distinct and not empty"})
+				break
+			}
+			seen[o] = true
+		}
+	} else if len(f.Options) > 0 {
+		errs = append(errs, FieldError{Field: "options", Message: "are only allowed for enum fields"})
+	}
+
+	if f.MaxLength != nil && (f.Type != FieldString || *f.MaxLength < 1 || *f.MaxLength > maxAttributeLength) {
+		errs = append(errs, FieldError{Field: "max_length", Message: "must be between 1 and 4096, for string fields"})
+	}
+
+	if (f.Min != nil || f.Max != nil) && f.Type != FieldInteger && f.Type != FieldNumber {
+		errs = append(errs, FieldError{Field: "min", Message: "bounds are only allowed for integer and number fields"})
+	} else if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		errs = append(errs, FieldError{Field: "min", Message: "must not be greater than max"})
+	}
+
+	return errs
+}
+
+// ValidateAttributes checks submitted attributes against the field
+// definitions of their organization. nil values remove an attribute, which
+// required fields do not allow. When create is true every required field
+// must have a value
+func ValidateAttributes(defs []*FieldDefinition, attrs Attributes, create bool) []FieldError {
+	var errs []FieldError
+
+	byName := make(map[string]*FieldDefinition, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+
+		if _, ok := attrs[d.Name]; create && d.Required && !ok {
+			errs = append(errs, FieldError{Field: "attributes." + d.Name, Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d, ok := byName[name]
+		if !ok {
+			errs = append(errs, FieldError{Field: "attributes." + name, Message: "is not a defined field"})
+			continue
+		}
+
+		if attrs[name] == nil {
+			if d.Required {
+				errs = append(errs, FieldError{Field: "attributes." + name, Message: "is required"})
+			}
+			continue
+		}
+
+		if msg := d.check(attrs[name]); msg != "" {
+			errs = append(errs, FieldError{Field: "attributes." + name, Message: msg})
+		}
+	}
+
+	return errs
+}
+
+// check returns why v is not a valid value of the field, or an empty string
+func (d *FieldDefinition) check(v interface{}) string {
+	switch d.Type {
+	case FieldString:
+		s, ok := v.(string)
+		if !ok {
+			return "must be a string"
+		}
+		limit := maxAttributeLength
+		if d.MaxLength != nil {
+			limit = *d.MaxLength
+		}
+		if utf8.RuneCountInString(s) > limit {
+			return fmt.Sprintf("must be at most %d characters", limit)
+		}
+	case FieldInteger, FieldNumber:
+		n, ok := v.(float64)
+		if !ok || (d.Type == FieldInteger && n != math.Trunc(n)) {
+			return "must be of type " + string(d.Type)
+		}
+		if d.Min != nil && n < *d.Min {
+			return fmt.Sprintf("must be at least %v", *d.Min)
+		}
+		if d.Max != nil && n > *d.Max {
+			return fmt.Sprintf("must be at most %v", *d.Max)
+		}
+	case FieldBoolean:
+		if _, ok := v.(bool); !ok {
+			return "must be a boolean"
+		}
+	case FieldDate:
+		s, ok := v.(string)
+		if !ok {
+			return "must be a date"
+		}
+		if _, err := time.Parse(attributeDateLayout, s); err != nil {
+			return "is not a valid YYYY-MM-DD date"
+		}
+	case FieldEnum:
+		s, _ := v.(string)
+		for _, o := range d.Options {
+			if s == o {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %v", d.Options)
+	}
+
+	return ""
+}
+
+// ParseAttribute reads a value of the field written as text, as in a query
+// string, and checks it
+func (d *FieldDefinition) ParseAttribute(raw string) (interface{}, error) {
+	var (
+		v   interface{} = raw
+		err error
+	)
+
+	switch d.Type {
+	case FieldInteger:
+		var n int64
+		n, err = strconv.ParseInt(raw, 10, 64)
+		v = float64(n)
+	case FieldNumber:
+		v, err = strconv.ParseFloat(raw, 64)
+	case FieldBoolean:
+		v, err = strconv.ParseBool(raw)
+	}
+
+	if err != nil {
+		return nil, errors.New("must be of type " + string(d.Type))
+	}
+
+	if msg := d.check(v); msg != "" {
+		return nil, errors.New(msg)
+	}
+
+	return v, nil
+}
+
+// encodeAttribute is the text a value is stored and compared as
+func encodeAttribute(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+
+	return string(b), err
+}
+
+type FieldRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	tenant  string
+}
+
+// NewFieldRepositoryFor returns the custom field store for a database of
+// the given dialect
+func NewFieldRepositoryFor(pool *sql.DB, dialect Dialect) IFieldRepository {
+	return &FieldRepository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+func (r *FieldRepository) WithTenant(orgID string) IFieldRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+// org is the organization the repository reads and writes
+func (r *FieldRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+const fieldColumns = "field_id, org_id, name, label, field_type, required, options, max_length, min_value, max_value, created_at, updated_at"
+
+func (r *FieldRepository) GetAll() ([]*FieldDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select(fieldColumns).
+		From("custom_fields").
+		Where(sq.Eq{"org_id": r.org()}).
+		OrderBy("name").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var defs []*FieldDefinition
+	for rows.Next() {
+		d, err := scanField(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		defs = append(defs, d)
+	}
+
+	return defs, rows.Err()
+}
+
+func (r *FieldRepository) GetByName(name string) (*FieldDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := r.sb.Select(fieldColumns).
+		From("custom_fields").
+		Where(sq.Eq{"org_id": r.org(), "name": name}).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	return scanField(row)
+}
+
+// Insert stores a definition under a generated id and returns it
+func (r *FieldRepository) Insert(f FieldDefinition) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	options, err := json.Marshal(f.Options)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = r.sb.Insert("custom_fields").
+		Columns("field_id", "org_id", "name", "label", "field_type", "required", "options", "max_length", "min_value", "max_value", "created_at", "updated_at").
+		Values(id, r.org(), f.Name, f.Label, f.Type, f.Required, string(options), f.MaxLength, f.Min, f.Max, now, now).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		if errors.Is(r.dialect.translateError(err), ErrDuplicateEmail) {
+			return "", ErrDuplicateField
+		}
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *FieldRepository) Update(f FieldDefinition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	options, err := json.Marshal(f.Options)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.sb.Update("custom_fields").
+		SetMap(sq.Eq{
+			"label":      f.Label,
+			"required":   f.Required,
+			"options":    string(options),
+			"max_length": f.MaxLength,
+			"min_value":  f.Min,
+			"max_value":  f.Max,
+			"updated_at": time.Now(),
+		}).
+		Where(sq.Eq{"org_id": r.org(), "name": f.Name}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *FieldRepository) DeleteByName(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = r.sb.Delete("user_attributes").
+		Where(sq.Eq{"org_id": r.org(), "name": name}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.sb.Delete("custom_fields").
+		Where(sq.Eq{"org_id": r.org(), "name": name}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+func (r *FieldRepository) Attributes(userIDs []string) (map[string]Attributes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	attrs := make(map[string]Attributes)
+	for _, chunk := range chunkIDs(uniqueIDs(userIDs)) {
+		rows, err := r.sb.Select("user_id, name, value").
+			From("user_attributes").
+			Where(sq.Eq{"org_id": r.org(), "user_id": chunk}).
+			RunWith(r.db).QueryContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var userID, name, raw string
+			if err := rows.Scan(&userID, &name, &raw); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			var v interface{}
+			if err := json.Unmarshal([]byte(raw), &v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			if attrs[userID] == nil {
+				attrs[userID] = make(Attributes)
+			}
+			attrs[userID][name] = v
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return attrs, nil
+}
+
+func (r *FieldRepository) SetAttributes(userID string, attrs Attributes) error {
+	if !uuidPattern.MatchString(userID) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for name, v := range attrs {
+		_, err := r.sb.Delete("user_attributes").
+			Where(sq.Eq{"user_id": userID, "name": name}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if v == nil {
+			continue
+		}
+
+		value, err := encodeAttribute(v)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.sb.Insert("user_attributes").
+			Columns("user_id", "org_id", "name", "value").
+			Values(userID, r.org(), name, value).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *FieldRepository) FindUsers(filters Attributes) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var match sq.Or
+	for name, v := range filters {
+		value, err := encodeAttribute(v)
+		if err != nil {
+			return nil, err
+		}
+		match = append(match, sq.Eq{"name": name, "value": value})
+	}
+
+	// each user has at most one row per name, so those matching every
+	// filter match len(filters) rows
+	rows, err := r.sb.Select("user_id").
+		From("user_attributes").
+		Where(sq.Eq{"org_id": r.org()}).
+		Where(match).
+		GroupBy("user_id").
+		Having("COUNT(*) = ?", len(filters)).
+		OrderBy("user_id").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func scanField(row sq.RowScanner) (*FieldDefinition, error) {
+	var (
+		d         FieldDefinition
+		options   string
+		maxLength sql.NullInt64
+		min, max  sql.NullFloat64
+	)
+
+	err := row.Scan(&d.ID, &d.OrgID, &d.Name, &d.Label, &d.Type, &d.Required, &options, &maxLength, &min, &max, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(options), &d.Options); err != nil {
+		return nil, err
+	}
+
+	if maxLength.Valid {
+		n := int(maxLength.Int64)
+		d.MaxLength = &n
+	}
+	if min.Valid {
+		d.Min = &min.Float64
+	}
+	if max.Valid {
+		d.Max = &max.Float64
+	}
+
+	return &d, nil
+}
+
+// MemoryFieldRepository is an IFieldRepository held in process memory
+type MemoryFieldRepository struct {
+	*memoryFields
+	tenant string
+}
+
+type memoryFields struct {
+	mu sync.RWMutex
+	// defs holds the definitions of each organization by name
+	defs map[string]map[string]*FieldDefinition
+	// values holds the attributes of each user
+	values map[string]*memoryAttributes
+}
+
+type memoryAttributes struct {
+	orgID string
+	attrs Attributes
+}
+
+func NewMemoryFieldRepository() IFieldRepository {
+	return &MemoryFieldRepository{memoryFields: &memoryFields{
+		defs:   make(map[string]map[string]*FieldDefinition),
+		values: make(map[string]*memoryAttributes),
+	}}
+}
+
+func (r *MemoryFieldRepository) WithTenant(orgID string) IFieldRepository {
+	return &MemoryFieldRepository{memoryFields: r.memoryFields, tenant: orgID}
+}
+
+func (r *MemoryFieldRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+func (r *MemoryFieldRepository) GetAll() ([]*FieldDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var defs []*FieldDefinition
+	for _, d := range r.defs[r.org()] {
+		c := *d
+		defs = append(defs, &c)
+	}
+
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+
+	return defs, nil
+}
+
+func (r *MemoryFieldRepository) GetByName(name string) (*FieldDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.defs[r.org()][name]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *d
+	return &c, nil
+}
+
+func (r *MemoryFieldRepository) Insert(f FieldDefinition) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defs := r.defs[r.org()]
+	if defs == nil {
+		defs = make(map[string]*FieldDefinition)
+		r.defs[r.org()] = defs
+	}
+
+	if _, ok := defs[f.Name]; ok {
+		return "", ErrDuplicateField
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	f.ID = id
+	f.OrgID = r.org()
+	f.CreatedAt = time.Now()
+	f.UpdatedAt = f.CreatedAt
+	defs[f.Name] = &f
+
+	return id, nil
+}
+
+func (r *MemoryFieldRepository) Update(f FieldDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.defs[r.org()][f.Name]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	d.Label = f.Label
+	d.Required = f.Required
+	d.Options = f.Options
+	d.MaxLength = f.MaxLength
+	d.Min = f.Min
+	d.Max = f.Max
+	d.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryFieldRepository) DeleteByName(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.defs[r.org()][name]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(r.defs[r.org()], name)
+
+	for _, v := range r.values {
+		if v.orgID == r.org() {
+			delete(v.attrs, name)
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryFieldRepository) Attributes(userIDs []string) (map[string]Attributes, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attrs := make(map[string]Attributes)
+	for _, id := range userIDs {
+		v, ok := r.values[id]
+		if !ok || v.orgID != r.org() || len(v.attrs) == 0 {
+			continue
+		}
+
+		c := make(Attributes, len(v.attrs))
+		for name, value := range v.attrs {
+			c[name] = value
+		}
+		attrs[id] = c
+	}
+
+	return attrs, nil
+}
+
+func (r *MemoryFieldRepository) SetAttributes(userID string, attrs Attributes) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.values[userID]
+	if !ok || v.orgID != r.org() {
+		v = &memoryAttributes{orgID: r.org(), attrs: make(Attributes)}
+		r.values[userID] = v
+	}
+
+	for name, value := range attrs {
+		if value == nil {
+			delete(v.attrs, name)
+			continue
+		}
+
+		// values are kept as they would read back from JSON
+		raw, err := encodeAttribute(value)
+		if err != nil {
+			return err
+		}
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+			return err
+		}
+		v.attrs[name] = decoded
+	}
+
+	return nil
+}
+
+func (r *MemoryFieldRepository) FindUsers(filters Attributes) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ids []string
+	for id, v := range r.values {
+		if v.orgID != r.org() {
+			continue
+		}
+
+		matches := true
+		for name, want := range filters {
+			if got, ok := v.attrs[name]; !ok || got != want {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
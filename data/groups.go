@@ -0,0 +1,658 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	maxGroupScopes         = 16
+	maxGroupDescriptionLen = 1000
+)
+
+// ErrDuplicateGroup is returned when two groups of an organization would
+// share a name
+var ErrDuplicateGroup = errors.New("group already exists")
+
+// Group is a team of users of an organization. Its members hold the scopes
+// of the group on top of their own
+type Group struct {
+	ID          string    `json:"group_id"`
+	OrgID       string    `json:"org_id,omitempty"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Scopes      []string  `json:"scopes"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type IGroupRepository interface {
+	// GetAll returns the groups of the organization, by name
+	GetAll() ([]*Group, error)
+	GetOne(id string) (*Group, error)
+	// Insert stores a group under a generated id and returns it
+	Insert(Group) (string, error)
+	// Update replaces the name, description and scopes of a group
+	Update(Group) error
+	// Delete removes a group along with its memberships
+	Delete(id string) error
+	// AddMember puts a user in a group. Adding a member again does nothing
+	AddMember(groupID, userID string) error
+	// RemoveMember takes a user out of a group, failing with sql.ErrNoRows
+	// when they were not in it
+	RemoveMember(groupID, userID string) error
+	// RemoveFromAll takes a user out of every group
+	RemoveFromAll(userID string) error
+	// Members returns the ids of a page of the members of a group, in the
+	// order they joined, along with their total
+	Members(groupID string, limit, offset int) ([]string, int, error)
+	// GroupsOf returns the groups a user is a member of, by name
+	GroupsOf(userID string) ([]*Group, error)
+	// WithTenant returns a view of the groups of one organization
+	WithTenant(orgID string) IGroupRepository
+}
+
+// ValidateGroup checks the fields of a group submitted for creation or
+// update against the scopes that may be granted
+func ValidateGroup(g Group, grantable []string) []FieldError {
+	var errs []FieldError
+
+	if strings.TrimSpace(g.Name) == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	} else if len(g.Name) > maxNameLength {
+		errs = append(errs, FieldError{Field: "name", Message: "must be at most 255 characters"})
+	}
+
+	if len(g.Description) > maxGroupDescriptionLen {
+		errs = append(errs, FieldError{Field: "description", Message: "must be at most 1000 characters"})
+	}
+
+	if len(g.Scopes) > maxGroupScopes {
+		errs = append(errs, FieldError{Field: "scopes", Message: "must list at most 16 scopes"})
+	}
+
+	allowed := make(map[string]bool, len(grantable))
+	for _, s := range grantable {
+		allowed[s] = true
+	}
+
+	for _, s := range g.Scopes {
+		if !allowed[s] {
+			errs = append(errs, FieldError{Field: "scopes", Message: "cannot grant " + s})
+			break
+		}
+	}
+
+	return errs
+}
+
+type GroupRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	tenant  string
+	// groups is the name of the groups table, quoted for the dialect
+	groups string
+}
+
+// NewGroupRepositoryFor returns the group store for a database of the given
+// dialect
+func NewGroupRepositoryFor(pool *sql.DB, dialect Dialect) IGroupRepository {
+	return &GroupRepository{db: pool, dialect: dialect, sb: dialect.builder(), groups: dialect.quote("groups")}
+}
+
+func (r *GroupRepository) WithTenant(orgID string) IGroupRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+// org is the organization the repository reads and writes
+func (r *GroupRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+const groupColumns = "group_id, org_id, name, description, scopes, created_at, updated_at"
+
+func (r *GroupRepository) GetAll() ([]*Group, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select(groupColumns).
+		From(r.groups).
+		Where(sq.Eq{"org_id": r.org()}).
+		OrderBy("name").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanGroups(rows)
+}
+
+func (r *GroupRepository) GetOne(id string) (*Group, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := r.sb.Select(groupColumns).
+		From(r.groups).
+		Where(sq.Eq{"org_id": r.org(), "group_id": id}).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	return scanGroup(row)
+}
+
+func (r *GroupRepository) Insert(g Group) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = r.sb.Insert(r.groups).
+		Columns("group_id", "org_id", "name", "description", "scopes", "created_at", "updated_at").
+		Values(id, r.org(), g.Name, g.Description, strings.Join(g.Scopes, " "), now, now).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		if errors.Is(r.dialect.translateError(err), ErrDuplicateEmail) {
+			return "", ErrDuplicateGroup
+		}
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *GroupRepository) Update(g Group) error {
+	if !uuidPattern.MatchString(g.ID) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := r.sb.Update(r.groups).
+		SetMap(sq.Eq{
+			"name":        g.Name,
+			"description": g.Description,
+			"scopes":      strings.Join(g.Scopes, " "),
+			"updated_at":  time.Now(),
+		}).
+		Where(sq.Eq{"org_id": r.org(), "group_id": g.ID}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		if errors.Is(r.dialect.translateError(err), ErrDuplicateEmail) {
+			return ErrDuplicateGroup
+		}
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *GroupRepository) Delete(id string) error {
+	if !uuidPattern.MatchString(id) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = r.sb.Delete("group_members").
+		Where(sq.Eq{"org_id": r.org(), "group_id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.sb.Delete(r.groups).
+		Where(sq.Eq{"org_id": r.org(), "group_id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+func (r *GroupRepository) AddMember(groupID, userID string) error {
+	if !uuidPattern.MatchString(groupID) || !uuidPattern.MatchString(userID) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	iq := r.sb.Insert("group_members").
+		Columns("group_id", "user_id", "org_id", "created_at").
+		Values(groupID, userID, r.org(), time.Now())
+	_, err := r.dialect.ignoreConflict(iq, "group_id, user_id").RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *GroupRepository) RemoveMember(groupID, userID string) error {
+	if !uuidPattern.MatchString(groupID) || !uuidPattern.MatchString(userID) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := r.sb.Delete("group_members").
+		Where(sq.Eq{"org_id": r.org(), "group_id": groupID, "user_id": userID}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *GroupRepository) RemoveFromAll(userID string) error {
+	if !uuidPattern.MatchString(userID) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Delete("group_members").
+		Where(sq.Eq{"org_id": r.org(), "user_id": userID}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *GroupRepository) Members(groupID string, limit, offset int) ([]string, int, error) {
+	if !uuidPattern.MatchString(groupID) {
+		return nil, 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where := sq.Eq{"org_id": r.org(), "group_id": groupID}
+
+	var total int
+	err := r.sb.Select("COUNT(*)").
+		From("group_members").
+		Where(where).
+		RunWith(r.db).QueryRowContext(ctx).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.sb.Select("user_id").
+		From("group_members").
+		Where(where).
+		OrderBy("created_at", "user_id").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, total, rows.Err()
+}
+
+func (r *GroupRepository) GroupsOf(userID string) ([]*Group, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	columns := make([]string, 0, 7)
+	for _, c := range strings.Split(groupColumns, ", ") {
+		columns = append(columns, "g."+c)
+	}
+
+	rows, err := r.sb.Select(columns...).
+		From(r.groups + " g").
+		Join("group_members m ON m.group_id = g.group_id").
+		Where(sq.Eq{"g.org_id": r.org(), "m.user_id": userID}).
+		OrderBy("g.name").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanGroups(rows)
+}
+
+func scanGroups(rows *sql.Rows) ([]*Group, error) {
+	var groups []*Group
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups, rows.Err()
+}
+
+func scanGroup(row sq.RowScanner) (*Group, error) {
+	var (
+		g      Group
+		scopes string
+	)
+
+	err := row.Scan(&g.ID, &g.OrgID, &g.Name, &g.Description, &scopes, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	g.Scopes = strings.Fields(scopes)
+
+	return &g, nil
+}
+
+// MemoryGroupRepository is an IGroupRepository held in process memory
+type MemoryGroupRepository struct {
+	*memoryGroups
+	tenant string
+}
+
+type memoryGroups struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+	// members holds the memberships of each group, in the order they were
+	// added
+	members map[string][]string
+}
+
+func NewMemoryGroupRepository() IGroupRepository {
+	return &MemoryGroupRepository{memoryGroups: &memoryGroups{
+		groups:  make(map[string]*Group),
+		members: make(map[string][]string),
+	}}
+}
+
+func (r *MemoryGroupRepository) WithTenant(orgID string) IGroupRepository {
+	return &MemoryGroupRepository{memoryGroups: r.memoryGroups, tenant: orgID}
+}
+
+func (r *MemoryGroupRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+// group returns the group of the organization with id. Callers hold the
+// lock
+func (r *MemoryGroupRepository) group(id string) (*Group, bool) {
+	g, ok := r.groups[id]
+	if !ok || g.OrgID != r.org() {
+		return nil, false
+	}
+
+	return g, true
+}
+
+// copyGroup keeps callers from changing the stored scopes
+func copyGroup(g *Group) *Group {
+	c := *g
+	c.Scopes = append([]string(nil), g.Scopes...)
+
+	return &c
+}
+
+func (r *MemoryGroupRepository) GetAll() ([]*Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var groups []*Group
+	for _, g := range r.groups {
+		if g.OrgID == r.org() {
+			groups = append(groups, copyGroup(g))
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	return groups, nil
+}
+
+func (r *MemoryGroupRepository) GetOne(id string) (*Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	g, ok := r.group(id)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyGroup(g), nil
+}
+
+// nameTaken reports whether another group of the organization is called
+// name. Callers hold the lock
+func (r *MemoryGroupRepository) nameTaken(name, except string) bool {
+	for _, g := range r.groups {
+		if g.OrgID == r.org() && g.Name == name && g.ID != except {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *MemoryGroupRepository) Insert(g Group) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(g.Name, "") {
+		return "", ErrDuplicateGroup
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	g.ID = id
+	g.OrgID = r.org()
+	g.CreatedAt = time.Now()
+	g.UpdatedAt = g.CreatedAt
+	r.groups[id] = copyGroup(&g)
+
+	return id, nil
+}
+
+func (r *MemoryGroupRepository) Update(g Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.group(g.ID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if r.nameTaken(g.Name, g.ID) {
+		return ErrDuplicateGroup
+	}
+
+	stored.Name = g.Name
+	stored.Description = g.Description
+	stored.Scopes = append([]string(nil), g.Scopes...)
+	stored.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryGroupRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.group(id); !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(r.groups, id)
+	delete(r.members, id)
+
+	return nil
+}
+
+func (r *MemoryGroupRepository) AddMember(groupID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.group(groupID); !ok {
+		return sql.ErrNoRows
+	}
+
+	for _, id := range r.members[groupID] {
+		if id == userID {
+			return nil
+		}
+	}
+
+	r.members[groupID] = append(r.members[groupID], userID)
+
+	return nil
+}
+
+func (r *MemoryGroupRepository) RemoveMember(groupID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.group(groupID); !ok {
+		return sql.ErrNoRows
+	}
+
+	members := r.members[groupID]
+	for i, id := range members {
+		if id == userID {
+			r.members[groupID] = append(members[:i:i], members[i+1:]...)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (r *MemoryGroupRepository) RemoveFromAll(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for groupID, members := range r.members {
+		if _, ok := r.group(groupID); !ok {
+			continue
+		}
+
+		for i, id := range members {
+			if id == userID {
+				r.members[groupID] = append(members[:i:i], members[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryGroupRepository) Members(groupID string, limit, offset int) ([]string, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.group(groupID); !ok {
+		return nil, 0, nil
+	}
+
+	members := r.members[groupID]
+	total := len(members)
+
+	var ids []string
+	for i := offset; i < total && len(ids) < limit; i++ {
+		ids = append(ids, members[i])
+	}
+
+	return ids, total, nil
+}
+
+func (r *MemoryGroupRepository) GroupsOf(userID string) ([]*Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var groups []*Group
+	for groupID, members := range r.members {
+		g, ok := r.group(groupID)
+		if !ok {
+			continue
+		}
+
+		for _, id := range members {
+			if id == userID {
+				groups = append(groups, copyGroup(g))
+				break
+			}
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	return groups, nil
+}
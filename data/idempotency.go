@@ -0,0 +1,303 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key header
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// UserID names the user the stored response describes, if any, so that
+	// it follows the exports and erasures of the user
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type IIdempotencyStore interface {
+	// Reserve claims key for a request with the given fingerprint for the
+	// length of the lease, after which a request that never completed can be
+	// retried. If the key is already held by a live record, that record is
+	// returned with reserved set to false
+	Reserve(key, fingerprint string, lease time.Duration) (rec *IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response for a previously reserved key and keeps it
+	// until the ExpiresAt of the record
+	Complete(IdempotencyRecord) error
+	// Release drops a reservation so that the key can be retried
+	Release(key string) error
+	// GetByUser returns the live completed records that describe a user,
+	// oldest first
+	GetByUser(userID string) ([]*IdempotencyRecord, error)
+	// DeleteByUser deletes the records that describe a user, after which
+	// their keys can be reused
+	DeleteByUser(userID string) error
+}
+
+type IdempotencyRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+}
+
+func NewIdempotencyRepository(pool *sql.DB) IIdempotencyStore {
+	return NewIdempotencyRepositoryFor(pool, Postgres)
+}
+
+// NewIdempotencyRepositoryFor returns the idempotency store for a database of
+// the given dialect
+func NewIdempotencyRepositoryFor(pool *sql.DB, dialect Dialect) IIdempotencyStore {
+	return &IdempotencyRepository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+// Reserve inserts a pending record for key unless a live one already exists
+func (r *IdempotencyRepository) Reserve(key, fingerprint string, lease time.Duration) (*IdempotencyRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := r.sb.Delete("idempotency_keys").
+		Where(sq.Eq{"idempotency_key": key}).
+		Where(sq.Lt{"expires_at": now}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	iq := r.sb.Insert("idempotency_keys").
+		Columns("idempotency_key", "fingerprint", "created_at", "expires_at").
+		Values(key, fingerprint, now, now.Add(lease))
+	res, err := r.dialect.ignoreConflict(iq, "idempotency_key").
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if n == 1 {
+		return &IdempotencyRecord{
+			Key: key, Fingerprint: fingerprint,
+			CreatedAt: now, ExpiresAt: now.Add(lease),
+		}, true, nil
+	}
+
+	var rec IdempotencyRecord
+	row := r.sb.Select("idempotency_key, fingerprint, completed, status_code, content_type, body, created_at, expires_at").
+		From("idempotency_keys").
+		Where(sq.Eq{"idempotency_key": key}).
+		RunWith(r.db).QueryRowContext(ctx)
+	err = row.Scan(
+		&rec.Key,
+		&rec.Fingerprint,
+		&rec.Completed,
+		&rec.StatusCode,
+		&rec.ContentType,
+		&rec.Body,
+		&rec.CreatedAt,
+		&rec.ExpiresAt,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &rec, false, nil
+}
+
+// Complete stores the captured response of the request holding the key
+func (r *IdempotencyRepository) Complete(rec IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	set := sq.Eq{
+		"completed": true, "status_code": rec.StatusCode,
+		"content_type": rec.ContentType, "body": rec.Body,
+		"expires_at": rec.ExpiresAt,
+	}
+	if rec.UserID != "" {
+		set["user_id"] = rec.UserID
+	}
+
+	_, err := r.sb.Update("idempotency_keys").
+		SetMap(set).
+		Where(sq.Eq{"idempotency_key": rec.Key}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+// Release deletes the record for key
+func (r *IdempotencyRepository) Release(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Delete("idempotency_keys").Where(sq.Eq{"idempotency_key": key}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *IdempotencyRepository) GetByUser(userID string) ([]*IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select("idempotency_key, fingerprint, completed, status_code, content_type, body, user_id, created_at, expires_at").
+		From("idempotency_keys").
+		Where(sq.Eq{"user_id": userID, "completed": true}).
+		Where(sq.GtOrEq{"expires_at": time.Now()}).
+		OrderBy("created_at", "idempotency_key").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*IdempotencyRecord
+	for rows.Next() {
+		var rec IdempotencyRecord
+		err := rows.Scan(
+			&rec.Key,
+			&rec.Fingerprint,
+			&rec.Completed,
+			&rec.StatusCode,
+			&rec.ContentType,
+			&rec.Body,
+			&rec.UserID,
+			&rec.CreatedAt,
+			&rec.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &rec)
+	}
+
+	return records, rows.Err()
+}
+
+func (r *IdempotencyRepository) DeleteByUser(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Delete("idempotency_keys").Where(sq.Eq{"user_id": userID}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+// MemoryIdempotencyStore keeps idempotency records in process memory. It is
+// meant for tests and single instance deployments
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func NewMemoryIdempotencyStore() IIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]IdempotencyRecord)}
+}
+
+// Reserve stores a pending record for key unless a live one already exists
+func (s *MemoryIdempotencyStore) Reserve(key, fingerprint string, lease time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if rec, ok := s.records[key]; ok && rec.ExpiresAt.After(now) {
+		return &rec, false, nil
+	}
+
+	rec := IdempotencyRecord{
+		Key: key, Fingerprint: fingerprint,
+		CreatedAt: now, ExpiresAt: now.Add(lease),
+	}
+	s.records[key] = rec
+
+	return &rec, true, nil
+}
+
+// Complete stores the captured response of the request holding the key
+func (s *MemoryIdempotencyStore) Complete(rec IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.records[rec.Key]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	stored.Completed = true
+	stored.StatusCode = rec.StatusCode
+	stored.ContentType = rec.ContentType
+	stored.Body = append([]byte(nil), rec.Body...)
+	stored.UserID = rec.UserID
+	stored.ExpiresAt = rec.ExpiresAt
+	s.records[rec.Key] = stored
+
+	return nil
+}
+
+// Release deletes the record for key
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// GetByUser returns the live completed records that describe a user
+func (s *MemoryIdempotencyStore) GetByUser(userID string) ([]*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var records []*IdempotencyRecord
+	for _, rec := range s.records {
+		if rec.UserID == userID && rec.Completed && !rec.ExpiresAt.Before(now) {
+			rec := rec
+			rec.Body = append([]byte(nil), rec.Body...)
+			records = append(records, &rec)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		}
+		return records[i].Key < records[j].Key
+	})
+
+	return records, nil
+}
+
+// DeleteByUser deletes the records that describe a user
+func (s *MemoryIdempotencyStore) DeleteByUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, rec := range s.records {
+		if rec.UserID == userID {
+			delete(s.records, key)
+		}
+	}
+
+	return nil
+}
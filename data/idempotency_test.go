@@ -0,0 +1,199 @@
+package data_test
+
+import (
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory idempotency store", func() {
+
+	var store data.IIdempotencyStore
+
+	BeforeEach(func() {
+		store = data.NewMemoryIdempotencyStore()
+	})
+
+	When("a key is reserved for the first time", func() {
+		It("should reserve it", func() {
+			rec, reserved, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeTrue())
+			Expect(rec.Completed).To(BeFalse())
+		})
+	})
+
+	When("a key is already reserved", func() {
+		It("should return the stored record", func() {
+			rec, _, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rec.StatusCode = 201
+			rec.Body = []byte(`{}`)
+			Expect(store.Complete(*rec)).To(Succeed())
+
+			stored, reserved, err := store.Reserve("key", "other", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeFalse())
+			Expect(stored.Fingerprint).To(Equal("fp"))
+			Expect(stored.Completed).To(BeTrue())
+			Expect(stored.StatusCode).To(Equal(201))
+		})
+	})
+
+	When("a reservation has expired", func() {
+		It("should reserve the key again", func() {
+			_, _, err := store.Reserve("key", "fp", -time.Second)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, reserved, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeTrue())
+		})
+	})
+
+	When("a request completes after its lease", func() {
+		It("should keep the response until the record expires", func() {
+			rec, _, err := store.Reserve("key", "fp", -time.Second)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rec.StatusCode = 201
+			rec.ExpiresAt = time.Now().Add(time.Hour)
+			Expect(store.Complete(*rec)).To(Succeed())
+
+			stored, reserved, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeFalse())
+			Expect(stored.Completed).To(BeTrue())
+		})
+	})
+
+	When("a response describes a user", func() {
+		It("should be listed and deleted with the user", func() {
+			rec, _, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rec.StatusCode = 201
+			rec.Body = []byte(`{"first_name":"Clark"}`)
+			rec.UserID = "clark"
+			rec.ExpiresAt = time.Now().Add(time.Hour)
+			Expect(store.Complete(*rec)).To(Succeed())
+
+			_, _, err = store.Reserve("other", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			records, err := store.GetByUser("clark")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Body).To(Equal(rec.Body))
+
+			Expect(store.DeleteByUser("clark")).To(Succeed())
+			Expect(store.GetByUser("clark")).To(BeEmpty())
+
+			_, reserved, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeTrue())
+		})
+	})
+
+	When("a key is released", func() {
+		It("should be free again", func() {
+			_, _, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(store.Release("key")).To(Succeed())
+
+			_, reserved, err := store.Reserve("key", "fp", time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Postgres idempotency store", func() {
+
+	var (
+		err      error
+		reserved bool
+		rec      *data.IdempotencyRecord
+	)
+
+	When("the key is free", func() {
+		BeforeEach(func() {
+			db, mockDB, e := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			Expect(e).Should(BeNil())
+
+			mockDB.ExpectExec(`
+					DELETE FROM idempotency_keys
+					WHERE idempotency_key = $1 AND expires_at < $2
+				`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectExec(`
+					INSERT INTO idempotency_keys (idempotency_key,fingerprint,created_at,expires_at)
+					VALUES ($1,$2,$3,$4)
+					ON CONFLICT (idempotency_key) DO NOTHING
+				`).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			rec, reserved, err = data.NewIdempotencyRepository(db).Reserve("key", "fp", time.Minute)
+		})
+
+		It("should reserve it", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeTrue())
+			Expect(rec.Key).To(Equal("key"))
+		})
+	})
+
+	When("the key is taken", func() {
+		BeforeEach(func() {
+			db, mockDB, e := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			Expect(e).Should(BeNil())
+
+			mockDB.ExpectExec(`
+					DELETE FROM idempotency_keys
+					WHERE idempotency_key = $1 AND expires_at < $2
+				`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectExec(`
+					INSERT INTO idempotency_keys (idempotency_key,fingerprint,created_at,expires_at)
+					VALUES ($1,$2,$3,$4)
+					ON CONFLICT (idempotency_key) DO NOTHING
+				`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectQuery(`
+					SELECT
+						idempotency_key, fingerprint, completed, status_code, content_type, body, created_at, expires_at
+					FROM idempotency_keys
+					WHERE idempotency_key = $1
+				`).
+				WithArgs("key").
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"idempotency_key", "fingerprint", "completed", "status_code",
+							"content_type", "body", "created_at", "expires_at",
+						},
+					).
+						AddRow(
+							"key", "fp", true, 201,
+							"application/json", []byte(`{}`), time.Now(), time.Now().Add(time.Minute),
+						),
+				)
+
+			rec, reserved, err = data.NewIdempotencyRepository(db).Reserve("key", "fp", time.Minute)
+		})
+
+		It("should return the stored record", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reserved).To(BeFalse())
+			Expect(rec.StatusCode).To(Equal(201))
+			Expect(rec.Body).To(Equal([]byte(`{}`)))
+		})
+	})
+})
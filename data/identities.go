@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ErrDuplicateIdentity is returned when an external identity is linked to a
+// second user of an organization
+var ErrDuplicateIdentity = errors.New("identity already linked")
+
+// Identity links the account of a user at an external identity provider,
+// named by the provider and its subject, to a user
+type Identity struct {
+	ID       string `json:"identity_id"`
+	OrgID    string `json:"org_id,omitempty"`
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	// Email is the email the provider gave when the identity was linked
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IIdentityRepository interface {
+	GetBySubject(provider, subject string) (*Identity, error)
+	// GetByUser returns the identities of a user, oldest first
+	GetByUser(userID string) ([]*Identity, error)
+	Insert(Identity) (string, error)
+	// DeleteByUser unlinks every identity of a user
+	DeleteByUser(userID string) error
+	// WithTenant returns a view of the identities of one organization,
+	// which also receives the identities it inserts
+	WithTenant(orgID string) IIdentityRepository
+}
+
+type IdentityRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	tenant  string
+}
+
+// NewIdentityRepositoryFor returns the identity store for a database of the
+// given dialect
+func NewIdentityRepositoryFor(pool *sql.DB, dialect Dialect) IIdentityRepository {
+	return &IdentityRepository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+func (r *IdentityRepository) WithTenant(orgID string) IIdentityRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+const identityColumns = "identity_id, org_id, user_id, provider, subject, email, created_at"
+
+func (r *IdentityRepository) GetBySubject(provider, subject string) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(identityColumns).
+		From("identities").
+		Where(sq.Eq{"provider": provider, "subject": subject})
+
+	return scanIdentity(scope(uq, r.tenant).RunWith(r.db).QueryRowContext(ctx))
+}
+
+func (r *IdentityRepository) GetByUser(userID string) ([]*Identity, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(identityColumns).
+		From("identities").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("created_at", "identity_id")
+	rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*Identity
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+// Insert links an identity under a generated id and returns it
+func (r *IdentityRepository) Insert(i Identity) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	org := i.OrgID
+	if r.tenant != "" {
+		org = r.tenant
+	}
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
+	_, err = r.sb.Insert("identities").
+		Columns("identity_id", "org_id", "user_id", "provider", "subject", "email", "created_at").
+		Values(id, org, i.UserID, i.Provider, i.Subject, i.Email, time.Now()).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		if errors.Is(r.dialect.translateError(err), ErrDuplicateEmail) {
+			return "", ErrDuplicateIdentity
+		}
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *IdentityRepository) DeleteByUser(userID string) error {
+	if !uuidPattern.MatchString(userID) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	dq := r.sb.Delete("identities").Where(sq.Eq{"user_id": userID})
+	_, err := scope(dq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func scanIdentity(row sq.RowScanner) (*Identity, error) {
+	var i Identity
+	if err := row.Scan(&i.ID, &i.OrgID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// MemoryIdentityRepository is an IIdentityRepository held in process memory
+type MemoryIdentityRepository struct {
+	*memoryIdentities
+	tenant string
+}
+
+type memoryIdentities struct {
+	mu         sync.RWMutex
+	identities map[string]*Identity
+}
+
+func NewMemoryIdentityRepository() IIdentityRepository {
+	return &MemoryIdentityRepository{memoryIdentities: &memoryIdentities{identities: make(map[string]*Identity)}}
+}
+
+func (r *MemoryIdentityRepository) WithTenant(orgID string) IIdentityRepository {
+	return &MemoryIdentityRepository{memoryIdentities: r.memoryIdentities, tenant: orgID}
+}
+
+// org is the organization of the identities this repository inserts and
+// looks up
+func (r *MemoryIdentityRepository) org() string {
+	if r.tenant == "" {
+		return DefaultOrganizationID
+	}
+
+	return r.tenant
+}
+
+func (r *MemoryIdentityRepository) GetBySubject(provider, subject string) (*Identity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, i := range r.identities {
+		if i.Provider == provider && i.Subject == subject && (r.tenant == "" || i.OrgID == r.tenant) {
+			c := *i
+			return &c, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryIdentityRepository) GetByUser(userID string) ([]*Identity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var identities []*Identity
+	for _, i := range r.identities {
+		if i.UserID == userID && (r.tenant == "" || i.OrgID == r.tenant) {
+			c := *i
+			identities = append(identities, &c)
+		}
+	}
+
+	sort.Slice(identities, func(a, b int) bool {
+		return identities[a].CreatedAt.Before(identities[b].CreatedAt)
+	})
+
+	return identities, nil
+}
+
+func (r *MemoryIdentityRepository) Insert(i Identity) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org := i.OrgID
+	if r.tenant != "" || org == "" {
+		org = r.org()
+	}
+
+	for _, existing := range r.identities {
+		if existing.OrgID == org && existing.Provider == i.Provider && existing.Subject == i.Subject {
+			return "", ErrDuplicateIdentity
+		}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	i.ID = id
+	i.OrgID = org
+	i.CreatedAt = time.Now()
+	r.identities[id] = &i
+
+	return id, nil
+}
+
+func (r *MemoryIdentityRepository) DeleteByUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, i := range r.identities {
+		if i.UserID == userID && (r.tenant == "" || i.OrgID == r.tenant) {
+			delete(r.identities, id)
+		}
+	}
+
+	return nil
+}
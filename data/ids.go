@@ -0,0 +1,62 @@
+package data
+
+import "regexp"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// uniqueIDs drops repeated ids and the ones that are not UUIDs, which
+// Postgres would reject for the whole query
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] || !uuidPattern.MatchString(id) {
+			continue
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+
+	return out
+}
+
+// idChunkSize is the most ids sent in one IN list, which keeps queries
+// under the bound parameter limits of the drivers
+const idChunkSize = 500
+
+// chunkIDs splits ids into lists of at most idChunkSize
+func chunkIDs(ids []string) [][]string {
+	var chunks [][]string
+	for len(ids) > idChunkSize {
+		chunks = append(chunks, ids[:idChunkSize])
+		ids = ids[idChunkSize:]
+	}
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+
+	return chunks
+}
+
+// inOrder lines found up with ids, returning each user once, and lists the
+// ids that were not found
+func inOrder(ids []string, found map[string]*User) ([]*User, []string) {
+	users := make([]*User, 0, len(found))
+	var missing []string
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if u, ok := found[id]; ok {
+			users = append(users, u)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return users, missing
+}
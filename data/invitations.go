@@ -0,0 +1,500 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// InvitationStatus is where an invitation stands. Pending invitations past
+// their expiry can no longer be accepted, but keep their status
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationRevoked  InvitationStatus = "revoked"
+)
+
+// Invitation asks someone to create their account at an email. It is sent
+// with a signed token, which the invitee hands back with their password;
+// only the hash of the latest token is kept, so resending it retires the
+// earlier ones
+type Invitation struct {
+	ID        string           `json:"invitation_id"`
+	OrgID     string           `json:"org_id,omitempty"`
+	Email     string           `json:"email"`
+	FirstName string           `json:"first_name"`
+	LastName  string           `json:"last_name"`
+	Status    InvitationStatus `json:"status"`
+	// InvitedBy is who sent the invitation, e.g. "admin" or "user:<id>"
+	InvitedBy string `json:"invited_by"`
+	TokenHash string `json:"-"`
+	// UserID is the user created when the invitation was accepted
+	UserID    string    `json:"user_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SentAt    time.Time `json:"sent_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Expired reports whether a pending invitation can no longer be accepted
+// at now
+func (i *Invitation) Expired(now time.Time) bool {
+	return i.Status == InvitationPending && !now.Before(i.ExpiresAt)
+}
+
+type IInvitationRepository interface {
+	// GetAll returns the invitations of the organization, newest first
+	GetAll() ([]*Invitation, error)
+	GetOne(id string) (*Invitation, error)
+	// GetPending returns the pending invitation of an email, failing with
+	// sql.ErrNoRows when there is none
+	GetPending(email string) (*Invitation, error)
+	// Insert stores a pending invitation under a generated id and returns
+	// it. It cannot be accepted until it is sent
+	Insert(Invitation) (string, error)
+	// Send records that a pending invitation was sent with the token of
+	// tokenHash, which replaces its earlier tokens, and may be accepted
+	// until expiresAt
+	Send(id, tokenHash string, expiresAt time.Time) error
+	// Revoke withdraws a pending invitation
+	Revoke(id string) error
+	// Accept marks the pending invitation with the token of tokenHash as
+	// accepted by the user created for it
+	Accept(id, tokenHash, userID string) error
+	// Anonymize replaces the email and names of the invitations accepted by
+	// a user or sent to email with those of the erased user, and revokes
+	// the pending ones
+	Anonymize(userID, email string) error
+	// WithTenant returns a view of the invitations of one organization
+	WithTenant(orgID string) IInvitationRepository
+}
+
+type InvitationRepository struct {
+	db     *sql.DB
+	sb     sq.StatementBuilderType
+	tenant string
+}
+
+// NewInvitationRepositoryFor returns the invitation store for a database of
+// the given dialect
+func NewInvitationRepositoryFor(pool *sql.DB, dialect Dialect) IInvitationRepository {
+	return &InvitationRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *InvitationRepository) WithTenant(orgID string) IInvitationRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+// org is the organization the repository reads and writes
+func (r *InvitationRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+const invitationColumns = "invitation_id, org_id, email, first_name, last_name, status, invited_by, token_hash, user_id, expires_at, sent_at, created_at, updated_at"
+
+func (r *InvitationRepository) GetAll() ([]*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select(invitationColumns).
+		From("invitations").
+		Where(sq.Eq{"org_id": r.org()}).
+		OrderBy("created_at DESC", "invitation_id").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*Invitation
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, i)
+	}
+
+	return invitations, rows.Err()
+}
+
+func (r *InvitationRepository) GetOne(id string) (*Invitation, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := r.sb.Select(invitationColumns).
+		From("invitations").
+		Where(sq.Eq{"org_id": r.org(), "invitation_id": id}).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	return scanInvitation(row)
+}
+
+func (r *InvitationRepository) GetPending(email string) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := r.sb.Select(invitationColumns).
+		From("invitations").
+		Where(sq.Eq{"org_id": r.org(), "email": email, "status": InvitationPending}).
+		OrderBy("created_at DESC").
+		Limit(1).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	return scanInvitation(row)
+}
+
+func (r *InvitationRepository) Insert(i Invitation) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = r.sb.Insert("invitations").
+		Columns("invitation_id", "org_id", "email", "first_name", "last_name", "status", "invited_by", "token_hash", "expires_at", "sent_at", "created_at", "updated_at").
+		Values(id, r.org(), i.Email, i.FirstName, i.LastName, InvitationPending, i.InvitedBy, "", now, now, now, now).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *InvitationRepository) Send(id, tokenHash string, expiresAt time.Time) error {
+	now := time.Now()
+
+	return r.updatePending(id, nil, sq.Eq{
+		"token_hash": tokenHash,
+		"expires_at": expiresAt,
+		"sent_at":    now,
+		"updated_at": now,
+	})
+}
+
+func (r *InvitationRepository) Revoke(id string) error {
+	return r.updatePending(id, nil, sq.Eq{
+		"status":     InvitationRevoked,
+		"updated_at": time.Now(),
+	})
+}
+
+func (r *InvitationRepository) Accept(id, tokenHash, userID string) error {
+	return r.updatePending(id, sq.Eq{"token_hash": tokenHash}, sq.Eq{
+		"status":     InvitationAccepted,
+		"user_id":    userID,
+		"updated_at": time.Now(),
+	})
+}
+
+func (r *InvitationRepository) Anonymize(userID, email string) error {
+	var of sq.Or
+	if uuidPattern.MatchString(userID) {
+		of = append(of, sq.Eq{"user_id": userID})
+	}
+	if email != "" {
+		of = append(of, sq.Eq{"email": email})
+	}
+	if len(of) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = r.sb.Update("invitations").
+		SetMap(sq.Eq{"status": InvitationRevoked, "updated_at": now}).
+		Where(sq.Eq{"org_id": r.org(), "status": InvitationPending}).
+		Where(of).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.sb.Update("invitations").
+		SetMap(sq.Eq{
+			"email":      ErasedEmail(userID),
+			"first_name": "",
+			"last_name":  "",
+			"token_hash": "",
+			"updated_at": now,
+		}).
+		Where(sq.Eq{"org_id": r.org()}).
+		Where(of).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updatePending sets the columns of a pending invitation that also matches
+// where, failing with sql.ErrNoRows when there is none. The status is
+// checked as it is changed, so an invitation is accepted or revoked once
+func (r *InvitationRepository) updatePending(id string, where, set sq.Eq) error {
+	if !uuidPattern.MatchString(id) {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	cond := sq.Eq{"org_id": r.org(), "invitation_id": id, "status": InvitationPending}
+	for column, value := range where {
+		cond[column] = value
+	}
+
+	res, err := r.sb.Update("invitations").
+		SetMap(set).
+		Where(cond).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func scanInvitation(row sq.RowScanner) (*Invitation, error) {
+	var (
+		i    Invitation
+		user sql.NullString
+	)
+
+	err := row.Scan(&i.ID, &i.OrgID, &i.Email, &i.FirstName, &i.LastName, &i.Status, &i.InvitedBy, &i.TokenHash, &user, &i.ExpiresAt, &i.SentAt, &i.CreatedAt, &i.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	i.UserID = user.String
+
+	return &i, nil
+}
+
+// MemoryInvitationRepository is an IInvitationRepository held in process
+// memory
+type MemoryInvitationRepository struct {
+	*memoryInvitations
+	tenant string
+}
+
+type memoryInvitations struct {
+	mu          sync.RWMutex
+	invitations map[string]*Invitation
+}
+
+func NewMemoryInvitationRepository() IInvitationRepository {
+	return &MemoryInvitationRepository{memoryInvitations: &memoryInvitations{invitations: make(map[string]*Invitation)}}
+}
+
+func (r *MemoryInvitationRepository) WithTenant(orgID string) IInvitationRepository {
+	return &MemoryInvitationRepository{memoryInvitations: r.memoryInvitations, tenant: orgID}
+}
+
+func (r *MemoryInvitationRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+// pending returns the pending invitation of the organization with id.
+// Callers hold the lock
+func (r *MemoryInvitationRepository) pending(id string) (*Invitation, error) {
+	i, ok := r.invitations[id]
+	if !ok || i.OrgID != r.org() || i.Status != InvitationPending {
+		return nil, sql.ErrNoRows
+	}
+
+	return i, nil
+}
+
+func (r *MemoryInvitationRepository) GetAll() ([]*Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var invitations []*Invitation
+	for _, i := range r.invitations {
+		if i.OrgID == r.org() {
+			c := *i
+			invitations = append(invitations, &c)
+		}
+	}
+
+	sort.Slice(invitations, func(a, b int) bool {
+		if !invitations[a].CreatedAt.Equal(invitations[b].CreatedAt) {
+			return invitations[a].CreatedAt.After(invitations[b].CreatedAt)
+		}
+		return invitations[a].ID < invitations[b].ID
+	})
+
+	return invitations, nil
+}
+
+func (r *MemoryInvitationRepository) GetOne(id string) (*Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, ok := r.invitations[id]
+	if !ok || i.OrgID != r.org() {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *i
+	return &c, nil
+}
+
+func (r *MemoryInvitationRepository) GetPending(email string) (*Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found *Invitation
+	for _, i := range r.invitations {
+		if i.OrgID == r.org() && i.Email == email && i.Status == InvitationPending {
+			if found == nil || i.CreatedAt.After(found.CreatedAt) {
+				found = i
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *found
+	return &c, nil
+}
+
+func (r *MemoryInvitationRepository) Insert(i Invitation) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	i.ID = id
+	i.OrgID = r.org()
+	i.Status = InvitationPending
+	i.TokenHash = ""
+	i.UserID = ""
+	i.ExpiresAt = now
+	i.SentAt = now
+	i.CreatedAt = now
+	i.UpdatedAt = now
+	r.invitations[id] = &i
+
+	return id, nil
+}
+
+func (r *MemoryInvitationRepository) Send(id, tokenHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.pending(id)
+	if err != nil {
+		return err
+	}
+
+	i.TokenHash = tokenHash
+	i.ExpiresAt = expiresAt
+	i.SentAt = time.Now()
+	i.UpdatedAt = i.SentAt
+
+	return nil
+}
+
+func (r *MemoryInvitationRepository) Revoke(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.pending(id)
+	if err != nil {
+		return err
+	}
+
+	i.Status = InvitationRevoked
+	i.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryInvitationRepository) Accept(id, tokenHash, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.pending(id)
+	if err != nil {
+		return err
+	}
+	if i.TokenHash != tokenHash {
+		return sql.ErrNoRows
+	}
+
+	i.Status = InvitationAccepted
+	i.UserID = userID
+	i.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryInvitationRepository) Anonymize(userID, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, i := range r.invitations {
+		if i.OrgID != r.org() || !((userID != "" && i.UserID == userID) || (email != "" && i.Email == email)) {
+			continue
+		}
+
+		if i.Status == InvitationPending {
+			i.Status = InvitationRevoked
+		}
+		i.Email = ErasedEmail(userID)
+		i.FirstName = ""
+		i.LastName = ""
+		i.TokenHash = ""
+		i.UpdatedAt = now
+	}
+
+	return nil
+}
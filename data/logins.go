@@ -0,0 +1,473 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Outcomes of login attempts
+const (
+	LoginSucceeded = "succeeded"
+	LoginFailed    = "failed"
+	// LoginThrottled attempts came too soon after failures, or while the
+	// account or IP was locked out, and were not checked
+	LoginThrottled = "throttled"
+	// LoginRefused attempts had the right credentials for a user whose
+	// status does not let them log in
+	LoginRefused = "refused"
+)
+
+// Attempts counts the recent failed logins of an account or an IP, named by
+// Key
+type Attempts struct {
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// Locked reports whether logins are locked out at now
+func (a *Attempts) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+type IAttemptRepository interface {
+	// Get returns the attempts of key, with no failures when there are none
+	Get(key string) (*Attempts, error)
+	// Fail records a failure at t and returns the updated attempts. The
+	// count starts over when the last failure is older than window
+	Fail(key string, t time.Time, window time.Duration) (*Attempts, error)
+	// Lock locks key out until t
+	Lock(key string, until time.Time) error
+	// Reset forgets the failures and lockout of key
+	Reset(key string) error
+}
+
+type AttemptRepository struct {
+	db *sql.DB
+	sb sq.StatementBuilderType
+}
+
+// NewAttemptRepositoryFor returns the failed login counters of a database of
+// the given dialect
+func NewAttemptRepositoryFor(pool *sql.DB, dialect Dialect) IAttemptRepository {
+	return &AttemptRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *AttemptRepository) Get(key string) (*Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.get(ctx, r.db, key)
+}
+
+func (r *AttemptRepository) get(ctx context.Context, runner sq.BaseRunner, key string) (*Attempts, error) {
+	var (
+		a      = Attempts{Key: key}
+		locked sql.NullTime
+	)
+
+	err := r.sb.Select("failures", "last_failed_at", "locked_until").
+		From("login_attempts").
+		Where(sq.Eq{"attempt_key": key}).
+		RunWith(runner).QueryRowContext(ctx).
+		Scan(&a.Failures, &a.LastFailedAt, &locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.LockedUntil = nullTime(locked)
+
+	return &a, nil
+}
+
+func (r *AttemptRepository) Fail(key string, t time.Time, window time.Duration) (*Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	a, err := r.get(ctx, tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := !a.LastFailedAt.IsZero()
+	a.count(t, window)
+
+	if exists {
+		_, err = r.sb.Update("login_attempts").
+			Set("failures", a.Failures).
+			Set("last_failed_at", a.LastFailedAt).
+			Where(sq.Eq{"attempt_key": key}).
+			RunWith(tx).ExecContext(ctx)
+	} else {
+		_, err = r.sb.Insert("login_attempts").
+			Columns("attempt_key", "failures", "last_failed_at").
+			Values(key, a.Failures, a.LastFailedAt).
+			RunWith(tx).ExecContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, tx.Commit()
+}
+
+// count adds a failure at t, starting over after a quiet window
+func (a *Attempts) count(t time.Time, window time.Duration) {
+	if t.Sub(a.LastFailedAt) > window {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailedAt = t
+}
+
+func (r *AttemptRepository) Lock(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Update("login_attempts").
+		Set("locked_until", until).
+		Where(sq.Eq{"attempt_key": key}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *AttemptRepository) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Delete("login_attempts").
+		Where(sq.Eq{"attempt_key": key}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+// MemoryAttemptRepository is an IAttemptRepository held in process memory
+type MemoryAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryAttemptRepository() IAttemptRepository {
+	return &MemoryAttemptRepository{attempts: make(map[string]Attempts)}
+}
+
+func (r *MemoryAttemptRepository) Get(key string) (*Attempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, ok := r.attempts[key]
+	if !ok {
+		a.Key = key
+	}
+
+	return &a, nil
+}
+
+func (r *MemoryAttemptRepository) Fail(key string, t time.Time, window time.Duration) (*Attempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a := r.attempts[key]
+	a.Key = key
+	a.count(t, window)
+	r.attempts[key] = a
+
+	return &a, nil
+}
+
+func (r *MemoryAttemptRepository) Lock(key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a, ok := r.attempts[key]; ok {
+		a.LockedUntil = &until
+		r.attempts[key] = a
+	}
+
+	return nil
+}
+
+func (r *MemoryAttemptRepository) Reset(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+
+	return nil
+}
+
+// LoginEvent is an attempt to log in. UserID is empty when the email named
+// no user
+type LoginEvent struct {
+	ID        string    `json:"event_id"`
+	OrgID     string    `json:"org_id,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Email     string    `json:"email"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ILoginEventRepository interface {
+	Insert(LoginEvent) error
+	// GetByUser returns the latest limit events of a user, newest first
+	GetByUser(userID string, limit int) ([]*LoginEvent, error)
+	// Devices returns the distinct user agents a user logged in from
+	Devices(userID string) ([]string, error)
+	// Anonymize blanks the email, IP and user agent of the events of a user
+	// and of those recording email, such as failures before they signed up.
+	// The outcomes and times are kept
+	Anonymize(userID, email string) error
+	// WithTenant returns a view of the events of one organization, which
+	// also receives the events it inserts
+	WithTenant(orgID string) ILoginEventRepository
+}
+
+type LoginEventRepository struct {
+	db     *sql.DB
+	sb     sq.StatementBuilderType
+	tenant string
+}
+
+// NewLoginEventRepositoryFor returns the login events of a database of the
+// given dialect
+func NewLoginEventRepositoryFor(pool *sql.DB, dialect Dialect) ILoginEventRepository {
+	return &LoginEventRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *LoginEventRepository) WithTenant(orgID string) ILoginEventRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+func (r *LoginEventRepository) Insert(e LoginEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	org := e.OrgID
+	if r.tenant != "" {
+		org = r.tenant
+	}
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
+	var userID interface{}
+	if e.UserID != "" {
+		userID = e.UserID
+	}
+
+	_, err = r.sb.Insert("login_events").
+		Columns("event_id", "org_id", "user_id", "email", "ip", "user_agent", "outcome", "created_at").
+		Values(id, org, userID, e.Email, e.IP, e.UserAgent, e.Outcome, time.Now()).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *LoginEventRepository) GetByUser(userID string, limit int) ([]*LoginEvent, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select("event_id", "org_id", "user_id", "email", "ip", "user_agent", "outcome", "created_at").
+		From("login_events").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("created_at DESC", "event_id").
+		Limit(uint64(limit))
+	rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*LoginEvent
+	for rows.Next() {
+		var (
+			e    LoginEvent
+			user sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &e.OrgID, &user, &e.Email, &e.IP, &e.UserAgent, &e.Outcome, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.UserID = user.String
+		events = append(events, &e)
+	}
+
+	return events, rows.Err()
+}
+
+func (r *LoginEventRepository) Devices(userID string) ([]string, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select("DISTINCT user_agent").
+		From("login_events").
+		Where(sq.Eq{"user_id": userID, "outcome": LoginSucceeded}).
+		OrderBy("user_agent")
+	rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agents []string
+	for rows.Next() {
+		var ua string
+		if err := rows.Scan(&ua); err != nil {
+			return nil, err
+		}
+		agents = append(agents, ua)
+	}
+
+	return agents, rows.Err()
+}
+
+func (r *LoginEventRepository) Anonymize(userID, email string) error {
+	var of sq.Or
+	if uuidPattern.MatchString(userID) {
+		of = append(of, sq.Eq{"user_id": userID})
+	}
+	if email != "" {
+		of = append(of, sq.Eq{"email": email})
+	}
+	if len(of) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Update("login_events").
+		SetMap(sq.Eq{"email": "", "ip": "", "user_agent": ""}).
+		Where(of)
+	_, err := scope(uq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+// MemoryLoginEventRepository is an ILoginEventRepository held in process
+// memory
+type MemoryLoginEventRepository struct {
+	*memoryLoginEvents
+	tenant string
+}
+
+type memoryLoginEvents struct {
+	mu     sync.RWMutex
+	events []*LoginEvent
+}
+
+func NewMemoryLoginEventRepository() ILoginEventRepository {
+	return &MemoryLoginEventRepository{memoryLoginEvents: &memoryLoginEvents{}}
+}
+
+func (r *MemoryLoginEventRepository) WithTenant(orgID string) ILoginEventRepository {
+	return &MemoryLoginEventRepository{memoryLoginEvents: r.memoryLoginEvents, tenant: orgID}
+}
+
+// visible reports whether the tenant may see e
+func (r *MemoryLoginEventRepository) visible(e *LoginEvent) bool {
+	return r.tenant == "" || e.OrgID == r.tenant
+}
+
+func (r *MemoryLoginEventRepository) Insert(e LoginEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	e.ID = id
+	if r.tenant != "" {
+		e.OrgID = r.tenant
+	}
+	if e.OrgID == "" {
+		e.OrgID = DefaultOrganizationID
+	}
+	e.CreatedAt = time.Now()
+	r.events = append(r.events, &e)
+
+	return nil
+}
+
+func (r *MemoryLoginEventRepository) GetByUser(userID string, limit int) ([]*LoginEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var events []*LoginEvent
+	for i := len(r.events) - 1; i >= 0 && len(events) < limit; i-- {
+		if e := r.events[i]; e.UserID == userID && r.visible(e) {
+			c := *e
+			events = append(events, &c)
+		}
+	}
+
+	return events, nil
+}
+
+func (r *MemoryLoginEventRepository) Devices(userID string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var agents []string
+	for _, e := range r.events {
+		if e.UserID == userID && e.Outcome == LoginSucceeded && r.visible(e) && !seen[e.UserAgent] {
+			seen[e.UserAgent] = true
+			agents = append(agents, e.UserAgent)
+		}
+	}
+	sort.Strings(agents)
+
+	return agents, nil
+}
+
+func (r *MemoryLoginEventRepository) Anonymize(userID, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.events {
+		if ((userID != "" && e.UserID == userID) || (email != "" && e.Email == email)) && r.visible(e) {
+			e.Email = ""
+			e.IP = ""
+			e.UserAgent = ""
+		}
+	}
+
+	return nil
+}
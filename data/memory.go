@@ -0,0 +1,348 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryRepository is an IRepository that keeps users in process memory.
+// It follows the semantics of Repository, so it can stand in for Postgres
+// during local development and in tests
+type MemoryRepository struct {
+	*memoryStore
+	// tenant scopes the repository to one organization when set
+	tenant string
+}
+
+// memoryStore holds the users of every tenant
+type memoryStore struct {
+	mu    sync.RWMutex
+	users map[string]*User
+	// emails maps each organization and email to the id of the user
+	// holding it
+	emails map[orgEmail]string
+	// history holds the status transitions of each user
+	history map[string][]StatusTransition
+}
+
+type orgEmail struct {
+	org, email string
+}
+
+func NewMemoryRepository() IRepository {
+	return &MemoryRepository{
+		memoryStore: &memoryStore{
+			users:   make(map[string]*User),
+			emails:  make(map[orgEmail]string),
+			history: make(map[string][]StatusTransition),
+		},
+	}
+}
+
+func (r *MemoryRepository) WithTenant(orgID string) IRepository {
+	return &MemoryRepository{memoryStore: r.memoryStore, tenant: orgID}
+}
+
+// org is the organization of the users this repository inserts, and of the
+// emails it looks up
+func (r *MemoryRepository) org() string {
+	if r.tenant == "" {
+		return DefaultOrganizationID
+	}
+
+	return r.tenant
+}
+
+// visible reports whether u belongs to the tenant, if there is one
+func (r *MemoryRepository) visible(u *User) bool {
+	return r.tenant == "" || u.OrgID == r.tenant
+}
+
+// get returns the visible user with this id. The caller must hold the lock
+func (r *MemoryRepository) get(id string) (*User, bool) {
+	u, ok := r.users[id]
+	if !ok || !r.visible(u) {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// GetAll returns a slice of all users, sorted by last name
+func (r *MemoryRepository) GetAll() ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []*User
+	for _, u := range r.sorted() {
+		user := *u
+		user.Password = ""
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
+
+// GetOne returns one user by id
+func (r *MemoryRepository) GetOne(id string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.get(id)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	user := *u
+	return &user, nil
+}
+
+// GetMany returns the users with the given ids, in the order of ids and
+// without passwords. The ids that match no user are returned as missing
+func (r *MemoryRepository) GetMany(ctx context.Context, ids []string) ([]*User, []string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := make(map[string]*User, len(ids))
+	for _, id := range ids {
+		if u, ok := r.get(id); ok {
+			user := *u
+			user.Password = ""
+			found[id] = &user
+		}
+	}
+
+	users, missing := inOrder(ids, found)
+	return users, missing, nil
+}
+
+// GetByEmail returns one user by email. Unscoped lookups are those of the
+// default organization
+func (r *MemoryRepository) GetByEmail(email string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.emails[orgEmail{r.org(), email}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	user := *r.users[id]
+	return &user, nil
+}
+
+// Update updates the email and names of the user with u's id
+func (r *MemoryRepository) Update(u User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.get(u.ID)
+	if !ok {
+		return nil
+	}
+
+	if id, taken := r.emails[orgEmail{stored.OrgID, u.Email}]; taken && id != u.ID {
+		return ErrDuplicateEmail
+	}
+
+	delete(r.emails, orgEmail{stored.OrgID, stored.Email})
+	r.emails[orgEmail{stored.OrgID, u.Email}] = u.ID
+
+	stored.Email = u.Email
+	stored.FirstName = u.FirstName
+	stored.LastName = u.LastName
+	stored.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// DeleteByID deletes one user, by ID
+func (r *MemoryRepository) DeleteByID(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u, ok := r.get(id); ok {
+		delete(r.emails, orgEmail{u.OrgID, u.Email})
+		delete(r.users, id)
+		delete(r.history, id)
+	}
+
+	return nil
+}
+
+// Anonymize overwrites the profile of one user with a tombstone
+func (r *MemoryRepository) Anonymize(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.get(id)
+	if !ok {
+		return nil
+	}
+
+	delete(r.emails, orgEmail{u.OrgID, u.Email})
+	u.Email = ErasedEmail(id)
+	r.emails[orgEmail{u.OrgID, u.Email}] = id
+
+	u.FirstName = ""
+	u.LastName = ""
+	u.Password = ""
+	u.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// Insert stores a new user and returns its generated ID
+func (r *MemoryRepository) Insert(u User) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tenant != "" || u.OrgID == "" {
+		u.OrgID = r.org()
+	}
+
+	if _, taken := r.emails[orgEmail{u.OrgID, u.Email}]; taken {
+		return "", ErrDuplicateEmail
+	}
+
+	return r.insert(u, hashedPassword, time.Now())
+}
+
+// InsertMany stores users and returns their ids keyed by email, skipping
+// users whose email already exists. When allOrNothing is set nothing is
+// stored if any email is taken and ErrBatchConflict is returned
+func (r *MemoryRepository) InsertMany(users []User, allOrNothing bool) (map[string]string, error) {
+	hashes, err := hashPasswords(users)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make(map[string]string, len(users))
+	var pending []int
+	for i, u := range users {
+		if _, taken := r.emails[orgEmail{r.org(), u.Email}]; taken {
+			continue
+		}
+
+		if _, dup := ids[u.Email]; dup {
+			continue
+		}
+
+		ids[u.Email] = ""
+		pending = append(pending, i)
+	}
+
+	if allOrNothing && len(pending) != len(users) {
+		return ids, ErrBatchConflict
+	}
+
+	now := time.Now()
+	for _, i := range pending {
+		u := users[i]
+		u.OrgID = r.org()
+
+		id, err := r.insert(u, hashes[i], now)
+		if err != nil {
+			return nil, err
+		}
+		ids[users[i].Email] = id
+	}
+
+	return ids, nil
+}
+
+// Stream calls fn for every user, sorted by last name. It stops at the
+// first error returned by fn
+func (r *MemoryRepository) Stream(fn func(*User) error) error {
+	users, err := r.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Search returns the users matching opts, ranked like Repository.Search
+func (r *MemoryRepository) Search(opts SearchOptions) ([]*SearchResult, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results, total := SearchUsers(r.sorted(), opts)
+
+	return results, total, nil
+}
+
+// insert stores u under a new id in its organization. The caller must hold
+// the write lock
+func (r *MemoryRepository) insert(u User, hashedPassword []byte, now time.Time) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	u.ID = id
+	u.Password = string(hashedPassword)
+	u.Status = u.statusOrPending()
+	u.CreatedAt = now
+	u.UpdatedAt = now
+
+	r.users[id] = &u
+	r.emails[orgEmail{u.OrgID, u.Email}] = id
+
+	return id, nil
+}
+
+// sorted returns the visible users ordered by last name. The caller must
+// hold the lock
+func (r *MemoryRepository) sorted() []*User {
+	users := make([]*User, 0, len(r.users))
+	for _, u := range r.users {
+		if r.visible(u) {
+			users = append(users, u)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].LastName != users[j].LastName {
+			return users[i].LastName < users[j].LastName
+		}
+
+		return users[i].ID < users[j].ID
+	})
+
+	return users
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"sort"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// Migrate applies every embedded migration of the dialect that has not been
+// recorded in the schema_migrations table yet, in file name order
+func Migrate(db *sql.DB, dialect Dialect) error {
+	ctx := context.Background()
+	sb := dialect.builder()
+
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(migrations, "migrations/"+string(dialect)+"/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(file[strings.LastIndex(file, "/")+1:], ".sql")
+
+		var applied int
+		err := sb.Select("COUNT(*)").
+			From("schema_migrations").
+			Where(sq.Eq{"version": version}).
+			RunWith(db).QueryRowContext(ctx).Scan(&applied)
+		if err != nil {
+			return err
+		}
+
+		if applied > 0 {
+			continue
+		}
+
+		stmt, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, string(stmt)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = sb.Insert("schema_migrations").
+			Columns("version").
+			Values(version).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
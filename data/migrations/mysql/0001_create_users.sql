@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id     CHAR(36)     PRIMARY KEY,
+	email       VARCHAR(255) NOT NULL UNIQUE,
+	first_name  VARCHAR(255) NOT NULL DEFAULT '',
+	last_name   VARCHAR(255) NOT NULL DEFAULT '',
+	password    VARCHAR(60)  NOT NULL,
+	user_active INTEGER      NOT NULL DEFAULT 0,
+	created_at  DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at  DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	INDEX users_last_name_idx (last_name)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	fingerprint     VARCHAR(64)  NOT NULL,
+	completed       BOOLEAN      NOT NULL DEFAULT FALSE,
+	status_code     INTEGER      NOT NULL DEFAULT 0,
+	content_type    VARCHAR(255) NOT NULL DEFAULT '',
+	body            LONGBLOB,
+	created_at      DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	expires_at      DATETIME(6)  NOT NULL,
+	INDEX idempotency_keys_expires_at_idx (expires_at)
+);
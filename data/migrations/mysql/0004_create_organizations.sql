@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS organizations (
+	org_id     CHAR(36)     PRIMARY KEY,
+	slug       VARCHAR(63)  NOT NULL UNIQUE,
+	name       VARCHAR(255) NOT NULL,
+	created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+INSERT IGNORE INTO organizations (org_id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 'Default');
+
+ALTER TABLE users
+	ADD COLUMN org_id CHAR(36) NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	ADD CONSTRAINT users_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id),
+	DROP INDEX email,
+	ADD UNIQUE INDEX users_org_id_email_idx (org_id, email);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	key_id       CHAR(36)     PRIMARY KEY,
+	org_id       CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	name         VARCHAR(255) NOT NULL,
+	prefix       VARCHAR(32)  NOT NULL UNIQUE,
+	key_hash     CHAR(64)     NOT NULL,
+	scopes       TEXT         NOT NULL,
+	expires_at   DATETIME(6)  NULL,
+	last_used_at DATETIME(6)  NULL,
+	revoked_at   DATETIME(6)  NULL,
+	created_at   DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	INDEX api_keys_org_id_idx (org_id),
+	CONSTRAINT api_keys_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
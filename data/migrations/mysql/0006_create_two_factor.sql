@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id      CHAR(36)    PRIMARY KEY,
+	secret       TEXT        NOT NULL,
+	confirmed_at DATETIME(6) NULL,
+	last_step    BIGINT      NOT NULL DEFAULT 0,
+	created_at   DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	CONSTRAINT user_totp_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+	user_id   CHAR(36)    NOT NULL,
+	code_hash CHAR(64)    NOT NULL,
+	used_at   DATETIME(6) NULL,
+	PRIMARY KEY (user_id, code_hash),
+	CONSTRAINT user_recovery_codes_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS identities (
+	identity_id CHAR(36)     PRIMARY KEY,
+	org_id      CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	user_id     CHAR(36)     NOT NULL,
+	provider    VARCHAR(63)  NOT NULL,
+	subject     VARCHAR(255) NOT NULL,
+	email       VARCHAR(255) NOT NULL DEFAULT '',
+	created_at  DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	UNIQUE INDEX identities_org_id_provider_subject_idx (org_id, provider, subject),
+	INDEX identities_user_id_idx (user_id),
+	CONSTRAINT identities_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id),
+	CONSTRAINT identities_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
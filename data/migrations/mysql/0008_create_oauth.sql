@@ -0,0 +1,53 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id     CHAR(36)     PRIMARY KEY,
+	org_id        CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	name          VARCHAR(255) NOT NULL,
+	secret_hash   CHAR(64)     NOT NULL DEFAULT '',
+	redirect_uris TEXT         NOT NULL,
+	grant_types   TEXT         NOT NULL,
+	scopes        TEXT         NOT NULL,
+	created_at    DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	INDEX oauth_clients_org_id_idx (org_id),
+	CONSTRAINT oauth_clients_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+	user_id    CHAR(36)    NOT NULL,
+	client_id  CHAR(36)    NOT NULL,
+	scopes     TEXT        NOT NULL,
+	created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	PRIMARY KEY (user_id, client_id),
+	CONSTRAINT oauth_consents_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	CONSTRAINT oauth_consents_client_id_fk FOREIGN KEY (client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+	code_hash      CHAR(64)    PRIMARY KEY,
+	client_id      CHAR(36)    NOT NULL,
+	user_id        CHAR(36)    NOT NULL,
+	redirect_uri   TEXT        NOT NULL,
+	scopes         TEXT        NOT NULL,
+	nonce          TEXT        NOT NULL,
+	code_challenge TEXT        NOT NULL,
+	expires_at     DATETIME(6) NOT NULL,
+	CONSTRAINT oauth_codes_client_id_fk FOREIGN KEY (client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	CONSTRAINT oauth_codes_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS oauth_refresh_tokens (
+	token_id   CHAR(36)    PRIMARY KEY,
+	family_id  CHAR(36)    NOT NULL,
+	token_hash CHAR(64)    NOT NULL,
+	client_id  CHAR(36)    NOT NULL,
+	user_id    CHAR(36)    NOT NULL,
+	scopes     TEXT        NOT NULL,
+	rotated_at DATETIME(6),
+	revoked_at DATETIME(6),
+	expires_at DATETIME(6) NOT NULL,
+	created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	UNIQUE INDEX oauth_refresh_tokens_token_hash_idx (token_hash),
+	INDEX oauth_refresh_tokens_family_id_idx (family_id),
+	CONSTRAINT oauth_refresh_tokens_client_id_fk FOREIGN KEY (client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	CONSTRAINT oauth_refresh_tokens_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id   CHAR(36)     PRIMARY KEY,
+	org_id       CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	user_id      CHAR(36)     NOT NULL,
+	device       VARCHAR(255) NOT NULL DEFAULT '',
+	ip           VARCHAR(45)  NOT NULL DEFAULT '',
+	user_agent   TEXT         NOT NULL,
+	created_at   DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	last_seen_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	expires_at   DATETIME(6)  NOT NULL,
+	revoked_at   DATETIME(6),
+	INDEX sessions_user_id_idx (user_id),
+	CONSTRAINT sessions_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id),
+	CONSTRAINT sessions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
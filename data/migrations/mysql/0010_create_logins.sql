@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+	attempt_key    VARCHAR(400) PRIMARY KEY,
+	failures       INT          NOT NULL DEFAULT 0,
+	last_failed_at DATETIME(6)  NOT NULL,
+	locked_until   DATETIME(6)
+);
+
+CREATE TABLE IF NOT EXISTS login_events (
+	event_id   CHAR(36)     PRIMARY KEY,
+	org_id     CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	user_id    CHAR(36),
+	email      VARCHAR(320) NOT NULL DEFAULT '',
+	ip         VARCHAR(45)  NOT NULL DEFAULT '',
+	user_agent TEXT         NOT NULL,
+	outcome    VARCHAR(16)  NOT NULL,
+	created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	INDEX login_events_user_id_idx (user_id, created_at),
+	CONSTRAINT login_events_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN user_status VARCHAR(16) NOT NULL DEFAULT 'pending';
+
+UPDATE users SET user_status = CASE WHEN user_active = 1 THEN 'active' ELSE 'pending' END;
+
+ALTER TABLE users DROP COLUMN user_active;
+
+CREATE TABLE IF NOT EXISTS user_status_transitions (
+	transition_id CHAR(36)     PRIMARY KEY,
+	user_id       CHAR(36)     NOT NULL,
+	from_status   VARCHAR(16)  NOT NULL,
+	to_status     VARCHAR(16)  NOT NULL,
+	reason        VARCHAR(500) NOT NULL DEFAULT '',
+	actor         VARCHAR(100) NOT NULL DEFAULT '',
+	created_at    DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	INDEX user_status_transitions_user_id_idx (user_id, created_at),
+	CONSTRAINT user_status_transitions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
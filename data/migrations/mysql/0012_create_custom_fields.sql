@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS custom_fields (
+	field_id   CHAR(36)     PRIMARY KEY,
+	org_id     CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	name       VARCHAR(63)  NOT NULL,
+	label      VARCHAR(255) NOT NULL DEFAULT '',
+	field_type VARCHAR(16)  NOT NULL,
+	required   BOOLEAN      NOT NULL DEFAULT FALSE,
+	options    TEXT         NOT NULL,
+	max_length INT,
+	min_value  DOUBLE,
+	max_value  DOUBLE,
+	created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	UNIQUE KEY custom_fields_org_id_name_key (org_id, name),
+	CONSTRAINT custom_fields_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_attributes (
+	user_id CHAR(36)    NOT NULL,
+	org_id  CHAR(36)    NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	name    VARCHAR(63) NOT NULL,
+	value   TEXT        NOT NULL,
+	PRIMARY KEY (user_id, name),
+	INDEX user_attributes_name_value_idx (org_id, name, value(255)),
+	CONSTRAINT user_attributes_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	CONSTRAINT user_attributes_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_avatars (
+	user_id      CHAR(36)    PRIMARY KEY,
+	org_id       CHAR(36)    NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	version      VARCHAR(64) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	created_at   DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	CONSTRAINT user_avatars_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	CONSTRAINT user_avatars_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
@@ -0,0 +1,24 @@
+-- GROUPS is a reserved word since MySQL 8.0.2
+CREATE TABLE IF NOT EXISTS `groups` (
+	group_id    CHAR(36)     PRIMARY KEY,
+	org_id      CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	name        VARCHAR(255) NOT NULL,
+	description TEXT         NOT NULL,
+	scopes      TEXT         NOT NULL,
+	created_at  DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at  DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	UNIQUE KEY groups_org_id_name_key (org_id, name),
+	CONSTRAINT groups_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id   CHAR(36)    NOT NULL,
+	user_id    CHAR(36)    NOT NULL,
+	org_id     CHAR(36)    NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	PRIMARY KEY (group_id, user_id),
+	INDEX group_members_user_id_idx (user_id),
+	CONSTRAINT group_members_group_id_fk FOREIGN KEY (group_id) REFERENCES `groups` (group_id) ON DELETE CASCADE,
+	CONSTRAINT group_members_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	CONSTRAINT group_members_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id)
+);
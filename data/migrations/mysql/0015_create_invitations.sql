@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS invitations (
+	invitation_id CHAR(36)     PRIMARY KEY,
+	org_id        CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	email         VARCHAR(255) NOT NULL,
+	first_name    VARCHAR(255) NOT NULL DEFAULT '',
+	last_name     VARCHAR(255) NOT NULL DEFAULT '',
+	status        VARCHAR(16)  NOT NULL DEFAULT 'pending',
+	invited_by    VARCHAR(64)  NOT NULL DEFAULT '',
+	token_hash    CHAR(64)     NOT NULL DEFAULT '',
+	user_id       CHAR(36),
+	expires_at    DATETIME(6)  NOT NULL,
+	sent_at       DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	created_at    DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at    DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	INDEX invitations_org_id_email_idx (org_id, email),
+	CONSTRAINT invitations_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id),
+	CONSTRAINT invitations_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE SET NULL
+);
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS privacy_jobs (
+	job_id       CHAR(36)     PRIMARY KEY,
+	org_id       CHAR(36)     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+	user_id      CHAR(36)     NOT NULL,
+	kind         VARCHAR(16)  NOT NULL,
+	status       VARCHAR(16)  NOT NULL DEFAULT 'running',
+	actor        VARCHAR(100) NOT NULL DEFAULT '',
+	error        VARCHAR(500) NOT NULL DEFAULT '',
+	created_at   DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at   DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	completed_at DATETIME(6),
+	INDEX privacy_jobs_user_id_idx (user_id, created_at),
+	CONSTRAINT privacy_jobs_org_id_fk FOREIGN KEY (org_id) REFERENCES organizations (org_id),
+	CONSTRAINT privacy_jobs_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+-- the output of an export step holds a section of the archive, which may
+-- outgrow TEXT
+CREATE TABLE IF NOT EXISTS privacy_job_steps (
+	job_id       CHAR(36)    NOT NULL,
+	step         VARCHAR(32) NOT NULL,
+	output       LONGTEXT    NOT NULL,
+	completed_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	PRIMARY KEY (job_id, step),
+	CONSTRAINT privacy_job_steps_job_id_fk FOREIGN KEY (job_id) REFERENCES privacy_jobs (job_id) ON DELETE CASCADE
+);
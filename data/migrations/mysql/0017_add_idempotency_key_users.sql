@@ -0,0 +1,4 @@
+ALTER TABLE idempotency_keys
+	ADD COLUMN user_id CHAR(36),
+	ADD INDEX idempotency_keys_user_id_idx (user_id),
+	ADD CONSTRAINT idempotency_keys_user_id_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	email       VARCHAR(255) NOT NULL UNIQUE,
+	first_name  VARCHAR(255) NOT NULL DEFAULT '',
+	last_name   VARCHAR(255) NOT NULL DEFAULT '',
+	password    VARCHAR(60)  NOT NULL,
+	user_active INTEGER      NOT NULL DEFAULT 0,
+	created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
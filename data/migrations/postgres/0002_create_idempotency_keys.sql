@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	fingerprint     VARCHAR(64)  NOT NULL,
+	completed       BOOLEAN      NOT NULL DEFAULT FALSE,
+	status_code     INTEGER      NOT NULL DEFAULT 0,
+	content_type    VARCHAR(255) NOT NULL DEFAULT '',
+	body            BYTEA,
+	created_at      TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	expires_at      TIMESTAMPTZ  NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
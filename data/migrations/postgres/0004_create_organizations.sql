@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS organizations (
+	org_id     UUID         PRIMARY KEY DEFAULT gen_random_uuid(),
+	slug       VARCHAR(63)  NOT NULL UNIQUE,
+	name       VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+INSERT INTO organizations (org_id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 'Default')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users
+	ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id);
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_org_id_email_key UNIQUE (org_id, email);
+
+-- rows are only visible to the tenant named by app.org_id, or to everyone
+-- when it is unset, which keeps single tenant deployments working
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+
+CREATE POLICY users_tenant_isolation ON users
+	USING (coalesce(current_setting('app.org_id', true), '') = '' OR org_id::text = current_setting('app.org_id', true))
+	WITH CHECK (coalesce(current_setting('app.org_id', true), '') = '' OR org_id::text = current_setting('app.org_id', true));
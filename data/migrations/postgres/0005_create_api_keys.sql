@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	key_id       UUID         PRIMARY KEY,
+	org_id       UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name         VARCHAR(255) NOT NULL,
+	prefix       VARCHAR(32)  NOT NULL UNIQUE,
+	key_hash     CHAR(64)     NOT NULL,
+	scopes       TEXT         NOT NULL DEFAULT '',
+	expires_at   TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	revoked_at   TIMESTAMPTZ,
+	created_at   TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_org_id_idx ON api_keys (org_id);
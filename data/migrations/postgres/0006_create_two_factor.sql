@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id      UUID        PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+	secret       TEXT        NOT NULL,
+	confirmed_at TIMESTAMPTZ,
+	last_step    BIGINT      NOT NULL DEFAULT 0,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+	user_id   UUID        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	code_hash CHAR(64)    NOT NULL,
+	used_at   TIMESTAMPTZ,
+	PRIMARY KEY (user_id, code_hash)
+);
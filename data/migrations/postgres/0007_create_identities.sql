@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS identities (
+	identity_id UUID         PRIMARY KEY,
+	org_id      UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id     UUID         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	provider    VARCHAR(63)  NOT NULL,
+	subject     VARCHAR(255) NOT NULL,
+	email       VARCHAR(255) NOT NULL DEFAULT '',
+	created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	UNIQUE (org_id, provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS identities_user_id_idx ON identities (user_id);
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id     UUID         PRIMARY KEY,
+	org_id        UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name          VARCHAR(255) NOT NULL,
+	secret_hash   CHAR(64)     NOT NULL DEFAULT '',
+	redirect_uris TEXT         NOT NULL DEFAULT '',
+	grant_types   TEXT         NOT NULL,
+	scopes        TEXT         NOT NULL DEFAULT '',
+	created_at    TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS oauth_clients_org_id_idx ON oauth_clients (org_id);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+	user_id    UUID        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	client_id  UUID        NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	scopes     TEXT        NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+	code_hash      CHAR(64)    PRIMARY KEY,
+	client_id      UUID        NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	user_id        UUID        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	redirect_uri   TEXT        NOT NULL,
+	scopes         TEXT        NOT NULL,
+	nonce          TEXT        NOT NULL DEFAULT '',
+	code_challenge TEXT        NOT NULL DEFAULT '',
+	expires_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_refresh_tokens (
+	token_id   UUID        PRIMARY KEY,
+	family_id  UUID        NOT NULL,
+	token_hash CHAR(64)    NOT NULL UNIQUE,
+	client_id  UUID        NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	user_id    UUID        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	scopes     TEXT        NOT NULL,
+	rotated_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_family_id_idx ON oauth_refresh_tokens (family_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id   UUID         PRIMARY KEY,
+	org_id       UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id      UUID         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	device       VARCHAR(255) NOT NULL DEFAULT '',
+	ip           VARCHAR(45)  NOT NULL DEFAULT '',
+	user_agent   TEXT         NOT NULL DEFAULT '',
+	created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	last_seen_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	expires_at   TIMESTAMPTZ  NOT NULL,
+	revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+	attempt_key    VARCHAR(400) PRIMARY KEY,
+	failures       INTEGER      NOT NULL DEFAULT 0,
+	last_failed_at TIMESTAMPTZ  NOT NULL,
+	locked_until   TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS login_events (
+	event_id   UUID         PRIMARY KEY,
+	org_id     UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id    UUID,
+	email      VARCHAR(320) NOT NULL DEFAULT '',
+	ip         VARCHAR(45)  NOT NULL DEFAULT '',
+	user_agent TEXT         NOT NULL DEFAULT '',
+	outcome    VARCHAR(16)  NOT NULL,
+	created_at TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, created_at);
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_status VARCHAR(16) NOT NULL DEFAULT 'pending';
+
+UPDATE users SET user_status = CASE WHEN user_active = 1 THEN 'active' ELSE 'pending' END;
+
+ALTER TABLE users DROP COLUMN IF EXISTS user_active;
+
+CREATE TABLE IF NOT EXISTS user_status_transitions (
+	transition_id UUID         PRIMARY KEY,
+	user_id       UUID         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	from_status   VARCHAR(16)  NOT NULL,
+	to_status     VARCHAR(16)  NOT NULL,
+	reason        VARCHAR(500) NOT NULL DEFAULT '',
+	actor         VARCHAR(100) NOT NULL DEFAULT '',
+	created_at    TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_status_transitions_user_id_idx ON user_status_transitions (user_id, created_at);
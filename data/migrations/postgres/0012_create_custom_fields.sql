@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS custom_fields (
+	field_id   UUID             PRIMARY KEY,
+	org_id     UUID             NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name       VARCHAR(63)      NOT NULL,
+	label      VARCHAR(255)     NOT NULL DEFAULT '',
+	field_type VARCHAR(16)      NOT NULL,
+	required   BOOLEAN          NOT NULL DEFAULT FALSE,
+	options    TEXT             NOT NULL DEFAULT 'null',
+	max_length INTEGER,
+	min_value  DOUBLE PRECISION,
+	max_value  DOUBLE PRECISION,
+	created_at TIMESTAMPTZ      NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ      NOT NULL DEFAULT now(),
+	UNIQUE (org_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS user_attributes (
+	user_id UUID        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	org_id  UUID        NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name    VARCHAR(63) NOT NULL,
+	value   TEXT        NOT NULL,
+	PRIMARY KEY (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS user_attributes_name_value_idx ON user_attributes (org_id, name, value);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_avatars (
+	user_id      UUID        PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+	org_id       UUID        NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	version      VARCHAR(64) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS groups (
+	group_id    UUID         PRIMARY KEY,
+	org_id      UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name        VARCHAR(255) NOT NULL,
+	description TEXT         NOT NULL DEFAULT '',
+	scopes      TEXT         NOT NULL DEFAULT '',
+	created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	UNIQUE (org_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id   UUID        NOT NULL REFERENCES groups (group_id) ON DELETE CASCADE,
+	user_id    UUID        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	org_id     UUID        NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_id_idx ON group_members (user_id);
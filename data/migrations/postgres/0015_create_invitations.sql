@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS invitations (
+	invitation_id UUID         PRIMARY KEY,
+	org_id        UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	email         VARCHAR(255) NOT NULL,
+	first_name    VARCHAR(255) NOT NULL DEFAULT '',
+	last_name     VARCHAR(255) NOT NULL DEFAULT '',
+	status        VARCHAR(16)  NOT NULL DEFAULT 'pending',
+	invited_by    VARCHAR(64)  NOT NULL DEFAULT '',
+	token_hash    CHAR(64)     NOT NULL DEFAULT '',
+	user_id       UUID         REFERENCES users (user_id) ON DELETE SET NULL,
+	expires_at    TIMESTAMPTZ  NOT NULL,
+	sent_at       TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	created_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	updated_at    TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS invitations_org_id_email_idx ON invitations (org_id, email);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS privacy_jobs (
+	job_id       UUID         PRIMARY KEY,
+	org_id       UUID         NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id      UUID         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	kind         VARCHAR(16)  NOT NULL,
+	status       VARCHAR(16)  NOT NULL DEFAULT 'running',
+	actor        VARCHAR(100) NOT NULL DEFAULT '',
+	error        VARCHAR(500) NOT NULL DEFAULT '',
+	created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+	completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS privacy_jobs_user_id_idx ON privacy_jobs (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS privacy_job_steps (
+	job_id       UUID        NOT NULL REFERENCES privacy_jobs (job_id) ON DELETE CASCADE,
+	step         VARCHAR(32) NOT NULL,
+	output       TEXT        NOT NULL DEFAULT '',
+	completed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (job_id, step)
+);
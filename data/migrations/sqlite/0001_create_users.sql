@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id     TEXT     PRIMARY KEY,
+	email       TEXT     NOT NULL UNIQUE,
+	first_name  TEXT     NOT NULL DEFAULT '',
+	last_name   TEXT     NOT NULL DEFAULT '',
+	password    TEXT     NOT NULL,
+	user_active INTEGER  NOT NULL DEFAULT 0,
+	created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
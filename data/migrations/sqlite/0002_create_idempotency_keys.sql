@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key TEXT     PRIMARY KEY,
+	fingerprint     TEXT     NOT NULL,
+	completed       BOOLEAN  NOT NULL DEFAULT FALSE,
+	status_code     INTEGER  NOT NULL DEFAULT 0,
+	content_type    TEXT     NOT NULL DEFAULT '',
+	body            BLOB,
+	created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at      DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS organizations (
+	org_id     TEXT     PRIMARY KEY,
+	slug       TEXT     NOT NULL UNIQUE,
+	name       TEXT     NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO organizations (org_id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 'Default');
+
+-- SQLite cannot drop the unique constraint on email, so the table is rebuilt
+CREATE TABLE users_new (
+	user_id     TEXT     PRIMARY KEY,
+	org_id      TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	email       TEXT     NOT NULL,
+	first_name  TEXT     NOT NULL DEFAULT '',
+	last_name   TEXT     NOT NULL DEFAULT '',
+	password    TEXT     NOT NULL,
+	user_active INTEGER  NOT NULL DEFAULT 0,
+	created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (org_id, email)
+);
+
+INSERT INTO users_new (user_id, email, first_name, last_name, password, user_active, created_at, updated_at)
+SELECT user_id, email, first_name, last_name, password, user_active, created_at, updated_at FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	key_id       TEXT     PRIMARY KEY,
+	org_id       TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name         TEXT     NOT NULL,
+	prefix       TEXT     NOT NULL UNIQUE,
+	key_hash     TEXT     NOT NULL,
+	scopes       TEXT     NOT NULL DEFAULT '',
+	expires_at   DATETIME,
+	last_used_at DATETIME,
+	revoked_at   DATETIME,
+	created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_org_id_idx ON api_keys (org_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id      TEXT     PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+	secret       TEXT     NOT NULL,
+	confirmed_at DATETIME,
+	last_step    INTEGER  NOT NULL DEFAULT 0,
+	created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+	user_id   TEXT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at   DATETIME,
+	PRIMARY KEY (user_id, code_hash)
+);
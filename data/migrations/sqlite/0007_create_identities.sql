@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS identities (
+	identity_id TEXT     PRIMARY KEY,
+	org_id      TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id     TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	provider    TEXT     NOT NULL,
+	subject     TEXT     NOT NULL,
+	email       TEXT     NOT NULL DEFAULT '',
+	created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (org_id, provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS identities_user_id_idx ON identities (user_id);
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id     TEXT     PRIMARY KEY,
+	org_id        TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name          TEXT     NOT NULL,
+	secret_hash   TEXT     NOT NULL DEFAULT '',
+	redirect_uris TEXT     NOT NULL DEFAULT '',
+	grant_types   TEXT     NOT NULL,
+	scopes        TEXT     NOT NULL DEFAULT '',
+	created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS oauth_clients_org_id_idx ON oauth_clients (org_id);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+	user_id    TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	client_id  TEXT     NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	scopes     TEXT     NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+	code_hash      TEXT     PRIMARY KEY,
+	client_id      TEXT     NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	user_id        TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	redirect_uri   TEXT     NOT NULL,
+	scopes         TEXT     NOT NULL,
+	nonce          TEXT     NOT NULL DEFAULT '',
+	code_challenge TEXT     NOT NULL DEFAULT '',
+	expires_at     DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_refresh_tokens (
+	token_id   TEXT     PRIMARY KEY,
+	family_id  TEXT     NOT NULL,
+	token_hash TEXT     NOT NULL UNIQUE,
+	client_id  TEXT     NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	user_id    TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	scopes     TEXT     NOT NULL,
+	rotated_at DATETIME,
+	revoked_at DATETIME,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_family_id_idx ON oauth_refresh_tokens (family_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id   TEXT     PRIMARY KEY,
+	org_id       TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id      TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	device       TEXT     NOT NULL DEFAULT '',
+	ip           TEXT     NOT NULL DEFAULT '',
+	user_agent   TEXT     NOT NULL DEFAULT '',
+	created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at   DATETIME NOT NULL,
+	revoked_at   DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+	attempt_key    TEXT     PRIMARY KEY,
+	failures       INTEGER  NOT NULL DEFAULT 0,
+	last_failed_at DATETIME NOT NULL,
+	locked_until   DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS login_events (
+	event_id   TEXT     PRIMARY KEY,
+	org_id     TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id    TEXT,
+	email      TEXT     NOT NULL DEFAULT '',
+	ip         TEXT     NOT NULL DEFAULT '',
+	user_agent TEXT     NOT NULL DEFAULT '',
+	outcome    TEXT     NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, created_at);
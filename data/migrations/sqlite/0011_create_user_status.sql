@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN user_status TEXT NOT NULL DEFAULT 'pending';
+
+UPDATE users SET user_status = CASE WHEN user_active = 1 THEN 'active' ELSE 'pending' END;
+
+ALTER TABLE users DROP COLUMN user_active;
+
+CREATE TABLE IF NOT EXISTS user_status_transitions (
+	transition_id TEXT     PRIMARY KEY,
+	user_id       TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	from_status   TEXT     NOT NULL,
+	to_status     TEXT     NOT NULL,
+	reason        TEXT     NOT NULL DEFAULT '',
+	actor         TEXT     NOT NULL DEFAULT '',
+	created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_status_transitions_user_id_idx ON user_status_transitions (user_id, created_at);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS custom_fields (
+	field_id   TEXT     PRIMARY KEY,
+	org_id     TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name       TEXT     NOT NULL,
+	label      TEXT     NOT NULL DEFAULT '',
+	field_type TEXT     NOT NULL,
+	required   BOOLEAN  NOT NULL DEFAULT FALSE,
+	options    TEXT     NOT NULL DEFAULT 'null',
+	max_length INTEGER,
+	min_value  REAL,
+	max_value  REAL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (org_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS user_attributes (
+	user_id TEXT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	org_id  TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name    TEXT NOT NULL,
+	value   TEXT NOT NULL,
+	PRIMARY KEY (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS user_attributes_name_value_idx ON user_attributes (org_id, name, value);
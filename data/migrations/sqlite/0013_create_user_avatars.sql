@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_avatars (
+	user_id      TEXT     PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+	org_id       TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	version      TEXT     NOT NULL,
+	content_type TEXT     NOT NULL,
+	created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
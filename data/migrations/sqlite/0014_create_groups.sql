@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS groups (
+	group_id    TEXT     PRIMARY KEY,
+	org_id      TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	name        TEXT     NOT NULL,
+	description TEXT     NOT NULL DEFAULT '',
+	scopes      TEXT     NOT NULL DEFAULT '',
+	created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (org_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id   TEXT     NOT NULL REFERENCES groups (group_id) ON DELETE CASCADE,
+	user_id    TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	org_id     TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_id_idx ON group_members (user_id);
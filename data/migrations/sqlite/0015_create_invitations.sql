@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS invitations (
+	invitation_id TEXT     PRIMARY KEY,
+	org_id        TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	email         TEXT     NOT NULL,
+	first_name    TEXT     NOT NULL DEFAULT '',
+	last_name     TEXT     NOT NULL DEFAULT '',
+	status        TEXT     NOT NULL DEFAULT 'pending',
+	invited_by    TEXT     NOT NULL DEFAULT '',
+	token_hash    TEXT     NOT NULL DEFAULT '',
+	user_id       TEXT     REFERENCES users (user_id) ON DELETE SET NULL,
+	expires_at    DATETIME NOT NULL,
+	sent_at       DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS invitations_org_id_email_idx ON invitations (org_id, email);
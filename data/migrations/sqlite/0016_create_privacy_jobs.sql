@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS privacy_jobs (
+	job_id       TEXT     PRIMARY KEY,
+	org_id       TEXT     NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations (org_id),
+	user_id      TEXT     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+	kind         TEXT     NOT NULL,
+	status       TEXT     NOT NULL DEFAULT 'running',
+	actor        TEXT     NOT NULL DEFAULT '',
+	error        TEXT     NOT NULL DEFAULT '',
+	created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	completed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS privacy_jobs_user_id_idx ON privacy_jobs (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS privacy_job_steps (
+	job_id       TEXT     NOT NULL REFERENCES privacy_jobs (job_id) ON DELETE CASCADE,
+	step         TEXT     NOT NULL,
+	output       TEXT     NOT NULL DEFAULT '',
+	completed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (job_id, step)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE idempotency_keys ADD COLUMN user_id TEXT REFERENCES users (user_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_user_id_idx ON idempotency_keys (user_id);
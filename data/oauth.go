@@ -0,0 +1,1143 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Grant types an OAuth client may use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+const (
+	maxOAuthClientScopes = 32
+	oauthTokenBytes      = 32
+)
+
+var grantTypes = map[string]bool{GrantAuthorizationCode: true, GrantClientCredentials: true, GrantRefreshToken: true}
+
+// OAuthClient is an application that signs users in through this service,
+// or calls other services on its own behalf, as an OAuth2 client
+type OAuthClient struct {
+	ID    string `json:"client_id"`
+	OrgID string `json:"org_id,omitempty"`
+	Name  string `json:"name"`
+	// SecretHash is the hash of the secret of a confidential client. Public
+	// clients, such as single-page and mobile apps, have none and must use
+	// PKCE
+	SecretHash   string    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Public reports whether the client has no secret
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// VerifySecret reports whether secret is the secret of a confidential client
+func (c *OAuthClient) VerifySecret(secret string) bool {
+	return !c.Public() && subtle.ConstantTimeCompare([]byte(HashOAuthToken(secret)), []byte(c.SecretHash)) == 1
+}
+
+// AllowsGrant reports whether the client was registered for a grant type
+func (c *OAuthClient) AllowsGrant(grant string) bool {
+	return contains(c.GrantTypes, grant)
+}
+
+// AllowsRedirect reports whether uri is one of the registered redirect URIs.
+// URIs are compared exactly, as OAuth 2.1 requires
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsScopes reports whether the client may ask for every one of scopes
+func (c *OAuthClient) AllowsScopes(scopes []string) bool {
+	for _, s := range scopes {
+		if !contains(c.Scopes, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type IOAuthClientRepository interface {
+	// GetAll returns the clients, sorted by name
+	GetAll() ([]*OAuthClient, error)
+	GetOne(id string) (*OAuthClient, error)
+	Insert(OAuthClient) (string, error)
+	DeleteByID(id string) error
+	// WithTenant returns a view of the clients of one organization, which
+	// also receives the clients it inserts
+	WithTenant(orgID string) IOAuthClientRepository
+}
+
+// NewOAuthToken generates a random token, such as a client secret, an
+// authorization code or a refresh token, and returns it along with the hash
+// to store
+func NewOAuthToken() (token, hash string, err error) {
+	b := make([]byte, oauthTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	return token, HashOAuthToken(token), nil
+}
+
+// HashOAuthToken hashes a token for storage. Tokens carry 256 random bits,
+// so a fast hash is enough to keep them from being recovered
+func HashOAuthToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateOAuthClient checks the fields of a client submitted for
+// registration
+func ValidateOAuthClient(c OAuthClient) []FieldError {
+	var errs []FieldError
+
+	if c.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	} else if len(c.Name) > maxNameLength {
+		errs = append(errs, FieldError{Field: "name", Message: "must be at most 255 characters"})
+	}
+
+	if len(c.GrantTypes) == 0 {
+		errs = append(errs, FieldError{Field: "grant_types", Message: "is required"})
+	}
+	for _, g := range c.GrantTypes {
+		if !grantTypes[g] {
+			errs = append(errs, FieldError{Field: "grant_types", Message: "has unknown grant type " + g})
+			break
+		}
+	}
+
+	if c.Public() && contains(c.GrantTypes, GrantClientCredentials) {
+		errs = append(errs, FieldError{Field: "grant_types", Message: "client_credentials needs a confidential client"})
+	}
+
+	if contains(c.GrantTypes, GrantAuthorizationCode) && len(c.RedirectURIs) == 0 {
+		errs = append(errs, FieldError{Field: "redirect_uris", Message: "is required for authorization_code"})
+	}
+	for _, uri := range c.RedirectURIs {
+		if !validRedirectURI(uri) {
+			errs = append(errs, FieldError{Field: "redirect_uris", Message: "must be https URLs without fragments, or http on localhost"})
+			break
+		}
+	}
+
+	if len(c.Scopes) > maxOAuthClientScopes {
+		errs = append(errs, FieldError{Field: "scopes", Message: "must list at most 32 scopes"})
+	}
+	for _, s := range c.Scopes {
+		if s == "" || strings.ContainsAny(s, " \t\n\"\\") {
+			errs = append(errs, FieldError{Field: "scopes", Message: "must not be empty or contain spaces, quotes or backslashes"})
+			break
+		}
+	}
+
+	return errs
+}
+
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+
+	return false
+}
+
+type OAuthClientRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	tenant  string
+}
+
+// NewOAuthClientRepositoryFor returns the OAuth client store for a database
+// of the given dialect
+func NewOAuthClientRepositoryFor(pool *sql.DB, dialect Dialect) IOAuthClientRepository {
+	return &OAuthClientRepository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+func (r *OAuthClientRepository) WithTenant(orgID string) IOAuthClientRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+const oauthClientColumns = "client_id, org_id, name, secret_hash, redirect_uris, grant_types, scopes, created_at"
+
+func (r *OAuthClientRepository) GetAll() ([]*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(oauthClientColumns).
+		From("oauth_clients").
+		OrderBy("name", "client_id")
+	rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*OAuthClient
+	for rows.Next() {
+		c, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
+
+func (r *OAuthClientRepository) GetOne(id string) (*OAuthClient, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(oauthClientColumns).
+		From("oauth_clients").
+		Where(sq.Eq{"client_id": id})
+
+	return scanOAuthClient(scope(uq, r.tenant).RunWith(r.db).QueryRowContext(ctx))
+}
+
+// Insert registers a client under a generated id and returns it
+func (r *OAuthClientRepository) Insert(c OAuthClient) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	org := c.OrgID
+	if r.tenant != "" {
+		org = r.tenant
+	}
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
+	_, err = r.sb.Insert("oauth_clients").
+		Columns("client_id", "org_id", "name", "secret_hash", "redirect_uris", "grant_types", "scopes", "created_at").
+		Values(id, org, c.Name, c.SecretHash, strings.Join(c.RedirectURIs, " "), strings.Join(c.GrantTypes, " "), strings.Join(c.Scopes, " "), time.Now()).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// DeleteByID removes a client along with its consents, codes and tokens
+func (r *OAuthClientRepository) DeleteByID(id string) error {
+	if !uuidPattern.MatchString(id) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	uq := r.sb.Select("COUNT(*)").
+		From("oauth_clients").
+		Where(sq.Eq{"client_id": id})
+
+	var n int
+	if err := scope(uq, r.tenant).RunWith(tx).QueryRowContext(ctx).Scan(&n); err != nil || n == 0 {
+		return err
+	}
+
+	for _, table := range []string{"oauth_consents", "oauth_codes", "oauth_refresh_tokens", "oauth_clients"} {
+		_, err := r.sb.Delete(table).
+			Where(sq.Eq{"client_id": id}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanOAuthClient(row sq.RowScanner) (*OAuthClient, error) {
+	var (
+		c                            OAuthClient
+		redirectURIs, grants, scopes string
+	)
+
+	err := row.Scan(&c.ID, &c.OrgID, &c.Name, &c.SecretHash, &redirectURIs, &grants, &scopes, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	c.GrantTypes = strings.Fields(grants)
+	c.Scopes = strings.Fields(scopes)
+
+	return &c, nil
+}
+
+// Consent records the scopes a user granted a client, so that they are not
+// asked again
+type Consent struct {
+	UserID    string
+	ClientID  string
+	Scopes    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Covers reports whether the consent grants every one of scopes
+func (c *Consent) Covers(scopes []string) bool {
+	for _, s := range scopes {
+		if !contains(c.Scopes, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type IConsentRepository interface {
+	Get(userID, clientID string) (*Consent, error)
+	// GetByUser returns the consents of a user, sorted by client
+	GetByUser(userID string) ([]*Consent, error)
+	// Save grants scopes to a client on behalf of a user, in addition to
+	// those granted before
+	Save(userID, clientID string, scopes []string) error
+	Delete(userID, clientID string) error
+	DeleteByUser(userID string) error
+}
+
+type ConsentRepository struct {
+	db *sql.DB
+	sb sq.StatementBuilderType
+}
+
+// NewConsentRepositoryFor returns the consent store for a database of the
+// given dialect
+func NewConsentRepositoryFor(pool *sql.DB, dialect Dialect) IConsentRepository {
+	return &ConsentRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *ConsentRepository) Get(userID, clientID string) (*Consent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.get(ctx, r.db, userID, clientID)
+}
+
+func (r *ConsentRepository) GetByUser(userID string) ([]*Consent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select("user_id, client_id, scopes, created_at, updated_at").
+		From("oauth_consents").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("client_id").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var consents []*Consent
+	for rows.Next() {
+		var (
+			c      Consent
+			scopes string
+		)
+		if err := rows.Scan(&c.UserID, &c.ClientID, &scopes, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.Scopes = strings.Fields(scopes)
+		consents = append(consents, &c)
+	}
+
+	return consents, rows.Err()
+}
+
+func (r *ConsentRepository) get(ctx context.Context, runner sq.BaseRunner, userID, clientID string) (*Consent, error) {
+	var (
+		c      Consent
+		scopes string
+	)
+
+	err := r.sb.Select("user_id, client_id, scopes, created_at, updated_at").
+		From("oauth_consents").
+		Where(sq.Eq{"user_id": userID, "client_id": clientID}).
+		RunWith(runner).QueryRowContext(ctx).
+		Scan(&c.UserID, &c.ClientID, &scopes, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Scopes = strings.Fields(scopes)
+
+	return &c, nil
+}
+
+func (r *ConsentRepository) Save(userID, clientID string, scopes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	existing, err := r.get(ctx, tx, userID, clientID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = r.sb.Insert("oauth_consents").
+			Columns("user_id", "client_id", "scopes", "created_at", "updated_at").
+			Values(userID, clientID, strings.Join(scopes, " "), now, now).
+			RunWith(tx).ExecContext(ctx)
+	case err == nil:
+		_, err = r.sb.Update("oauth_consents").
+			Set("scopes", strings.Join(union(existing.Scopes, scopes), " ")).
+			Set("updated_at", now).
+			Where(sq.Eq{"user_id": userID, "client_id": clientID}).
+			RunWith(tx).ExecContext(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *ConsentRepository) Delete(userID, clientID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Delete("oauth_consents").
+		Where(sq.Eq{"user_id": userID, "client_id": clientID}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *ConsentRepository) DeleteByUser(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Delete("oauth_consents").
+		Where(sq.Eq{"user_id": userID}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+// AuthorizationCode is a code handed to a client at the end of an
+// authorization, which it redeems once for tokens
+type AuthorizationCode struct {
+	Hash     string
+	ClientID string
+	UserID   string
+	// RedirectURI is the redirect URI named by the authorization request,
+	// empty when the client left it to the one it registered
+	RedirectURI string
+	Scopes      []string
+	Nonce       string
+	// Challenge is the S256 PKCE challenge the redeeming verifier must match
+	Challenge string
+	ExpiresAt time.Time
+}
+
+// RefreshToken is a long-lived token a client trades for new access tokens.
+// Each use rotates it: the token is spent and a new one of the same family,
+// descending from the same authorization, is issued
+type RefreshToken struct {
+	ID       string
+	FamilyID string
+	Hash     string
+	ClientID string
+	UserID   string
+	Scopes   []string
+	// RotatedAt is when the token was spent. Spent tokens presented again
+	// signal a leak, and revoke their family
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Active reports whether the token may be used at t
+func (t *RefreshToken) Active(now time.Time) bool {
+	return t.RotatedAt == nil && t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+type IOAuthTokenRepository interface {
+	InsertCode(AuthorizationCode) error
+	// ConsumeCode returns and deletes the code with this hash, so that each
+	// code is redeemed once
+	ConsumeCode(hash string) (*AuthorizationCode, error)
+	// InsertRefreshToken stores a token and returns its id. Tokens without
+	// a family start their own
+	InsertRefreshToken(RefreshToken) (string, error)
+	GetRefreshToken(hash string) (*RefreshToken, error)
+	// RotateRefreshToken spends a token, and reports false when it already
+	// was spent or revoked
+	RotateRefreshToken(id string) (bool, error)
+	// RevokeFamily revokes every token of a family, and with them the
+	// access tokens issued alongside
+	RevokeFamily(familyID string) error
+	FamilyRevoked(familyID string) (bool, error)
+	// GetRefreshTokensByUser returns the refresh tokens issued to a user,
+	// spent and revoked ones included, oldest first
+	GetRefreshTokensByUser(userID string) ([]*RefreshToken, error)
+	// RevokeByUser deletes the unredeemed codes of a user and revokes their
+	// refresh tokens. The revoked tokens are kept, as they are what revokes
+	// the access tokens issued alongside
+	RevokeByUser(userID string) error
+}
+
+type OAuthTokenRepository struct {
+	db *sql.DB
+	sb sq.StatementBuilderType
+}
+
+// NewOAuthTokenRepositoryFor returns the code and refresh token store for a
+// database of the given dialect
+func NewOAuthTokenRepositoryFor(pool *sql.DB, dialect Dialect) IOAuthTokenRepository {
+	return &OAuthTokenRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *OAuthTokenRepository) InsertCode(code AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Insert("oauth_codes").
+		Columns("code_hash", "client_id", "user_id", "redirect_uri", "scopes", "nonce", "code_challenge", "expires_at").
+		Values(code.Hash, code.ClientID, code.UserID, code.RedirectURI, strings.Join(code.Scopes, " "), code.Nonce, code.Challenge, code.ExpiresAt).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *OAuthTokenRepository) ConsumeCode(hash string) (*AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		code   AuthorizationCode
+		scopes string
+	)
+
+	err = r.sb.Select("code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at").
+		From("oauth_codes").
+		Where(sq.Eq{"code_hash": hash}).
+		RunWith(tx).QueryRowContext(ctx).
+		Scan(&code.Hash, &code.ClientID, &code.UserID, &code.RedirectURI, &scopes, &code.Nonce, &code.Challenge, &code.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	code.Scopes = strings.Fields(scopes)
+
+	// a concurrent redemption may have deleted it in the meantime
+	res, err := r.sb.Delete("oauth_codes").
+		Where(sq.Eq{"code_hash": hash}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+func (r *OAuthTokenRepository) InsertRefreshToken(t RefreshToken) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	family := t.FamilyID
+	if family == "" {
+		family = id
+	}
+
+	_, err = r.sb.Insert("oauth_refresh_tokens").
+		Columns("token_id", "family_id", "token_hash", "client_id", "user_id", "scopes", "expires_at", "created_at").
+		Values(id, family, t.Hash, t.ClientID, t.UserID, strings.Join(t.Scopes, " "), t.ExpiresAt, time.Now()).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *OAuthTokenRepository) GetRefreshToken(hash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return scanRefreshToken(r.sb.Select(refreshTokenColumns).
+		From("oauth_refresh_tokens").
+		Where(sq.Eq{"token_hash": hash}).
+		RunWith(r.db).QueryRowContext(ctx))
+}
+
+func (r *OAuthTokenRepository) GetRefreshTokensByUser(userID string) ([]*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select(refreshTokenColumns).
+		From("oauth_refresh_tokens").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("created_at", "token_id").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*RefreshToken
+	for rows.Next() {
+		t, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+const refreshTokenColumns = "token_id, family_id, token_hash, client_id, user_id, scopes, rotated_at, revoked_at, expires_at, created_at"
+
+func scanRefreshToken(row sq.RowScanner) (*RefreshToken, error) {
+	var (
+		t                RefreshToken
+		scopes           string
+		rotated, revoked sql.NullTime
+	)
+
+	err := row.Scan(&t.ID, &t.FamilyID, &t.Hash, &t.ClientID, &t.UserID, &scopes, &rotated, &revoked, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	t.RotatedAt = nullTime(rotated)
+	t.RevokedAt = nullTime(revoked)
+
+	return &t, nil
+}
+
+func (r *OAuthTokenRepository) RotateRefreshToken(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := r.sb.Update("oauth_refresh_tokens").
+		Set("rotated_at", time.Now()).
+		Where(sq.Eq{"token_id": id, "rotated_at": nil, "revoked_at": nil}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func (r *OAuthTokenRepository) RevokeFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Update("oauth_refresh_tokens").
+		Set("revoked_at", time.Now()).
+		Where(sq.Eq{"family_id": familyID, "revoked_at": nil}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *OAuthTokenRepository) FamilyRevoked(familyID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var n int
+	err := r.sb.Select("COUNT(*)").
+		From("oauth_refresh_tokens").
+		Where(sq.Eq{"family_id": familyID}).
+		Where(sq.NotEq{"revoked_at": nil}).
+		RunWith(r.db).QueryRowContext(ctx).Scan(&n)
+
+	return n > 0, err
+}
+
+func (r *OAuthTokenRepository) RevokeByUser(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = r.sb.Delete("oauth_codes").
+		Where(sq.Eq{"user_id": userID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.sb.Update("oauth_refresh_tokens").
+		Set("revoked_at", time.Now()).
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MemoryOAuthClientRepository is an IOAuthClientRepository held in process
+// memory
+type MemoryOAuthClientRepository struct {
+	*memoryOAuthClients
+	tenant string
+}
+
+type memoryOAuthClients struct {
+	mu      sync.RWMutex
+	clients map[string]*OAuthClient
+}
+
+func NewMemoryOAuthClientRepository() IOAuthClientRepository {
+	return &MemoryOAuthClientRepository{memoryOAuthClients: &memoryOAuthClients{clients: make(map[string]*OAuthClient)}}
+}
+
+func (r *MemoryOAuthClientRepository) WithTenant(orgID string) IOAuthClientRepository {
+	return &MemoryOAuthClientRepository{memoryOAuthClients: r.memoryOAuthClients, tenant: orgID}
+}
+
+// get returns the client with this id if the tenant may see it. The caller
+// must hold the lock
+func (r *MemoryOAuthClientRepository) get(id string) (*OAuthClient, bool) {
+	c, ok := r.clients[id]
+	if !ok || (r.tenant != "" && c.OrgID != r.tenant) {
+		return nil, false
+	}
+
+	return c, true
+}
+
+func (r *MemoryOAuthClientRepository) GetAll() ([]*OAuthClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var clients []*OAuthClient
+	for id := range r.clients {
+		if c, ok := r.get(id); ok {
+			clients = append(clients, copyOAuthClient(c))
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Name != clients[j].Name {
+			return clients[i].Name < clients[j].Name
+		}
+		return clients[i].ID < clients[j].ID
+	})
+
+	return clients, nil
+}
+
+func (r *MemoryOAuthClientRepository) GetOne(id string) (*OAuthClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.get(id)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyOAuthClient(c), nil
+}
+
+func (r *MemoryOAuthClientRepository) Insert(c OAuthClient) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	c.ID = id
+	if r.tenant != "" {
+		c.OrgID = r.tenant
+	}
+	if c.OrgID == "" {
+		c.OrgID = DefaultOrganizationID
+	}
+	c.CreatedAt = time.Now()
+	r.clients[id] = copyOAuthClient(&c)
+
+	return id, nil
+}
+
+// DeleteByID removes a client. Its consents and tokens are left in their
+// stores, where they no longer match a client
+func (r *MemoryOAuthClientRepository) DeleteByID(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.get(id); ok {
+		delete(r.clients, id)
+	}
+
+	return nil
+}
+
+func copyOAuthClient(c *OAuthClient) *OAuthClient {
+	cp := *c
+	cp.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+	cp.GrantTypes = append([]string(nil), c.GrantTypes...)
+	cp.Scopes = append([]string(nil), c.Scopes...)
+
+	return &cp
+}
+
+// MemoryConsentRepository is an IConsentRepository held in process memory
+type MemoryConsentRepository struct {
+	mu       sync.Mutex
+	consents map[[2]string]*Consent
+}
+
+func NewMemoryConsentRepository() IConsentRepository {
+	return &MemoryConsentRepository{consents: make(map[[2]string]*Consent)}
+}
+
+func (r *MemoryConsentRepository) Get(userID, clientID string) (*Consent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.consents[[2]string{userID, clientID}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	cp := *c
+	cp.Scopes = append([]string(nil), c.Scopes...)
+
+	return &cp, nil
+}
+
+func (r *MemoryConsentRepository) GetByUser(userID string) ([]*Consent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var consents []*Consent
+	for _, c := range r.consents {
+		if c.UserID == userID {
+			cp := *c
+			cp.Scopes = append([]string(nil), c.Scopes...)
+			consents = append(consents, &cp)
+		}
+	}
+
+	sort.Slice(consents, func(i, j int) bool { return consents[i].ClientID < consents[j].ClientID })
+
+	return consents, nil
+}
+
+func (r *MemoryConsentRepository) Save(userID, clientID string, scopes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	key := [2]string{userID, clientID}
+
+	if c, ok := r.consents[key]; ok {
+		c.Scopes = union(c.Scopes, scopes)
+		c.UpdatedAt = now
+		return nil
+	}
+
+	r.consents[key] = &Consent{
+		UserID:    userID,
+		ClientID:  clientID,
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	return nil
+}
+
+func (r *MemoryConsentRepository) Delete(userID, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.consents, [2]string{userID, clientID})
+
+	return nil
+}
+
+func (r *MemoryConsentRepository) DeleteByUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.consents {
+		if key[0] == userID {
+			delete(r.consents, key)
+		}
+	}
+
+	return nil
+}
+
+// MemoryOAuthTokenRepository is an IOAuthTokenRepository held in process
+// memory
+type MemoryOAuthTokenRepository struct {
+	mu      sync.Mutex
+	codes   map[string]*AuthorizationCode
+	refresh map[string]*RefreshToken
+}
+
+func NewMemoryOAuthTokenRepository() IOAuthTokenRepository {
+	return &MemoryOAuthTokenRepository{
+		codes:   make(map[string]*AuthorizationCode),
+		refresh: make(map[string]*RefreshToken),
+	}
+}
+
+func (r *MemoryOAuthTokenRepository) InsertCode(code AuthorizationCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code.Scopes = append([]string(nil), code.Scopes...)
+	r.codes[code.Hash] = &code
+
+	return nil
+}
+
+func (r *MemoryOAuthTokenRepository) ConsumeCode(hash string) (*AuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.codes, hash)
+
+	return code, nil
+}
+
+func (r *MemoryOAuthTokenRepository) InsertRefreshToken(t RefreshToken) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	t.ID = id
+	if t.FamilyID == "" {
+		t.FamilyID = id
+	}
+	t.Scopes = append([]string(nil), t.Scopes...)
+	t.RotatedAt = nil
+	t.RevokedAt = nil
+	t.CreatedAt = time.Now()
+	r.refresh[id] = &t
+
+	return id, nil
+}
+
+func (r *MemoryOAuthTokenRepository) GetRefreshToken(hash string) (*RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.refresh {
+		if t.Hash == hash {
+			cp := *t
+			cp.Scopes = append([]string(nil), t.Scopes...)
+			return &cp, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryOAuthTokenRepository) RotateRefreshToken(id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.refresh[id]
+	if !ok || t.RotatedAt != nil || t.RevokedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	t.RotatedAt = &now
+
+	return true, nil
+}
+
+func (r *MemoryOAuthTokenRepository) RevokeFamily(familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range r.refresh {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryOAuthTokenRepository) FamilyRevoked(familyID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.refresh {
+		if t.FamilyID == familyID && t.RevokedAt != nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *MemoryOAuthTokenRepository) GetRefreshTokensByUser(userID string) ([]*RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var tokens []*RefreshToken
+	for _, t := range r.refresh {
+		if t.UserID == userID {
+			cp := *t
+			cp.Scopes = append([]string(nil), t.Scopes...)
+			tokens = append(tokens, &cp)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+
+	return tokens, nil
+}
+
+func (r *MemoryOAuthTokenRepository) RevokeByUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, code := range r.codes {
+		if code.UserID == userID {
+			delete(r.codes, hash)
+		}
+	}
+
+	now := time.Now()
+	for _, t := range r.refresh {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// union returns the strings of a followed by those of b not in a
+func union(a, b []string) []string {
+	out := append([]string(nil), a...)
+	for _, s := range b {
+		if !contains(out, s) {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
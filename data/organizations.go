@@ -0,0 +1,362 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// DefaultOrganizationID is the organization that holds the users created
+// before there were organizations, and those inserted without a tenant
+const DefaultOrganizationID = "00000000-0000-0000-0000-000000000001"
+
+var (
+	ErrDuplicateSlug       = errors.New("slug already exists")
+	ErrOrganizationInUse   = errors.New("organization still has users")
+	ErrDefaultOrganization = errors.New("the default organization cannot be deleted")
+)
+
+// slugPattern accepts a DNS label, so that slugs can be used as subdomains
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Organization is a tenant. Its users are isolated from those of every
+// other organization
+type Organization struct {
+	ID        string    `json:"org_id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type IOrganizationRepository interface {
+	GetAll() ([]*Organization, error)
+	GetOne(id string) (*Organization, error)
+	GetBySlug(slug string) (*Organization, error)
+	Insert(Organization) (string, error)
+	Update(Organization) error
+	// DeleteByID refuses to delete organizations that still have users
+	DeleteByID(id string) error
+}
+
+// ValidateOrganization checks the fields of a submitted organization
+func ValidateOrganization(o Organization) []FieldError {
+	var errs []FieldError
+
+	if !slugPattern.MatchString(o.Slug) {
+		errs = append(errs, FieldError{Field: "slug", Message: "must be lowercase letters, digits and inner hyphens, at most 63 characters"})
+	}
+
+	if o.Name == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	} else if len(o.Name) > maxNameLength {
+		errs = append(errs, FieldError{Field: "name", Message: "must be at most 255 characters"})
+	}
+
+	return errs
+}
+
+// LookupOrganization finds the organization named by ref, which is either
+// its id or its slug
+func LookupOrganization(orgs IOrganizationRepository, ref string) (*Organization, error) {
+	if uuidPattern.MatchString(ref) {
+		return orgs.GetOne(ref)
+	}
+
+	return orgs.GetBySlug(ref)
+}
+
+type OrganizationRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+}
+
+// NewOrganizationRepositoryFor returns the organization store for a database
+// of the given dialect
+func NewOrganizationRepositoryFor(pool *sql.DB, dialect Dialect) IOrganizationRepository {
+	return &OrganizationRepository{db: pool, dialect: dialect, sb: dialect.builder()}
+}
+
+// GetAll returns every organization, sorted by slug
+func (r *OrganizationRepository) GetAll() ([]*Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select("org_id, slug, name, created_at, updated_at").
+		From("organizations").
+		OrderBy("slug ASC").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []*Organization
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Slug, &o.Name, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, &o)
+	}
+
+	return orgs, rows.Err()
+}
+
+func (r *OrganizationRepository) GetOne(id string) (*Organization, error) {
+	// Postgres rejects ids that are not UUIDs instead of finding nothing
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.getBy(sq.Eq{"org_id": id})
+}
+
+func (r *OrganizationRepository) GetBySlug(slug string) (*Organization, error) {
+	return r.getBy(sq.Eq{"slug": slug})
+}
+
+func (r *OrganizationRepository) getBy(pred sq.Eq) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var o Organization
+	err := r.sb.Select("org_id, slug, name, created_at, updated_at").
+		From("organizations").
+		Where(pred).
+		RunWith(r.db).QueryRowContext(ctx).
+		Scan(&o.ID, &o.Slug, &o.Name, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// Insert stores a new organization under a generated id and returns it
+func (r *OrganizationRepository) Insert(o Organization) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = r.sb.Insert("organizations").
+		Columns("org_id", "slug", "name", "created_at", "updated_at").
+		Values(id, o.Slug, o.Name, now, now).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return "", slugError(r.dialect.translateError(err))
+	}
+
+	return id, nil
+}
+
+// Update changes the slug and name of the organization with o's id
+func (r *OrganizationRepository) Update(o Organization) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := r.sb.Update("organizations").
+		SetMap(sq.Eq{"slug": o.Slug, "name": o.Name, "updated_at": time.Now()}).
+		Where(sq.Eq{"org_id": o.ID}).
+		RunWith(r.db).ExecContext(ctx)
+
+	return slugError(r.dialect.translateError(err))
+}
+
+func (r *OrganizationRepository) DeleteByID(id string) error {
+	if id == DefaultOrganizationID {
+		return ErrDefaultOrganization
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var users int
+	err = r.sb.Select("COUNT(*)").
+		From("users").
+		Where(sq.Eq{"org_id": id}).
+		RunWith(tx).QueryRowContext(ctx).Scan(&users)
+	if err != nil {
+		return err
+	}
+
+	if users > 0 {
+		return ErrOrganizationInUse
+	}
+
+	_, err = r.sb.Delete("organizations").
+		Where(sq.Eq{"org_id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// slugError reports unique violations on organizations as taken slugs
+func slugError(err error) error {
+	if errors.Is(err, ErrDuplicateEmail) {
+		return ErrDuplicateSlug
+	}
+
+	return err
+}
+
+// MemoryOrganizationRepository is an IOrganizationRepository held in process
+// memory. It checks users for DeleteByID
+type MemoryOrganizationRepository struct {
+	mu    sync.RWMutex
+	orgs  map[string]*Organization
+	users IRepository
+}
+
+// NewMemoryOrganizationRepository returns a store holding the default
+// organization, whose users are kept in users
+func NewMemoryOrganizationRepository(users IRepository) IOrganizationRepository {
+	now := time.Now()
+
+	return &MemoryOrganizationRepository{
+		orgs: map[string]*Organization{
+			DefaultOrganizationID: {ID: DefaultOrganizationID, Slug: "default", Name: "Default", CreatedAt: now, UpdatedAt: now},
+		},
+		users: users,
+	}
+}
+
+func (r *MemoryOrganizationRepository) GetAll() ([]*Organization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orgs := make([]*Organization, 0, len(r.orgs))
+	for _, o := range r.orgs {
+		org := *o
+		orgs = append(orgs, &org)
+	}
+
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Slug < orgs[j].Slug })
+
+	return orgs, nil
+}
+
+func (r *MemoryOrganizationRepository) GetOne(id string) (*Organization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	o, ok := r.orgs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	org := *o
+	return &org, nil
+}
+
+func (r *MemoryOrganizationRepository) GetBySlug(slug string) (*Organization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, o := range r.orgs {
+		if o.Slug == slug {
+			org := *o
+			return &org, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryOrganizationRepository) Insert(o Organization) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.slugTaken(o.Slug, "") {
+		return "", ErrDuplicateSlug
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	o.ID = id
+	o.CreatedAt = now
+	o.UpdatedAt = now
+	r.orgs[id] = &o
+
+	return id, nil
+}
+
+func (r *MemoryOrganizationRepository) Update(o Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orgs[o.ID]
+	if !ok {
+		return nil
+	}
+
+	if r.slugTaken(o.Slug, o.ID) {
+		return ErrDuplicateSlug
+	}
+
+	stored.Slug = o.Slug
+	stored.Name = o.Name
+	stored.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) DeleteByID(id string) error {
+	if id == DefaultOrganizationID {
+		return ErrDefaultOrganization
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.users.WithTenant(id).GetAll()
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		return ErrOrganizationInUse
+	}
+
+	delete(r.orgs, id)
+
+	return nil
+}
+
+// slugTaken reports whether an organization other than id holds slug. The
+// caller must hold the lock
+func (r *MemoryOrganizationRepository) slugTaken(slug, id string) bool {
+	for _, o := range r.orgs {
+		if o.Slug == slug && o.ID != id {
+			return true
+		}
+	}
+
+	return false
+}
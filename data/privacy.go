@@ -0,0 +1,707 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// PrivacyJobKind is what a privacy job does with the data of a user
+type PrivacyJobKind string
+
+const (
+	// PrivacyExport gathers everything stored about a user into an archive
+	PrivacyExport PrivacyJobKind = "export"
+	// PrivacyErase anonymizes a user and removes the rows related to them,
+	// leaving a tombstone
+	PrivacyErase PrivacyJobKind = "erase"
+)
+
+// PrivacyJobStatus is where a privacy job stands. Running and failed jobs
+// are unfinished, and are resumed by the next request of their kind
+type PrivacyJobStatus string
+
+const (
+	PrivacyJobRunning   PrivacyJobStatus = "running"
+	PrivacyJobFailed    PrivacyJobStatus = "failed"
+	PrivacyJobCompleted PrivacyJobStatus = "completed"
+	// PrivacyJobAbandoned jobs are never resumed, e.g. the exports of a user
+	// who was erased meanwhile
+	PrivacyJobAbandoned PrivacyJobStatus = "abandoned"
+)
+
+// maxJobErrorLength bounds the error kept for a failed job
+const maxJobErrorLength = 500
+
+// PrivacyJob is an export or erasure of the data of a user, run as a series
+// of steps. The steps done are recorded as they complete, so that a job that
+// failed or was cut short resumes where it stopped
+type PrivacyJob struct {
+	ID     string           `json:"job_id"`
+	OrgID  string           `json:"org_id,omitempty"`
+	UserID string           `json:"user_id"`
+	Kind   PrivacyJobKind   `json:"kind"`
+	Status PrivacyJobStatus `json:"status"`
+	// Actor is who asked for the job, e.g. "admin" or "user:<id>"
+	Actor string `json:"actor"`
+	// Error is why the job last failed
+	Error       string           `json:"error,omitempty"`
+	Steps       []PrivacyJobStep `json:"steps"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+}
+
+// PrivacyJobStep is a completed step of a job
+type PrivacyJobStep struct {
+	Name string `json:"name"`
+	// Output is what the step produced, such as a section of an export. It
+	// is kept until the job completes, so that a resumed job need not redo
+	// the step
+	Output      string    `json:"-"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Unfinished reports whether the job may still be resumed
+func (j *PrivacyJob) Unfinished() bool {
+	return j.Status == PrivacyJobRunning || j.Status == PrivacyJobFailed
+}
+
+// Step returns the completed step of a job with this name
+func (j *PrivacyJob) Step(name string) (*PrivacyJobStep, bool) {
+	for i := range j.Steps {
+		if j.Steps[i].Name == name {
+			return &j.Steps[i], true
+		}
+	}
+
+	return nil, false
+}
+
+type IPrivacyJobRepository interface {
+	// GetOne returns a job with its steps, in the order they completed
+	GetOne(id string) (*PrivacyJob, error)
+	// GetByUser returns the jobs of a user with their steps, newest first
+	GetByUser(userID string) ([]*PrivacyJob, error)
+	// Unfinished returns the latest unfinished job of a kind for a user,
+	// failing with sql.ErrNoRows when there is none
+	Unfinished(userID string, kind PrivacyJobKind) (*PrivacyJob, error)
+	// Start stores a running job under a generated id and returns it
+	Start(PrivacyJob) (string, error)
+	// Resume sets an unfinished job running again
+	Resume(id string) error
+	// CompleteStep records that a step of a running job completed with
+	// output, replacing an earlier record of it
+	CompleteStep(id, step, output string) error
+	// Fail records that a running job stopped with an error. Its steps are
+	// kept for it to be resumed
+	Fail(id, reason string) error
+	// Complete marks a running job as completed and drops the outputs of
+	// its steps
+	Complete(id string) error
+	// Abandon gives up the unfinished jobs of a kind for a user and drops
+	// the outputs of their steps
+	Abandon(userID string, kind PrivacyJobKind) error
+	// WithTenant returns a view of the jobs of one organization
+	WithTenant(orgID string) IPrivacyJobRepository
+}
+
+type PrivacyJobRepository struct {
+	db     *sql.DB
+	sb     sq.StatementBuilderType
+	tenant string
+}
+
+// NewPrivacyJobRepositoryFor returns the privacy job store for a database of
+// the given dialect
+func NewPrivacyJobRepositoryFor(pool *sql.DB, dialect Dialect) IPrivacyJobRepository {
+	return &PrivacyJobRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *PrivacyJobRepository) WithTenant(orgID string) IPrivacyJobRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+// org is the organization the repository reads and writes
+func (r *PrivacyJobRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+const privacyJobColumns = "job_id, org_id, user_id, kind, status, actor, error, created_at, updated_at, completed_at"
+
+func (r *PrivacyJobRepository) GetOne(id string) (*PrivacyJob, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.getOne(sq.Eq{"job_id": id}, nil)
+}
+
+func (r *PrivacyJobRepository) Unfinished(userID string, kind PrivacyJobKind) (*PrivacyJob, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.getOne(sq.Eq{
+		"user_id": userID,
+		"kind":    kind,
+		"status":  []PrivacyJobStatus{PrivacyJobRunning, PrivacyJobFailed},
+	}, []string{"created_at DESC", "job_id"})
+}
+
+// getOne returns the first job of the organization matching where in the
+// given order, with its steps
+func (r *PrivacyJobRepository) getOne(where sq.Eq, orderBy []string) (*PrivacyJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	cond := sq.Eq{"org_id": r.org()}
+	for column, value := range where {
+		cond[column] = value
+	}
+
+	row := r.sb.Select(privacyJobColumns).
+		From("privacy_jobs").
+		Where(cond).
+		OrderBy(orderBy...).
+		Limit(1).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	j, err := scanPrivacyJob(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.attachSteps(ctx, j); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (r *PrivacyJobRepository) GetByUser(userID string) ([]*PrivacyJob, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := r.sb.Select(privacyJobColumns).
+		From("privacy_jobs").
+		Where(sq.Eq{"org_id": r.org(), "user_id": userID}).
+		OrderBy("created_at DESC", "job_id").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*PrivacyJob
+	for rows.Next() {
+		j, err := scanPrivacyJob(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := r.attachSteps(ctx, jobs...); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// attachSteps reads the steps of jobs, in the order they completed
+func (r *PrivacyJobRepository) attachSteps(ctx context.Context, jobs ...*PrivacyJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*PrivacyJob, len(jobs))
+	ids := make([]string, len(jobs))
+	for i, j := range jobs {
+		j.Steps = []PrivacyJobStep{}
+		byID[j.ID] = j
+		ids[i] = j.ID
+	}
+
+	rows, err := r.sb.Select("job_id", "step", "output", "completed_at").
+		From("privacy_job_steps").
+		Where(sq.Eq{"job_id": ids}).
+		OrderBy("completed_at", "step").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			jobID string
+			s     PrivacyJobStep
+		)
+		if err := rows.Scan(&jobID, &s.Name, &s.Output, &s.CompletedAt); err != nil {
+			return err
+		}
+
+		j := byID[jobID]
+		j.Steps = append(j.Steps, s)
+	}
+
+	return rows.Err()
+}
+
+func (r *PrivacyJobRepository) Start(j PrivacyJob) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = r.sb.Insert("privacy_jobs").
+		Columns("job_id", "org_id", "user_id", "kind", "status", "actor", "error", "created_at", "updated_at").
+		Values(id, r.org(), j.UserID, j.Kind, PrivacyJobRunning, j.Actor, "", now, now).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *PrivacyJobRepository) Resume(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.update(ctx, r.db, id, []PrivacyJobStatus{PrivacyJobRunning, PrivacyJobFailed}, sq.Eq{
+		"status":     PrivacyJobRunning,
+		"error":      "",
+		"updated_at": time.Now(),
+	})
+}
+
+func (r *PrivacyJobRepository) CompleteStep(id, step, output string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := r.update(ctx, tx, id, []PrivacyJobStatus{PrivacyJobRunning}, sq.Eq{"updated_at": now}); err != nil {
+		return err
+	}
+
+	_, err = r.sb.Delete("privacy_job_steps").
+		Where(sq.Eq{"job_id": id, "step": step}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.sb.Insert("privacy_job_steps").
+		Columns("job_id", "step", "output", "completed_at").
+		Values(id, step, output, now).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PrivacyJobRepository) Fail(id, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if len(reason) > maxJobErrorLength {
+		reason = reason[:maxJobErrorLength]
+	}
+
+	return r.update(ctx, r.db, id, []PrivacyJobStatus{PrivacyJobRunning}, sq.Eq{
+		"status":     PrivacyJobFailed,
+		"error":      reason,
+		"updated_at": time.Now(),
+	})
+}
+
+func (r *PrivacyJobRepository) Complete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	err = r.update(ctx, tx, id, []PrivacyJobStatus{PrivacyJobRunning}, sq.Eq{
+		"status":       PrivacyJobCompleted,
+		"updated_at":   now,
+		"completed_at": now,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.sb.Update("privacy_job_steps").
+		Set("output", "").
+		Where(sq.Eq{"job_id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PrivacyJobRepository) Abandon(userID string, kind PrivacyJobKind) error {
+	if !uuidPattern.MatchString(userID) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cond := sq.Eq{
+		"org_id":  r.org(),
+		"user_id": userID,
+		"kind":    kind,
+		"status":  []PrivacyJobStatus{PrivacyJobRunning, PrivacyJobFailed},
+	}
+
+	rows, err := r.sb.Select("job_id").From("privacy_jobs").Where(cond).RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = r.sb.Update("privacy_jobs").
+		SetMap(sq.Eq{"status": PrivacyJobAbandoned, "updated_at": time.Now()}).
+		Where(sq.Eq{"job_id": ids}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.sb.Update("privacy_job_steps").
+		Set("output", "").
+		Where(sq.Eq{"job_id": ids}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// update sets the columns of a job of the organization in one of statuses,
+// failing with sql.ErrNoRows when there is none
+func (r *PrivacyJobRepository) update(ctx context.Context, runner sq.BaseRunner, id string, statuses []PrivacyJobStatus, set sq.Eq) error {
+	if !uuidPattern.MatchString(id) {
+		return sql.ErrNoRows
+	}
+
+	res, err := r.sb.Update("privacy_jobs").
+		SetMap(set).
+		Where(sq.Eq{"org_id": r.org(), "job_id": id, "status": statuses}).
+		RunWith(runner).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func scanPrivacyJob(row sq.RowScanner) (*PrivacyJob, error) {
+	var (
+		j         PrivacyJob
+		completed sql.NullTime
+	)
+
+	err := row.Scan(&j.ID, &j.OrgID, &j.UserID, &j.Kind, &j.Status, &j.Actor, &j.Error, &j.CreatedAt, &j.UpdatedAt, &completed)
+	if err != nil {
+		return nil, err
+	}
+	j.CompletedAt = nullTime(completed)
+
+	return &j, nil
+}
+
+// MemoryPrivacyJobRepository is an IPrivacyJobRepository held in process
+// memory
+type MemoryPrivacyJobRepository struct {
+	*memoryPrivacyJobs
+	tenant string
+}
+
+type memoryPrivacyJobs struct {
+	mu   sync.RWMutex
+	jobs map[string]*PrivacyJob
+}
+
+func NewMemoryPrivacyJobRepository() IPrivacyJobRepository {
+	return &MemoryPrivacyJobRepository{memoryPrivacyJobs: &memoryPrivacyJobs{jobs: make(map[string]*PrivacyJob)}}
+}
+
+func (r *MemoryPrivacyJobRepository) WithTenant(orgID string) IPrivacyJobRepository {
+	return &MemoryPrivacyJobRepository{memoryPrivacyJobs: r.memoryPrivacyJobs, tenant: orgID}
+}
+
+func (r *MemoryPrivacyJobRepository) org() string {
+	if r.tenant != "" {
+		return r.tenant
+	}
+
+	return DefaultOrganizationID
+}
+
+// get returns the job of the organization with id if it is in one of
+// statuses, or in any when there are none. Callers hold the lock
+func (r *MemoryPrivacyJobRepository) get(id string, statuses ...PrivacyJobStatus) (*PrivacyJob, error) {
+	j, ok := r.jobs[id]
+	if !ok || j.OrgID != r.org() {
+		return nil, sql.ErrNoRows
+	}
+
+	if len(statuses) == 0 {
+		return j, nil
+	}
+	for _, s := range statuses {
+		if j.Status == s {
+			return j, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// copyJob returns a copy of j that does not share its steps
+func copyJob(j *PrivacyJob) *PrivacyJob {
+	c := *j
+	c.Steps = append([]PrivacyJobStep{}, j.Steps...)
+
+	return &c
+}
+
+func (r *MemoryPrivacyJobRepository) GetOne(id string) (*PrivacyJob, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	j, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyJob(j), nil
+}
+
+func (r *MemoryPrivacyJobRepository) GetByUser(userID string) ([]*PrivacyJob, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var jobs []*PrivacyJob
+	for _, j := range r.jobs {
+		if j.OrgID == r.org() && j.UserID == userID {
+			jobs = append(jobs, copyJob(j))
+		}
+	}
+
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].CreatedAt.Equal(jobs[b].CreatedAt) {
+			return jobs[a].CreatedAt.After(jobs[b].CreatedAt)
+		}
+		return jobs[a].ID < jobs[b].ID
+	})
+
+	return jobs, nil
+}
+
+func (r *MemoryPrivacyJobRepository) Unfinished(userID string, kind PrivacyJobKind) (*PrivacyJob, error) {
+	jobs, err := r.GetByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, j := range jobs {
+		if j.Kind == kind && j.Unfinished() {
+			return j, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryPrivacyJobRepository) Start(j PrivacyJob) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	j.ID = id
+	j.OrgID = r.org()
+	j.Status = PrivacyJobRunning
+	j.Error = ""
+	j.Steps = []PrivacyJobStep{}
+	j.CreatedAt = now
+	j.UpdatedAt = now
+	j.CompletedAt = nil
+	r.jobs[id] = &j
+
+	return id, nil
+}
+
+func (r *MemoryPrivacyJobRepository) Resume(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, err := r.get(id, PrivacyJobRunning, PrivacyJobFailed)
+	if err != nil {
+		return err
+	}
+
+	j.Status = PrivacyJobRunning
+	j.Error = ""
+	j.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryPrivacyJobRepository) CompleteStep(id, step, output string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, err := r.get(id, PrivacyJobRunning)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	steps := []PrivacyJobStep{}
+	for _, s := range j.Steps {
+		if s.Name != step {
+			steps = append(steps, s)
+		}
+	}
+	j.Steps = append(steps, PrivacyJobStep{Name: step, Output: output, CompletedAt: now})
+	j.UpdatedAt = now
+
+	return nil
+}
+
+func (r *MemoryPrivacyJobRepository) Fail(id, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, err := r.get(id, PrivacyJobRunning)
+	if err != nil {
+		return err
+	}
+
+	if len(reason) > maxJobErrorLength {
+		reason = reason[:maxJobErrorLength]
+	}
+
+	j.Status = PrivacyJobFailed
+	j.Error = reason
+	j.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (r *MemoryPrivacyJobRepository) Complete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, err := r.get(id, PrivacyJobRunning)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	j.Status = PrivacyJobCompleted
+	j.UpdatedAt = now
+	j.CompletedAt = &now
+	for i := range j.Steps {
+		j.Steps[i].Output = ""
+	}
+
+	return nil
+}
+
+func (r *MemoryPrivacyJobRepository) Abandon(userID string, kind PrivacyJobKind) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, j := range r.jobs {
+		if j.OrgID != r.org() || j.UserID != userID || j.Kind != kind || !j.Unfinished() {
+			continue
+		}
+
+		j.Status = PrivacyJobAbandoned
+		j.UpdatedAt = now
+		for i := range j.Steps {
+			j.Steps[i].Output = ""
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStickiness    = 5 * time.Second
+	defaultCheckInterval = 5 * time.Second
+)
+
+// ReplicaSet routes reads to healthy replicas in turn and everything else to
+// the primary. Callers who wrote within the stickiness window, as told by
+// their WriteClock, read from the primary so that they see their own writes
+// despite replication lag
+type ReplicaSet struct {
+	primary    *sql.DB
+	replicas   []*replica
+	next       atomic.Uint64
+	stickiness time.Duration
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// NewReplicaSet returns a set whose replicas are taken to be healthy until
+// a check fails. Stickiness defaults to five seconds when it is zero
+func NewReplicaSet(primary *sql.DB, replicas []*sql.DB, stickiness time.Duration) *ReplicaSet {
+	if stickiness <= 0 {
+		stickiness = defaultStickiness
+	}
+
+	s := &ReplicaSet{primary: primary, stickiness: stickiness}
+
+	for _, db := range replicas {
+		rep := &replica{db: db}
+		rep.healthy.Store(true)
+		s.replicas = append(s.replicas, rep)
+	}
+
+	return s
+}
+
+// Primary returns the database taking writes
+func (s *ReplicaSet) Primary() *sql.DB {
+	return s.primary
+}
+
+// Check pings every replica and marks the ones that do not answer as
+// unhealthy until a later check succeeds
+func (s *ReplicaSet) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rep := range s.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+			defer cancel()
+
+			rep.healthy.Store(rep.db.PingContext(ctx) == nil)
+		}(rep)
+	}
+	wg.Wait()
+}
+
+// Watch checks the replicas every interval until ctx is done. The interval
+// defaults to five seconds when it is zero
+func (s *ReplicaSet) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Check(ctx)
+		}
+	}
+}
+
+// reader picks the database for a read of a caller whose last write was at
+// lastWrite, which is zero when it has not written
+func (s *ReplicaSet) reader(lastWrite time.Time) *sql.DB {
+	if lastWrite.After(time.Now().Add(-s.stickiness)) {
+		return s.primary
+	}
+
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if rep := s.replicas[(start+i)%n]; rep.healthy.Load() {
+			return rep.db
+		}
+	}
+
+	return s.primary
+}
+
+// WriteClock holds the time of the last write of one caller, such as a
+// request or a client across its requests. Repositories given the clock
+// with Sticky advance it when they write and read from the primary while it
+// is recent
+type WriteClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewWriteClock returns the clock of a caller whose last write was at last,
+// e.g. as remembered by a cookie, or zero if it has not written
+func NewWriteClock(last time.Time) *WriteClock {
+	return &WriteClock{last: last}
+}
+
+// LastWrite returns the time of the last write, zero for a nil clock
+func (c *WriteClock) LastWrite() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.last
+}
+
+func (c *WriteClock) wrote() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = time.Now()
+}
+
+// Sticky returns a view of repo whose writes advance clock and whose reads
+// go to the primary for the stickiness window after them. Reads through
+// views without a clock, such as those of background jobs, always go to the
+// replicas. Repositories without replicas are returned as they are
+func Sticky(repo IRepository, clock *WriteClock) IRepository {
+	switch r := repo.(type) {
+	case *Repository:
+		if r.replicas == nil {
+			return r
+		}
+
+		scoped := *r
+		scoped.clock = clock
+		return &scoped
+	case *CachedRepository:
+		scoped := *r
+		scoped.repo = Sticky(r.repo, clock)
+		return &scoped
+	}
+
+	return repo
+}
@@ -0,0 +1,142 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replicated repository", func() {
+
+	const (
+		clark = "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"
+		lois  = "ae17b2e2-6b87-4c5b-9c94-3623dacf113b"
+
+		selectOne = `SELECT .* FROM users WHERE user_id = \$1`
+		selectAll = `SELECT .* FROM users ORDER BY last_name ASC`
+	)
+
+	var (
+		primary, first, second sqlmock.Sqlmock
+		set                    *data.ReplicaSet
+		repo                   data.IRepository
+	)
+
+	newMock := func() (*sql.DB, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		return db, mock
+	}
+
+	userRow := func(id string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "email", "first_name", "last_name", "password", "user_status", "created_at", "updated_at"}).
+			AddRow(id, "clark@mail.com", "Clark", "Kent", "hash", "active", time.Now(), time.Now())
+	}
+
+	setup := func(stickiness time.Duration) {
+		var primaryDB, firstDB, secondDB *sql.DB
+		primaryDB, primary = newMock()
+		firstDB, first = newMock()
+		secondDB, second = newMock()
+
+		set = data.NewReplicaSet(primaryDB, []*sql.DB{firstDB, secondDB}, stickiness)
+		repo = data.NewReplicatedRepository(set, data.Postgres)
+	}
+
+	BeforeEach(func() {
+		setup(time.Minute)
+	})
+
+	It("should spread reads across the replicas in turn", func() {
+		second.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		first.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		second.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+
+		for i := 0; i < 3; i++ {
+			_, err := repo.GetOne(clark)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+	})
+
+	It("should write to the primary and read the writes of the caller back from it", func() {
+		clock := data.NewWriteClock(time.Time{})
+		caller := data.Sticky(repo, clock)
+
+		primary.ExpectExec(`UPDATE users SET`).WillReturnResult(sqlmock.NewResult(1, 1))
+		primary.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		primary.ExpectQuery(selectAll).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+		second.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+
+		Expect(caller.Update(data.User{ID: clark, Email: "clark@mail.com"})).To(Succeed())
+		Expect(clock.LastWrite()).NotTo(BeZero())
+
+		_, err := caller.GetOne(clark)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = caller.GetAll()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// other callers are unaffected
+		_, err = data.Sticky(repo, data.NewWriteClock(time.Time{})).GetOne(clark)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should stick to the primary for a write remembered from an earlier request", func() {
+		primary.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+
+		_, err := data.Sticky(repo, data.NewWriteClock(time.Now())).GetOne(clark)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should go back to the replicas once the stickiness window ends", func() {
+		setup(20 * time.Millisecond)
+		caller := data.Sticky(repo, data.NewWriteClock(time.Time{}))
+
+		primary.ExpectExec(`DELETE FROM users`).WillReturnResult(sqlmock.NewResult(1, 1))
+		second.ExpectQuery(selectOne).WithArgs(clark).WillReturnError(sql.ErrNoRows)
+
+		Expect(caller.DeleteByID(clark)).To(Succeed())
+		time.Sleep(30 * time.Millisecond)
+
+		_, err := caller.GetOne(clark)
+		Expect(err).To(MatchError(sql.ErrNoRows))
+	})
+
+	It("should skip unhealthy replicas and fall back to the primary", func() {
+		first.ExpectPing().WillReturnError(errors.New("down"))
+		second.ExpectPing()
+		set.Check(context.Background())
+
+		second.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		second.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		for i := 0; i < 2; i++ {
+			_, err := repo.GetOne(clark)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		first.ExpectPing().WillReturnError(errors.New("down"))
+		second.ExpectPing().WillReturnError(errors.New("down"))
+		set.Check(context.Background())
+
+		primary.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		_, err := repo.GetOne(clark)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		first.ExpectPing()
+		second.ExpectPing().WillReturnError(errors.New("down"))
+		set.Check(context.Background())
+
+		first.ExpectQuery(selectOne).WithArgs(clark).WillReturnRows(userRow(clark))
+		_, err = repo.GetOne(clark)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+})
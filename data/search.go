@@ -0,0 +1,305 @@
+package data
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// SimilarityThreshold mirrors the default of pg_trgm.similarity_threshold,
+// the cut-off used by the % operator
+const SimilarityThreshold = 0.3
+
+// SearchOptions filters and pages a user search
+type SearchOptions struct {
+	// Query is matched against first name, last name and email, either as a
+	// case-insensitive substring or by trigram similarity
+	Query string
+	// Active restricts the results to active users when set, or to the
+	// others when unset
+	Active *bool
+	// Status restricts the results to users with this status when set
+	Status Status
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a user matching a search along with its rank and the
+// matched fields, with the matched text wrapped in <mark> tags
+type SearchResult struct {
+	User       User              `json:"user"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// Search returns the users matching opts, best match first, and the total
+// number of matches. On Postgres ranking relies on the pg_trgm extension;
+// the other dialects have no trigram support, so their users are ranked in
+// process with SearchUsers
+func (r *Repository) Search(opts SearchOptions) ([]*SearchResult, int, error) {
+	if r.dialect != Postgres {
+		var users []*User
+		err := r.Stream(func(u *User) error {
+			users = append(users, u)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		results, total := SearchUsers(users, opts)
+		return results, total, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	q := opts.Query
+	pattern := "%" + escapeLike(q) + "%"
+
+	uq := r.sb.Select("user_id, email, first_name, last_name, user_status, created_at, updated_at").
+		Column(sq.Expr("GREATEST(similarity(first_name, ?), similarity(last_name, ?), similarity(email, ?)) AS rank", q, q, q)).
+		Column("COUNT(*) OVER() AS total").
+		From("users").
+		Where(sq.Or{
+			sq.Expr("first_name % ?", q),
+			sq.Expr("last_name % ?", q),
+			sq.Expr("email % ?", q),
+			sq.ILike{"first_name": pattern},
+			sq.ILike{"last_name": pattern},
+			sq.ILike{"email": pattern},
+		}).
+		OrderBy("rank DESC", "last_name ASC", "user_id ASC")
+
+	if opts.Active != nil {
+		if *opts.Active {
+			uq = uq.Where(sq.Eq{"user_status": StatusActive})
+		} else {
+			uq = uq.Where(sq.NotEq{"user_status": StatusActive})
+		}
+	}
+
+	if opts.Status != "" {
+		uq = uq.Where(sq.Eq{"user_status": opts.Status})
+	}
+
+	if opts.Limit > 0 {
+		uq = uq.Limit(uint64(opts.Limit))
+	}
+
+	if opts.Offset > 0 {
+		uq = uq.Offset(uint64(opts.Offset))
+	}
+
+	rows, err := scope(uq, r.tenant).RunWith(r.reader()).QueryContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		results []*SearchResult
+		total   int
+	)
+
+	for rows.Next() {
+		var (
+			user User
+			rank float64
+		)
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Status,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&rank,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		user.OrgID = r.tenant
+
+		results = append(results, newSearchResult(user, q, rank))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Filters reports whether u passes the status filters of opts, regardless
+// of the query
+func (opts SearchOptions) Filters(u *User) bool {
+	if opts.Active != nil && (u.Status == StatusActive) != *opts.Active {
+		return false
+	}
+
+	return opts.Status == "" || u.Status == opts.Status
+}
+
+// SearchUsers applies the ranking of Repository.Search to users held in
+// memory and returns the requested page along with the total number of
+// matches
+func SearchUsers(users []*User, opts SearchOptions) ([]*SearchResult, int) {
+	q := strings.ToLower(opts.Query)
+
+	var results []*SearchResult
+	for _, u := range users {
+		if !opts.Filters(u) {
+			continue
+		}
+
+		var (
+			rank    float64
+			matched bool
+		)
+		for _, field := range []string{u.FirstName, u.LastName, u.Email} {
+			s := Similarity(field, q)
+			if s > rank {
+				rank = s
+			}
+
+			if s >= SimilarityThreshold || strings.Contains(strings.ToLower(field), q) {
+				matched = true
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		user := *u
+		user.Password = ""
+		results = append(results, newSearchResult(user, opts.Query, rank))
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+
+		if a.User.LastName != b.User.LastName {
+			return a.User.LastName < b.User.LastName
+		}
+
+		return a.User.ID < b.User.ID
+	})
+
+	total := len(results)
+
+	if opts.Offset >= len(results) {
+		return nil, total
+	}
+	results = results[opts.Offset:]
+
+	if opts.Limit > 0 && opts.Limit < len(results) {
+		results = results[:opts.Limit]
+	}
+
+	return results, total
+}
+
+// Similarity computes the trigram similarity of a and b the way pg_trgm's
+// similarity() does: the number of shared trigrams divided by the number of
+// distinct trigrams of both strings
+func Similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+// trigrams splits s into lower-cased alphanumeric words, pads each with two
+// spaces in front and one behind, and collects their three letter windows
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, w := range words {
+		padded := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+func newSearchResult(u User, q string, rank float64) *SearchResult {
+	res := &SearchResult{User: u, Rank: rank}
+
+	fields := map[string]string{
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"email":      u.Email,
+	}
+	for name, value := range fields {
+		if h, ok := highlight(value, q); ok {
+			if res.Highlights == nil {
+				res.Highlights = make(map[string]string)
+			}
+			res.Highlights[name] = h
+		}
+	}
+
+	return res
+}
+
+// highlight HTML-escapes s and wraps every case-insensitive occurrence of q
+// in <mark> tags. It reports whether q occurs in s at all
+func highlight(s, q string) (string, bool) {
+	if q == "" {
+		return "", false
+	}
+
+	lower, lq := strings.ToLower(s), strings.ToLower(q)
+	if len(lower) != len(s) || !strings.Contains(lower, lq) {
+		return "", false
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, lq)
+		if i < 0 {
+			b.WriteString(html.EscapeString(s))
+			break
+		}
+
+		b.WriteString(html.EscapeString(s[:i]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(s[i : i+len(lq)]))
+		b.WriteString("</mark>")
+
+		s, lower = s[i+len(lq):], lower[i+len(lq):]
+	}
+
+	return b.String(), true
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,147 @@
+package data_test
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/data"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trigram similarity", func() {
+
+	It("should match pg_trgm", func() {
+		// SELECT similarity('word', 'two words') => 0.36363637
+		Expect(data.Similarity("word", "two words")).To(BeNumerically("~", 0.363636, 0.000001))
+	})
+
+	It("should ignore case and punctuation", func() {
+		Expect(data.Similarity("Clark", "clark!")).To(Equal(1.0))
+	})
+
+	It("should be zero for empty strings", func() {
+		Expect(data.Similarity("", "clark")).To(BeZero())
+	})
+})
+
+var _ = Describe("Search users in memory", func() {
+
+	var users []*data.User
+
+	BeforeEach(func() {
+		users = []*data.User{
+			{ID: "1", Email: "clark@dailyplanet.com", FirstName: "Clark", LastName: "Kent", Status: data.StatusActive},
+			{ID: "2", Email: "lois@dailyplanet.com", FirstName: "Lois", LastName: "Lane", Status: data.StatusActive},
+			{ID: "3", Email: "lex@lexcorp.com", FirstName: "Lex", LastName: "Luthor", Status: data.StatusPending},
+			{ID: "4", Email: "clarke@mail.com", FirstName: "Arthur", LastName: "Clarke", Status: data.StatusActive},
+		}
+	})
+
+	It("should rank closer matches first", func() {
+		results, total := data.SearchUsers(users, data.SearchOptions{Query: "clark"})
+		Expect(total).To(Equal(2))
+		Expect(results[0].User.ID).To(Equal("1"))
+		Expect(results[1].User.ID).To(Equal("4"))
+		Expect(results[0].Rank).To(BeNumerically(">", results[1].Rank))
+	})
+
+	It("should find fuzzy matches", func() {
+		results, _ := data.SearchUsers(users, data.SearchOptions{Query: "luthr"})
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].User.ID).To(Equal("3"))
+		Expect(results[0].Highlights).To(BeEmpty())
+	})
+
+	It("should highlight substring matches", func() {
+		results, _ := data.SearchUsers(users, data.SearchOptions{Query: "dailyplanet"})
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Highlights).To(HaveKeyWithValue("email", "clark@<mark>dailyplanet</mark>.com"))
+	})
+
+	It("should filter by status", func() {
+		active := false
+		results, _ := data.SearchUsers(users, data.SearchOptions{Query: "l", Active: &active})
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].User.ID).To(Equal("3"))
+
+		results, _ = data.SearchUsers(users, data.SearchOptions{Query: "l", Status: data.StatusActive})
+		Expect(results).To(HaveLen(3))
+	})
+
+	It("should page the results", func() {
+		results, total := data.SearchUsers(users, data.SearchOptions{Query: "clark", Limit: 1, Offset: 1})
+		Expect(total).To(Equal(2))
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].User.ID).To(Equal("4"))
+	})
+})
+
+var _ = Describe("Search users", func() {
+
+	const searchQuery = `
+		SELECT
+			user_id, email, first_name, last_name, user_status, created_at, updated_at,
+			GREATEST(similarity(first_name, $1), similarity(last_name, $2), similarity(email, $3)) AS rank,
+			COUNT(*) OVER() AS total
+		FROM users
+		WHERE (first_name % $4 OR last_name % $5 OR email % $6 OR first_name ILIKE $7 OR last_name ILIKE $8 OR email ILIKE $9)
+		ORDER BY rank DESC, last_name ASC, user_id ASC
+		LIMIT 20 OFFSET 20`
+
+	var (
+		err     error
+		total   int
+		results []*data.SearchResult
+	)
+
+	When("there is a match", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+
+			mockDB.ExpectQuery(searchQuery).
+				WithArgs("cla", "cla", "cla", "cla", "cla", "cla", "%cla%", "%cla%", "%cla%").
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"user_id", "email", "first_name",
+							"last_name", "user_status",
+							"created_at", "updated_at",
+							"rank", "total",
+						},
+					).
+						AddRow(
+							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", "example@mail.com", "Clark",
+							"Kent", "active",
+							time.Now(), time.Now(),
+							0.5, 21,
+						),
+				)
+
+			results, total, err = testRepo.Search(data.SearchOptions{Query: "cla", Limit: 20, Offset: 20})
+		})
+
+		It("should return ranked results", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(total).To(Equal(21))
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Rank).To(Equal(0.5))
+			Expect(results[0].Highlights).To(HaveKeyWithValue("first_name", "<mark>Cla</mark>rk"))
+		})
+	})
+
+	When("the query fails", func() {
+		BeforeEach(func() {
+			mockDB, testRepo := newTestRepo()
+
+			mockDB.ExpectQuery(searchQuery).WillReturnError(sql.ErrConnDone)
+
+			results, total, err = testRepo.Search(data.SearchOptions{Query: "cla", Limit: 20, Offset: 20})
+		})
+
+		It("should return error", func() {
+			Expect(err).To(MatchError(sql.ErrConnDone))
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrSecretKeySize is returned for SecretBox keys that are not 32 bytes
+var ErrSecretKeySize = errors.New("secret key must be 32 bytes")
+
+// SecretBox encrypts the secrets stored in the database, such as TOTP seeds,
+// with AES-256-GCM, so that a copy of the database alone does not reveal
+// them
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox returns a SecretBox sealing with a 32 byte key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, ErrSecretKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plaintext and returns it in base64 with its nonce. The
+// context, e.g. the id of the owning row, is authenticated but not stored;
+// the same context must be given to Open, so that sealed values cannot be
+// moved between rows
+func (b *SecretBox) Seal(plaintext []byte, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, plaintext, []byte(context))
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal for the same context
+func (b *SecretBox) Open(sealed, context string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	n := b.aead.NonceSize()
+	if len(raw) < n {
+		return nil, errors.New("sealed value is too short")
+	}
+
+	return b.aead.Open(nil, raw[:n], raw[n:], []byte(context))
+}
@@ -0,0 +1,361 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Session is a login of a user on a device. Access tokens name their
+// session, and stop working once it is revoked
+type Session struct {
+	ID         string     `json:"session_id"`
+	OrgID      string     `json:"org_id,omitempty"`
+	UserID     string     `json:"user_id"`
+	Device     string     `json:"device"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the session is neither revoked nor expired at now
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+type ISessionRepository interface {
+	GetOne(id string) (*Session, error)
+	// GetByUser returns the active sessions of a user, most recently seen
+	// first
+	GetByUser(userID string) ([]*Session, error)
+	Insert(Session) (string, error)
+	// Touch records that the session was used at t
+	Touch(id string, t time.Time) error
+	Revoke(id string) error
+	// RevokeAll revokes every session of a user
+	RevokeAll(userID string) error
+	// DeleteByUser removes every session of a user, along with the devices
+	// and IPs they were used from
+	DeleteByUser(userID string) error
+	// WithTenant returns a view of the sessions of one organization, which
+	// also receives the sessions it inserts
+	WithTenant(orgID string) ISessionRepository
+}
+
+type SessionRepository struct {
+	db     *sql.DB
+	sb     sq.StatementBuilderType
+	tenant string
+}
+
+// NewSessionRepositoryFor returns the session store for a database of the
+// given dialect
+func NewSessionRepositoryFor(pool *sql.DB, dialect Dialect) ISessionRepository {
+	return &SessionRepository{db: pool, sb: dialect.builder()}
+}
+
+func (r *SessionRepository) WithTenant(orgID string) ISessionRepository {
+	scoped := *r
+	scoped.tenant = orgID
+
+	return &scoped
+}
+
+const sessionColumns = "session_id, org_id, user_id, device, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at"
+
+func (r *SessionRepository) GetOne(id string) (*Session, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(sessionColumns).
+		From("sessions").
+		Where(sq.Eq{"session_id": id})
+
+	return scanSession(scope(uq, r.tenant).RunWith(r.db).QueryRowContext(ctx))
+}
+
+func (r *SessionRepository) GetByUser(userID string) ([]*Session, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select(sessionColumns).
+		From("sessions").
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil}).
+		OrderBy("last_seen_at DESC", "session_id")
+	rows, err := scope(uq, r.tenant).RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// expiry is checked here, as SQLite does not compare times it stores as
+	// text reliably
+	now := time.Now()
+
+	var sessions []*Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.Active(now) {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions, rows.Err()
+}
+
+// Insert records a session under a generated id and returns it
+func (r *SessionRepository) Insert(s Session) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	org := s.OrgID
+	if r.tenant != "" {
+		org = r.tenant
+	}
+	if org == "" {
+		org = DefaultOrganizationID
+	}
+
+	now := time.Now()
+	_, err = r.sb.Insert("sessions").
+		Columns("session_id", "org_id", "user_id", "device", "ip", "user_agent", "created_at", "last_seen_at", "expires_at").
+		Values(id, org, s.UserID, s.Device, s.IP, s.UserAgent, now, now, s.ExpiresAt).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (r *SessionRepository) Touch(id string, t time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Update("sessions").
+		Set("last_seen_at", t).
+		Where(sq.Eq{"session_id": id})
+	_, err := scope(uq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *SessionRepository) Revoke(id string) error {
+	if !uuidPattern.MatchString(id) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Update("sessions").
+		Set("revoked_at", time.Now()).
+		Where(sq.Eq{"session_id": id, "revoked_at": nil})
+	_, err := scope(uq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *SessionRepository) RevokeAll(userID string) error {
+	if !uuidPattern.MatchString(userID) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Update("sessions").
+		Set("revoked_at", time.Now()).
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil})
+	_, err := scope(uq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func (r *SessionRepository) DeleteByUser(userID string) error {
+	if !uuidPattern.MatchString(userID) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	dq := r.sb.Delete("sessions").Where(sq.Eq{"user_id": userID})
+	_, err := scope(dq, r.tenant).RunWith(r.db).ExecContext(ctx)
+
+	return err
+}
+
+func scanSession(row sq.RowScanner) (*Session, error) {
+	var (
+		s       Session
+		revoked sql.NullTime
+	)
+
+	err := row.Scan(&s.ID, &s.OrgID, &s.UserID, &s.Device, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &revoked)
+	if err != nil {
+		return nil, err
+	}
+	s.RevokedAt = nullTime(revoked)
+
+	return &s, nil
+}
+
+// MemorySessionRepository is an ISessionRepository held in process memory
+type MemorySessionRepository struct {
+	*memorySessions
+	tenant string
+}
+
+type memorySessions struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewMemorySessionRepository() ISessionRepository {
+	return &MemorySessionRepository{memorySessions: &memorySessions{sessions: make(map[string]*Session)}}
+}
+
+func (r *MemorySessionRepository) WithTenant(orgID string) ISessionRepository {
+	return &MemorySessionRepository{memorySessions: r.memorySessions, tenant: orgID}
+}
+
+// visible reports whether the tenant may see s
+func (r *MemorySessionRepository) visible(s *Session) bool {
+	return r.tenant == "" || s.OrgID == r.tenant
+}
+
+func (r *MemorySessionRepository) GetOne(id string) (*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.sessions[id]
+	if !ok || !r.visible(s) {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *s
+	return &c, nil
+}
+
+func (r *MemorySessionRepository) GetByUser(userID string) ([]*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+
+	var sessions []*Session
+	for _, s := range r.sessions {
+		if s.UserID == userID && r.visible(s) && s.Active(now) {
+			c := *s
+			sessions = append(sessions, &c)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (r *MemorySessionRepository) Insert(s Session) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	s.ID = id
+	if r.tenant != "" {
+		s.OrgID = r.tenant
+	}
+	if s.OrgID == "" {
+		s.OrgID = DefaultOrganizationID
+	}
+	s.CreatedAt = time.Now()
+	s.LastSeenAt = s.CreatedAt
+	s.RevokedAt = nil
+	r.sessions[id] = &s
+
+	return id, nil
+}
+
+func (r *MemorySessionRepository) Touch(id string, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[id]; ok && r.visible(s) {
+		s.LastSeenAt = t
+	}
+
+	return nil
+}
+
+func (r *MemorySessionRepository) Revoke(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[id]; ok && r.visible(s) && s.RevokedAt == nil {
+		now := time.Now()
+		s.RevokedAt = &now
+	}
+
+	return nil
+}
+
+func (r *MemorySessionRepository) RevokeAll(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, s := range r.sessions {
+		if s.UserID == userID && r.visible(s) && s.RevokedAt == nil {
+			s.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (r *MemorySessionRepository) DeleteByUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, s := range r.sessions {
+		if s.UserID == userID && r.visible(s) {
+			delete(r.sessions, id)
+		}
+	}
+
+	return nil
+}
@@ -2,17 +2,70 @@ package data
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
+	"strings"
 )
 
-func OpenDB() (*sql.DB, error) {
-	dsn := os.Getenv("DSN")
+// OpenDB connects to the database named by the DSN environment variable and
+// reports its dialect, which is picked from the DSN scheme
+func OpenDB() (*sql.DB, Dialect, error) {
+	dialect, dsn, err := ParseDSN(os.Getenv("DSN"))
+	if err != nil {
+		return nil, "", err
+	}
 
-	db, err := sql.Open("pgx", dsn)
+	db, err := open(dialect, dsn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return db, dialect, nil
+}
+
+// OpenReplicas connects to the read replicas listed, comma separated, in the
+// REPLICA_DSNS environment variable. They must share the dialect of the
+// primary. Replicas that cannot be reached are still returned, and are
+// skipped until a health check succeeds
+func OpenReplicas(dialect Dialect) ([]*sql.DB, error) {
+	var replicas []*sql.DB
+	for _, v := range strings.Split(os.Getenv("REPLICA_DSNS"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		d, dsn, err := ParseDSN(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if d != dialect {
+			return nil, fmt.Errorf("replica dialect %s does not match the primary %s", d, dialect)
+		}
+
+		db, err := sql.Open(d.Driver(), dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		replicas = append(replicas, db)
+	}
+
+	return replicas, nil
+}
+
+func open(dialect Dialect, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(dialect.Driver(), dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	if dialect == SQLite {
+		// SQLite allows a single writer, and every connection to :memory:
+		// would open a database of its own
+		db.SetMaxOpenConns(1)
+	}
+
 	err = db.Ping()
 	if err != nil {
 		return nil, err
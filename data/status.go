@@ -0,0 +1,313 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Status is where a user stands in its lifecycle
+type Status string
+
+const (
+	// StatusPending users were created but not activated yet
+	StatusPending Status = "pending"
+	StatusActive  Status = "active"
+	// StatusSuspended users were barred for a while, e.g. pending a review
+	StatusSuspended Status = "suspended"
+	// StatusDeactivated users were switched off, by themselves or an admin
+	StatusDeactivated Status = "deactivated"
+	// StatusDeleted users are tombstones, kept after their data was erased
+	StatusDeleted Status = "deleted"
+)
+
+// Statuses lists every status, in lifecycle order
+var Statuses = []Status{StatusPending, StatusActive, StatusSuspended, StatusDeactivated, StatusDeleted}
+
+// transitions is the state machine of user statuses: the statuses each one
+// may move to. Deleted is final
+var transitions = map[Status][]Status{
+	StatusPending:     {StatusActive, StatusDeactivated, StatusDeleted},
+	StatusActive:      {StatusSuspended, StatusDeactivated, StatusDeleted},
+	StatusSuspended:   {StatusActive, StatusDeactivated, StatusDeleted},
+	StatusDeactivated: {StatusActive, StatusDeleted},
+}
+
+// maxReasonLength bounds the reason given for a status change
+const maxReasonLength = 500
+
+// ErrInvalidTransition is returned for status changes the state machine
+// does not allow
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// ErrStatusConflict is returned when the status of a user changed between
+// reading it and moving it on
+var ErrStatusConflict = errors.New("user status changed concurrently")
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok || s == StatusDeleted
+}
+
+// CanBecome reports whether a user may move from s to status to
+func (s Status) CanBecome(to Status) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAuthenticate reports whether users of this status may log in and use
+// their tokens
+func (s Status) CanAuthenticate() bool {
+	return s == StatusActive
+}
+
+// StatusOf maps the active flag that older clients send onto the status of
+// a new user
+func StatusOf(active bool) Status {
+	if active {
+		return StatusActive
+	}
+
+	return StatusPending
+}
+
+// WithActive returns the status the active flag of older clients moves a
+// user in status s to: pending users become active when it is set, and
+// active users deactivated when it is unset. The flag cannot bring back
+// suspended, deactivated or deleted users, which takes an activation with
+// a reason, and fails with ErrInvalidTransition for them
+func (s Status) WithActive(active bool) (Status, error) {
+	switch {
+	case active && s == StatusPending:
+		return StatusActive, nil
+	case active && s != StatusActive:
+		return s, ErrInvalidTransition
+	case !active && s == StatusActive:
+		return StatusDeactivated, nil
+	}
+
+	return s, nil
+}
+
+// statusOrPending is the status u is stored with: new users are pending
+// unless given another status
+func (u *User) statusOrPending() Status {
+	if u.Status == "" {
+		return StatusPending
+	}
+
+	return u.Status
+}
+
+// StatusTransition is a change of the status of a user, as recorded in its
+// history
+type StatusTransition struct {
+	ID     string `json:"transition_id"`
+	UserID string `json:"user_id"`
+	From   Status `json:"from"`
+	To     Status `json:"to"`
+	Reason string `json:"reason"`
+	// Actor is who made the change, e.g. "admin" or "user:<id>"
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidateTransition checks the fields of a status change submitted by a
+// caller
+func ValidateTransition(t StatusTransition) []FieldError {
+	var errs []FieldError
+
+	if strings.TrimSpace(t.Reason) == "" {
+		errs = append(errs, FieldError{Field: "reason", Message: "is required"})
+	} else if len(t.Reason) > maxReasonLength {
+		errs = append(errs, FieldError{Field: "reason", Message: "must be at most 500 characters"})
+	}
+
+	return errs
+}
+
+// SetActive moves u to the status the active flag of older clients stands
+// for, recording the change as made by actor. Users already there are left
+// alone
+func SetActive(repo IRepository, u *User, active bool, actor string) error {
+	to, err := u.Status.WithActive(active)
+	if err != nil || to == u.Status {
+		return err
+	}
+
+	if !u.Status.CanBecome(to) {
+		return ErrInvalidTransition
+	}
+
+	reason := "set inactive"
+	if active {
+		reason = "set active"
+	}
+
+	err = repo.Transition(StatusTransition{UserID: u.ID, From: u.Status, To: to, Reason: reason, Actor: actor})
+	if err != nil {
+		return err
+	}
+	u.Status = to
+
+	return nil
+}
+
+// Transition moves a user from t.From to t.To and records the change, in one
+// transaction. It fails with ErrStatusConflict when the user is no longer in
+// t.From, and sql.ErrNoRows when there is no such user
+func (r *Repository) Transition(t StatusTransition) error {
+	if !t.From.CanBecome(t.To) {
+		return ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	defer r.wrote()
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if r.rls {
+		if _, err := tx.ExecContext(ctx, "SELECT set_config('app.org_id', $1, true)", r.tenant); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	uq := r.sb.Update("users").
+		Set("user_status", t.To).
+		Set("updated_at", now).
+		Where(sq.Eq{"user_id": t.UserID, "user_status": t.From})
+	res, err := scope(uq, r.tenant).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var status Status
+		sel := r.sb.Select("user_status").From("users").Where(sq.Eq{"user_id": t.UserID})
+		err := scope(sel, r.tenant).RunWith(tx).QueryRowContext(ctx).Scan(&status)
+		if err != nil {
+			return err
+		}
+		return ErrStatusConflict
+	}
+
+	_, err = r.sb.Insert("user_status_transitions").
+		Columns("transition_id", "user_id", "from_status", "to_status", "reason", "actor", "created_at").
+		Values(id, t.UserID, t.From, t.To, t.Reason, t.Actor, now).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Transitions returns the status history of a user, oldest first
+func (r *Repository) Transitions(userID string) ([]*StatusTransition, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	uq := r.sb.Select("t.transition_id", "t.user_id", "t.from_status", "t.to_status", "t.reason", "t.actor", "t.created_at").
+		From("user_status_transitions t").
+		Join("users u ON u.user_id = t.user_id").
+		Where(sq.Eq{"t.user_id": userID}).
+		OrderBy("t.created_at", "t.transition_id")
+	if r.tenant != "" {
+		uq = uq.Where(sq.Eq{"u.org_id": r.tenant})
+	}
+
+	rows, err := uq.RunWith(r.reader()).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*StatusTransition
+	for rows.Next() {
+		var t StatusTransition
+		if err := rows.Scan(&t.ID, &t.UserID, &t.From, &t.To, &t.Reason, &t.Actor, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, &t)
+	}
+
+	return history, rows.Err()
+}
+
+// Transition moves a user from t.From to t.To and records the change
+func (r *MemoryRepository) Transition(t StatusTransition) error {
+	if !t.From.CanBecome(t.To) {
+		return ErrInvalidTransition
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.get(t.UserID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if u.Status != t.From {
+		return ErrStatusConflict
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	u.Status = t.To
+	u.UpdatedAt = now
+
+	t.ID = id
+	t.CreatedAt = now
+	r.history[t.UserID] = append(r.history[t.UserID], t)
+
+	return nil
+}
+
+// Transitions returns the status history of a user, oldest first
+func (r *MemoryRepository) Transitions(userID string) ([]*StatusTransition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.get(userID); !ok {
+		return nil, nil
+	}
+
+	var history []*StatusTransition
+	for _, t := range r.history[userID] {
+		t := t
+		history = append(history, &t)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// scope restricts a select, update or delete to the users of tenant. An
+// empty tenant leaves the statement unscoped
+func scope[T interface {
+	Where(pred interface{}, args ...interface{}) T
+}](b T, tenant string) T {
+	if tenant == "" {
+		return b
+	}
+
+	return b.Where(sq.Eq{"org_id": tenant})
+}
+
+// rlsRunner runs each statement in a transaction whose app.org_id setting
+// names the tenant, which the row level security policy on users checks.
+// The setting is local to the transaction, so connections go back to the
+// pool without a tenant. An empty tenant leaves it empty, which the policy
+// lets see every row
+type rlsRunner struct {
+	db     *sql.DB
+	tenant string
+}
+
+func (r rlsRunner) begin(ctx context.Context) (*sql.Tx, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('app.org_id', $1, true)", r.tenant); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func (r rlsRunner) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, tx.Commit()
+}
+
+// QueryContext leaves the transaction open for the rows to be read. It only
+// reads, and is rolled back once ctx is done, which the repositories see to
+// by cancelling their context when they return
+func (r rlsRunner) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (r rlsRunner) QueryRowContext(ctx context.Context, query string, args ...interface{}) sq.RowScanner {
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return errScanner{err}
+	}
+
+	return &txRow{row: tx.QueryRowContext(ctx, query, args...), tx: tx}
+}
+
+func (r rlsRunner) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.ExecContext(context.Background(), query, args...)
+}
+
+// Query is not supported: without a context nothing would end the
+// transaction of the rows
+func (r rlsRunner) Query(string, ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("row level security queries need a context")
+}
+
+// txRow ends its transaction once it has been scanned. A statement that
+// also writes, as INSERT ... RETURNING does, is committed
+type txRow struct {
+	row *sql.Row
+	tx  *sql.Tx
+}
+
+func (r *txRow) Scan(dest ...interface{}) error {
+	defer r.tx.Rollback()
+
+	if err := r.row.Scan(dest...); err != nil {
+		return err
+	}
+
+	return r.tx.Commit()
+}
+
+type errScanner struct {
+	err error
+}
+
+func (s errScanner) Scan(...interface{}) error {
+	return s.err
+}
@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes issued at once
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is the randomness of a recovery code, written as
+	// 16 base32 digits
+	recoveryCodeBytes = 10
+)
+
+// ErrTwoFactorEnabled is returned when enrolling a user who already
+// confirmed an enrollment
+var ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+
+// TwoFactor is the TOTP enrollment of a user. It takes effect once it is
+// confirmed with a first code
+type TwoFactor struct {
+	UserID      string
+	Secret      []byte
+	ConfirmedAt *time.Time
+	// LastStep is the time step of the last code accepted. Codes of that
+	// step or earlier are refused, so that an intercepted code cannot be
+	// replayed
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// Enabled reports whether logins of the user need a second factor
+func (t *TwoFactor) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+type ITwoFactorRepository interface {
+	// Get returns the enrollment of a user, confirmed or not
+	Get(userID string) (*TwoFactor, error)
+	// Enroll stores a new secret for a user, replacing an unconfirmed one.
+	// It fails with ErrTwoFactorEnabled once an enrollment is confirmed
+	Enroll(userID string, secret []byte) error
+	// Confirm enables an enrollment whose code of step was accepted and
+	// replaces the recovery codes of the user with the given hashes
+	Confirm(userID string, step int64, recoveryHashes []string) error
+	// UseStep records that a code of step was accepted. It reports false
+	// when a code of that step or a later one already was
+	UseStep(userID string, step int64) (bool, error)
+	// UseRecoveryCode spends the unused recovery code with this hash and
+	// reports whether there was one
+	UseRecoveryCode(userID, hash string) (bool, error)
+	// Delete removes the enrollment and the recovery codes of a user
+	Delete(userID string) error
+}
+
+// NewRecoveryCodes generates a set of recovery codes and returns them along
+// with the hashes to store
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		s := strings.ToLower(enc.EncodeToString(b))
+		code := s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage, ignoring case,
+// spaces and dashes. Codes carry 80 random bits, so a fast hash is enough
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// TwoFactorRepository stores enrollments with their secrets sealed by a
+// SecretBox
+type TwoFactorRepository struct {
+	db      *sql.DB
+	dialect Dialect
+	sb      sq.StatementBuilderType
+	box     *SecretBox
+}
+
+// NewTwoFactorRepositoryFor returns the two-factor store for a database of
+// the given dialect
+func NewTwoFactorRepositoryFor(pool *sql.DB, dialect Dialect, box *SecretBox) ITwoFactorRepository {
+	return &TwoFactorRepository{db: pool, dialect: dialect, sb: dialect.builder(), box: box}
+}
+
+func (r *TwoFactorRepository) Get(userID string) (*TwoFactor, error) {
+	if !uuidPattern.MatchString(userID) {
+		return nil, sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var (
+		t         TwoFactor
+		sealed    string
+		confirmed sql.NullTime
+	)
+
+	err := r.sb.Select("user_id, secret, confirmed_at, last_step, created_at").
+		From("user_totp").
+		Where(sq.Eq{"user_id": userID}).
+		RunWith(r.db).QueryRowContext(ctx).
+		Scan(&t.UserID, &sealed, &confirmed, &t.LastStep, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Secret, err = r.box.Open(sealed, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	t.ConfirmedAt = nullTime(confirmed)
+
+	return &t, nil
+}
+
+func (r *TwoFactorRepository) Enroll(userID string, secret []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	sealed, err := r.box.Seal(secret, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var confirmed sql.NullTime
+	err = r.sb.Select("confirmed_at").
+		From("user_totp").
+		Where(sq.Eq{"user_id": userID}).
+		RunWith(tx).QueryRowContext(ctx).
+		Scan(&confirmed)
+	switch {
+	case err == nil && confirmed.Valid:
+		return ErrTwoFactorEnabled
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	_, err = r.sb.Delete("user_totp").
+		Where(sq.Eq{"user_id": userID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.sb.Insert("user_totp").
+		Columns("user_id", "secret", "last_step", "created_at").
+		Values(userID, sealed, 0, time.Now()).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *TwoFactorRepository) Confirm(userID string, step int64, recoveryHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := r.sb.Update("user_totp").
+		Set("confirmed_at", time.Now()).
+		Set("last_step", step).
+		Where(sq.Eq{"user_id": userID, "confirmed_at": nil}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	if err := r.replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *TwoFactorRepository) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, hashes []string) error {
+	_, err := r.sb.Delete("user_recovery_codes").
+		Where(sq.Eq{"user_id": userID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil || len(hashes) == 0 {
+		return err
+	}
+
+	ib := r.sb.Insert("user_recovery_codes").Columns("user_id", "code_hash")
+	for _, h := range hashes {
+		ib = ib.Values(userID, h)
+	}
+
+	_, err = ib.RunWith(tx).ExecContext(ctx)
+
+	return err
+}
+
+func (r *TwoFactorRepository) UseStep(userID string, step int64) (bool, error) {
+	return r.exec(r.sb.Update("user_totp").
+		Set("last_step", step).
+		Where(sq.Eq{"user_id": userID}).
+		Where(sq.Lt{"last_step": step}))
+}
+
+func (r *TwoFactorRepository) UseRecoveryCode(userID, hash string) (bool, error) {
+	return r.exec(r.sb.Update("user_recovery_codes").
+		Set("used_at", time.Now()).
+		Where(sq.Eq{"user_id": userID, "code_hash": hash, "used_at": nil}))
+}
+
+// exec runs a conditional update and reports whether it changed a row
+func (r *TwoFactorRepository) exec(ub sq.UpdateBuilder) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := ub.RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func (r *TwoFactorRepository) Delete(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"user_recovery_codes", "user_totp"} {
+		_, err := r.sb.Delete(table).
+			Where(sq.Eq{"user_id": userID}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MemoryTwoFactorRepository is an ITwoFactorRepository held in process
+// memory
+type MemoryTwoFactorRepository struct {
+	mu       sync.Mutex
+	enrolled map[string]*TwoFactor
+	// recovery maps each user to the hashes of their codes and whether
+	// they were used
+	recovery map[string]map[string]bool
+}
+
+func NewMemoryTwoFactorRepository() ITwoFactorRepository {
+	return &MemoryTwoFactorRepository{
+		enrolled: make(map[string]*TwoFactor),
+		recovery: make(map[string]map[string]bool),
+	}
+}
+
+func (r *MemoryTwoFactorRepository) Get(userID string) (*TwoFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.enrolled[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *t
+	c.Secret = append([]byte(nil), t.Secret...)
+
+	return &c, nil
+}
+
+func (r *MemoryTwoFactorRepository) Enroll(userID string, secret []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.enrolled[userID]; ok && t.Enabled() {
+		return ErrTwoFactorEnabled
+	}
+
+	r.enrolled[userID] = &TwoFactor{
+		UserID:    userID,
+		Secret:    append([]byte(nil), secret...),
+		CreatedAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (r *MemoryTwoFactorRepository) Confirm(userID string, step int64, recoveryHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.enrolled[userID]
+	if !ok || t.Enabled() {
+		return ErrTwoFactorEnabled
+	}
+
+	now := time.Now()
+	t.ConfirmedAt = &now
+	t.LastStep = step
+
+	codes := make(map[string]bool, len(recoveryHashes))
+	for _, h := range recoveryHashes {
+		codes[h] = false
+	}
+	r.recovery[userID] = codes
+
+	return nil
+}
+
+func (r *MemoryTwoFactorRepository) UseStep(userID string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.enrolled[userID]
+	if !ok || t.LastStep >= step {
+		return false, nil
+	}
+	t.LastStep = step
+
+	return true, nil
+}
+
+func (r *MemoryTwoFactorRepository) UseRecoveryCode(userID, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.recovery[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recovery[userID][hash] = true
+
+	return true, nil
+}
+
+func (r *MemoryTwoFactorRepository) Delete(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.enrolled, userID)
+	delete(r.recovery, userID)
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"net/mail"
+)
+
+const (
+	maxNameLength     = 255
+	minPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes
+	maxPasswordLength = 72
+)
+
+// FieldError reports a problem with one field of a submitted user
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidateUser checks the fields of a user submitted for creation
+func ValidateUser(u User) []FieldError {
+	errs := ValidateProfile(u)
+
+	if len(u.Password) < minPasswordLength {
+		errs = append(errs, FieldError{Field: "password", Message: "must be at least 8 characters"})
+	} else if len(u.Password) > maxPasswordLength {
+		errs = append(errs, FieldError{Field: "password", Message: "must be at most 72 bytes"})
+	}
+
+	return errs
+}
+
+// ValidateProfile checks the fields of a user that can be changed after
+// creation
+func ValidateProfile(u User) []FieldError {
+	var errs []FieldError
+
+	if u.Email == "" {
+		errs = append(errs, FieldError{Field: "email", Message: "is required"})
+	} else if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+		errs = append(errs, FieldError{Field: "email", Message: "is not a valid email address"})
+	}
+
+	if len(u.FirstName) > maxNameLength {
+		errs = append(errs, FieldError{Field: "first_name", Message: "is too long"})
+	}
+
+	if len(u.LastName) > maxNameLength {
+		errs = append(errs, FieldError{Field: "last_name", Message: "is too long"})
+	}
+
+	if u.Status != "" && !u.Status.Valid() {
+		errs = append(errs, FieldError{Field: "status", Message: "is not a valid status"})
+	}
+
+	return errs
+}
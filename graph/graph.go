@@ -0,0 +1,139 @@
+// Package graph serves the users API as GraphQL. Lists are exposed as Relay
+// connections, lookups by id are batched per request, and operations are
+// bounded in depth and complexity before they run.
+package graph
+
+import (
+	"context"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	defaultMaxDepth      = 10
+	defaultMaxComplexity = 1000
+)
+
+type Config struct {
+	Repo data.IRepository
+
+	// MaxDepth is the deepest field nesting an operation may have, and
+	// MaxComplexity the highest cost, where connections cost their page size
+	// times their selection. Defaults apply when they are zero
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type Schema struct {
+	schema        graphql.Schema
+	repo          data.IRepository
+	maxDepth      int
+	maxComplexity int
+}
+
+func (app *Config) NewSchema() (*Schema, error) {
+	s := &Schema{
+		repo:          app.Repo,
+		maxDepth:      app.MaxDepth,
+		maxComplexity: app.MaxComplexity,
+	}
+
+	if s.maxDepth <= 0 {
+		s.maxDepth = defaultMaxDepth
+	}
+
+	if s.maxComplexity <= 0 {
+		s.maxComplexity = defaultMaxComplexity
+	}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    s.queryType(),
+		Mutation: s.mutationType(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.schema = schema
+
+	return s, nil
+}
+
+// Do parses, validates, measures and runs one request. executed is false
+// when the request was rejected before it ran
+func (s *Schema) Do(ctx context.Context, req Request) (res *graphql.Result, executed bool) {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, false
+	}
+
+	if v := graphql.ValidateDocument(&s.schema, doc, nil); !v.IsValid {
+		return &graphql.Result{Errors: v.Errors}, false
+	}
+
+	if err := s.checkLimits(doc, req.OperationName, req.Variables); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, false
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       withLoader(ctx, newUserLoader(ctx, s.repository(ctx))),
+	}), true
+}
+
+// IsMutation reports whether req runs a mutation, so that callers can
+// authorize writes before running it. Requests that do not parse are not
+// mutations; Do rejects them
+func IsMutation(req Request) bool {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return false
+	}
+
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if req.OperationName == "" || (op.Name != nil && op.Name.Value == req.OperationName) {
+			return op.Operation == ast.OperationTypeMutation
+		}
+	}
+
+	return false
+}
+
+type repositoryKey struct{}
+
+// WithRepository makes the requests run with ctx use repo instead of the
+// repository of the schema, e.g. one scoped to the tenant of the request
+func WithRepository(ctx context.Context, repo data.IRepository) context.Context {
+	return context.WithValue(ctx, repositoryKey{}, repo)
+}
+
+func (s *Schema) repository(ctx context.Context) data.IRepository {
+	if repo, ok := ctx.Value(repositoryKey{}).(data.IRepository); ok {
+		return repo
+	}
+
+	return s.repo
+}
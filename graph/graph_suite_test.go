@@ -0,0 +1,54 @@
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/graph"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestGraph(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Graph Suite")
+}
+
+// countingRepo records the id batches passed to GetMany
+type countingRepo struct {
+	data.IRepository
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (r *countingRepo) GetMany(ctx context.Context, ids []string) ([]*data.User, []string, error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, ids)
+	r.mu.Unlock()
+
+	return r.IRepository.GetMany(ctx, ids)
+}
+
+func newTestSchema(repo data.IRepository) *graph.Schema {
+	s, err := (&graph.Config{Repo: repo}).NewSchema()
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return s
+}
+
+// do runs query and returns its result as plain JSON values
+func do(s *graph.Schema, query string, vars map[string]interface{}) map[string]interface{} {
+	res, _ := s.Do(context.Background(), graph.Request{Query: query, Variables: vars})
+
+	b, err := json.Marshal(res)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	var out map[string]interface{}
+	Expect(json.Unmarshal(b, &out)).To(Succeed())
+
+	return out
+}
@@ -0,0 +1,232 @@
+package graph_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/graph"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func errorCode(out map[string]interface{}) interface{} {
+	errs := out["errors"].([]interface{})
+	ext, _ := errs[0].(map[string]interface{})["extensions"].(map[string]interface{})
+
+	return ext["code"]
+}
+
+var _ = Describe("Schema", func() {
+
+	var (
+		repo   *countingRepo
+		schema *graph.Schema
+		ids    []string
+	)
+
+	BeforeEach(func() {
+		repo = &countingRepo{IRepository: data.NewMemoryRepository()}
+		schema = newTestSchema(repo)
+
+		ids = nil
+		for i := 0; i < 5; i++ {
+			id, err := repo.Insert(data.User{
+				Email:     fmt.Sprintf("user%d@example.com", i),
+				FirstName: "Ada",
+				LastName:  fmt.Sprintf("Lovelace%d", i),
+				Password:  "password",
+				Status:    data.StatusOf(i%2 == 1),
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			ids = append(ids, id)
+		}
+	})
+
+	Context("user", func() {
+
+		It("returns a user by id", func() {
+			out := do(schema, `query($id: ID!) { user(id: $id) { id email lastName active } }`,
+				map[string]interface{}{"id": ids[1]})
+
+			Expect(out).ShouldNot(HaveKey("errors"))
+			Expect(out["data"]).To(Equal(map[string]interface{}{
+				"user": map[string]interface{}{
+					"id": ids[1], "email": "user1@example.com", "lastName": "Lovelace1", "active": true,
+				},
+			}))
+		})
+
+		It("returns null for unknown users", func() {
+			out := do(schema, `{ user(id: "00000000-0000-0000-0000-000000000000") { id } }`, nil)
+
+			Expect(out).ShouldNot(HaveKey("errors"))
+			Expect(out["data"]).To(Equal(map[string]interface{}{"user": nil}))
+		})
+
+		It("batches lookups into one query", func() {
+			var fields []string
+			for i, id := range ids {
+				fields = append(fields, fmt.Sprintf(`u%d: user(id: %q) { email }`, i, id))
+			}
+
+			out := do(schema, "{ "+strings.Join(fields, " ")+" }", nil)
+
+			Expect(out).ShouldNot(HaveKey("errors"))
+			Expect(out["data"]).To(HaveLen(5))
+			Expect(repo.batches).To(HaveLen(1))
+			Expect(repo.batches[0]).To(ConsistOf(ids))
+		})
+	})
+
+	Context("users", func() {
+
+		It("pages through users with cursors", func() {
+			query := `query($after: String) {
+				users(first: 2, after: $after) {
+					totalCount
+					edges { cursor node { lastName } }
+					pageInfo { hasNextPage hasPreviousPage endCursor }
+				}
+			}`
+
+			var names []interface{}
+			var after interface{}
+			for page := 0; page < 3; page++ {
+				out := do(schema, query, map[string]interface{}{"after": after})
+				Expect(out).ShouldNot(HaveKey("errors"))
+
+				conn := out["data"].(map[string]interface{})["users"].(map[string]interface{})
+				Expect(conn["totalCount"]).To(BeNumerically("==", 5))
+
+				for _, e := range conn["edges"].([]interface{}) {
+					names = append(names, e.(map[string]interface{})["node"].(map[string]interface{})["lastName"])
+				}
+
+				info := conn["pageInfo"].(map[string]interface{})
+				Expect(info["hasPreviousPage"]).To(Equal(page > 0))
+				Expect(info["hasNextPage"]).To(Equal(page < 2))
+				after = info["endCursor"]
+			}
+
+			Expect(names).To(Equal([]interface{}{
+				"Lovelace0", "Lovelace1", "Lovelace2", "Lovelace3", "Lovelace4",
+			}))
+		})
+
+		It("filters by status and query", func() {
+			out := do(schema, `{
+				active: users(filter: {active: true}) { totalCount }
+				matched: users(filter: {query: "user3"}) { edges { node { email } } }
+			}`, nil)
+
+			Expect(out).ShouldNot(HaveKey("errors"))
+			result := out["data"].(map[string]interface{})
+			Expect(result["active"]).To(Equal(map[string]interface{}{"totalCount": float64(2)}))
+
+			edges := result["matched"].(map[string]interface{})["edges"].([]interface{})
+			Expect(edges).ToNot(BeEmpty())
+			Expect(edges[0]).To(Equal(map[string]interface{}{
+				"node": map[string]interface{}{"email": "user3@example.com"},
+			}))
+		})
+
+		It("rejects bad page sizes and cursors", func() {
+			Expect(errorCode(do(schema, `{ users(first: 101) { totalCount } }`, nil))).To(Equal("BAD_USER_INPUT"))
+			Expect(errorCode(do(schema, `{ users(after: "nope") { totalCount } }`, nil))).To(Equal("BAD_USER_INPUT"))
+		})
+	})
+
+	Context("mutations", func() {
+
+		It("creates, updates and deletes a user", func() {
+			out := do(schema, `mutation {
+				createUser(input: {email: "grace@example.com", firstName: "Grace", lastName: "Hopper", password: "password", active: true}) {
+					id email active
+				}
+			}`, nil)
+			Expect(out).ShouldNot(HaveKey("errors"))
+
+			created := out["data"].(map[string]interface{})["createUser"].(map[string]interface{})
+			Expect(created["email"]).To(Equal("grace@example.com"))
+			Expect(created["active"]).To(BeTrue())
+			id := created["id"].(string)
+
+			out = do(schema, `mutation($id: ID!) {
+				updateUser(id: $id, input: {email: "grace@example.com", lastName: "Murray"}) { lastName active }
+			}`, map[string]interface{}{"id": id})
+			Expect(out).ShouldNot(HaveKey("errors"))
+			Expect(out["data"]).To(Equal(map[string]interface{}{
+				"updateUser": map[string]interface{}{"lastName": "Murray", "active": false},
+			}))
+
+			out = do(schema, `mutation($id: ID!) { deleteUser(id: $id) }`, map[string]interface{}{"id": id})
+			Expect(out).ShouldNot(HaveKey("errors"))
+
+			_, err := repo.GetOne(id)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("reports invalid input with its fields", func() {
+			out := do(schema, `mutation {
+				createUser(input: {email: "not-an-email", firstName: "Grace", password: "short"}) { id }
+			}`, nil)
+
+			errs := out["errors"].([]interface{})
+			ext := errs[0].(map[string]interface{})["extensions"].(map[string]interface{})
+			Expect(ext["code"]).To(Equal("BAD_USER_INPUT"))
+
+			var fields []interface{}
+			for _, f := range ext["fields"].([]interface{}) {
+				fields = append(fields, f.(map[string]interface{})["field"])
+			}
+			Expect(fields).To(ContainElements("email", "password"))
+		})
+
+		It("reports conflicts and missing users", func() {
+			out := do(schema, `mutation {
+				createUser(input: {email: "user0@example.com", password: "password"}) { id }
+			}`, nil)
+			Expect(errorCode(out)).To(Equal("CONFLICT"))
+
+			out = do(schema, `mutation { deleteUser(id: "00000000-0000-0000-0000-000000000000") }`, nil)
+			Expect(errorCode(out)).To(Equal("NOT_FOUND"))
+		})
+	})
+
+	Context("limits", func() {
+
+		It("rejects queries nested too deeply", func() {
+			s, err := (&graph.Config{Repo: repo, MaxDepth: 2}).NewSchema()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			out := do(s, `{ users { edges { node { id } } } }`, nil)
+			Expect(out["data"]).To(BeNil())
+			Expect(out["errors"].([]interface{})[0]).To(HaveKeyWithValue("message", ContainSubstring("depth 4")))
+
+			out = do(s, `{ user(id: "x") { id } }`, nil)
+			Expect(out).ShouldNot(HaveKey("errors"))
+		})
+
+		It("counts page sizes towards complexity", func() {
+			s, err := (&graph.Config{Repo: repo, MaxComplexity: 50}).NewSchema()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			query := `query($n: Int) { users(first: $n) { edges { node { id email } } } }`
+
+			out := do(s, query, map[string]interface{}{"n": 100})
+			Expect(out["errors"].([]interface{})[0]).To(HaveKeyWithValue("message", ContainSubstring("complexity")))
+
+			out = do(s, query, map[string]interface{}{"n": 5})
+			Expect(out).ShouldNot(HaveKey("errors"))
+		})
+
+		It("follows fragments", func() {
+			s, err := (&graph.Config{Repo: repo, MaxDepth: 3}).NewSchema()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			out := do(s, `{ users { ...conn } } fragment conn on UserConnection { edges { node { id } } }`, nil)
+			Expect(out["errors"].([]interface{})[0]).To(HaveKeyWithValue("message", ContainSubstring("depth")))
+		})
+	})
+})
@@ -0,0 +1,164 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// checkLimits rejects the selected operation of doc when it nests deeper
+// than maxDepth or costs more than maxComplexity. A field costs one plus the
+// cost of its selection, multiplied by its first argument when it has one,
+// so a page of users costs its size times the fields read from each user.
+// Introspection fields are free
+func (s *Schema) checkLimits(doc *ast.Document, opName string, vars map[string]interface{}) error {
+	var op *ast.OperationDefinition
+	fragments := make(map[string]*ast.FragmentDefinition)
+
+	for _, def := range doc.Definitions {
+		switch d := def.(type) {
+		case *ast.OperationDefinition:
+			if opName == "" || (d.Name != nil && d.Name.Value == opName) {
+				if op == nil {
+					op = d
+				}
+			}
+		case *ast.FragmentDefinition:
+			fragments[d.Name.Value] = d
+		}
+	}
+
+	if op == nil {
+		// Execute reports the missing operation
+		return nil
+	}
+
+	root := s.schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = s.schema.MutationType()
+	}
+
+	m := measurer{fragments: fragments, vars: vars, seen: make(map[string]bool)}
+	depth, cost := m.selectionSet(op.SelectionSet, root, 1)
+
+	if depth > s.maxDepth {
+		return fmt.Errorf("query depth %d exceeds the maximum of %d", depth, s.maxDepth)
+	}
+
+	if cost > s.maxComplexity {
+		return fmt.Errorf("query complexity %d exceeds the maximum of %d", cost, s.maxComplexity)
+	}
+
+	return nil
+}
+
+type measurer struct {
+	fragments map[string]*ast.FragmentDefinition
+	vars      map[string]interface{}
+	// seen guards against fragment cycles, which validation rejects anyway
+	seen map[string]bool
+}
+
+// selectionSet returns the depth and cost of set, whose fields are read from
+// parent and sit at the given level
+func (m *measurer) selectionSet(set *ast.SelectionSet, parent graphql.Type, level int) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	depth, cost := 0, 0
+	for _, sel := range set.Selections {
+		var d, c int
+
+		switch sel := sel.(type) {
+		case *ast.Field:
+			d, c = m.field(sel, parent, level)
+		case *ast.InlineFragment:
+			d, c = m.selectionSet(sel.SelectionSet, parent, level)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := m.fragments[name]
+			if !ok || m.seen[name] {
+				continue
+			}
+
+			m.seen[name] = true
+			d, c = m.selectionSet(frag.SelectionSet, parent, level)
+			delete(m.seen, name)
+		}
+
+		if d > depth {
+			depth = d
+		}
+		cost += c
+	}
+
+	return depth, cost
+}
+
+func (m *measurer) field(f *ast.Field, parent graphql.Type, level int) (int, int) {
+	if strings.HasPrefix(f.Name.Value, "__") {
+		return 0, 0
+	}
+
+	obj, _ := parent.(*graphql.Object)
+	if obj == nil {
+		return level, 1
+	}
+
+	def, ok := obj.Fields()[f.Name.Value]
+	if !ok {
+		return level, 1
+	}
+
+	child, _ := graphql.GetNamed(def.Type).(graphql.Type)
+	depth, cost := m.selectionSet(f.SelectionSet, child, level+1)
+	if depth < level {
+		depth = level
+	}
+
+	return depth, 1 + m.multiplier(f, def)*cost
+}
+
+// multiplier is the value of the first argument of f, falling back to its
+// schema default, or 1 for fields that are not paginated
+func (m *measurer) multiplier(f *ast.Field, def *graphql.FieldDefinition) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			switch n := m.vars[v.Name.Value].(type) {
+			case float64:
+				if n > 0 {
+					return int(n)
+				}
+			case int:
+				if n > 0 {
+					return n
+				}
+			}
+		}
+
+		return 1
+	}
+
+	for _, a := range def.Args {
+		if a.Name() == "first" {
+			if n, ok := a.DefaultValue.(int); ok && n > 0 {
+				return n
+			}
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,100 @@
+package graph
+
+import (
+	"context"
+	"sync"
+
+	"github.com/danielboakye/go-echo-app/data"
+)
+
+type loaderKey struct{}
+
+// userLoader batches the user lookups of one request. Resolvers register
+// ids with Load and return the thunk it gives them; the executor resolves
+// every field of a level before calling thunks, so the first thunk called
+// fetches all ids registered so far with a single GetMany
+type userLoader struct {
+	ctx  context.Context
+	repo data.IRepository
+
+	mu      sync.Mutex
+	pending []string
+	loaded  map[string]*data.User
+	errs    map[string]error
+}
+
+func newUserLoader(ctx context.Context, repo data.IRepository) *userLoader {
+	return &userLoader{
+		ctx:    ctx,
+		repo:   repo,
+		loaded: make(map[string]*data.User),
+		errs:   make(map[string]error),
+	}
+}
+
+func withLoader(ctx context.Context, l *userLoader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, l)
+}
+
+func loaderFrom(ctx context.Context) *userLoader {
+	return ctx.Value(loaderKey{}).(*userLoader)
+}
+
+// Load queues id and returns a thunk yielding its user, or nil when there is
+// no such user
+func (l *userLoader) Load(id string) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, ok := l.loaded[id]; !ok {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.dispatch()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if err := l.errs[id]; err != nil {
+			return nil, err
+		}
+
+		if u := l.loaded[id]; u != nil {
+			return u, nil
+		}
+
+		return nil, nil
+	}
+}
+
+// Forget drops id from the cache after the user has changed
+func (l *userLoader) Forget(id string) {
+	l.mu.Lock()
+	delete(l.loaded, id)
+	delete(l.errs, id)
+	l.mu.Unlock()
+}
+
+func (l *userLoader) dispatch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.pending) == 0 {
+		return
+	}
+
+	ids := l.pending
+	l.pending = nil
+
+	users, _, err := l.repo.GetMany(l.ctx, ids)
+	for _, id := range ids {
+		l.loaded[id] = nil
+		if err != nil {
+			l.errs[id] = err
+		}
+	}
+
+	for _, u := range users {
+		l.loaded[u.ID] = u
+	}
+}
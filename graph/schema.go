@@ -0,0 +1,407 @@
+package graph
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/graphql-go/graphql"
+)
+
+// actor is who status changes made through GraphQL are recorded as made by
+const actor = "graphql"
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	cursorPrefix    = "offset:"
+)
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":        userField(graphql.NewNonNull(graphql.ID), func(u *data.User) interface{} { return u.ID }),
+		"email":     userField(graphql.NewNonNull(graphql.String), func(u *data.User) interface{} { return u.Email }),
+		"firstName": userField(graphql.String, func(u *data.User) interface{} { return u.FirstName }),
+		"lastName":  userField(graphql.String, func(u *data.User) interface{} { return u.LastName }),
+		"active":    userField(graphql.NewNonNull(graphql.Boolean), func(u *data.User) interface{} { return u.Status == data.StatusActive }),
+		"status":    userField(graphql.NewNonNull(graphql.String), func(u *data.User) interface{} { return string(u.Status) }),
+		"createdAt": userField(graphql.NewNonNull(graphql.DateTime), func(u *data.User) interface{} { return u.CreatedAt }),
+		"updatedAt": userField(graphql.NewNonNull(graphql.DateTime), func(u *data.User) interface{} { return u.UpdatedAt }),
+	},
+})
+
+func userField(t graphql.Output, get func(*data.User) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*data.User)), nil
+		},
+	}
+}
+
+var pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: graphql.Fields{
+		"hasNextPage":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"hasPreviousPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"startCursor":     &graphql.Field{Type: graphql.String},
+		"endCursor":       &graphql.Field{Type: graphql.String},
+	},
+})
+
+var userEdgeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "UserEdge",
+	Fields: graphql.Fields{
+		"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"node":   &graphql.Field{Type: graphql.NewNonNull(userType)},
+	},
+})
+
+var userConnectionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "UserConnection",
+	Fields: graphql.Fields{
+		"edges":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userEdgeType)))},
+		"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var userFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"query":  &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Partial or fuzzy match on names and email"},
+		"active": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		"status": &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var createUserInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "CreateUserInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"email":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"firstName": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"lastName":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"password":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"active":    &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+	},
+})
+
+var updateUserInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UpdateUserInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"email":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"firstName": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"lastName":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"active":    &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+	},
+})
+
+func (s *Schema) queryType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaderFrom(p.Context).Load(p.Args["id"].(string)), nil
+				},
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewNonNull(userConnectionType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: userFilterType},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: s.resolveUsers,
+			},
+		},
+	})
+}
+
+func (s *Schema) mutationType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(createUserInputType)},
+				},
+				Resolve: s.createUser,
+			},
+			"updateUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(updateUserInputType)},
+				},
+				Resolve: s.updateUser,
+			},
+			"deleteUser": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "Deletes a user and returns its id",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: s.deleteUser,
+			},
+		},
+	})
+}
+
+func (s *Schema) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	first, _ := p.Args["first"].(int)
+	if first < 1 || first > maxPageSize {
+		return nil, badInput(data.FieldError{Field: "first", Message: fmt.Sprintf("must be between 1 and %d", maxPageSize)})
+	}
+
+	offset := 0
+	if after, ok := p.Args["after"].(string); ok {
+		n, err := decodeCursor(after)
+		if err != nil {
+			return nil, badInput(data.FieldError{Field: "after", Message: "is not a valid cursor"})
+		}
+		offset = n + 1
+	}
+
+	filter, _ := p.Args["filter"].(map[string]interface{})
+	query, _ := filter["query"].(string)
+
+	opts := data.SearchOptions{Limit: first, Offset: offset}
+	if b, ok := filter["active"].(bool); ok {
+		opts.Active = &b
+	}
+	if status, ok := filter["status"].(string); ok {
+		opts.Status = data.Status(status)
+		if !opts.Status.Valid() {
+			return nil, badInput(data.FieldError{Field: "status", Message: "is not a valid status"})
+		}
+	}
+
+	var (
+		page  []*data.User
+		total int
+	)
+
+	if query = strings.TrimSpace(query); query != "" {
+		opts.Query = query
+		results, n, err := s.repository(p.Context).Search(opts)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		for _, r := range results {
+			u := r.User
+			page = append(page, &u)
+		}
+		total = n
+	} else {
+		users, err := s.repository(p.Context).GetAll()
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		var matched []*data.User
+		for _, u := range users {
+			if opts.Filters(u) {
+				matched = append(matched, u)
+			}
+		}
+
+		total = len(matched)
+		if offset < len(matched) {
+			page = matched[offset:]
+		}
+		if len(page) > first {
+			page = page[:first]
+		}
+	}
+
+	edges := make([]interface{}, len(page))
+	for i, u := range page {
+		edges[i] = map[string]interface{}{"cursor": encodeCursor(offset + i), "node": u}
+	}
+
+	pageInfo := map[string]interface{}{
+		"hasNextPage":     offset+len(page) < total,
+		"hasPreviousPage": offset > 0,
+	}
+	if len(page) > 0 {
+		pageInfo["startCursor"] = encodeCursor(offset)
+		pageInfo["endCursor"] = encodeCursor(offset + len(page) - 1)
+	}
+
+	return map[string]interface{}{
+		"edges":      edges,
+		"pageInfo":   pageInfo,
+		"totalCount": total,
+	}, nil
+}
+
+func (s *Schema) createUser(p graphql.ResolveParams) (interface{}, error) {
+	in := p.Args["input"].(map[string]interface{})
+
+	u := data.User{Password: stringArg(in, "password")}
+	setProfile(&u, in)
+
+	active, _ := in["active"].(bool)
+	u.Status = data.StatusOf(active)
+
+	if errs := data.ValidateUser(u); len(errs) > 0 {
+		return nil, badInput(errs...)
+	}
+
+	id, err := s.repository(p.Context).Insert(u)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return loaderFrom(p.Context).Load(id), nil
+}
+
+func (s *Schema) updateUser(p graphql.ResolveParams) (interface{}, error) {
+	id := p.Args["id"].(string)
+
+	u, err := s.repository(p.Context).GetOne(id)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	in := p.Args["input"].(map[string]interface{})
+	setProfile(u, in)
+
+	if errs := data.ValidateProfile(*u); len(errs) > 0 {
+		return nil, badInput(errs...)
+	}
+
+	active, _ := in["active"].(bool)
+	if _, err := u.Status.WithActive(active); err != nil {
+		return nil, mapError(err)
+	}
+
+	if err := s.repository(p.Context).Update(*u); err != nil {
+		return nil, mapError(err)
+	}
+
+	if err := data.SetActive(s.repository(p.Context), u, active, actor); err != nil {
+		return nil, mapError(err)
+	}
+
+	loader := loaderFrom(p.Context)
+	loader.Forget(id)
+
+	return loader.Load(id), nil
+}
+
+func (s *Schema) deleteUser(p graphql.ResolveParams) (interface{}, error) {
+	id := p.Args["id"].(string)
+
+	if _, err := s.repository(p.Context).GetOne(id); err != nil {
+		return nil, mapError(err)
+	}
+
+	if err := s.repository(p.Context).DeleteByID(id); err != nil {
+		return nil, mapError(err)
+	}
+	loaderFrom(p.Context).Forget(id)
+
+	return id, nil
+}
+
+// setProfile copies the editable fields of a mutation input onto u
+func setProfile(u *data.User, in map[string]interface{}) {
+	u.Email = stringArg(in, "email")
+	u.FirstName = stringArg(in, "firstName")
+	u.LastName = stringArg(in, "lastName")
+}
+
+func stringArg(in map[string]interface{}, name string) string {
+	s, _ := in[name].(string)
+	return s
+}
+
+// cursors are opaque to clients but only carry the offset of an edge
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	if !strings.HasPrefix(string(b), cursorPrefix) {
+		return 0, errors.New("invalid cursor")
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), cursorPrefix))
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+
+	return n, nil
+}
+
+// Error is a resolver error whose code and invalid fields are reported in
+// the extensions of the GraphQL error
+type Error struct {
+	Message string
+	Code    string
+	Fields  []data.FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if len(e.Fields) > 0 {
+		ext["fields"] = e.Fields
+	}
+
+	return ext
+}
+
+// graphQLFieldNames maps the field names of data.FieldError to the names
+// used by the schema
+var graphQLFieldNames = map[string]string{
+	"first_name": "firstName",
+	"last_name":  "lastName",
+}
+
+func badInput(errs ...data.FieldError) error {
+	fields := make([]data.FieldError, len(errs))
+	for i, fe := range errs {
+		if name, ok := graphQLFieldNames[fe.Field]; ok {
+			fe.Field = name
+		}
+		fields[i] = fe
+	}
+
+	return &Error{Message: "invalid input", Code: "BAD_USER_INPUT", Fields: fields}
+}
+
+// mapError hides storage errors, which may leak details, behind a generic
+// message
+func mapError(err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return &Error{Message: "user not found", Code: "NOT_FOUND"}
+	case errors.Is(err, data.ErrDuplicateEmail):
+		return &Error{Message: "user exists", Code: "CONFLICT"}
+	case errors.Is(err, data.ErrInvalidTransition), errors.Is(err, data.ErrStatusConflict):
+		return &Error{Message: err.Error(), Code: "CONFLICT"}
+	}
+
+	return &Error{Message: "processing error", Code: "INTERNAL"}
+}
@@ -0,0 +1,13 @@
+package mail_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestMail(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mail Suite")
+}
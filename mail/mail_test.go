@@ -0,0 +1,150 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/mail"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// relay is an SMTP server that accepts one message per connection and
+// hands over the envelope and data of the messages it receives
+type relay struct {
+	ln       net.Listener
+	messages chan []string
+}
+
+func newRelay() *relay {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ShouldNot(HaveOccurred())
+
+	r := &relay{ln: ln, messages: make(chan []string, 1)}
+	go r.serve()
+
+	return r
+}
+
+func (r *relay) serve() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *relay) handle(conn net.Conn) {
+	defer conn.Close()
+
+	in := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	var got []string
+	reply("220 relay ready")
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO", "HELO":
+			reply("250 relay")
+		case "MAIL", "RCPT":
+			got = append(got, line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				l, err := in.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				got = append(got, strings.TrimSuffix(l, "\r\n"))
+			}
+			r.messages <- got
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+var _ = Describe("SMTP", func() {
+	var r *relay
+
+	BeforeEach(func() {
+		r = newRelay()
+		DeferCleanup(r.ln.Close)
+	})
+
+	It("should deliver a plain text message to the relay", func() {
+		m := mail.NewSMTP(r.ln.Addr().String(), "security@example.com", "", "")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		Expect(m.Send(ctx, mail.Message{
+			To:      "clark@mail.com",
+			Subject: "New sign-in to your account",
+			Body:    "Someone signed in.\nWas it you?",
+		})).To(Succeed())
+
+		var got []string
+		Eventually(r.messages).Should(Receive(&got))
+		Expect(got).To(ContainElements(
+			"MAIL FROM:<security@example.com>",
+			"RCPT TO:<clark@mail.com>",
+			"From: security@example.com",
+			"To: clark@mail.com",
+			"Subject: New sign-in to your account",
+			"Content-Type: text/plain; charset=utf-8",
+			"Someone signed in.",
+			"Was it you?",
+		))
+	})
+
+	It("should refuse headers spanning several lines", func() {
+		m := mail.NewSMTP(r.ln.Addr().String(), "security@example.com", "", "")
+
+		err := m.Send(context.Background(), mail.Message{
+			To:      "clark@mail.com\r\nBcc: everyone@mail.com",
+			Subject: "hello",
+		})
+		Expect(err).To(MatchError(mail.ErrInvalidHeader))
+		Consistently(r.messages, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should not send credentials to a relay that takes none", func() {
+		m := mail.NewSMTP(r.ln.Addr().String(), "security@example.com", "user", "secret")
+
+		err := m.Send(context.Background(), mail.Message{To: "clark@mail.com", Subject: "hello"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Memory", func() {
+	It("should keep the messages it is given", func() {
+		m := mail.NewMemory()
+		Expect(m.Send(context.Background(), mail.Message{To: "clark@mail.com", Subject: "one"})).To(Succeed())
+		Expect(m.Send(context.Background(), mail.Message{To: "lois@mail.com", Subject: "two"})).To(Succeed())
+
+		Expect(m.Sent()).To(Equal([]mail.Message{
+			{To: "clark@mail.com", Subject: "one"},
+			{To: "lois@mail.com", Subject: "two"},
+		}))
+	})
+})
@@ -0,0 +1,109 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/oidc"
+	"github.com/danielboakye/go-echo-app/oidc/oidctest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Provider", func() {
+
+	var (
+		server   *oidctest.Server
+		provider *oidc.Provider
+		ctx      = context.Background()
+	)
+
+	BeforeEach(func() {
+		server = oidctest.NewServer("client", "secret")
+		DeferCleanup(server.Close)
+
+		provider = &oidc.Provider{
+			Issuer:       server.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://app.test/callback",
+		}
+	})
+
+	// authorize follows the authorization URL to the code the provider
+	// sends back
+	authorize := func(state, nonce, challenge string) string {
+		target, err := provider.AuthCodeURL(ctx, state, nonce, challenge)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		res, err := client.Get(target)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusFound))
+
+		back, err := url.Parse(res.Header.Get("Location"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(back.Query().Get("state")).To(Equal(state))
+
+		return back.Query().Get("code")
+	}
+
+	It("should run the authorization code flow with PKCE", func() {
+		server.SignIn(map[string]interface{}{"sub": "u-1", "email": "clark@mail.com", "email_verified": true})
+
+		verifier, challenge, err := oidc.NewPKCE()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		code := authorize("state", "nonce", challenge)
+
+		_, err = provider.Exchange(ctx, code, "wrong verifier", "nonce")
+		Expect(err).To(HaveOccurred())
+
+		code = authorize("state", "nonce", challenge)
+		claims, err := provider.Exchange(ctx, code, verifier, "nonce")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(claims.Subject).To(Equal("u-1"))
+		Expect(claims.Verified()).To(BeTrue())
+
+		_, err = provider.Exchange(ctx, code, verifier, "nonce")
+		Expect(err).To(HaveOccurred(), "codes are redeemed once")
+	})
+
+	It("should read email_verified sent as a string", func() {
+		claims, err := provider.Verify(ctx, server.IDToken(map[string]interface{}{
+			"sub": "u-1", "nonce": "n", "email": "clark@mail.com", "email_verified": "true",
+		}), "n")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(claims.Verified()).To(BeTrue())
+	})
+
+	DescribeTable("should reject invalid ID tokens",
+		func(claims map[string]interface{}, nonce string) {
+			_, err := provider.Verify(ctx, server.IDToken(claims), nonce)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("for another audience", map[string]interface{}{"sub": "u-1", "nonce": "n", "aud": "other"}, "n"),
+		Entry("from another issuer", map[string]interface{}{"sub": "u-1", "nonce": "n", "iss": "https://evil.test"}, "n"),
+		Entry("when expired", map[string]interface{}{"sub": "u-1", "nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()}, "n"),
+		Entry("with another nonce", map[string]interface{}{"sub": "u-1", "nonce": "n"}, "other"),
+		Entry("without a subject", map[string]interface{}{"nonce": "n"}, "n"),
+	)
+
+	It("should reject tokens signed with other keys", func() {
+		other := oidctest.NewServer("client", "secret")
+		defer other.Close()
+
+		_, err := provider.Verify(ctx, other.IDToken(map[string]interface{}{"sub": "u-1", "nonce": "n", "iss": server.URL}), "n")
+		Expect(err).To(MatchError(oidc.ErrInvalidIDToken))
+	})
+
+	It("should refuse providers whose discovery names another issuer", func() {
+		provider.Issuer = server.URL + "/"
+
+		_, err := provider.AuthCodeURL(ctx, "state", "nonce", "challenge")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,207 @@
+// Package openapi loads OpenAPI 3.1 documents and validates requests and
+// responses against the subset of JSON Schema this service's spec uses
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Components struct {
+	Schemas   map[string]*Schema   `json:"schemas"`
+	Responses map[string]*Response `json:"responses"`
+}
+
+// PathItem holds the operations of one path keyed by lower case method
+type PathItem struct {
+	Parameters []*Parameter
+	Operations map[string]*Operation
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Load parses an OpenAPI document and checks that its references resolve
+func Load(raw []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", doc.OpenAPI)
+	}
+
+	for path, item := range doc.Paths {
+		for method, op := range item.Operations {
+			if err := doc.checkRefs(op); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+		}
+	}
+
+	return &doc, nil
+}
+
+func (p *PathItem) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	p.Operations = make(map[string]*Operation)
+	for _, m := range methods {
+		if msg, ok := raw[m]; ok {
+			var op Operation
+			if err := json.Unmarshal(msg, &op); err != nil {
+				return err
+			}
+			p.Operations[m] = &op
+		}
+	}
+
+	if msg, ok := raw["parameters"]; ok {
+		return json.Unmarshal(msg, &p.Parameters)
+	}
+
+	return nil
+}
+
+// Operation returns the operation documented for method on the templated
+// path, e.g. "/users/{id}", or nil
+func (d *Document) Operation(method, path string) *Operation {
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil
+	}
+
+	op, ok := item.Operations[strings.ToLower(method)]
+	if !ok {
+		return nil
+	}
+
+	if len(item.Parameters) > 0 {
+		merged := *op
+		merged.Parameters = append(append([]*Parameter{}, item.Parameters...), op.Parameters...)
+		return &merged
+	}
+
+	return op
+}
+
+// Resolve follows the $ref of s, if any
+func (d *Document) Resolve(s *Schema) (*Schema, error) {
+	for s != nil && s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		next, ok := d.Components.Schemas[name]
+		if !ok || name == s.Ref {
+			return nil, fmt.Errorf("unresolved reference %q", s.Ref)
+		}
+		s = next
+	}
+
+	return s, nil
+}
+
+func (d *Document) resolveResponse(r *Response) (*Response, error) {
+	if r == nil || r.Ref == "" {
+		return r, nil
+	}
+
+	name := strings.TrimPrefix(r.Ref, "#/components/responses/")
+	res, ok := d.Components.Responses[name]
+	if !ok || name == r.Ref || res.Ref != "" {
+		return nil, fmt.Errorf("unresolved reference %q", r.Ref)
+	}
+
+	return res, nil
+}
+
+func (d *Document) checkRefs(op *Operation) error {
+	var schemas []*Schema
+	for _, p := range op.Parameters {
+		schemas = append(schemas, p.Schema)
+	}
+
+	if op.RequestBody != nil {
+		for _, mt := range op.RequestBody.Content {
+			schemas = append(schemas, mt.Schema)
+		}
+	}
+
+	for _, res := range op.Responses {
+		res, err := d.resolveResponse(res)
+		if err != nil {
+			return err
+		}
+
+		for _, mt := range res.Content {
+			schemas = append(schemas, mt.Schema)
+		}
+	}
+
+	for _, s := range schemas {
+		if err := d.walk(s, map[*Schema]bool{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Document) walk(s *Schema, seen map[*Schema]bool) error {
+	s, err := d.Resolve(s)
+	if err != nil || s == nil || seen[s] {
+		return err
+	}
+	seen[s] = true
+
+	children := []*Schema{s.Items}
+	children = append(children, s.OneOf...)
+	for _, p := range s.Properties {
+		children = append(children, p)
+	}
+
+	for _, c := range children {
+		if err := d.walk(c, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package openapi_test
+
+import (
+	"net/http/httptest"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/openapi"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const testSpec = `{
+	"openapi": "3.1.0",
+	"paths": {
+		"/users/{id}": {
+			"parameters": [
+				{ "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }
+			],
+			"post": {
+				"parameters": [
+					{ "name": "limit", "in": "query", "schema": { "type": "integer", "minimum": 1, "maximum": 100 } }
+				],
+				"requestBody": {
+					"required": true,
+					"content": { "application/json": { "schema": { "$ref": "#/components/schemas/User" } } }
+				},
+				"responses": {
+					"200": { "description": "ok", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/User" } } } },
+					"202": { "description": "accepted" }
+				}
+			}
+		}
+	},
+	"components": {
+		"schemas": {
+			"User": {
+				"type": "object",
+				"required": ["email"],
+				"properties": {
+					"email": { "type": "string", "format": "email" },
+					"active": { "type": "integer", "enum": [0, 1] },
+					"tags": { "type": ["array", "null"], "items": { "type": "string", "maxLength": 3 } }
+				},
+				"additionalProperties": false
+			}
+		}
+	}
+}`
+
+const validID = "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"
+
+var _ = Describe("Load", func() {
+	It("should reject unresolved references", func() {
+		_, err := openapi.Load([]byte(`{
+			"openapi": "3.1.0",
+			"paths": { "/users": { "get": { "responses": { "200": {
+				"description": "ok",
+				"content": { "application/json": { "schema": { "$ref": "#/components/schemas/Missing" } } }
+			} } } } }
+		}`))
+		Expect(err).To(MatchError(ContainSubstring(`unresolved reference "#/components/schemas/Missing"`)))
+	})
+
+	It("should reject unresolved response references", func() {
+		_, err := openapi.Load([]byte(`{
+			"openapi": "3.1.0",
+			"paths": { "/users": { "get": { "responses": { "400": { "$ref": "#/components/responses/Missing" } } } } }
+		}`))
+		Expect(err).To(MatchError(ContainSubstring(`unresolved reference "#/components/responses/Missing"`)))
+	})
+
+	It("should reject other versions", func() {
+		_, err := openapi.Load([]byte(`{"openapi": "2.0"}`))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Document", func() {
+
+	var doc *openapi.Document
+
+	BeforeEach(func() {
+		var err error
+		doc, err = openapi.Load([]byte(testSpec))
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	Describe("Operation", func() {
+		It("should merge path level parameters", func() {
+			op := doc.Operation("POST", "/users/{id}")
+			Expect(op).NotTo(BeNil())
+			Expect(op.Parameters).To(HaveLen(2))
+			Expect(op.Parameters[0].Name).To(Equal("id"))
+		})
+
+		It("should return nil for undocumented operations", func() {
+			Expect(doc.Operation("GET", "/users/{id}")).To(BeNil())
+			Expect(doc.Operation("POST", "/users")).To(BeNil())
+		})
+	})
+
+	Describe("ValidateRequest", func() {
+		validate := func(id, query, contentType, body string) []openapi.ValidationError {
+			r := httptest.NewRequest("POST", "/users/"+id+query, strings.NewReader(body))
+			r.Header.Set("Content-Type", contentType)
+			return doc.ValidateRequest(doc.Operation("POST", "/users/{id}"), r, map[string]string{"id": id}, []byte(body))
+		}
+
+		It("should accept a valid request", func() {
+			Expect(validate(validID, "?limit=10", "application/json", `{"email":"clark@mail.com","active":1,"tags":null}`)).To(BeEmpty())
+		})
+
+		It("should check path and query parameters", func() {
+			Expect(validate("42", "?limit=1000", "application/json", `{"email":"clark@mail.com"}`)).To(ConsistOf(
+				openapi.ValidationError{Field: "path.id", Message: "is not a valid UUID"},
+				openapi.ValidationError{Field: "query.limit", Message: "must be at most 100"},
+			))
+
+			Expect(validate(validID, "?limit=ten", "application/json", `{"email":"clark@mail.com"}`)).To(ConsistOf(
+				openapi.ValidationError{Field: "query.limit", Message: "must be of type integer"},
+			))
+		})
+
+		It("should check the body", func() {
+			Expect(validate(validID, "", "application/json", `{"active":2,"tags":["abcd"],"extra":true}`)).To(ConsistOf(
+				openapi.ValidationError{Field: "body.email", Message: "is required"},
+				openapi.ValidationError{Field: "body.active", Message: "must be one of [0 1]"},
+				openapi.ValidationError{Field: "body.extra", Message: "is not allowed"},
+				openapi.ValidationError{Field: "body.tags[0]", Message: "must be at most 3 characters"},
+			))
+		})
+
+		It("should require a body in a documented content type", func() {
+			Expect(validate(validID, "", "application/json", "")).To(ConsistOf(
+				openapi.ValidationError{Field: "body", Message: "is required"},
+			))
+
+			Expect(validate(validID, "", "text/plain", "hello")).To(ConsistOf(
+				openapi.ValidationError{Field: "body", Message: "has an unsupported content type"},
+			))
+
+			Expect(validate(validID, "", "application/json", "{")).To(ConsistOf(
+				openapi.ValidationError{Field: "body", Message: "is not valid JSON"},
+			))
+		})
+	})
+
+	Describe("ValidateResponse", func() {
+		It("should check the status, content type and body", func() {
+			op := doc.Operation("POST", "/users/{id}")
+
+			Expect(doc.ValidateResponse(op, 200, "application/json; charset=UTF-8", []byte(`{"email":"clark@mail.com"}`))).To(BeEmpty())
+			Expect(doc.ValidateResponse(op, 202, "", nil)).To(BeEmpty())
+
+			Expect(doc.ValidateResponse(op, 200, "application/json", []byte(`{"email":"clark"}`))).To(ConsistOf(
+				openapi.ValidationError{Field: "body.email", Message: "is not a valid email address"},
+			))
+			Expect(doc.ValidateResponse(op, 500, "application/json", nil)).To(ConsistOf(
+				openapi.ValidationError{Field: "status", Message: "500 is not documented"},
+			))
+			Expect(doc.ValidateResponse(op, 200, "text/html", []byte("<p>"))).To(HaveLen(1))
+		})
+	})
+})
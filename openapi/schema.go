@@ -0,0 +1,258 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Schema is the subset of JSON Schema 2020-12 understood by the validator
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	WriteOnly            bool               `json:"writeOnly,omitempty"`
+}
+
+// Types is the "type" keyword, which may be a single name or a list
+type Types []string
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*t = many
+
+	return nil
+}
+
+// ValidationError describes one value that does not match its schema. Field
+// is the location of the value, e.g. "body.email" or "query.limit"
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Validate checks a decoded JSON value against s. at names the value in the
+// returned errors
+func (d *Document) Validate(s *Schema, v interface{}, at string) []ValidationError {
+	s, err := d.Resolve(s)
+	if err != nil {
+		return []ValidationError{{Field: at, Message: err.Error()}}
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, alt := range s.OneOf {
+			if len(d.Validate(alt, v, at)) == 0 {
+				matches++
+			}
+		}
+
+		if matches != 1 {
+			return []ValidationError{{Field: at, Message: "must match exactly one schema"}}
+		}
+	}
+
+	if len(s.Type) > 0 && !s.Type.allows(v) {
+		return []ValidationError{{Field: at, Message: "must be of type " + strings.Join(s.Type, " or ")}}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return []ValidationError{{Field: at, Message: fmt.Sprintf("must be one of %v", s.Enum)}}
+	}
+
+	switch val := v.(type) {
+	case string:
+		return s.validateString(val, at)
+	case float64:
+		return s.validateNumber(val, at)
+	case []interface{}:
+		return d.validateArray(s, val, at)
+	case map[string]interface{}:
+		return d.validateObject(s, val, at)
+	}
+
+	return nil
+}
+
+func (t Types) allows(v interface{}) bool {
+	for _, name := range t {
+		switch val := v.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && val == math.Trunc(val)) {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Schema) validateString(v, at string) []ValidationError {
+	var errs []ValidationError
+
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		errs = append(errs, ValidationError{Field: at, Message: fmt.Sprintf("must be at least %d characters", *s.MinLength)})
+	}
+
+	if s.MaxLength != nil && n > *s.MaxLength {
+		errs = append(errs, ValidationError{Field: at, Message: fmt.Sprintf("must be at most %d characters", *s.MaxLength)})
+	}
+
+	switch s.Format {
+	case "email":
+		if addr, err := mail.ParseAddress(v); err != nil || addr.Address != v {
+			errs = append(errs, ValidationError{Field: at, Message: "is not a valid email address"})
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(v) {
+			errs = append(errs, ValidationError{Field: at, Message: "is not a valid UUID"})
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+			errs = append(errs, ValidationError{Field: at, Message: "is not a valid RFC 3339 date-time"})
+		}
+	}
+
+	return errs
+}
+
+func (s *Schema) validateNumber(v float64, at string) []ValidationError {
+	var errs []ValidationError
+
+	if s.Minimum != nil && v < *s.Minimum {
+		errs = append(errs, ValidationError{Field: at, Message: fmt.Sprintf("must be at least %v", *s.Minimum)})
+	}
+
+	if s.Maximum != nil && v > *s.Maximum {
+		errs = append(errs, ValidationError{Field: at, Message: fmt.Sprintf("must be at most %v", *s.Maximum)})
+	}
+
+	return errs
+}
+
+func (d *Document) validateArray(s *Schema, v []interface{}, at string) []ValidationError {
+	var errs []ValidationError
+
+	if s.MinItems != nil && len(v) < *s.MinItems {
+		errs = append(errs, ValidationError{Field: at, Message: fmt.Sprintf("must have at least %d items", *s.MinItems)})
+	}
+
+	if s.MaxItems != nil && len(v) > *s.MaxItems {
+		errs = append(errs, ValidationError{Field: at, Message: fmt.Sprintf("must have at most %d items", *s.MaxItems)})
+	}
+
+	if s.Items != nil {
+		for i, item := range v {
+			errs = append(errs, d.Validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	}
+
+	return errs
+}
+
+func (d *Document) validateObject(s *Schema, v map[string]interface{}, at string) []ValidationError {
+	var errs []ValidationError
+
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			errs = append(errs, ValidationError{Field: join(at, name), Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, ValidationError{Field: join(at, name), Message: "is not allowed"})
+			}
+			continue
+		}
+
+		errs = append(errs, d.Validate(prop, v[name], join(at, name))...)
+	}
+
+	return errs
+}
+
+func join(at, name string) string {
+	if at == "" {
+		return name
+	}
+
+	return at + "." + name
+}
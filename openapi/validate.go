@@ -0,0 +1,172 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ValidateRequest checks the parameters and the JSON body of r against op.
+// pathParams holds the values of the templated path segments and body the
+// request body, which the caller has already read
+func (d *Document) ValidateRequest(op *Operation, r *http.Request, pathParams map[string]string, body []byte) []ValidationError {
+	var errs []ValidationError
+
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var (
+			raw     string
+			present bool
+		)
+
+		switch p.In {
+		case "path":
+			raw, present = pathParams[p.Name]
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		case "header":
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		default:
+			continue
+		}
+
+		at := p.In + "." + p.Name
+		if !present {
+			if p.Required {
+				errs = append(errs, ValidationError{Field: at, Message: "is required"})
+			}
+			continue
+		}
+
+		v, err := d.coerce(p.Schema, raw)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: at, Message: err.Error()})
+			continue
+		}
+
+		errs = append(errs, d.Validate(p.Schema, v, at)...)
+	}
+
+	if op.RequestBody == nil {
+		return errs
+	}
+
+	if len(body) == 0 {
+		if op.RequestBody.Required {
+			errs = append(errs, ValidationError{Field: "body", Message: "is required"})
+		}
+		return errs
+	}
+
+	mt, mediaType, ok := lookupContent(op.RequestBody.Content, r.Header.Get("Content-Type"))
+	if !ok {
+		return append(errs, ValidationError{Field: "body", Message: "has an unsupported content type"})
+	}
+
+	return append(errs, d.validateBody(mt, mediaType, body)...)
+}
+
+// ValidateResponse checks a response produced for op against the documented
+// responses
+func (d *Document) ValidateResponse(op *Operation, status int, contentType string, body []byte) []ValidationError {
+	res, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		res, ok = op.Responses["default"]
+	}
+
+	if !ok {
+		return []ValidationError{{Field: "status", Message: fmt.Sprintf("%d is not documented", status)}}
+	}
+
+	res, err := d.resolveResponse(res)
+	if err != nil {
+		return []ValidationError{{Field: "body", Message: err.Error()}}
+	}
+
+	if len(res.Content) == 0 {
+		if len(body) > 0 {
+			return []ValidationError{{Field: "body", Message: "is not documented"}}
+		}
+		return nil
+	}
+
+	mt, mediaType, ok := lookupContent(res.Content, contentType)
+	if !ok {
+		return []ValidationError{{Field: "body", Message: fmt.Sprintf("content type %q is not documented", contentType)}}
+	}
+
+	return d.validateBody(mt, mediaType, body)
+}
+
+func (d *Document) validateBody(mt *MediaType, mediaType string, body []byte) []ValidationError {
+	if mt.Schema == nil || !isJSON(mediaType) {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []ValidationError{{Field: "body", Message: "is not valid JSON"}}
+	}
+
+	return d.Validate(mt.Schema, v, "body")
+}
+
+// coerce converts a parameter from its string form to the JSON value its
+// schema describes
+func (d *Document) coerce(s *Schema, raw string) (interface{}, error) {
+	s, err := d.Resolve(s)
+	if err != nil || s == nil || len(s.Type) == 0 {
+		return raw, err
+	}
+
+	switch s.Type[0] {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be of type %s", s.Type[0])
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be of type boolean")
+		}
+		return b, nil
+	}
+
+	return raw, nil
+}
+
+// lookupContent finds the media type matching contentType, falling back to
+// wildcard entries
+func lookupContent(content map[string]*MediaType, contentType string) (*MediaType, string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	if mt, ok := content[mediaType]; ok {
+		return mt, mediaType, true
+	}
+
+	if i := strings.Index(mediaType, "/"); i > 0 {
+		if mt, ok := content[mediaType[:i]+"/*"]; ok {
+			return mt, mediaType, true
+		}
+	}
+
+	if mt, ok := content["*/*"]; ok {
+		return mt, mediaType, true
+	}
+
+	return nil, mediaType, false
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
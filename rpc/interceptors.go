@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// validationError carries the field errors of a rejected request until
+// mapError turns them into an INVALID_ARGUMENT status
+type validationError []data.FieldError
+
+func (e validationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + " " + fe.Message
+	}
+
+	return strings.Join(msgs, ", ")
+}
+
+// mapError converts the errors returned by the service and the repository
+// to gRPC statuses. Unknown errors become INTERNAL without their message,
+// which may leak storage details
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var verr validationError
+	switch {
+	case errors.As(err, &verr):
+		br := &errdetails.BadRequest{}
+		for _, fe := range verr {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: fe.Message,
+			})
+		}
+
+		st, detailErr := status.New(codes.InvalidArgument, "invalid request").WithDetails(br)
+		if detailErr != nil {
+			return status.Error(codes.InvalidArgument, verr.Error())
+		}
+		return st.Err()
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, data.ErrDuplicateEmail):
+		return status.Error(codes.AlreadyExists, "user exists")
+	case errors.Is(err, data.ErrInvalidTransition), errors.Is(err, data.ErrStatusConflict):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+
+	return status.Error(codes.Internal, "processing error")
+}
+
+func mapErrorsUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	return res, mapError(err)
+}
+
+func mapErrorsStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return mapError(handler(srv, ss))
+}
+
+// authenticate checks the bearer token in the authorization metadata
+func (app *Config) authenticate(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, v := range md.Get("authorization") {
+		token := strings.TrimPrefix(v, "Bearer ")
+		if app.Token != "" && token != v && subtle.ConstantTimeCompare([]byte(token), []byte(app.Token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+func (app *Config) authUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := app.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (app *Config) authStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := app.authenticate(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+func (app *Config) logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+	app.logCall(info.FullMethod, start, err)
+
+	return res, err
+}
+
+func (app *Config) logStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	app.logCall(info.FullMethod, start, err)
+
+	return err
+}
+
+func (app *Config) logCall(method string, start time.Time, err error) {
+	st := status.Convert(err)
+	app.Logger.Printf("method=%s code=%s latency=%s error=%q", method, st.Code(), time.Since(start), st.Message())
+}
@@ -0,0 +1,76 @@
+// Package rpc serves the user operations of the HTTP API over gRPC for
+// internal callers. The service is defined in proto/user/v1/user.proto.
+package rpc
+
+//go:generate protoc -I ../proto --go_out=.. --go_opt=module=github.com/danielboakye/go-echo-app --go-grpc_out=.. --go-grpc_opt=module=github.com/danielboakye/go-echo-app user/v1/user.proto
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/rpc/userpb"
+	"google.golang.org/grpc"
+)
+
+type Config struct {
+	Repo data.IRepository
+
+	// Sessions and Groups, when set, follow the users changed through the
+	// service as they do over HTTP: users who can no longer authenticate
+	// are logged out, and deleted ones leave their groups
+	Sessions data.ISessionRepository
+	Groups   data.IGroupRepository
+
+	// Token is the bearer token callers send in the authorization metadata
+	Token string
+
+	// Organizations makes the server multi-tenant: each call is scoped to the
+	// organization named by id or slug in the x-organization metadata, or
+	// else to DefaultOrganization. Calls naming neither are rejected
+	Organizations       data.IOrganizationRepository
+	DefaultOrganization string
+
+	// Logger receives one line per call. Standard error is used when it is
+	// nil
+	Logger *log.Logger
+}
+
+func (app *Config) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+
+	if app.Logger == nil {
+		app.Logger = log.New(os.Stderr, "grpc ", log.LstdFlags)
+	}
+
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(app.logUnary, mapErrorsUnary, app.authUnary, app.tenantUnary),
+		grpc.ChainStreamInterceptor(app.logStream, mapErrorsStream, app.authStream, app.tenantStream),
+	)
+
+	s := grpc.NewServer(opts...)
+	userpb.RegisterUserServiceServer(s, &userService{
+		repo: app.Repo,
+		deps: data.Dependents{Sessions: app.Sessions, Groups: app.Groups},
+	})
+
+	return s
+}
+
+// Shutdown stops s once in-flight calls have finished, or cuts them short
+// when ctx is done first
+func Shutdown(ctx context.Context, s *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,61 @@
+package rpc_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/rpc"
+	"github.com/danielboakye/go-echo-app/rpc/userpb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testToken = "secret"
+
+func TestRPC(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RPC Suite")
+}
+
+// newTestClient serves app over an in-memory connection and returns a
+// client for it. The server is stopped when the spec ends
+func newTestClient(app *rpc.Config) (userpb.UserServiceClient, *grpc.Server) {
+	if app.Logger == nil {
+		app.Logger = log.New(io.Discard, "", 0)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := app.NewServer()
+	go func() {
+		_ = s.Serve(lis)
+	}()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	DeferCleanup(func() {
+		conn.Close()
+		s.Stop()
+	})
+
+	return userpb.NewUserServiceClient(conn), s
+}
+
+func newMemoryTestApp() *rpc.Config {
+	return &rpc.Config{
+		Repo:  data.NewMemoryRepository(),
+		Token: testToken,
+	}
+}
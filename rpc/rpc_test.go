@@ -0,0 +1,284 @@
+package rpc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/rpc"
+	"github.com/danielboakye/go-echo-app/rpc/userpb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func authorized() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testToken)
+}
+
+func code(err error) codes.Code {
+	return status.Code(err)
+}
+
+var _ = Describe("UserService", func() {
+
+	var (
+		app    *rpc.Config
+		client userpb.UserServiceClient
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		app = newMemoryTestApp()
+		client, _ = newTestClient(app)
+		ctx = authorized()
+	})
+
+	create := func(email, first, last string) *userpb.User {
+		u, err := client.CreateUser(ctx, &userpb.CreateUserRequest{
+			Email: email, FirstName: first, LastName: last,
+			Password: "password", Active: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return u
+	}
+
+	It("should create, get, update and delete a user", func() {
+		u := create("clark@mail.com", "Clark", "Kent")
+		Expect(u.Id).NotTo(BeEmpty())
+		Expect(u.Active).To(BeTrue())
+		Expect(u.CreateTime.AsTime()).To(BeTemporally("~", time.Now(), time.Minute))
+
+		got, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: u.Id})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(got.Email).To(Equal("clark@mail.com"))
+
+		updated, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{
+			Id: u.Id, Email: "superman@mail.com", FirstName: "Kal", LastName: "El",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(updated.Email).To(Equal("superman@mail.com"))
+		Expect(updated.Active).To(BeFalse())
+
+		_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: u.Id})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: u.Id})
+		Expect(code(err)).To(Equal(codes.NotFound))
+	})
+
+	It("should log deactivated and deleted users out and out of their groups", func() {
+		app.Sessions = data.NewMemorySessionRepository()
+		app.Groups = data.NewMemoryGroupRepository()
+		client, _ = newTestClient(app)
+
+		u := create("clark@mail.com", "Clark", "Kent")
+		_, err := app.Sessions.Insert(data.Session{UserID: u.Id, ExpiresAt: time.Now().Add(time.Hour)})
+		Expect(err).ShouldNot(HaveOccurred())
+		groupID, err := app.Groups.Insert(data.Group{Name: "Heroes"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(app.Groups.AddMember(groupID, u.Id)).To(Succeed())
+
+		_, err = client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: u.Id, Email: u.Email, Active: false})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(app.Sessions.GetByUser(u.Id)).To(BeEmpty())
+
+		_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: u.Id})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(app.Groups.Members(groupID, 10, 0)).To(BeEmpty())
+	})
+
+	It("should stream users sorted by last name", func() {
+		create("lois@mail.com", "Lois", "Lane")
+		create("clark@mail.com", "Clark", "Kent")
+
+		stream, err := client.ListUsers(ctx, &userpb.ListUsersRequest{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var names []string
+		for {
+			u, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			Expect(err).ShouldNot(HaveOccurred())
+			names = append(names, u.LastName)
+		}
+		Expect(names).To(Equal([]string{"Kent", "Lane"}))
+	})
+
+	Describe("tenancy", func() {
+		BeforeEach(func() {
+			app.Organizations = data.NewMemoryOrganizationRepository(app.Repo)
+			_, err := app.Organizations.Insert(data.Organization{Slug: "acme", Name: "Acme"})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			client, _ = newTestClient(app)
+		})
+
+		It("should scope calls to the organization in the metadata", func() {
+			acme := metadata.AppendToOutgoingContext(ctx, "x-organization", "acme")
+
+			u, err := client.CreateUser(acme, &userpb.CreateUserRequest{Email: "clark@mail.com", Password: "password"})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = client.GetUser(acme, &userpb.GetUserRequest{Id: u.Id})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			other := metadata.AppendToOutgoingContext(ctx, "x-organization", "default")
+			_, err = client.GetUser(other, &userpb.GetUserRequest{Id: u.Id})
+			Expect(code(err)).To(Equal(codes.NotFound))
+		})
+
+		It("should reject calls naming no or an unknown organization", func() {
+			_, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: "00000000-0000-0000-0000-000000000000"})
+			Expect(code(err)).To(Equal(codes.InvalidArgument))
+
+			unknown := metadata.AppendToOutgoingContext(ctx, "x-organization", "initech")
+			_, err = client.GetUser(unknown, &userpb.GetUserRequest{Id: "00000000-0000-0000-0000-000000000000"})
+			Expect(code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+
+	Describe("error mapping", func() {
+		It("should report a taken email as ALREADY_EXISTS", func() {
+			create("clark@mail.com", "Clark", "Kent")
+
+			_, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Email: "clark@mail.com", Password: "password"})
+			Expect(code(err)).To(Equal(codes.AlreadyExists))
+		})
+
+		It("should report invalid fields as INVALID_ARGUMENT with details", func() {
+			_, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Email: "clark", Password: "short"})
+			Expect(code(err)).To(Equal(codes.InvalidArgument))
+
+			var fields []string
+			for _, d := range status.Convert(err).Details() {
+				br, ok := d.(*errdetails.BadRequest)
+				Expect(ok).To(BeTrue())
+				for _, v := range br.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+			Expect(fields).To(ConsistOf("email", "password"))
+		})
+
+		It("should report missing users as NOT_FOUND", func() {
+			_, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3", Email: "clark@mail.com"})
+			Expect(code(err)).To(Equal(codes.NotFound))
+
+			_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"})
+			Expect(code(err)).To(Equal(codes.NotFound))
+		})
+
+		It("should hide storage errors behind INTERNAL", func() {
+			conn, mockDB, err := sqlmock.New()
+			Expect(err).ShouldNot(HaveOccurred())
+			mockDB.ExpectQuery("SELECT").WillReturnError(errors.New("connection refused"))
+
+			client, _ := newTestClient(&rpc.Config{Repo: data.NewRepository(conn), Token: testToken})
+
+			_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: "2899bacc-7107-4cd4-9364-6a6fc4fc2fd3"})
+			Expect(code(err)).To(Equal(codes.Internal))
+			Expect(status.Convert(err).Message()).To(Equal("processing error"))
+		})
+	})
+
+	Describe("auth", func() {
+		It("should reject calls without a valid token", func() {
+			_, err := client.GetUser(context.Background(), &userpb.GetUserRequest{Id: "x"})
+			Expect(code(err)).To(Equal(codes.Unauthenticated))
+
+			bad := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer nope")
+			_, err = client.GetUser(bad, &userpb.GetUserRequest{Id: "x"})
+			Expect(code(err)).To(Equal(codes.Unauthenticated))
+
+			stream, err := client.ListUsers(context.Background(), &userpb.ListUsersRequest{})
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(code(err)).To(Equal(codes.Unauthenticated))
+		})
+
+		It("should reject every call when no token is configured", func() {
+			client, _ := newTestClient(&rpc.Config{Repo: data.NewMemoryRepository()})
+
+			_, err := client.GetUser(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "), &userpb.GetUserRequest{Id: "x"})
+			Expect(code(err)).To(Equal(codes.Unauthenticated))
+		})
+	})
+
+	It("should log every call with its code", func() {
+		logs := new(bytes.Buffer)
+		app := newMemoryTestApp()
+		app.Logger = log.New(logs, "", 0)
+		client, _ := newTestClient(app)
+
+		_, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: "x"})
+		Expect(code(err)).To(Equal(codes.NotFound))
+		Expect(logs.String()).To(ContainSubstring("method=/user.v1.UserService/GetUser code=NotFound"))
+	})
+})
+
+// blockingRepo holds Stream until release is closed
+type blockingRepo struct {
+	data.IRepository
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRepo) Stream(fn func(*data.User) error) error {
+	close(r.started)
+	<-r.release
+	return nil
+}
+
+var _ = Describe("Shutdown", func() {
+
+	var (
+		repo   *blockingRepo
+		client userpb.UserServiceClient
+		server *grpc.Server
+	)
+
+	BeforeEach(func() {
+		repo = &blockingRepo{
+			IRepository: data.NewMemoryRepository(),
+			started:     make(chan struct{}),
+			release:     make(chan struct{}),
+		}
+		client, server = newTestClient(&rpc.Config{Repo: repo, Token: testToken})
+
+		stream, err := client.ListUsers(authorized(), &userpb.ListUsersRequest{})
+		Expect(err).ShouldNot(HaveOccurred())
+		go func() {
+			_, _ = stream.Recv()
+		}()
+		Eventually(repo.started).Should(BeClosed())
+	})
+
+	It("should wait for calls in flight", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		time.AfterFunc(50*time.Millisecond, func() { close(repo.release) })
+		Expect(rpc.Shutdown(ctx, server)).To(Succeed())
+	})
+
+	It("should cut calls short when the timeout passes", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		Expect(rpc.Shutdown(ctx, server)).To(MatchError(context.DeadlineExceeded))
+		close(repo.release)
+	})
+})
@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type tenantKey struct{}
+
+// tenant returns ctx carrying the id of the organization named, by id or
+// slug, in the x-organization metadata, or of the default organization when
+// none is named
+func (app *Config) tenant(ctx context.Context) (context.Context, error) {
+	if app.Organizations == nil {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	ref := app.DefaultOrganization
+	if v := md.Get("x-organization"); len(v) > 0 {
+		ref = v[0]
+	}
+
+	if ref == "" {
+		return nil, status.Error(codes.InvalidArgument, "organization required")
+	}
+
+	org, err := data.LookupOrganization(app.Organizations, ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.InvalidArgument, "unknown organization")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, tenantKey{}, org.ID), nil
+}
+
+func (app *Config) tenantUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := app.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (app *Config) tenantStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := app.tenant(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &tenantStream{ServerStream: ss, ctx: ctx})
+}
+
+// tenantStream hands the tenant scoped context to stream handlers
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
+
+// users returns the repository for a call, scoped to its tenant when the
+// server is multi-tenant
+func (s *userService) users(ctx context.Context) data.IRepository {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return s.repo.WithTenant(tenant)
+	}
+
+	return s.repo
+}
+
+// dependents returns the dependents of the users of a call, scoped like
+// users
+func (s *userService) dependents(ctx context.Context) data.Dependents {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return s.deps.WithTenant(tenant)
+	}
+
+	return s.deps
+}
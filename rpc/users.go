@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/danielboakye/go-echo-app/data"
+	"github.com/danielboakye/go-echo-app/rpc/userpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// actor is who status changes made through gRPC are recorded as made by
+const actor = "grpc"
+
+type userService struct {
+	userpb.UnimplementedUserServiceServer
+
+	repo data.IRepository
+	deps data.Dependents
+}
+
+func (s *userService) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	u, err := s.users(ctx).GetOne(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return newUser(u), nil
+}
+
+func (s *userService) ListUsers(req *userpb.ListUsersRequest, stream userpb.UserService_ListUsersServer) error {
+	ctx := stream.Context()
+
+	return s.users(ctx).Stream(func(u *data.User) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return stream.Send(newUser(u))
+	})
+}
+
+func (s *userService) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	u := data.User{
+		Email:     req.GetEmail(),
+		FirstName: req.GetFirstName(),
+		LastName:  req.GetLastName(),
+		Password:  req.GetPassword(),
+		Status:    data.StatusOf(req.GetActive()),
+	}
+
+	if errs := data.ValidateUser(u); len(errs) > 0 {
+		return nil, validationError(errs)
+	}
+
+	id, err := s.users(ctx).Insert(u)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.users(ctx).GetOne(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUser(created), nil
+}
+
+func (s *userService) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	u, err := s.users(ctx).GetOne(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	u.Email = req.GetEmail()
+	u.FirstName = req.GetFirstName()
+	u.LastName = req.GetLastName()
+
+	if errs := data.ValidateProfile(*u); len(errs) > 0 {
+		return nil, validationError(errs)
+	}
+
+	if _, err := u.Status.WithActive(req.GetActive()); err != nil {
+		return nil, err
+	}
+
+	if err := s.users(ctx).Update(*u); err != nil {
+		return nil, err
+	}
+
+	if err := data.SetActive(s.users(ctx), u, req.GetActive(), actor); err != nil {
+		return nil, err
+	}
+
+	if err := s.dependents(ctx).StatusChanged(u); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.users(ctx).GetOne(u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUser(updated), nil
+}
+
+func (s *userService) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*emptypb.Empty, error) {
+	if _, err := s.users(ctx).GetOne(req.GetId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.users(ctx).DeleteByID(req.GetId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.dependents(ctx).Deleted(req.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// newUser maps a user to its protobuf message, leaving out the password hash
+func newUser(u *data.User) *userpb.User {
+	return &userpb.User{
+		Id:         u.ID,
+		Email:      u.Email,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Active:     u.Status == data.StatusActive,
+		CreateTime: timestamppb.New(u.CreatedAt),
+		UpdateTime: timestamppb.New(u.UpdatedAt),
+	}
+}
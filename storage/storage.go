@@ -0,0 +1,58 @@
+// Package storage keeps binary objects, such as the images users upload,
+// in a BlobStore: Local writes them to a directory, S3 to a bucket of an
+// S3-compatible service, and Memory keeps them for tests
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when there is no object under a key
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned for keys that are empty, absolute or climb out
+// of the store with ".." segments
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Info describes a stored object
+type Info struct {
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// BlobStore stores objects under slash separated keys, e.g.
+// avatars/1234/original.png. Putting an object replaces the one under its
+// key
+type BlobStore interface {
+	Put(ctx context.Context, key, contentType string, r io.Reader) error
+	// Get opens the object under key, which the caller must close
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	// Delete removes the object under key. Deleting a missing object is not
+	// an error
+	Delete(ctx context.Context, key string) error
+}
+
+// keySegment is the alphabet of the segments of a key. Segments may not
+// start with a dot, which keeps out ".." and the metadata of Local
+var keySegment = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidKey reports whether key can name an object in every store
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 1024 {
+		return false
+	}
+
+	for _, seg := range strings.Split(key, "/") {
+		if !keySegment.MatchString(seg) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/danielboakye/go-echo-app/storage"
+	"github.com/danielboakye/go-echo-app/storage/s3test"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// blobStoreContract describes the behaviour every BlobStore must share.
+// newStore is called before each spec and must return an empty store
+func blobStoreContract(newStore func() storage.BlobStore) {
+
+	var (
+		store storage.BlobStore
+		ctx   = context.Background()
+	)
+
+	read := func(key string) (string, storage.Info) {
+		rc, info, err := store.Get(ctx, key)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer rc.Close()
+
+		b, err := io.ReadAll(rc)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return string(b), info
+	}
+
+	BeforeEach(func() {
+		store = newStore()
+	})
+
+	It("should put, replace and get objects", func() {
+		Expect(store.Put(ctx, "avatars/1/original.png", "image/png", strings.NewReader("first"))).To(Succeed())
+		Expect(store.Put(ctx, "avatars/1/original.png", "image/png", strings.NewReader("second"))).To(Succeed())
+
+		body, info := read("avatars/1/original.png")
+		Expect(body).To(Equal("second"))
+		Expect(info.ContentType).To(Equal("image/png"))
+		Expect(info.Size).To(Equal(int64(6)))
+		Expect(info.ModTime).NotTo(BeZero())
+	})
+
+	It("should delete objects", func() {
+		Expect(store.Put(ctx, "a/b.txt", "text/plain", strings.NewReader("x"))).To(Succeed())
+		Expect(store.Delete(ctx, "a/b.txt")).To(Succeed())
+		Expect(store.Delete(ctx, "a/b.txt")).To(Succeed())
+
+		_, _, err := store.Get(ctx, "a/b.txt")
+		Expect(err).To(MatchError(storage.ErrNotFound))
+	})
+
+	It("should refuse keys escaping the store", func() {
+		for _, key := range []string{"", "/etc/passwd", "../up", "a/../../b", "a//b", ".meta/a"} {
+			Expect(store.Put(ctx, key, "text/plain", strings.NewReader("x"))).To(MatchError(storage.ErrInvalidKey), key)
+		}
+	})
+}
+
+var _ = Describe("Memory", func() {
+	blobStoreContract(func() storage.BlobStore {
+		return storage.NewMemory()
+	})
+})
+
+var _ = Describe("Local", func() {
+	blobStoreContract(func() storage.BlobStore {
+		store, err := storage.NewLocal(GinkgoT().TempDir())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return store
+	})
+})
+
+var _ = Describe("S3", func() {
+	var server *s3test.Server
+
+	BeforeEach(func() {
+		server = s3test.NewServer("eu-west-1", "AKIDEXAMPLE", "secret")
+		server.CreateBucket("avatars")
+		DeferCleanup(server.Close)
+	})
+
+	blobStoreContract(func() storage.BlobStore {
+		return storage.NewS3(server.URL, "eu-west-1", "avatars", "AKIDEXAMPLE", "secret")
+	})
+
+	It("should store objects in the bucket", func() {
+		store := storage.NewS3(server.URL, "eu-west-1", "avatars", "AKIDEXAMPLE", "secret")
+		Expect(store.Put(context.Background(), "1/64.png", "image/png", strings.NewReader("x"))).To(Succeed())
+		Expect(server.Keys("avatars")).To(Equal([]string{"1/64.png"}))
+	})
+
+	It("should fail with wrong credentials", func() {
+		store := storage.NewS3(server.URL, "eu-west-1", "avatars", "AKIDEXAMPLE", "wrong")
+		err := store.Put(context.Background(), "1/64.png", "image/png", strings.NewReader("x"))
+		Expect(err).To(MatchError(ContainSubstring("SignatureDoesNotMatch")))
+
+		store = storage.NewS3(server.URL, "eu-west-1", "missing", "AKIDEXAMPLE", "secret")
+		err = store.Put(context.Background(), "1/64.png", "image/png", strings.NewReader("x"))
+		Expect(err).To(MatchError(ContainSubstring("NoSuchBucket")))
+	})
+})
@@ -0,0 +1,114 @@
+// Package thumbnail decodes uploaded JPEG, PNG and GIF images and scales
+// them into square thumbnails, with the standard library alone
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+var (
+	// ErrFormat is returned for data that is not a JPEG, PNG or GIF image
+	ErrFormat = errors.New("thumbnail: not a JPEG, PNG or GIF image")
+	// ErrTooLarge is returned for images wider or taller than allowed,
+	// before they are decoded
+	ErrTooLarge = errors.New("thumbnail: image too large")
+)
+
+// jpegQuality is the quality thumbnails of JPEG images are encoded with
+const jpegQuality = 85
+
+// Decode reads an image whose sides are at most maxSide pixels. It returns
+// the format of the image: "jpeg", "png" or "gif"
+func Decode(b []byte, maxSide int) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", ErrFormat
+	}
+
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width > maxSide || cfg.Height > maxSide {
+		return nil, "", ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", ErrFormat
+	}
+
+	return img, format, nil
+}
+
+// Square crops the middle square out of src and scales it to size by size
+// pixels. Each pixel is the average of the pixels of src it covers, which
+// keeps downscaled images free of aliasing
+func Square(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	// copying the square out once lets the scaling read pixels directly,
+	// whatever the color model of src
+	crop := image.NewRGBA(image.Rect(0, 0, side, side))
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	draw.Draw(crop, crop.Bounds(), src, origin, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		y0, y1 := span(dy, size, side)
+		for dx := 0; dx < size; dx++ {
+			x0, x1 := span(dx, size, side)
+
+			var r, g, bl, a, n uint32
+			for y := y0; y < y1; y++ {
+				row := crop.Pix[y*crop.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+
+			out := dst.Pix[dy*dst.Stride+dx*4:]
+			out[0] = uint8(r / n)
+			out[1] = uint8(g / n)
+			out[2] = uint8(bl / n)
+			out[3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// span returns the source pixels [from, to) that destination pixel i of
+// size covers in a source of side pixels. Upscaling covers a single pixel
+func span(i, size, side int) (int, int) {
+	from := i * side / size
+	to := (i + 1) * side / size
+	if to <= from {
+		to = from + 1
+	}
+
+	return from, to
+}
+
+// Encode writes img as a JPEG when format is "jpeg", and as a PNG
+// otherwise, which keeps the transparency of PNG and GIF images. It returns
+// the content type written
+func Encode(w io.Writer, img image.Image, format string) (string, error) {
+	if format == "jpeg" {
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	}
+
+	return "image/png", png.Encode(w, img)
+}
@@ -0,0 +1,94 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/danielboakye/go-echo-app/thumbnail"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("thumbnail", func() {
+
+	// halves is a wide image, red on its left half and blue on its right,
+	// with a green border column at each end that the square crop drops
+	halves := func() *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 120, 100))
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 120; x++ {
+				c := color.RGBA{R: 255, A: 255}
+				switch {
+				case x < 10 || x >= 110:
+					c = color.RGBA{G: 255, A: 255}
+				case x >= 60:
+					c = color.RGBA{B: 255, A: 255}
+				}
+				img.Set(x, y, c)
+			}
+		}
+
+		return img
+	}
+
+	It("should decode JPEG, PNG and GIF images within the size limit", func() {
+		var buf bytes.Buffer
+		Expect(png.Encode(&buf, halves())).To(Succeed())
+
+		img, format, err := thumbnail.Decode(buf.Bytes(), 200)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(format).To(Equal("png"))
+		Expect(img.Bounds().Dx()).To(Equal(120))
+
+		_, _, err = thumbnail.Decode(buf.Bytes(), 100)
+		Expect(err).To(MatchError(thumbnail.ErrTooLarge))
+
+		buf.Reset()
+		Expect(jpeg.Encode(&buf, halves(), nil)).To(Succeed())
+		_, format, err = thumbnail.Decode(buf.Bytes(), 200)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(format).To(Equal("jpeg"))
+
+		buf.Reset()
+		Expect(gif.Encode(&buf, halves(), nil)).To(Succeed())
+		_, format, err = thumbnail.Decode(buf.Bytes(), 200)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(format).To(Equal("gif"))
+
+		_, _, err = thumbnail.Decode([]byte("<svg></svg>"), 200)
+		Expect(err).To(MatchError(thumbnail.ErrFormat))
+	})
+
+	It("should crop the middle square and average it down", func() {
+		thumb := thumbnail.Square(halves(), 10)
+		Expect(thumb.Bounds()).To(Equal(image.Rect(0, 0, 10, 10)))
+
+		Expect(thumb.RGBAAt(0, 0)).To(Equal(color.RGBA{R: 255, A: 255}))
+		Expect(thumb.RGBAAt(9, 9)).To(Equal(color.RGBA{B: 255, A: 255}))
+	})
+
+	It("should scale small images up", func() {
+		thumb := thumbnail.Square(halves(), 256)
+		Expect(thumb.Bounds().Dx()).To(Equal(256))
+		Expect(thumb.RGBAAt(255, 0)).To(Equal(color.RGBA{B: 255, A: 255}))
+	})
+
+	It("should keep JPEG images as JPEG and write PNG for the others", func() {
+		var buf bytes.Buffer
+		contentType, err := thumbnail.Encode(&buf, thumbnail.Square(halves(), 8), "jpeg")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(contentType).To(Equal("image/jpeg"))
+
+		buf.Reset()
+		contentType, err = thumbnail.Encode(&buf, thumbnail.Square(halves(), 8), "gif")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(contentType).To(Equal("image/png"))
+
+		_, err = png.Decode(&buf)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,59 @@
+package totp_test
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/danielboakye/go-echo-app/totp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TOTP", func() {
+
+	// the SHA-1 secret of the RFC 6238 test vectors
+	secret := []byte("12345678901234567890")
+
+	DescribeTable("should match the RFC 6238 test vectors",
+		func(unix int64, code string) {
+			Expect(totp.Code(secret, totp.Step(time.Unix(unix, 0)))).To(Equal(code))
+		},
+		Entry("at 59", int64(59), "287082"),
+		Entry("at 1111111109", int64(1111111109), "081804"),
+		Entry("at 1111111111", int64(1111111111), "050471"),
+		Entry("at 1234567890", int64(1234567890), "005924"),
+		Entry("at 2000000000", int64(2000000000), "279037"),
+	)
+
+	It("should accept codes within the skew only", func() {
+		now := time.Unix(1234567890, 0)
+		previous := totp.Code(secret, totp.Step(now)-1)
+
+		step, ok := totp.Validate(secret, previous, now, 1)
+		Expect(ok).To(BeTrue())
+		Expect(step).To(Equal(totp.Step(now) - 1))
+
+		_, ok = totp.Validate(secret, previous, now, 0)
+		Expect(ok).To(BeFalse())
+
+		_, ok = totp.Validate(secret, "005 924", now, 0)
+		Expect(ok).To(BeTrue())
+
+		_, ok = totp.Validate(secret, "12345", now, 1)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should describe secrets in otpauth URIs", func() {
+		s, err := totp.NewSecret()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(s).To(HaveLen(totp.SecretSize))
+
+		u, err := url.Parse(totp.URI("Acme Users", "clark@mail.com", s))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(u.Scheme).To(Equal("otpauth"))
+		Expect(u.Host).To(Equal("totp"))
+		Expect(u.Path).To(Equal("/Acme Users:clark@mail.com"))
+		Expect(u.Query().Get("secret")).To(Equal(totp.Encode(s)))
+		Expect(u.Query().Get("issuer")).To(Equal("Acme Users"))
+	})
+})